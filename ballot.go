@@ -1,6 +1,7 @@
 package paxi
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -11,6 +12,12 @@ import (
 // Ballot is ballot number type combines 32 bits of natual number and 32 bits of node id into uint64
 type Ballot uint64
 
+// MaxBallotN is the largest natural number a ballot can hold
+const MaxBallotN = 1<<32 - 1
+
+// ErrBallotOverflow is returned when the ballot counter cannot grow without wrapping around
+var ErrBallotOverflow = errors.New("ballot number overflow")
+
 // NewBallot generates ballot number in format <n, zone, node>
 func NewBallot(n int, id ID) Ballot {
 	return Ballot(n<<32 | id.Zone()<<16 | id.Node())
@@ -53,9 +60,19 @@ func (b Ballot) ID() ID {
 	return NewID(zone, node)
 }
 
-// Next generates the next ballot number given node id
-func (b *Ballot) Next(id ID) {
+// Next generates the next ballot number given node id,
+// ballot is unchanged and ErrBallotOverflow is returned if counter reaches MaxBallotN
+func (b *Ballot) Next(id ID) error {
+	if b.N() >= MaxBallotN {
+		return ErrBallotOverflow
+	}
 	*b = NewBallot(b.N()+1, id)
+	return nil
+}
+
+// Headroom returns the number of times Next can still be called before overflow
+func (b Ballot) Headroom() int {
+	return MaxBallotN - b.N()
 }
 
 func (b Ballot) String() string {
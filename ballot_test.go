@@ -20,3 +20,22 @@ func TestBallot(t *testing.T) {
 		t.Errorf("Ballot.ID() %v != %v", b.ID(), id)
 	}
 }
+
+func TestBallotOverflow(t *testing.T) {
+	id := NewID(1, 1)
+	b := NewBallot(MaxBallotN-1, id)
+	if b.Headroom() != 1 {
+		t.Errorf("Ballot.Headroom() %v != 1", b.Headroom())
+	}
+
+	if err := b.Next(id); err != nil {
+		t.Fatalf("Ballot.Next() returns %v before overflow", err)
+	}
+	max := b
+	if err := b.Next(id); err != ErrBallotOverflow {
+		t.Errorf("Ballot.Next() returns %v, expected %v", err, ErrBallotOverflow)
+	}
+	if b != max || b.Headroom() != 0 {
+		t.Errorf("Ballot %v wraps around after overflow", b)
+	}
+}
@@ -40,8 +40,8 @@ type Bconfig struct {
 	Speed int     // moving speed in milliseconds intervals per key
 
 	// zipfian distribution
-	ZipfianS float64 // zipfian s parameter
-	ZipfianV float64 // zipfian v parameter
+	ZipfianS float64 `json:"Zipfian_s"` // zipfian s parameter
+	ZipfianV float64 `json:"Zipfian_v"` // zipfian v parameter
 
 	// exponential distribution
 	Lambda float64 // rate parameter
@@ -91,12 +91,7 @@ func NewBenchmark(db DB) *Benchmark {
 	b.db = db
 	b.Bconfig = config.Benchmark
 	b.History = NewHistory()
-	if b.Throttle > 0 {
-		b.rate = NewLimiter(b.Throttle)
-	}
 	rand.Seed(time.Now().UTC().UnixNano())
-	r := rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
-	b.zipf = rand.NewZipf(r, b.ZipfianS, b.ZipfianV, uint64(b.K))
 	return b
 }
 
@@ -226,8 +221,13 @@ func (b *Benchmark) next() int {
 			key -= b.K
 		}
 
-	case "zipfan":
-		key = int(b.zipf.Uint64())
+	case "zipfan", "zipfian":
+		// created on first use so that K and parameters can be changed after NewBenchmark
+		if b.zipf == nil {
+			r := rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
+			b.zipf = rand.NewZipf(r, b.ZipfianS, b.ZipfianV, uint64(b.K-1))
+		}
+		key = int(b.zipf.Uint64()) + b.Min
 
 	case "exponential":
 		key = int(rand.ExpFloat64() / b.Lambda)
@@ -237,6 +237,9 @@ func (b *Benchmark) next() int {
 	}
 
 	if b.Throttle > 0 {
+		if b.rate == nil {
+			b.rate = NewLimiter(b.Throttle)
+		}
 		b.rate.Wait()
 	}
 
@@ -269,6 +272,7 @@ func (b *Benchmark) worker(keys <-chan int, result chan<- time.Duration) {
 		} else {
 			op.end = math.MaxInt64
 			log.Error(err)
+			b.wait.Done()
 		}
 		b.History.AddOperation(k, op)
 	}
@@ -69,3 +69,31 @@ func TestBenchmark(t *testing.T) {
 
 	b.Run()
 }
+
+func TestBenchmarkZipfian(t *testing.T) {
+	f := new(FakeDB)
+	f.start = 100
+	f.end = 100
+
+	b := NewBenchmark(f)
+	b.Min = 100
+	b.K = 50
+	b.W = 0.2
+	b.Distribution = "zipfian"
+	b.ZipfianS = 2
+	b.ZipfianV = 1
+	b.T = 0
+	b.N = 2000
+	b.LinearizabilityCheck = false
+
+	b.Run()
+	// first key takes about 1/zeta(2) = 61% of requests
+	if f.total != b.N || f.local < b.N/2 {
+		t.Errorf("expected most of %d requests on key %d, got %d of %d", b.N, b.Min, f.local, f.total)
+	}
+	for k := range b.History.shard {
+		if k < b.Min || k >= b.Min+b.K {
+			t.Errorf("key %d out of key space [%d, %d)", k, b.Min, b.Min+b.K)
+		}
+	}
+}
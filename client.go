@@ -2,6 +2,7 @@ package paxi
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"io"
@@ -37,7 +38,10 @@ type HTTPClient struct {
 	N      int // total number of nodes
 	LocalN int // number of nodes in local zone
 
-	CID int // command id
+	CID      int // command id
+	Session  int // session returned by last reply, so that reads observe earlier writes of this client
+	Priority int // priority of requests of this client, 0 is default
+	Leader   ID  // leader learned from redirect reply, requests for ID go there until it fails
 	*http.Client
 }
 
@@ -48,7 +52,7 @@ func NewHTTPClient(id ID) *HTTPClient {
 		N:      len(config.Addrs),
 		Addrs:  config.Addrs,
 		HTTP:   config.HTTPAddrs,
-		Client: &http.Client{},
+		Client: &http.Client{CheckRedirect: unfollowed},
 	}
 	if id != "" {
 		i := 0
@@ -63,6 +67,11 @@ func NewHTTPClient(id ID) *HTTPClient {
 	return c
 }
 
+// unfollowed leaves redirect to leader for rest to follow, which remembers the leader for next requests
+func unfollowed(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
 // Get gets value of given key (use REST)
 // Default implementation of Client interface
 func (c *HTTPClient) Get(key Key) (Value, error) {
@@ -79,20 +88,62 @@ func (c *HTTPClient) Put(key Key, value Value) error {
 	return err
 }
 
+// PutTTL puts new key value pair that expires ttl slots after the slot executing it,
+// reads of the key return empty value from then on until it is written again
+func (c *HTTPClient) PutTTL(key Key, value Value, ttl int) error {
+	c.CID++
+	if value == nil {
+		value = Value{}
+	}
+	header := http.Header{}
+	header.Set(HTTPTTL, strconv.Itoa(ttl))
+	_, _, err := c.rest(c.ID, key, value, header)
+	return err
+}
+
+// CompareAndSwap writes value to key only if its current value equals expected, an empty expected value
+// requires key to have no value, returns false if current value differs
+func (c *HTTPClient) CompareAndSwap(key Key, expected, value Value) (bool, error) {
+	c.CID++
+	if value == nil {
+		value = Value{}
+	}
+	header := http.Header{}
+	header.Set(HTTPExpected, base64.StdEncoding.EncodeToString(expected))
+	_, _, err := c.rest(c.ID, key, value, header)
+	if err != nil && err.Error() == ErrCompareFailed.Error() {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (c *HTTPClient) GetURL(id ID, key Key) string {
-	if id == "" {
+	return c.Address(id) + "/" + strconv.Itoa(int(key))
+}
+
+// Address returns http address of node id, or of any node in local zone if id is not a node id, e.g. a client id
+func (c *HTTPClient) Address(id ID) string {
+	if _, exists := c.HTTP[id]; !exists {
 		for id = range c.HTTP {
 			if c.ID == "" || id.Zone() == c.ID.Zone() {
 				break
 			}
 		}
 	}
-	return c.HTTP[id] + "/" + strconv.Itoa(int(key))
+	return c.HTTP[id]
 }
 
-// rest accesses server's REST API with url = http://ip:port/key
-// if value == nil, it's a read
-func (c *HTTPClient) rest(id ID, key Key, value Value) (Value, map[string]string, error) {
+// rest accesses server's REST API with url = http://ip:port/key and extra request headers
+// if value == nil, it's a read. Requests for ID go to the leader once a node redirects there.
+func (c *HTTPClient) rest(id ID, key Key, value Value, header http.Header) (Value, map[string]string, error) {
+	if id == c.ID && c.Leader != "" {
+		id = c.Leader
+	}
+	return c.send(id, key, value, header, true)
+}
+
+// send sends request of rest to node id, and again to the leader if id redirects and follow is true
+func (c *HTTPClient) send(id ID, key Key, value Value, header http.Header, follow bool) (Value, map[string]string, error) {
 	// get url
 	url := c.GetURL(id, key)
 
@@ -107,13 +158,26 @@ func (c *HTTPClient) rest(id ID, key Key, value Value) (Value, map[string]string
 		log.Error(err)
 		return nil, nil, err
 	}
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
 	req.Header.Set(HTTPClientID, string(c.ID))
 	req.Header.Set(HTTPCommandID, strconv.Itoa(c.CID))
+	if c.Session > 0 {
+		req.Header.Set(HTTPSession, strconv.Itoa(c.Session))
+	}
+	if c.Priority != 0 {
+		req.Header.Set(HTTPPriority, strconv.Itoa(c.Priority))
+	}
 	// r.Header.Set(HTTPTimestamp, strconv.FormatInt(time.Now().UnixNano(), 10))
 
 	rep, err := c.Client.Do(req)
 	if err != nil {
 		log.Error(err)
+		if id == c.Leader {
+			// back to own node, which knows the next leader
+			c.Leader = ""
+		}
 		return nil, nil, err
 	}
 	defer rep.Body.Close()
@@ -123,6 +187,9 @@ func (c *HTTPClient) rest(id ID, key Key, value Value) (Value, map[string]string
 	for k := range rep.Header {
 		metadata[k] = rep.Header.Get(k)
 	}
+	if s, err := strconv.Atoi(metadata[HTTPSession]); err == nil && s > c.Session {
+		c.Session = s
+	}
 
 	if rep.StatusCode == http.StatusOK {
 		b, err := ioutil.ReadAll(rep.Body)
@@ -138,6 +205,24 @@ func (c *HTTPClient) rest(id ID, key Key, value Value) (Value, map[string]string
 		return Value(b), metadata, nil
 	}
 
+	if rep.StatusCode == http.StatusTemporaryRedirect {
+		leader := ID(metadata[HTTPLeader])
+		if follow && leader != "" && leader != id {
+			log.Debugf("node=%v redirects key=%v to leader=%v", id, key, leader)
+			c.Leader = leader
+			return c.send(leader, key, value, header, false)
+		}
+	}
+
+	if rep.StatusCode == http.StatusConflict {
+		// command is rejected by state machine with its error in body
+		b, err := ioutil.ReadAll(rep.Body)
+		if err != nil {
+			return nil, metadata, err
+		}
+		return nil, metadata, errors.New(string(b))
+	}
+
 	// http call failed
 	dump, _ := httputil.DumpResponse(rep, true)
 	log.Debugf("%q", dump)
@@ -146,12 +231,12 @@ func (c *HTTPClient) rest(id ID, key Key, value Value) (Value, map[string]string
 
 // RESTGet issues a http call to node and return value and headers
 func (c *HTTPClient) RESTGet(id ID, key Key) (Value, map[string]string, error) {
-	return c.rest(id, key, nil)
+	return c.rest(id, key, nil, nil)
 }
 
 // RESTPut puts new value as http.request body and return previous value
 func (c *HTTPClient) RESTPut(id ID, key Key, value Value) (Value, map[string]string, error) {
-	return c.rest(id, key, value)
+	return c.rest(id, key, value, nil)
 }
 
 func (c *HTTPClient) json(id ID, key Key, value Value) (Value, error) {
@@ -201,7 +286,7 @@ func (c *HTTPClient) MultiGet(n int, key Key) ([]Value, []map[string]string) {
 	i := 0
 	for id := range c.HTTP {
 		go func(id ID) {
-			v, meta, err := c.rest(id, key, nil)
+			v, meta, err := c.rest(id, key, nil, nil)
 			if err != nil {
 				log.Error(err)
 				return
@@ -237,7 +322,7 @@ func (c *HTTPClient) LocalQuorumGet(key Key) ([]Value, []map[string]string) {
 			break
 		}
 		go func(id ID) {
-			v, meta, err := c.rest(id, key, nil)
+			v, meta, err := c.rest(id, key, nil, nil)
 			if err != nil {
 				log.Error(err)
 				return
@@ -268,7 +353,7 @@ func (c *HTTPClient) QuorumPut(key Key, value Value) {
 		}
 		wait.Add(1)
 		go func(id ID) {
-			c.rest(id, key, value)
+			c.rest(id, key, value, nil)
 			wait.Done()
 		}(id)
 	}
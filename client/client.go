@@ -13,6 +13,16 @@ var algorithm = flag.String("algorithm", "", "Client API type [paxos]")
 var load = flag.Bool("load", false, "Load K keys into DB")
 var master = flag.String("master", "", "Master address.")
 
+// benchmark flags override benchmark config when given
+var duration = flag.Int("T", 0, "benchmark running time in seconds, 0 runs N requests")
+var number = flag.Int("N", 0, "total number of requests if T is 0")
+var keys = flag.Int("K", 1000, "key space")
+var write = flag.Float64("W", 0.5, "write ratio")
+var concurrency = flag.Int("concurrency", 1, "number of simulated clients")
+var throttle = flag.Int("throttle", 0, "requests per second throttle, 0 is unlimited")
+var distribution = flag.String("distribution", "uniform", "key distribution [uniform, zipfian, normal, exponential, conflict, order]")
+var zipfianS = flag.Float64("zipfian_s", 2, "zipfian s parameter, greater than 1")
+
 // db implements Paxi.DB interface for benchmarking
 type db struct {
 	paxi.Client
@@ -60,9 +70,34 @@ func main() {
 	}
 
 	b := paxi.NewBenchmark(d)
+	override(b)
 	if *load {
 		b.Load()
 	} else {
 		b.Run()
 	}
 }
+
+// override replaces benchmark config with benchmark flags set in command line
+func override(b *paxi.Benchmark) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "T":
+			b.T = *duration
+		case "N":
+			b.N = *number
+		case "K":
+			b.K = *keys
+		case "W":
+			b.W = *write
+		case "concurrency":
+			b.Concurrency = *concurrency
+		case "throttle":
+			b.Throttle = *throttle
+		case "distribution":
+			b.Distribution = *distribution
+		case "zipfian_s":
+			b.ZipfianS = *zipfianS
+		}
+	})
+}
@@ -1,36 +1,107 @@
 package paxi
 
 import (
+	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"fmt"
 	"io"
-
-	"github.com/ailidani/paxi/log"
+	"reflect"
+	"sync"
 )
 
 // Codec interface provide methods for serialization and deserialization
 // combines json and gob encoder decoder interface
 type Codec interface {
 	Scheme() string
-	Encode(interface{})
-	Decode(interface{})
+	Encode(interface{}) error
+	Decode(interface{}) error
 }
 
-// NewCodec creates new codec object based on scheme, i.e. json and gob
-func NewCodec(scheme string, rw io.ReadWriter) Codec {
-	switch scheme {
-	case "json":
+// Marshaler is implemented by codecs that also serialize one message into bytes without stream framing
+type Marshaler interface {
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+}
+
+var codecs = map[string]func(io.ReadWriter) Codec{
+	"json": func(rw io.ReadWriter) Codec {
 		return &codecJSON{
 			encoder: json.NewEncoder(rw),
 			decoder: json.NewDecoder(rw),
 		}
-	case "gob":
+	},
+	"gob": func(rw io.ReadWriter) Codec {
 		return &codecGOB{
 			encoder: gob.NewEncoder(rw),
 			decoder: gob.NewDecoder(rw),
 		}
+	},
+	"proto": func(rw io.ReadWriter) Codec {
+		return &codecProto{rw: rw}
+	},
+}
+
+// RegisterCodec makes codec available by scheme name, e.g. a protobuf codec,
+// it must be called before any transport is created
+func RegisterCodec(scheme string, f func(io.ReadWriter) Codec) {
+	codecs[scheme] = f
+}
+
+// NewCodec creates new codec object based on scheme, i.e. json, gob and proto
+func NewCodec(scheme string, rw io.ReadWriter) Codec {
+	f, ok := codecs[scheme]
+	if !ok {
+		return nil
 	}
-	return nil
+	return f(rw)
+}
+
+// Marshal serializes message m by codec of scheme, through Marshaler if the codec implements it,
+// otherwise as one message of a new stream, which carries type information of gob
+func Marshal(scheme string, m interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	c := NewCodec(scheme, &b)
+	if c == nil {
+		return nil, fmt.Errorf("unknown codec %s", scheme)
+	}
+	if marshaler, ok := c.(Marshaler); ok {
+		return marshaler.Marshal(m)
+	}
+	err := c.Encode(m)
+	return b.Bytes(), err
+}
+
+// Unmarshal deserializes message b of Marshal by codec of scheme into m
+func Unmarshal(scheme string, b []byte, m interface{}) error {
+	c := NewCodec(scheme, bytes.NewBuffer(b))
+	if c == nil {
+		return fmt.Errorf("unknown codec %s", scheme)
+	}
+	if marshaler, ok := c.(Marshaler); ok {
+		return marshaler.Unmarshal(b, m)
+	}
+	return c.Decode(m)
+}
+
+// types of messages by name, used by json codec to decode interface values
+var types = make(map[string]reflect.Type)
+var typesLock sync.RWMutex
+
+// RegisterMessage records message type for every codec,
+// messages sent between nodes as interface value must be registered
+func RegisterMessage(m interface{}) {
+	gob.Register(m)
+	t := reflect.TypeOf(m)
+	typesLock.Lock()
+	types[t.String()] = t
+	typesLock.Unlock()
+}
+
+// envelope wraps json value with its registered type name
+type envelope struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
 }
 
 type codecJSON struct {
@@ -42,18 +113,44 @@ func (j *codecJSON) Scheme() string {
 	return "json"
 }
 
-func (j *codecJSON) Encode(m interface{}) {
-	err := j.encoder.Encode(m)
+func (j *codecJSON) Encode(m interface{}) error {
+	v, ok := m.(*interface{})
+	if !ok {
+		return j.encoder.Encode(m)
+	}
+	b, err := json.Marshal(*v)
 	if err != nil {
-		log.Error(err)
+		return err
 	}
+	return j.encoder.Encode(envelope{
+		Type:  reflect.TypeOf(*v).String(),
+		Value: b,
+	})
 }
 
-func (j *codecJSON) Decode(m interface{}) {
-	err := j.decoder.Decode(m)
+func (j *codecJSON) Decode(m interface{}) error {
+	v, ok := m.(*interface{})
+	if !ok {
+		return j.decoder.Decode(m)
+	}
+	var e envelope
+	err := j.decoder.Decode(&e)
 	if err != nil {
-		log.Error(err)
+		return err
 	}
+	typesLock.RLock()
+	t, exists := types[e.Type]
+	typesLock.RUnlock()
+	if !exists {
+		return fmt.Errorf("json codec cannot decode unregistered type %s", e.Type)
+	}
+	value := reflect.New(t)
+	err = json.Unmarshal(e.Value, value.Interface())
+	if err != nil {
+		return err
+	}
+	*v = value.Elem().Interface()
+	return nil
 }
 
 type codecGOB struct {
@@ -65,16 +162,10 @@ func (g *codecGOB) Scheme() string {
 	return "gob"
 }
 
-func (g *codecGOB) Encode(m interface{}) {
-	err := g.encoder.Encode(m)
-	if err != nil {
-		log.Error(err)
-	}
+func (g *codecGOB) Encode(m interface{}) error {
+	return g.encoder.Encode(m)
 }
 
-func (g *codecGOB) Decode(m interface{}) {
-	err := g.decoder.Decode(m)
-	if err != nil {
-		log.Error(err)
-	}
+func (g *codecGOB) Decode(m interface{}) error {
+	return g.decoder.Decode(m)
 }
@@ -42,6 +42,82 @@ func TestCodecGob(t *testing.T) {
 	}
 }
 
+func TestCodecJSON(t *testing.T) {
+	RegisterMessage(A{})
+	RegisterMessage(B{})
+	var send interface{}
+	var recv interface{}
+
+	buf := new(bytes.Buffer)
+	c := NewCodec("json", buf)
+
+	send = A{1, "a", true}
+
+	c.Encode(&send)
+	c.Decode(&recv)
+	if send.(A) != recv.(A) {
+		t.Errorf("expect send %v and recv %v to be euqal", send, recv)
+	}
+
+	send = B{"test"}
+
+	c.Encode(&send)
+	c.Decode(&recv)
+	if send.(B) != recv.(B) {
+		t.Errorf("expect send %v and recv %v to be euqal", send, recv)
+	}
+}
+
+func TestCodecProto(t *testing.T) {
+	RegisterMessage(A{})
+	RegisterMessage(B{})
+	var send interface{}
+	var recv interface{}
+
+	buf := new(bytes.Buffer)
+	c := NewCodec("proto", buf)
+
+	for _, send = range []interface{}{A{-1, "a", true}, A{}, B{"test"}} {
+		if err := c.Encode(&send); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Decode(&recv); err != nil {
+			t.Fatal(err)
+		}
+		if send != recv {
+			t.Errorf("expect send %v and recv %v to be equal", send, recv)
+		}
+	}
+
+	// fields are numbered in declaration order, signed integers are zigzag varint
+	b, err := Marshal("proto", A{1, "a", true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x08, 0x02, 0x12, 0x01, 'a', 0x18, 0x01}; !bytes.Equal(b, expected) {
+		t.Errorf("expected protobuf wire format %x, got %x", expected, b)
+	}
+	var a A
+	if err := Unmarshal("proto", b, &a); err != nil || a != (A{1, "a", true}) {
+		t.Errorf("expected %v unmarshaled, got %v, %v", A{1, "a", true}, a, err)
+	}
+	if err := Unmarshal("proto", []byte{0x12, 0x05, 'a'}, &a); err != ErrProtoMalformed {
+		t.Errorf("expected truncated message rejected, got %v", err)
+	}
+
+	// gob and json go through their stream codec
+	for _, scheme := range []string{"gob", "json"} {
+		b, err := Marshal(scheme, A{2, "b", false})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a A
+		if err := Unmarshal(scheme, b, &a); err != nil || a != (A{2, "b", false}) {
+			t.Errorf("expected %s round trip of %v, got %v, %v", scheme, A{2, "b", false}, a, err)
+		}
+	}
+}
+
 func BenchmarkCodecGob(b *testing.B) {
 	gob.Register(A{})
 	var send interface{}
@@ -64,6 +140,7 @@ func BenchmarkCodecJSON(b *testing.B) {
 	var send interface{}
 	var recv interface{}
 
+	RegisterMessage(A{})
 	c := NewCodec("json", buf)
 
 	send = A{1, "a", true}
@@ -18,20 +18,91 @@ type Config struct {
 	Policy    string  `json:"policy"`    // leader change policy {consecutive, majority}
 	Threshold float64 `json:"threshold"` // threshold for policy in WPaxos {n consecutive or time interval in ms}
 
+	ZoneMin  map[int]int `json:"zone_min"` // min number of acks from each zone in phase 2 quorum
+	Priority map[ID]int  `json:"priority"` // leader preference of each node, higher is preferred, 0 if not listed
+	Learners []ID        `json:"learners"` // non-voting nodes that only learn committed commands, excluded from quorums and elections
+
 	Thrifty        bool    `json:"thrifty"`          // only send messages to a quorum
+	ThriftyTimeout int     `json:"thrifty_timeout"`  // broadcast to every node if thrifty quorum does not ack within timeout in ms
+	Q1Size         int     `json:"q1_size"`          // phase 1 quorum size, 0 is majority
+	Q2Size         int     `json:"q2_size"`          // phase 2 quorum size, 0 is majority
+	Lease          int     `json:"lease"`            // leader lease duration in ms, 0 disables lease
+	BackOff        int     `json:"backoff"`          // base delay in ms before retrying phase 1 after a failed attempt
+	MaxBackOff     int     `json:"max_backoff"`      // max delay in ms before retrying phase 1
+	MaxRetry       int     `json:"max_retry"`        // max number of times a request is retried with backoff after losing its slot, 0 is unlimited
+	Preemption     string  `json:"preemption"`       // handling of request that lost its slot, retry with backoff, fail back to client or fifo by arrival, retry if empty
+	Heartbeat      int     `json:"heartbeat"`        // leader heartbeat interval in ms, 0 disables heartbeat
+	Timeout        int     `json:"timeout"`          // follower starts phase 1 if leader is silent for timeout in ms, 0 disables
+	MaxTimeout     int     `json:"max_timeout"`      // follower waits random election timeout in [timeout, max_timeout] ms, 0 is twice timeout
+	PreVote        bool    `json:"pre_vote"`         // probe with pre-vote before raising ballot in phase 1
+	Grace          int     `json:"grace"`            // new leader ignores phase 1 without pre-vote for grace ms, 0 disables
+	MinElection    int     `json:"min_election"`     // min interval in ms between successive phase 1 broadcasts of a node, 0 disables
+	Fast           bool    `json:"fast"`             // every replica learns commit from a fast quorum of accepts in one round trip
+	FastTimeout    int     `json:"fast_timeout"`     // leader skips commit message of slot acked by a fast quorum without conflict within timeout in ms, 0 always sends it
+	BatchSize      int     `json:"batch_size"`       // max number of commands proposed in one slot
+	BatchInterval  int     `json:"batch_interval"`   // max time in ms a request waits for its batch to fill
+	AdaptiveBatch  bool    `json:"adaptive_batch"`   // tune batch size and interval to request rate, bounded by min and batch size and interval
+	MaxInflight    int     `json:"max_inflight"`     // max number of proposed but unexecuted slots, 0 is unlimited
+	MaxPending     int     `json:"max_pending"`      // max number of requests waiting for a leader, 0 is unlimited
+	RequestTimeout int     `json:"request_timeout"`  // request fails if not committed within timeout in ms, 0 waits forever
+	QuorumTimeout  int     `json:"quorum_timeout"`   // leader retries P2a of slot without phase 2 quorum after timeout in ms, 0 disables
+	QuorumRetries  int     `json:"quorum_retries"`   // number of P2a retries on quorum timeout before leader starts phase 1
+	MaxBacklog     int     `json:"max_backlog"`      // replica warns once committed but unexecuted slots exceed max backlog, 0 disables
+	Backfill       int     `json:"backfill"`         // max bytes per second of repair and snapshot replies served to recovering replicas, 0 is unlimited
+	SnapshotChunk  int     `json:"snapshot_chunk"`   // max bytes of state machine snapshot in one reply, 0 sends snapshot in one reply
+	Transfer       int     `json:"transfer_timeout"` // replica resends snapshot request if no reply or chunk arrives within timeout in ms, 0 disables
+	RepairTimeout  int     `json:"repair_timeout"`   // replica resends repair request of a slot not repaired within timeout in ms, 0 disables
+	Dedup          bool    `json:"dedup"`            // execute each client command at most once, requires increasing command id per client
+	SessionWindow  int     `json:"session_window"`   // slots a session of unregistered client is kept after its last command for dedup, 0 is log_window, registered clients are kept forever
+	Coalesce       bool    `json:"coalesce"`         // leader attaches write request to an identical command still in flight instead of proposing it again
+	Rejoin         bool    `json:"rejoin"`           // restarted or partitioned replica adopts ballot and catches up with a quorum before phase 1
+	Shadow         bool    `json:"shadow_mode"`      // replica handles every message but executes nothing and replies to no client, for replaying traffic
+	OutOfOrder     bool    `json:"out_of_order"`     // execute committed command once earlier commands of the same key and client are executed
+	Digest         int     `json:"digest"`           // interval in ms of exchanging digest of executed commands between replicas, 0 disables
+	FanOut         string  `json:"fan_out"`          // order of sending phase 2 messages to peers, zone or rtt nearest first, any order if empty
+	Compress       int     `json:"compress"`         // min size in bytes of command value compressed in phase 2 messages, 0 disables
+	QuorumRead     bool    `json:"quorum_read"`      // any replica serves linearizable read after asking a quorum for the highest accepted slot
+	Ephemeral      bool    `json:"ephemeral_leader"` // every replica handles client requests itself and starts phase 1, instead of redirecting to the known leader
+	Proxy          bool    `json:"proxy"`            // replica forwards client requests to the known leader and relays its reply, instead of redirecting the client there
+	ForwardTimeout int     `json:"forward_timeout"`  // replica handles forwarded request itself if leader is silent for timeout in ms, 0 waits forever
+	Tracing        bool    `json:"tracing"`          // log spans of requests carrying traceparent header as they pass every node
+	Codec          string  `json:"codec"`            // codec for message serialization between nodes, gob, json or proto, json and proto require messages registered by RegisterMessage
+	TLSCert        string  `json:"tls_cert"`         // certificate file of this node, empty disables tls between nodes
+	TLSKey         string  `json:"tls_key"`          // private key file of tls certificate
+	TLSCA          string  `json:"tls_ca"`           // ca file that signs certificates of every node
+	Retransmit     int     `json:"retransmit"`       // udp transport resends unacknowledged message after retransmit ms
 	BufferSize     int     `json:"buffer_size"`      // buffer size for maps
+	LogCapacity    int     `json:"log_capacity"`     // initial capacity of paxos log, 0 grows on demand
+	SyncInterval   int     `json:"sync_interval"`    // max delay in ms of group commit of wal records, 0 fsyncs every record
+	SyncBatch      int     `json:"sync_batch"`       // wal group commit once this many records are pending, 0 waits for sync_interval
+	Executors      int     `json:"executors"`        // goroutines applying committed commands, 0 applies on the message handling goroutine
 	ChanBufferSize int     `json:"chan_buffer_size"` // buffer size for channels
+	Record         string  `json:"record"`           // directory of files recording inbound phase 1 and phase 2 messages of each replica for replay, empty disables
+	MaxMessageSize int     `json:"max_message_size"` // max bytes of encoded message between nodes, larger ones are dropped and batches are split below it, 0 is unlimited
+	QueueSize      int     `json:"queue_size"`       // max number of messages from peers waiting for handlers, 0 is chan_buffer_size
+	QueuePolicy    string  `json:"queue_policy"`     // handling of message from peer while queue is full, block or drop the oldest, block if empty
+	SuspectAfter   int     `json:"suspect_after"`    // peer is suspected down after this many failed writes in a row, 0 disables
+	LogWindow      int     `json:"log_window"`       // number of executed log entries kept in memory, 0 keeps all
 	MultiVersion   bool    `json:"multiversion"`     // create multi-version database
+	LogFormat      string  `json:"log_format"`       // log format of text or json
 	Benchmark      Bconfig `json:"benchmark"`        // benchmark configuration
 
+	MinBatchSize     int `json:"min_batch_size"`     // min batch size of adaptive batching
+	MinBatchInterval int `json:"min_batch_interval"` // min batch interval in ms of adaptive batching
+
+	AdaptiveHeartbeat bool    `json:"adaptive_heartbeat"` // tune heartbeat to peer round trip time and election timeout to phi accrual of heartbeats
+	MinHeartbeat      int     `json:"min_heartbeat"`      // min heartbeat interval in ms of adaptive heartbeat
+	MinTimeout        int     `json:"min_timeout"`        // min election timeout in ms of adaptive failure detection
+	PhiThreshold      float64 `json:"phi_threshold"`      // suspicion level at which follower presumes the leader failed, 8 if 0
+
 	// for future implementation
 	// Batching bool `json:"batching"`
 	// Consistency string `json:"consistency"`
-	// Codec string `json:"codec"` // codec for message serialization between nodes
 
-	n   int         // total number of nodes
+	n   int         // total number of voting nodes
 	z   int         // total number of zones
 	npz map[int]int // nodes per zone
+	npr map[int]int // nodes per grid row, row i consists of node i of every zone
 }
 
 // Config is global configuration singleton generated by init() func below
@@ -56,9 +127,20 @@ func Simulation() {
 func MakeDefaultConfig() Config {
 	return Config{
 		Policy:         "consecutive",
+		Codec:          "gob",
+		LogFormat:      "text",
 		Threshold:      3,
 		BufferSize:     1024,
+		LogCapacity:    1024,
 		ChanBufferSize: 1024,
+		LogWindow:      1024,
+		BatchSize:      1,
+		BatchInterval:  1,
+		MinBatchSize:   1,
+		ThriftyTimeout: 100,
+		BackOff:        10,
+		Retransmit:     10,
+		MaxBackOff:     1000,
 		MultiVersion:   false,
 		Benchmark:      DefaultBConfig(),
 	}
@@ -73,7 +155,28 @@ func (c Config) IDs() []ID {
 	return ids
 }
 
-// N returns total number of nodes
+// Voters returns ids of nodes that vote in quorums, every node except learners
+func (c Config) Voters() []ID {
+	ids := make([]ID, 0)
+	for id := range c.Addrs {
+		if !c.IsLearner(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// IsLearner returns true if node id only learns committed commands without voting
+func (c Config) IsLearner(id ID) bool {
+	for _, l := range c.Learners {
+		if l == id {
+			return true
+		}
+	}
+	return false
+}
+
+// N returns total number of voting nodes
 func (c Config) N() int {
 	return c.n
 }
@@ -105,12 +208,95 @@ func (c *Config) Load() {
 		log.Fatal(err)
 	}
 
+	for _, id := range c.Learners {
+		if _, exists := c.Addrs[id]; !exists {
+			log.Fatalf("learner %s has no address", id)
+		}
+	}
 	c.npz = make(map[int]int)
+	c.npr = make(map[int]int)
 	for id := range c.Addrs {
+		if c.IsLearner(id) {
+			continue
+		}
 		c.n++
 		c.npz[id.Zone()]++
+		c.npr[id.Node()]++
 	}
 	c.z = len(c.npz)
+
+	q1, q2 := quorumSize(c.n, c.Q1Size), quorumSize(c.n, c.Q2Size)
+	if !Intersect(c.n, c.Q1Size, c.Q2Size) {
+		log.Fatalf("phase 1 quorum of %d and phase 2 quorum of %d do not intersect in %d nodes, sum of q1_size and q2_size must exceed %d and each is at most %d", q1, q2, c.n, c.n, c.n)
+	}
+	log.Infof("phase 1 quorum size %d, phase 2 quorum size %d of %d nodes", q1, q2, c.n)
+	if c.AdaptiveBatch && (c.MinBatchSize > c.BatchSize || c.MinBatchInterval > c.BatchInterval) {
+		log.Fatalf("min batch %d in %d ms exceeds batch %d in %d ms", c.MinBatchSize, c.MinBatchInterval, c.BatchSize, c.BatchInterval)
+	}
+	if c.Retransmit <= 0 {
+		log.Fatalf("retransmit %d must be positive", c.Retransmit)
+	}
+	if c.MaxTimeout > 0 && c.MaxTimeout < c.Timeout {
+		log.Fatalf("max_timeout %d is less than timeout %d", c.MaxTimeout, c.Timeout)
+	}
+	if c.AdaptiveHeartbeat && (c.MinHeartbeat > c.Heartbeat || c.MinTimeout > c.Timeout) {
+		log.Fatalf("min_heartbeat %d and min_timeout %d exceed heartbeat %d and timeout %d", c.MinHeartbeat, c.MinTimeout, c.Heartbeat, c.Timeout)
+	}
+	if c.PhiThreshold < 0 {
+		log.Fatalf("phi_threshold %g must not be negative", c.PhiThreshold)
+	}
+	if c.FanOut != "" && c.FanOut != "zone" && c.FanOut != "rtt" {
+		log.Fatalf("fan_out %q must be zone, rtt or empty", c.FanOut)
+	}
+	if c.Preemption != "" && c.Preemption != "retry" && c.Preemption != "fail" && c.Preemption != "fifo" {
+		log.Fatalf("preemption %q must be retry, fail, fifo or empty", c.Preemption)
+	}
+	if c.QuorumTimeout < 0 || c.QuorumRetries < 0 {
+		log.Fatalf("quorum_timeout %d and quorum_retries %d must not be negative", c.QuorumTimeout, c.QuorumRetries)
+	}
+	if c.MaxBacklog < 0 {
+		log.Fatalf("max_backlog %d must not be negative", c.MaxBacklog)
+	}
+	if c.MaxMessageSize < 0 {
+		log.Fatalf("max_message_size %d must not be negative", c.MaxMessageSize)
+	}
+	if c.FastTimeout < 0 {
+		log.Fatalf("fast_timeout %d must not be negative", c.FastTimeout)
+	}
+	if c.SessionWindow < 0 {
+		log.Fatalf("session_window %d must not be negative", c.SessionWindow)
+	}
+	if c.Transfer < 0 || c.RepairTimeout < 0 {
+		log.Fatalf("transfer_timeout %d and repair_timeout %d must not be negative", c.Transfer, c.RepairTimeout)
+	}
+	if c.Backfill < 0 || c.SnapshotChunk < 0 {
+		log.Fatalf("backfill %d and snapshot_chunk %d must not be negative", c.Backfill, c.SnapshotChunk)
+	}
+	if c.QueueSize < 0 {
+		log.Fatalf("queue_size %d must not be negative", c.QueueSize)
+	}
+	if c.SuspectAfter < 0 {
+		log.Fatalf("suspect_after %d must not be negative", c.SuspectAfter)
+	}
+	if c.QueuePolicy != "" && c.QueuePolicy != QueueBlock && c.QueuePolicy != QueueDrop {
+		log.Fatalf("queue_policy %q must be block, drop or empty", c.QueuePolicy)
+	}
+	if c.LogCapacity < 0 {
+		log.Fatalf("log_capacity %d must not be negative", c.LogCapacity)
+	}
+	for id, w := range c.Priority {
+		if _, exists := c.Addrs[id]; !exists {
+			log.Fatalf("priority %d of unknown node %s", w, id)
+		}
+		if w < 0 {
+			log.Fatalf("priority %d of node %s must not be negative", w, id)
+		}
+	}
+	for z, min := range c.ZoneMin {
+		if min > c.npz[z] {
+			log.Fatalf("zone_min %d of zone %d exceeds %d nodes in zone", min, z, c.npz[z])
+		}
+	}
 }
 
 // Save saves configuration to file in JSON format
@@ -2,9 +2,15 @@ package paxi
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"sync"
+
+	"github.com/ailidani/paxi/log"
 )
 
 // Key of key value database
@@ -19,6 +25,52 @@ type Command struct {
 	Value     Value
 	ClientID  ID
 	CommandID int
+	CAS       bool  // compare-and-swap, writes value only if current value of key equals expected
+	Expected  Value // value of key expected by compare-and-swap, empty if key must have no value
+	TTL       int   // number of slots after the one executing this write until its key expires, 0 never expires
+	Protocol  bool  // built by the protocol on a reserved key, requests of clients never have it
+}
+
+// ErrReservedKey is returned for requests of clients on a key reserved for protocol commands
+var ErrReservedKey = errors.New("key is reserved for protocol commands")
+
+// keys of protocol commands, which clients can neither read nor write
+var reserved = map[Key]bool{NoOpKey: true}
+
+// ReserveKey reserves key for protocol commands, e.g. reconfiguration,
+// it must be called before any node is created
+func ReserveKey(key Key) {
+	reserved[key] = true
+}
+
+// Reserved returns true if key is reserved for protocol commands
+func Reserved(key Key) bool {
+	return reserved[key]
+}
+
+// ErrCompareFailed is returned by compare-and-swap when current value differs from the expected value
+var ErrCompareFailed = errors.New("compare-and-swap failed, value differs from expected")
+
+// CompareAndSwap returns command that writes value to key only if current value of key equals expected
+func CompareAndSwap(key Key, expected, value Value) Command {
+	if value == nil {
+		// nil value would be taken for a read
+		value = Value{}
+	}
+	return Command{Key: key, Value: value, CAS: true, Expected: expected}
+}
+
+// NoOpKey is the reserved key of no-op command
+const NoOpKey Key = math.MinInt32
+
+// NoOp returns command that occupies a slot without changing the state machine
+func NoOp() Command {
+	return Command{Key: NoOpKey, Protocol: true}
+}
+
+// IsNoOp returns true if command is no-op, a command of client on NoOpKey is not
+func (c Command) IsNoOp() bool {
+	return c.Protocol && c.Key == NoOpKey
 }
 
 // Empty check if empty command
@@ -31,24 +83,78 @@ func (c Command) Empty() bool {
 
 // IsRead returns true if command is read
 func (c Command) IsRead() bool {
-	return c.Value == nil
+	return c.Value == nil && !c.IsNoOp()
 }
 
-// Equal returns true if two commands are equal
+// Equal returns true if two commands have the same key, value, client id, command id and ttl,
+// and the same expected value if compare-and-swap, every no-op equals each other. Fixed size fields are
+// compared before values, so commands of different clients or keys are told apart without reading their values.
 func (c Command) Equal(a Command) bool {
-	return c.Key == a.Key && bytes.Equal(c.Value, a.Value) && c.ClientID == a.ClientID && c.CommandID == a.CommandID
+	if c.IsNoOp() || a.IsNoOp() {
+		return c.IsNoOp() && a.IsNoOp()
+	}
+	return c.Key == a.Key && c.CommandID == a.CommandID && c.ClientID == a.ClientID && c.CAS == a.CAS && c.TTL == a.TTL &&
+		len(c.Value) == len(a.Value) && bytes.Equal(c.Value, a.Value) && (!c.CAS || bytes.Equal(c.Expected, a.Expected))
+}
+
+// Hash returns 64 bit FNV-1a hash of the fields compared by Equal, key, value, client id, command id,
+// expected value of compare-and-swap and ttl if any,
+// so equal commands have the same hash and commands of different hash are not equal.
+// Every no-op has the hash of NoOpKey alone.
+func (c Command) Hash() uint64 {
+	f := fnv.New64a()
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(c.Key))
+	f.Write(b)
+	if c.IsNoOp() {
+		return f.Sum64()
+	}
+	binary.BigEndian.PutUint64(b, uint64(len(c.Value)))
+	f.Write(b)
+	f.Write(c.Value)
+	f.Write([]byte(c.ClientID))
+	binary.BigEndian.PutUint64(b, uint64(c.CommandID))
+	f.Write(b)
+	if c.CAS {
+		binary.BigEndian.PutUint64(b, uint64(len(c.Expected)))
+		f.Write(b)
+		f.Write(c.Expected)
+	}
+	if c.TTL != 0 {
+		binary.BigEndian.PutUint64(b, uint64(c.TTL))
+		f.Write(b)
+	}
+	return f.Sum64()
 }
 
 func (c Command) String() string {
+	if c.IsNoOp() {
+		return "NoOp{}"
+	}
 	if c.Value == nil {
 		return fmt.Sprintf("Get{key=%v id=%s cid=%d}", c.Key, c.ClientID, c.CommandID)
 	}
+	if c.CAS {
+		return fmt.Sprintf("CAS{key=%v expected=%x value=%x id=%s cid=%d}", c.Key, c.Expected, c.Value, c.ClientID, c.CommandID)
+	}
+	if c.TTL > 0 {
+		return fmt.Sprintf("Put{key=%v value=%x ttl=%d id=%s cid=%d}", c.Key, c.Value, c.TTL, c.ClientID, c.CommandID)
+	}
 	return fmt.Sprintf("Put{key=%v value=%x id=%s cid=%d", c.Key, c.Value, c.ClientID, c.CommandID)
 }
 
-// Database defines a database interface
-// TODO replace with more general StateMachine interface
+// PutTTL returns command that writes value to key until ttl slots after the slot executing it
+func PutTTL(key Key, value Value, ttl int) Command {
+	if value == nil {
+		value = Value{}
+	}
+	return Command{Key: key, Value: value, TTL: ttl}
+}
+
+// Database defines a key-value database, which is the default StateMachine
 type Database interface {
+	StateMachine
+	Clocked
 	Execute(Command) Value
 	History(Key) []Value
 	Get(Key) Value
@@ -62,6 +168,16 @@ type database struct {
 	version      int
 	multiversion bool
 	history      map[Key][]Value
+
+	expiry map[Key]int // slot from which key with ttl has no value
+	now    int         // highest slot applied by ApplyAt, the logical time of Apply and Get
+}
+
+// state is snapshot of database with keys that expire, database without them snapshots data alone
+type state struct {
+	Data   map[Key]Value
+	Expiry map[Key]int
+	Now    int
 }
 
 // NewDatabase returns database that impelements Database interface
@@ -71,6 +187,7 @@ func NewDatabase() Database {
 		version:      0,
 		multiversion: config.MultiVersion,
 		history:      make(map[Key][]Value),
+		expiry:       make(map[Key]int),
 	}
 }
 
@@ -103,19 +220,67 @@ func (d *database) Execute(c Command) Value {
 	defer d.Unlock()
 
 	// get previous value
-	v := d.data[c.Key]
+	v := d.value(c.Key, d.now)
 
 	// writes new value
 	d.put(c.Key, c.Value)
+	if c.Value != nil {
+		delete(d.expiry, c.Key)
+	}
 
 	return v
 }
 
+// Apply implements StateMachine interface, writes value of c and returns previous value of its key,
+// at the logical time of the last command applied by ApplyAt.
+// Compare-and-swap that fails returns current value with ErrCompareFailed and writes nothing.
+func (d *database) Apply(c Command) (Value, error) {
+	d.Lock()
+	defer d.Unlock()
+	return d.apply(c, d.now)
+}
+
+// ApplyAt implements Clocked interface, applies c executed in slot s, where keys written s slots ago
+// with ttl of s or less have expired and read empty
+func (d *database) ApplyAt(c Command, s int) (Value, error) {
+	d.Lock()
+	defer d.Unlock()
+	if s > d.now {
+		d.now = s
+	}
+	return d.apply(c, s)
+}
+
+func (d *database) apply(c Command, now int) (Value, error) {
+	v := d.value(c.Key, now)
+	if c.IsRead() {
+		return v, nil
+	}
+	if c.CAS && !bytes.Equal(v, c.Expected) {
+		return v, ErrCompareFailed
+	}
+	d.put(c.Key, c.Value)
+	if c.TTL > 0 {
+		d.expiry[c.Key] = now + c.TTL
+	} else {
+		delete(d.expiry, c.Key)
+	}
+	return v, nil
+}
+
+// value returns value of key k at logical time now, nil if expired
+func (d *database) value(k Key, now int) Value {
+	if e, exists := d.expiry[k]; exists && now >= e {
+		return nil
+	}
+	return d.data[k]
+}
+
 // Get gets the current value and version of given key
 func (d *database) Get(k Key) Value {
 	d.RLock()
 	defer d.RUnlock()
-	return d.data[k]
+	return d.value(k, d.now)
 }
 
 func (d *database) put(k Key, v Value) {
@@ -136,6 +301,9 @@ func (d *database) Put(k Key, v Value) {
 	d.Lock()
 	defer d.Unlock()
 	d.put(k, v)
+	if v != nil {
+		delete(d.expiry, k)
+	}
 }
 
 // Version returns current version of given key
@@ -152,6 +320,54 @@ func (d *database) History(k Key) []Value {
 	return d.history[k]
 }
 
+// Snapshot returns the serialized current state of database, without keys expired by the last applied slot
+// since every later slot reads them empty
+func (d *database) Snapshot() Value {
+	d.RLock()
+	defer d.RUnlock()
+	var v interface{} = d.data
+	if len(d.expiry) > 0 {
+		s := state{Data: make(map[Key]Value, len(d.data)), Expiry: make(map[Key]int, len(d.expiry)), Now: d.now}
+		for k, value := range d.data {
+			if e, exists := d.expiry[k]; exists {
+				if d.now >= e {
+					continue
+				}
+				s.Expiry[k] = e
+			}
+			s.Data[k] = value
+		}
+		v = s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Error(err)
+	}
+	return b
+}
+
+// Restore replaces current state of database with the snapshot
+func (d *database) Restore(snapshot Value) {
+	s := state{Data: make(map[Key]Value), Expiry: make(map[Key]int)}
+	if err := json.Unmarshal(snapshot, &s.Data); err != nil {
+		// not plain data, snapshot of database with keys that expire
+		s.Data = nil
+		err = json.Unmarshal(snapshot, &s)
+		if err != nil || s.Data == nil {
+			log.Error("cannot restore snapshot: ", err)
+			return
+		}
+		if s.Expiry == nil {
+			s.Expiry = make(map[Key]int)
+		}
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.data = s.Data
+	d.expiry = s.Expiry
+	d.now = s.Now
+}
+
 func (d *database) String() string {
 	d.RLock()
 	defer d.RUnlock()
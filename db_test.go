@@ -0,0 +1,89 @@
+package paxi
+
+import (
+	"testing"
+)
+
+func TestCommandHash(t *testing.T) {
+	c := Command{Key: 1, Value: Value("v"), ClientID: "1.1", CommandID: 1}
+	same := Command{Key: 1, Value: Value("v"), ClientID: "1.1", CommandID: 1}
+	if !c.Equal(same) || c.Hash() != same.Hash() {
+		t.Errorf("expected %v and %v equal with the same hash", c, same)
+	}
+	for _, other := range []Command{
+		{Key: 2, Value: Value("v"), ClientID: "1.1", CommandID: 1},
+		{Key: 1, Value: Value("w"), ClientID: "1.1", CommandID: 1},
+		{Key: 1, Value: Value("vv"), ClientID: "1.1", CommandID: 1},
+		{Key: 1, Value: Value("v"), ClientID: "1.2", CommandID: 1},
+		{Key: 1, Value: Value("v"), ClientID: "1.1", CommandID: 2},
+		{Key: 1, ClientID: "1.1", CommandID: 1},
+	} {
+		if c.Equal(other) || c.Hash() == other.Hash() {
+			t.Errorf("expected %v and %v different", c, other)
+		}
+	}
+	noop := NoOp()
+	noop.CommandID = 1
+	if !noop.Equal(NoOp()) || noop.Hash() != NoOp().Hash() {
+		t.Error("expected every no-op equal with the same hash")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	db := NewDatabase()
+	if _, err := db.Apply(CompareAndSwap(1, nil, Value("a"))); err != nil {
+		t.Fatalf("expected swap of absent key, got %v", err)
+	}
+	v, err := db.Apply(CompareAndSwap(1, Value("b"), Value("c")))
+	if err != ErrCompareFailed || string(v) != "a" || string(db.Get(1)) != "a" {
+		t.Errorf("expected failed swap to return current value a, got %q %v, value %q", v, err, db.Get(1))
+	}
+	v, err = db.Apply(CompareAndSwap(1, Value("a"), Value("c")))
+	if err != nil || string(v) != "a" || string(db.Get(1)) != "c" {
+		t.Errorf("expected swap from a to c, got %q %v, value %q", v, err, db.Get(1))
+	}
+
+	put := Command{Key: 1, Value: Value("c")}
+	cas := CompareAndSwap(1, Value("a"), Value("c"))
+	if cas.Equal(put) || cas.Hash() == put.Hash() || cas.IsRead() {
+		t.Errorf("expected compare-and-swap %v to differ from put %v", cas, put)
+	}
+	if cas.Equal(CompareAndSwap(1, Value("b"), Value("c"))) {
+		t.Error("expected compare-and-swap of different expected values to differ")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	db := NewDatabase()
+	if _, err := db.ApplyAt(PutTTL(1, Value("a"), 2), 3); err != nil {
+		t.Fatal(err)
+	}
+	db.ApplyAt(Command{Key: 2, Value: Value("b")}, 4)
+	if v, _ := db.ApplyAt(Command{Key: 1}, 4); string(v) != "a" || string(db.Get(1)) != "a" {
+		t.Errorf("expected key read before expiry, got %q", v)
+	}
+	if v, _ := db.ApplyAt(Command{Key: 1}, 5); v != nil || db.Get(1) != nil {
+		t.Errorf("expected key expired in slot 5, got %q", v)
+	}
+	if _, err := db.ApplyAt(CompareAndSwap(1, nil, Value("c")), 6); err != nil {
+		t.Errorf("expected swap of expired key as absent, got %v", err)
+	}
+
+	// write without ttl keeps the value
+	db.ApplyAt(PutTTL(3, Value("d"), 1), 6)
+	db.ApplyAt(Command{Key: 3, Value: Value("e")}, 6)
+	db.ApplyAt(PutTTL(4, Value("f"), 1), 6)
+	db.ApplyAt(PutTTL(5, Value("g"), 10), 7)
+	restored := NewDatabase()
+	restored.Restore(db.Snapshot())
+	if string(restored.Get(3)) != "e" || restored.Get(4) != nil || string(restored.Get(5)) != "g" {
+		t.Errorf("expected snapshot without expired keys, got %v", restored)
+	}
+	if v, _ := restored.ApplyAt(Command{Key: 5}, 17); v != nil {
+		t.Errorf("expected restored key expired in slot 17, got %q", v)
+	}
+
+	if PutTTL(1, Value("a"), 1).Equal(PutTTL(1, Value("a"), 2)) || PutTTL(1, Value("a"), 1).Hash() == PutTTL(1, Value("a"), 2).Hash() {
+		t.Error("expected writes of different ttl to differ")
+	}
+}
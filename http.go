@@ -1,6 +1,7 @@
 package paxi
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -18,6 +19,14 @@ const (
 	HTTPCommandID = "Cid"
 	HTTPTimestamp = "Timestamp"
 	HTTPNodeID    = "Id"
+	HTTPDeadline  = "Deadline"
+	HTTPSession   = "Session"
+	HTTPBarrier   = "Barrier"
+	HTTPTrace     = "Traceparent"
+	HTTPPriority  = "Priority"
+	HTTPExpected  = "Expected" // base64 of value expected by compare-and-swap, empty if key must have no value
+	HTTPTTL       = "Ttl"      // number of slots until written key expires
+	HTTPLeader    = "Leader"   // id of the known leader in redirect reply, whose address is in Location
 )
 
 // serve serves the http REST API request from clients
@@ -27,6 +36,9 @@ func (n *node) http() {
 	mux.HandleFunc("/history", n.handleHistory)
 	mux.HandleFunc("/crash", n.handleCrash)
 	mux.HandleFunc("/drop", n.handleDrop)
+	for pattern, handler := range n.endpoints {
+		mux.HandleFunc(pattern, handler)
+	}
 	// http string should be in form of ":8080"
 	url, err := url.Parse(config.HTTPAddrs[n.id])
 	if err != nil {
@@ -60,6 +72,61 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 			}
 			continue
 		}
+		if k == HTTPDeadline {
+			req.Deadline, err = strconv.ParseInt(r.Header.Get(HTTPDeadline), 10, 64)
+			if err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		if k == HTTPSession {
+			req.Session, err = strconv.Atoi(r.Header.Get(HTTPSession))
+			if err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		if k == HTTPBarrier {
+			req.Barrier, err = strconv.ParseBool(r.Header.Get(HTTPBarrier))
+			if err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		if k == HTTPPriority {
+			req.Priority, err = strconv.Atoi(r.Header.Get(HTTPPriority))
+			if err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		if k == HTTPExpected {
+			cmd.CAS = true
+			cmd.Expected, err = base64.StdEncoding.DecodeString(r.Header.Get(HTTPExpected))
+			if err != nil {
+				http.Error(w, "invalid expected value", http.StatusBadRequest)
+				log.Error(err)
+				return
+			}
+			continue
+		}
+		if k == HTTPTTL {
+			cmd.TTL, err = strconv.Atoi(r.Header.Get(HTTPTTL))
+			if err != nil || cmd.TTL < 0 {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+		if k == HTTPTrace {
+			if config.Tracing {
+				req.TraceID, err = ParseTraceParent(r.Header.Get(HTTPTrace))
+				if err != nil {
+					log.Error(err)
+				}
+			}
+			continue
+		}
 		req.Properties[k] = r.Header.Get(k)
 	}
 
@@ -81,6 +148,10 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 			}
 			cmd.Value = Value(body)
 		}
+		if cmd.CAS && cmd.Value == nil {
+			http.Error(w, "compare-and-swap requires PUT or POST", http.StatusBadRequest)
+			return
+		}
 	} else {
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
@@ -90,6 +161,10 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 		}
 		json.Unmarshal(body, &cmd)
 	}
+	if Reserved(cmd.Key) || cmd.Protocol {
+		http.Error(w, ErrReservedKey.Error(), http.StatusBadRequest)
+		return
+	}
 
 	req.Command = cmd
 	req.Timestamp = time.Now().UnixNano()
@@ -99,18 +174,55 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 	n.MessageChan <- req
 
 	reply := <-req.c
+	if req.TraceID != "" {
+		span := Span{
+			TraceID:    req.TraceID,
+			SpanID:     NewSpanID(),
+			Name:       "request",
+			Start:      time.Unix(0, req.Timestamp),
+			End:        time.Now(),
+			Attributes: map[string]interface{}{"node": n.id, "key": cmd.Key},
+		}
+		span.Export()
+		w.Header().Set(HTTPTrace, TraceParent(span.TraceID, span.SpanID))
+	}
 
 	if reply.Err != nil {
 		http.Error(w, reply.Err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if reply.Leader != "" {
+		// client retries at the leader, which keeps the method, body and headers of this request
+		w.Header().Set(HTTPLeader, string(reply.Leader))
+		for k, v := range reply.Properties {
+			w.Header().Set(k, v)
+		}
+		if addr, exists := config.HTTPAddrs[reply.Leader]; exists {
+			w.Header().Set("Location", addr+r.URL.RequestURI())
+		}
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
 	// set all http headers
 	w.Header().Set(HTTPClientID, string(reply.Command.ClientID))
 	w.Header().Set(HTTPCommandID, strconv.Itoa(reply.Command.CommandID))
+	if reply.Session > 0 {
+		w.Header().Set(HTTPSession, strconv.Itoa(reply.Session))
+	}
 	for k, v := range reply.Properties {
 		w.Header().Set(k, v)
 	}
+	if reply.Status != "" {
+		// command is committed but rejected by state machine
+		w.WriteHeader(http.StatusConflict)
+		_, err = io.WriteString(w, reply.Status)
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	}
 
 	_, err = io.WriteString(w, string(reply.Value))
 	if err != nil {
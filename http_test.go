@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 )
@@ -66,3 +67,35 @@ func TestHTTP(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRedirect(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		io.WriteString(w, string(b))
+	}))
+	defer leader.Close()
+	redirects := 0
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirects++
+		w.Header().Set(HTTPLeader, "1.2")
+		w.Header().Set("Location", leader.URL+r.URL.RequestURI())
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer follower.Close()
+
+	c := NewHTTPClient("1.1")
+	c.HTTP = map[ID]string{"1.1": follower.URL, "1.2": leader.URL}
+	v, _, err := c.rest(c.ID, 1, Value("v"), nil)
+	if err != nil || string(v) != "v" || c.Leader != "1.2" {
+		t.Fatalf("expected put retried at leader 1.2, got %q leader %q: %v", v, c.Leader, err)
+	}
+	if _, _, err := c.rest(c.ID, 1, Value("w"), nil); err != nil || redirects != 1 {
+		t.Errorf("expected next request sent to leader directly, %d redirects: %v", redirects, err)
+	}
+
+	// leader that fails sends the client back to its own node
+	leader.Close()
+	if _, _, err := c.rest(c.ID, 1, Value("x"), nil); err == nil || c.Leader != "" {
+		t.Errorf("expected failed leader forgotten, leader %q: %v", c.Leader, err)
+	}
+}
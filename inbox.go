@@ -0,0 +1,55 @@
+package paxi
+
+import (
+	"sync/atomic"
+)
+
+// queue policies when inbox is full
+const (
+	QueueBlock = "block" // receiver waits for room, which stalls the sending peer through transport
+	QueueDrop  = "drop"  // oldest queued message is dropped as if lost by the network
+)
+
+// Inbox is implemented by node keeping messages from peers in a bounded queue until they are handled
+type Inbox interface {
+	// Depth returns number of messages waiting for handlers
+	Depth() int
+
+	// Dropped returns number of messages dropped because queue is full
+	Dropped() int
+}
+
+// inbox is bounded queue of protocol messages from peers
+type inbox struct {
+	c       chan interface{}
+	drop    bool
+	dropped int64
+}
+
+func newInbox(size int, policy string) *inbox {
+	return &inbox{
+		c:    make(chan interface{}, size),
+		drop: policy == QueueDrop,
+	}
+}
+
+// push queues m, blocking or dropping the oldest message while queue is full
+func (q *inbox) push(m interface{}) {
+	if !q.drop {
+		q.c <- m
+		return
+	}
+	for {
+		select {
+		case q.c <- m:
+			return
+		default:
+		}
+		select {
+		case <-q.c:
+			atomic.AddInt64(&q.dropped, 1)
+		default:
+			// handler took one meanwhile
+		}
+	}
+}
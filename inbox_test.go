@@ -0,0 +1,39 @@
+package paxi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInboxDrop(t *testing.T) {
+	q := newInbox(2, QueueDrop)
+	for i := 0; i < 5; i++ {
+		q.push(i)
+	}
+	if q.dropped != 3 || len(q.c) != 2 {
+		t.Fatalf("expected 3 dropped and 2 queued, got %d and %d", q.dropped, len(q.c))
+	}
+	if m := <-q.c; m != 3 {
+		t.Errorf("expected oldest messages dropped, got %v first", m)
+	}
+}
+
+func TestInboxBlock(t *testing.T) {
+	q := newInbox(1, QueueBlock)
+	q.push(0)
+	done := make(chan bool)
+	go func() {
+		q.push(1)
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected push to full queue to block")
+	case <-time.After(10 * time.Millisecond):
+	}
+	<-q.c
+	<-done
+	if q.dropped != 0 || len(q.c) != 1 {
+		t.Errorf("expected no message dropped, got %d dropped and %d queued", q.dropped, len(q.c))
+	}
+}
@@ -12,5 +12,6 @@ func Init() {
 	flag.Parse()
 	log.Setup()
 	config.Load()
+	log.SetFormat(config.LogFormat)
 	http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost = 1000
 }
@@ -0,0 +1,67 @@
+package paxi
+
+import (
+	"sync/atomic"
+)
+
+// Link is sending statistic of messages from this node to one peer
+type Link struct {
+	Sent      int    // messages handed to transport
+	Failed    int    // messages transport failed to write
+	LastError string // last write error, empty if none
+	Suspected bool   // last suspect_after writes in a row failed, cleared by the next successful write
+}
+
+// Links is implemented by node reporting sending statistic to each peer
+type Links interface {
+	// Links returns statistic of every peer, nil if transport keeps none
+	Links() map[ID]Link
+}
+
+// link counts messages sent through one transport with atomics so that senders never wait for readers
+type link struct {
+	sent   int64
+	failed int64
+	streak int64        // consecutive failed writes
+	last   atomic.Value // error string of last failed write
+}
+
+// ok records a successful write
+func (l *link) ok() {
+	if atomic.LoadInt64(&l.streak) != 0 {
+		atomic.StoreInt64(&l.streak, 0)
+	}
+}
+
+// fail records a failed write with its error
+func (l *link) fail(err error) {
+	atomic.AddInt64(&l.failed, 1)
+	atomic.AddInt64(&l.streak, 1)
+	l.last.Store(err.Error())
+}
+
+func (l *link) stat() Link {
+	last, _ := l.last.Load().(string)
+	return Link{
+		Sent:      int(atomic.LoadInt64(&l.sent)),
+		Failed:    int(atomic.LoadInt64(&l.failed)),
+		LastError: last,
+		Suspected: config.SuspectAfter > 0 && atomic.LoadInt64(&l.streak) >= int64(config.SuspectAfter),
+	}
+}
+
+// counted is implemented by transports keeping link statistic
+type counted interface {
+	stat() Link
+}
+
+// Links returns sending statistic of transport to every peer
+func (s *socket) Links() map[ID]Link {
+	links := make(map[ID]Link, len(s.nodes))
+	for id, t := range s.nodes {
+		if c, ok := t.(counted); ok && id != s.id {
+			links[id] = c.stat()
+		}
+	}
+	return links
+}
@@ -0,0 +1,27 @@
+package paxi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	suspect := config.SuspectAfter
+	defer func() { config.SuspectAfter = suspect }()
+	config.SuspectAfter = 2
+
+	var l link
+	l.sent = 3
+	l.fail(errors.New("broken pipe"))
+	if s := l.stat(); s.Failed != 1 || s.LastError != "broken pipe" || s.Suspected {
+		t.Fatalf("expected one failure not yet suspected, got %+v", s)
+	}
+	l.fail(errors.New("connection reset"))
+	if s := l.stat(); s.Sent != 3 || s.Failed != 2 || s.LastError != "connection reset" || !s.Suspected {
+		t.Fatalf("expected peer suspected after 2 failures in a row, got %+v", s)
+	}
+	l.ok()
+	if s := l.stat(); s.Failed != 2 || s.Suspected {
+		t.Errorf("expected suspicion cleared by successful write, got %+v", s)
+	}
+}
@@ -0,0 +1,106 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	stdlog "log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fields are key value pairs of one structured log entry, e.g. node id, ballot, slot and message type
+type Fields map[string]interface{}
+
+// jsonFormat writes every log entry as one json object per line instead of human readable text
+var jsonFormat bool
+
+// SetFormat selects log format of "json" or "text", text is used for any other value
+func SetFormat(format string) {
+	jsonFormat = format == "json"
+}
+
+func Debugw(msg string, fields Fields) {
+	if log.severity == DEBUG {
+		log.output(DEBUG, msg, fields)
+	}
+}
+
+func Infow(msg string, fields Fields) {
+	if log.severity <= INFO {
+		log.output(INFO, msg, fields)
+	}
+}
+
+func Warningw(msg string, fields Fields) {
+	if log.severity <= WARNING {
+		log.output(WARNING, msg, fields)
+	}
+}
+
+func Errorw(msg string, fields Fields) {
+	log.output(ERROR, msg, fields)
+}
+
+func (l *logger) std(s severity) *stdlog.Logger {
+	switch s {
+	case DEBUG:
+		return l.debug
+	case INFO:
+		return l.info
+	case WARNING:
+		return l.warning
+	default:
+		return l.err
+	}
+}
+
+// output writes entry of severity s called from public log function
+func (l *logger) output(s severity, msg string, fields Fields) {
+	std := l.std(s)
+	if !jsonFormat {
+		std.Output(3, text(msg, fields))
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		if s, ok := v.(fmt.Stringer); ok {
+			v = s.String()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = names[s]
+	entry["msg"] = msg
+	if _, file, line, ok := runtime.Caller(2); ok {
+		entry["file"] = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(map[string]string{"level": names[s], "msg": msg, "error": err.Error()})
+	}
+	l.Lock()
+	std.Writer().Write(append(b, '\n'))
+	l.Unlock()
+}
+
+// text formats message followed by fields sorted by key
+func text(msg string, fields Fields) string {
+	if len(fields) == 0 {
+		return msg
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	return b.String()
+}
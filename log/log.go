@@ -127,54 +127,54 @@ func Setup() {
 
 func Debug(v ...interface{}) {
 	if log.severity == DEBUG {
-		log.debug.Output(2, fmt.Sprint(v...))
+		log.output(DEBUG, fmt.Sprint(v...), nil)
 	}
 }
 
 func Debugf(format string, v ...interface{}) {
 	if log.severity == DEBUG {
-		log.debug.Output(2, fmt.Sprintf(format, v...))
+		log.output(DEBUG, fmt.Sprintf(format, v...), nil)
 	}
 }
 
 func Info(v ...interface{}) {
 	if log.severity <= INFO {
-		log.info.Output(2, fmt.Sprint(v...))
+		log.output(INFO, fmt.Sprint(v...), nil)
 	}
 }
 
 func Infof(format string, v ...interface{}) {
 	if log.severity <= INFO {
-		log.info.Output(2, fmt.Sprintf(format, v...))
+		log.output(INFO, fmt.Sprintf(format, v...), nil)
 	}
 }
 
 func Warning(v ...interface{}) {
 	if log.severity <= WARNING {
-		log.warning.Output(2, fmt.Sprint(v...))
+		log.output(WARNING, fmt.Sprint(v...), nil)
 	}
 }
 
 func Warningf(format string, v ...interface{}) {
 	if log.severity <= WARNING {
-		log.warning.Output(2, fmt.Sprintf(format, v...))
+		log.output(WARNING, fmt.Sprintf(format, v...), nil)
 	}
 }
 
 func Error(v ...interface{}) {
-	log.err.Output(2, fmt.Sprint(v...))
+	log.output(ERROR, fmt.Sprint(v...), nil)
 }
 
 func Errorf(format string, v ...interface{}) {
-	log.err.Output(2, fmt.Sprintf(format, v...))
+	log.output(ERROR, fmt.Sprintf(format, v...), nil)
 }
 
 func Fatal(v ...interface{}) {
-	log.err.Output(2, fmt.Sprint(v...))
+	log.output(ERROR, fmt.Sprint(v...), nil)
 	stdlog.Fatal(v...)
 }
 
 func Fatalf(format string, v ...interface{}) {
-	log.err.Output(2, fmt.Sprintf(format, v...))
+	log.output(ERROR, fmt.Sprintf(format, v...), nil)
 	stdlog.Fatalf(format, v...)
 }
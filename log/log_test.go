@@ -0,0 +1,39 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	stdlog "log"
+	"strings"
+	"testing"
+)
+
+func TestFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	saved := log.info
+	defer func() {
+		log.info = saved
+		SetFormat("text")
+	}()
+	log.info = stdlog.New(buf, "[INFO] ", 0)
+
+	Infow("commit", Fields{"slot": 3, "id": "1.1"})
+	if line := buf.String(); line != "[INFO] commit id=1.1 slot=3\n" {
+		t.Errorf("unexpected text entry %q", line)
+	}
+
+	buf.Reset()
+	SetFormat("json")
+	Infow("commit", Fields{"slot": 3, "id": "1.1"})
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "commit" || entry["level"] != "INFO" || entry["slot"] != 3.0 || entry["id"] != "1.1" {
+		t.Errorf("unexpected json entry %v", entry)
+	}
+	if !strings.HasPrefix(entry["file"].(string), "log_test.go:") {
+		t.Errorf("expected caller file, got %v", entry["file"])
+	}
+}
@@ -1,19 +1,19 @@
 package paxi
 
 import (
-	"encoding/gob"
 	"fmt"
+	"time"
 )
 
 func init() {
-	gob.Register(Request{})
-	gob.Register(Reply{})
-	gob.Register(Read{})
-	gob.Register(ReadReply{})
-	gob.Register(Transaction{})
-	gob.Register(TransactionReply{})
-	gob.Register(Register{})
-	gob.Register(Config{})
+	RegisterMessage(Request{})
+	RegisterMessage(Reply{})
+	RegisterMessage(Read{})
+	RegisterMessage(ReadReply{})
+	RegisterMessage(Transaction{})
+	RegisterMessage(TransactionReply{})
+	RegisterMessage(Register{})
+	RegisterMessage(Config{})
 }
 
 /***************************
@@ -25,13 +25,42 @@ type Request struct {
 	Command    Command
 	Properties map[string]string
 	Timestamp  int64
+	Deadline   int64      // unix nano time after which request fails with timeout, 0 waits forever
+	Retries    int        // number of times request lost its slot to other commands
+	Session    int        // every slot before session is executed before request is served, 0 has no constraint
+	Barrier    bool       // read is served once every slot of the leader at its arrival is executed
+	TraceID    string     // trace of request whose handling is logged as spans when tracing, empty if not traced
+	Priority   int        // pending requests of higher priority are proposed first, positive priority skips batch wait
 	NodeID     ID         // forward by node
 	c          chan Reply // reply channel created by request receiver
 }
 
+// NewRequest returns request of command received by an endpoint of the protocol, whose reply is waited by Wait
+func NewRequest(cmd Command, nid ID) Request {
+	return Request{
+		Command:   cmd,
+		Timestamp: time.Now().UnixNano(),
+		NodeID:    nid,
+		c:         make(chan Reply, 1),
+	}
+}
+
+// Wait blocks until request created by NewRequest is replied
+func (r Request) Wait() Reply {
+	return <-r.c
+}
+
 // Reply replies to current client session
+// reply is dropped if no client is waiting on this request
 func (r *Request) Reply(reply Reply) {
-	r.c <- reply
+	if r.c == nil {
+		return
+	}
+	select {
+	case r.c <- reply:
+	default:
+		// client is already replied, e.g. by both the leader and this node after forward timeout
+	}
 }
 
 func (r Request) String() string {
@@ -44,11 +73,16 @@ type Reply struct {
 	Value      Value
 	Properties map[string]string
 	Timestamp  int64
+	Slot       int    // slot of the log the command is committed in, by protocols that order commands in a log
+	Session    int    // every slot before session includes the writes of the client so far, echoed in next requests
+	TraceID    string // trace of the request, empty if not traced
+	Status     string // error of state machine rejecting the command, empty if applied
+	Leader     ID     // known leader that client retries the command at, set by node that does not serve it
 	Err        error
 }
 
 func (r Reply) String() string {
-	return fmt.Sprintf("Reply {cmd=%v value=%x prop=%v}", r.Command, r.Value, r.Properties)
+	return fmt.Sprintf("Reply {cmd=%v value=%x slot=%d prop=%v status=%q leader=%s}", r.Command, r.Value, r.Slot, r.Properties, r.Status, r.Leader)
 }
 
 // Read can be used as a special request that directly read the value of key without go through replication protocol in Replica
@@ -81,7 +115,11 @@ type Transaction struct {
 }
 
 // Reply replies to current client session
+// reply is dropped if no client is waiting on this transaction
 func (t *Transaction) Reply(r TransactionReply) {
+	if t.c == nil {
+		return
+	}
 	t.c <- r
 }
 
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ailidani/paxi/log"
 )
@@ -18,6 +20,12 @@ type Node interface {
 	Retry(r Request)
 	Forward(id ID, r Request)
 	Register(m interface{}, f interface{})
+
+	// After calls f in the message handling goroutine once duration d elapsed
+	After(d time.Duration, f func())
+
+	// HandleHTTP adds handler of pattern to http server of the node, must be called before Run
+	HandleHTTP(pattern string, handler http.HandlerFunc)
 }
 
 // node implements Node interface
@@ -27,8 +35,10 @@ type node struct {
 	Socket
 	Database
 	MessageChan chan interface{}
+	inbox       *inbox // messages from peers, client requests and timers go to MessageChan
 	handles     map[string]reflect.Value
 	server      *http.Server
+	endpoints   map[string]http.HandlerFunc
 
 	sync.RWMutex
 	forwards map[string]*Request
@@ -41,7 +51,9 @@ func NewNode(id ID) Node {
 		Socket:      NewSocket(id, config.Addrs),
 		Database:    NewDatabase(),
 		MessageChan: make(chan interface{}, config.ChanBufferSize),
+		inbox:       newInbox(queueSize(), config.QueuePolicy),
 		handles:     make(map[string]reflect.Value),
+		endpoints:   make(map[string]http.HandlerFunc),
 		forwards:    make(map[string]*Request),
 	}
 }
@@ -50,11 +62,44 @@ func (n *node) ID() ID {
 	return n.id
 }
 
+// queueSize returns capacity of inbox, chan_buffer_size if queue_size is not set
+func queueSize() int {
+	if config.QueueSize > 0 {
+		return config.QueueSize
+	}
+	return config.ChanBufferSize
+}
+
+func (n *node) Depth() int {
+	return len(n.inbox.c)
+}
+
+func (n *node) Dropped() int {
+	return int(atomic.LoadInt64(&n.inbox.dropped))
+}
+
+func (n *node) Links() map[ID]Link {
+	if l, ok := n.Socket.(Links); ok {
+		return l.Links()
+	}
+	return nil
+}
+
 func (n *node) Retry(r Request) {
 	log.Debugf("node %v retry reqeust %v", n.id, r)
 	n.MessageChan <- r
 }
 
+func (n *node) After(d time.Duration, f func()) {
+	time.AfterFunc(d, func() {
+		n.MessageChan <- f
+	})
+}
+
+func (n *node) HandleHTTP(pattern string, handler http.HandlerFunc) {
+	n.endpoints[pattern] = handler
+}
+
 // Register a handle function for each message type
 func (n *node) Register(m interface{}, f interface{}) {
 	t := reflect.TypeOf(m)
@@ -75,7 +120,7 @@ func (n *node) Run() {
 	n.http()
 }
 
-// recv receives messages from socket and pass to message channel
+// recv receives messages from socket and pass client requests to message channel and others to inbox
 func (n *node) recv() {
 	for {
 		m := n.Recv()
@@ -90,20 +135,30 @@ func (n *node) recv() {
 
 		case Reply:
 			n.RLock()
-			r := n.forwards[m.Command.String()]
+			r, exists := n.forwards[m.Command.String()]
 			log.Debugf("node %v received reply %v", n.id, m)
 			n.RUnlock()
-			r.Reply(m)
+			if exists {
+				r.Reply(m)
+			}
 			continue
 		}
-		n.MessageChan <- m
+		n.inbox.push(m)
 	}
 }
 
-// handle receives messages from message channel and calls handle function using refection
+// handle receives messages from message channel and inbox and calls handle function using refection
 func (n *node) handle() {
 	for {
-		msg := <-n.MessageChan
+		var msg interface{}
+		select {
+		case msg = <-n.MessageChan:
+		case msg = <-n.inbox.c:
+		}
+		if f, ok := msg.(func()); ok {
+			f()
+			continue
+		}
 		v := reflect.ValueOf(msg)
 		name := v.Type().String()
 		f, exists := n.handles[name]
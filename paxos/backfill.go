@@ -0,0 +1,150 @@
+package paxos
+
+import (
+	"time"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// bytes counted for each command besides its value when pacing backfill
+const commandOverhead = 16
+
+// backfill sends repair reply or snapshot chunk m of size bytes to recovering replica id,
+// paced to at most Backfill bytes per second so that recovery leaves room for live traffic.
+// Messages are sent in order, each one waits until the earlier ones are paid for.
+func (p *Paxos) backfill(id paxi.ID, m interface{}, size int) {
+	p.metrics.backfilled(size)
+	if p.Backfill <= 0 {
+		p.Send(id, m)
+		return
+	}
+	now := p.Clock.Now()
+	if p.paced.Before(now) {
+		p.paced = now
+	}
+	wait := p.paced.Sub(now)
+	p.paced = p.paced.Add(time.Duration(size) * time.Second / time.Duration(p.Backfill))
+	if wait <= 0 {
+		p.Send(id, m)
+		return
+	}
+	p.After(wait, func() { p.Send(id, m) })
+}
+
+// footprint returns number of bytes commands take when pacing backfill
+func footprint(commands []paxi.Command) int {
+	n := 0
+	for _, c := range commands {
+		n += len(c.Value) + len(c.Expected) + commandOverhead
+	}
+	return n
+}
+
+// sessionSize returns number of bytes session s of client id takes in a snapshot reply
+func sessionSize(id paxi.ID, s session) int {
+	return len(id) + len(s.Value) + len(s.Status) + commandOverhead
+}
+
+// chunks splits snapshot m into replies of at most SnapshotChunk bytes of state, and of at most MaxMessageSize
+// bytes in total if configured. Executed records and sessions follow the state in the last chunk,
+// and in chunks without state after it once it is full.
+func (p *Paxos) chunks(m SnapshotReply) []SnapshotReply {
+	size := len(m.State)
+	for _, r := range m.Executed {
+		size += footprint(r.Commands)
+	}
+	for id, s := range m.Sessions {
+		size += sessionSize(id, s)
+	}
+	fits := p.MaxMessageSize <= 0 || size+messageOverhead <= p.MaxMessageSize
+	if len(m.State) == 0 || (p.SnapshotChunk <= 0 || len(m.State) <= p.SnapshotChunk) && fits {
+		return []SnapshotReply{m}
+	}
+	max := p.MaxMessageSize - messageOverhead
+	limit := p.SnapshotChunk
+	if p.MaxMessageSize > 0 && (limit <= 0 || limit > max) {
+		limit = max
+	}
+	total := len(m.State)
+	header := SnapshotReply{
+		Ballot:  m.Ballot,
+		Slot:    m.Slot,
+		Members: m.Members,
+		Sizes:   m.Sizes,
+		Hash:    m.Hash,
+		Total:   total,
+		Records: len(m.Executed),
+		Clients: len(m.Sessions),
+	}
+	replies := make([]SnapshotReply, 0, total/limit+1)
+	for offset := 0; offset < total; offset += limit {
+		end := offset + limit
+		if end > total {
+			end = total
+		}
+		c := header
+		c.State, c.Offset = m.State[offset:end], offset
+		replies = append(replies, c)
+	}
+
+	c := &replies[len(replies)-1]
+	room := max - len(c.State)
+	// next returns the chunk with room for n more bytes, a chunk without state is added once the last one is full
+	next := func(n int) *SnapshotReply {
+		if p.MaxMessageSize > 0 && n > room && (len(c.State) > 0 || len(c.Executed) > 0 || len(c.Sessions) > 0) {
+			trailer := header
+			trailer.Offset = total
+			replies = append(replies, trailer)
+			c, room = &replies[len(replies)-1], max
+		}
+		room -= n
+		return c
+	}
+	for _, r := range m.Executed {
+		chunk := next(footprint(r.Commands))
+		chunk.Executed = append(chunk.Executed, r)
+	}
+	for id, s := range m.Sessions {
+		chunk := next(sessionSize(id, s))
+		if chunk.Sessions == nil {
+			chunk.Sessions = make(map[paxi.ID]session)
+		}
+		chunk.Sessions[id] = s
+	}
+	return replies
+}
+
+// assemble appends state, executed records and sessions of chunk m to the snapshot being received,
+// returns the whole snapshot once its last chunk arrives
+func (p *Paxos) assemble(m SnapshotReply) (SnapshotReply, bool) {
+	if m.Offset == 0 {
+		// a new snapshot replaces the partial one
+		p.partial = make(paxi.Value, 0, m.Total)
+		p.chunked = m.Slot
+		p.trailer = SnapshotReply{Sessions: make(map[paxi.ID]session, m.Clients)}
+	}
+	if m.Slot != p.chunked || m.Offset != len(p.partial) {
+		log.Warningw("snapshot chunk out of order", log.Fields{"id": p.ID(), "slot": m.Slot, "offset": m.Offset, "have": len(p.partial)})
+		p.partial = nil
+		// request snapshot again on next catchup
+		p.transfer = false
+		return m, false
+	}
+	p.progress = p.Clock.Now()
+	p.partial = append(p.partial, m.State...)
+	p.trailer.Executed = append(p.trailer.Executed, m.Executed...)
+	for id, s := range m.Sessions {
+		p.trailer.Sessions[id] = s
+	}
+	if len(p.partial) < m.Total || len(p.trailer.Executed) < m.Records || len(p.trailer.Sessions) < m.Clients {
+		return m, false
+	}
+	m.State = p.partial
+	m.Executed = p.trailer.Executed
+	m.Sessions = p.trailer.Sessions
+	m.Offset, m.Total, m.Records, m.Clients = 0, 0, 0, 0
+	p.partial = nil
+	p.trailer = SnapshotReply{}
+	return m, true
+}
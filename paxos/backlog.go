@@ -0,0 +1,32 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi/log"
+)
+
+// Backlog returns number of slots from execute up to the highest committed slot,
+// which grows while execution is blocked on a slot not committed or missing here.
+// It reads the log thus must be called from a handler, others read it from Status or Metrics.
+func (p *Paxos) Backlog() int {
+	for s := p.slot; s >= p.execute; s-- {
+		if e, exists := p.log[s]; exists && e.commit {
+			return s - p.execute + 1
+		}
+	}
+	return 0
+}
+
+// backlogged records backlog in metrics and warns once it grows beyond MaxBacklog
+func (p *Paxos) backlogged() {
+	n := p.Backlog()
+	p.metrics.Lock()
+	p.metrics.backlog = n
+	p.metrics.Unlock()
+	if p.MaxBacklog <= 0 {
+		return
+	}
+	if n > p.MaxBacklog && !p.stuck {
+		log.Warningw("execution backlog", log.Fields{"id": p.ID(), "execute": p.execute, "backlog": n, "max": p.MaxBacklog})
+	}
+	p.stuck = n > p.MaxBacklog
+}
@@ -0,0 +1,90 @@
+package paxos
+
+import (
+	"time"
+
+	"github.com/ailidani/paxi"
+)
+
+// weight of the latest inter-arrival time in its moving average
+const weight = 0.2
+
+// batcher tunes batch size and interval between min and max to recent request arrival rate
+type batcher struct {
+	min, max                 int
+	minInterval, maxInterval time.Duration
+
+	last     time.Time     // arrival time of last request
+	gap      time.Duration // moving average of request inter-arrival time
+	size     int
+	interval time.Duration
+}
+
+func newBatcher(p *Paxos) batcher {
+	b := batcher{
+		min:         paxi.Max(p.MinBatchSize, 1),
+		max:         paxi.Max(p.BatchSize, 1),
+		minInterval: p.MinBatchInterval,
+		maxInterval: p.BatchInterval,
+	}
+	b.size = b.min
+	b.interval = b.minInterval
+	return b
+}
+
+// tune updates arrival rate with request received at now,
+// batch size is the number of requests expected within max interval, as long as it takes to fill the batch
+// and no wait at all while there is no inflight slot to overlap with
+func (p *Paxos) tune(now time.Time) {
+	b := &p.batcher
+	if !b.last.IsZero() {
+		gap := now.Sub(b.last)
+		if b.gap == 0 {
+			b.gap = gap
+		} else {
+			b.gap = time.Duration(weight*float64(gap) + (1-weight)*float64(b.gap))
+		}
+	}
+	b.last = now
+
+	b.size = b.min
+	b.interval = b.maxInterval
+	if b.gap > 0 {
+		b.size = int(b.maxInterval/b.gap) + 1
+	}
+	if b.size < b.min {
+		b.size = b.min
+	}
+	if b.size > b.max {
+		b.size = b.max
+	}
+	if b.gap > 0 {
+		b.interval = time.Duration(b.size-1) * b.gap
+	}
+	if p.slot < p.execute {
+		b.interval = b.minInterval
+	}
+	if b.interval < b.minInterval {
+		b.interval = b.minInterval
+	}
+	if b.interval > b.maxInterval {
+		b.interval = b.maxInterval
+	}
+	p.metrics.batched(b.size, b.interval)
+}
+
+// batchSize returns max number of requests proposed in one slot
+func (p *Paxos) batchSize() int {
+	if p.AdaptiveBatch {
+		return p.batcher.size
+	}
+	return p.BatchSize
+}
+
+// batchInterval returns max time a request waits for its batch
+func (p *Paxos) batchInterval() time.Duration {
+	if p.AdaptiveBatch {
+		return p.batcher.interval
+	}
+	return p.BatchInterval
+}
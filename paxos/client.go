@@ -1,7 +1,11 @@
 package paxos
 
 import (
+	"errors"
+	"io/ioutil"
+	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/ailidani/paxi"
 	"github.com/ailidani/paxi/log"
@@ -11,6 +15,7 @@ import (
 type Client struct {
 	*paxi.HTTPClient
 	ballot paxi.Ballot
+	nonce  string // nonce of registration, kept so that retries without client id get the same id
 }
 
 func NewClient(id paxi.ID) *Client {
@@ -45,6 +50,51 @@ func (c *Client) Put(key paxi.Key, value paxi.Value) error {
 	return err
 }
 
+// Register registers client id with the last command id as baseline of dedup, so that commands before
+// a reconnect are not executed again, or gets a client id assigned through the log if it has none
+func (c *Client) Register() error {
+	if c.nonce == "" {
+		c.nonce = paxi.NewSpanID()
+	}
+	id := c.ID
+	if c.Leader != "" {
+		id = c.Leader
+	}
+	v, err := c.register(id, true)
+	if err != nil {
+		return err
+	}
+	c.ID = paxi.ID(v)
+	return nil
+}
+
+// register posts registration to node id, and again to the leader if id redirects and follow is true
+func (c *Client) register(id paxi.ID, follow bool) (paxi.Value, error) {
+	req, err := http.NewRequest(http.MethodPost, c.Address(id)+"/register", strings.NewReader(c.nonce))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(paxi.HTTPClientID, string(c.ID))
+	req.Header.Set(paxi.HTTPCommandID, strconv.Itoa(c.CID))
+	rep, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+	b, err := ioutil.ReadAll(rep.Body)
+	if err != nil {
+		return nil, err
+	}
+	if leader := paxi.ID(rep.Header.Get(paxi.HTTPLeader)); rep.StatusCode == http.StatusTemporaryRedirect && follow && leader != "" {
+		c.Leader = leader
+		return c.register(leader, false)
+	}
+	if rep.StatusCode != http.StatusOK {
+		return nil, errors.New(rep.Status)
+	}
+	return paxi.Value(b), nil
+}
+
 func (c *Client) readLeader(key paxi.Key) (paxi.Value, error) {
 	if c.ballot == 0 {
 		v, meta, err := c.HTTPClient.RESTGet(c.ID, key)
@@ -0,0 +1,20 @@
+package paxos
+
+import "time"
+
+// Clock tells current time to Paxos, tests inject a fake clock to drive lease, timeout and latency
+// timers are not part of clock since they are scheduled on the node by paxi.Node.After
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// since returns time elapsed since t by clock of p
+func (p *Paxos) since(t time.Time) time.Duration {
+	return p.Clock.Now().Sub(t)
+}
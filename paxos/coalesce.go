@@ -0,0 +1,90 @@
+package paxos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/ailidani/paxi"
+)
+
+// content returns hash of key, value and ttl of command c, regardless of its client
+func content(c paxi.Command) uint64 {
+	f := fnv.New64a()
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(c.Key))
+	f.Write(b)
+	f.Write(c.Value)
+	if c.TTL != 0 {
+		binary.BigEndian.PutUint64(b, uint64(c.TTL))
+		f.Write(b)
+	}
+	return f.Sum64()
+}
+
+// identical returns true if commands a and b write the same value to the same key with the same ttl
+func identical(a, b paxi.Command) bool {
+	return a.Key == b.Key && a.TTL == b.TTL && bytes.Equal(a.Value, b.Value)
+}
+
+// coalescable returns true if command c may share its slot with identical commands of other requests
+func coalescable(c paxi.Command) bool {
+	return !c.IsRead() && !c.IsNoOp() && !c.CAS && !IsReconfig(c) && !IsRegister(c)
+}
+
+// coalesce attaches every request whose command is identical to a command in flight to that slot,
+// and returns the requests left to propose
+func (p *Paxos) coalesce(requests []*paxi.Request) []*paxi.Request {
+	left := make([]*paxi.Request, 0, len(requests))
+	for _, r := range requests {
+		if !p.attach(r) {
+			left = append(left, r)
+		}
+	}
+	return left
+}
+
+// attach appends request r to the uncommitted slot of this ballot that proposes an identical command
+func (p *Paxos) attach(r *paxi.Request) bool {
+	if !coalescable(r.Command) {
+		return false
+	}
+	h := content(r.Command)
+	s, exists := p.contents[h]
+	if !exists {
+		return false
+	}
+	e, exists := p.log[s]
+	if !exists || e.commit || e.ballot != p.ballot || len(e.requests) == 0 {
+		// slot is committed or lost to another leader
+		delete(p.contents, h)
+		return false
+	}
+	for _, c := range e.commands {
+		if identical(c, r.Command) {
+			e.requests = append(e.requests, r)
+			p.metrics.inc(&p.metrics.coalesced)
+			return true
+		}
+	}
+	// hash collision
+	return false
+}
+
+// index records slot s as in flight for every coalescable command
+func (p *Paxos) index(s int, commands []paxi.Command) {
+	for _, c := range commands {
+		if coalescable(c) {
+			p.contents[content(c)] = s
+		}
+	}
+}
+
+// uncoalesce forgets in-flight commands of executed slots
+func (p *Paxos) uncoalesce() {
+	for h, s := range p.contents {
+		if s < p.execute {
+			delete(p.contents, h)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package paxos
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+
+	"github.com/ailidani/paxi"
+)
+
+// p2a returns P2a message of slot s, values of at least Compress bytes are deflated
+func (p *Paxos) p2a(s int, commands []paxi.Command) P2a {
+	m := P2a{
+		Ballot:   p.ballot,
+		Slot:     s,
+		Commands: commands,
+		Commit:   p.execute,
+		Fast:     p.Fast,
+	}
+	if e, exists := p.log[s]; exists {
+		m.TraceID = e.trace
+	}
+	if p.Compress <= 0 {
+		return m
+	}
+	for i, c := range commands {
+		if len(c.Value) < p.Compress {
+			continue
+		}
+		v := deflate(c.Value)
+		if len(v) >= len(c.Value) {
+			// incompressible value is sent as is
+			p.metrics.deflated(len(c.Value), len(c.Value))
+			continue
+		}
+		p.metrics.deflated(len(c.Value), len(v))
+		if m.Compressed == nil {
+			// commands are shared with log entry
+			m.Commands = append([]paxi.Command(nil), commands...)
+		}
+		m.Commands[i].Value = v
+		m.Compressed = append(m.Compressed, i)
+	}
+	return m
+}
+
+// inflate returns commands of P2a with compressed values restored,
+// a value inflating beyond max bytes fails the message unless max is 0
+func inflate(m P2a, max int) ([]paxi.Command, error) {
+	if len(m.Compressed) == 0 {
+		return m.Commands, nil
+	}
+	commands := append([]paxi.Command(nil), m.Commands...)
+	for _, i := range m.Compressed {
+		r := flate.NewReader(bytes.NewReader(commands[i].Value))
+		var v []byte
+		var err error
+		if max > 0 {
+			v, err = ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+		} else {
+			v, err = ioutil.ReadAll(r)
+		}
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		if max > 0 && len(v) > max {
+			return nil, paxi.ErrMessageTooLarge
+		}
+		commands[i].Value = v
+	}
+	return commands, nil
+}
+
+// deflate compresses value v
+func deflate(v paxi.Value) paxi.Value {
+	var b bytes.Buffer
+	// writer only fails on invalid level or write error, which bytes.Buffer never returns
+	w, _ := flate.NewWriter(&b, flate.BestSpeed)
+	w.Write(v)
+	w.Close()
+	return b.Bytes()
+}
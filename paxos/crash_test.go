@@ -0,0 +1,282 @@
+package paxos
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ailidani/paxi"
+)
+
+// errCrash is the panic of crashStorage at its crash point
+var errCrash = errors.New("crash")
+
+// crashStorage crashes the node right before storage operation number at, counting from 0
+type crashStorage struct {
+	Storage
+	at  int
+	ops int
+}
+
+func (s *crashStorage) op() {
+	if s.ops == s.at {
+		panic(errCrash)
+	}
+	s.ops++
+}
+
+func (s *crashStorage) AppendEntry(r Record) error {
+	s.op()
+	return s.Storage.AppendEntry(r)
+}
+
+func (s *crashStorage) SaveBallot(b paxi.Ballot) error {
+	s.op()
+	return s.Storage.SaveBallot(b)
+}
+
+// cluster delivers messages among replicas of test nodes in the order they are sent,
+// one replica of which persists to storage that crashes
+type cluster struct {
+	t       *testing.T
+	ids     []paxi.ID
+	nodes   map[paxi.ID]*node
+	paxos   map[paxi.ID]*Paxos
+	crasher paxi.ID
+	path    string
+	down    bool
+	sent    []interface{} // messages sent by crasher before crash
+}
+
+func newCluster(t *testing.T, crasher paxi.ID, at int) *cluster {
+	c := &cluster{
+		t:       t,
+		ids:     []paxi.ID{paxi.NewID(1, 1), paxi.NewID(1, 2), paxi.NewID(1, 3)},
+		nodes:   make(map[paxi.ID]*node),
+		paxos:   make(map[paxi.ID]*Paxos),
+		crasher: crasher,
+		path:    filepath.Join(t.TempDir(), "wal"),
+	}
+	for _, id := range c.ids {
+		if id != crasher {
+			c.start(id)
+		}
+	}
+	storage, err := NewFileStorage(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.start(crasher, func(p *Paxos) { p.Storage = &crashStorage{Storage: storage, at: at} })
+	return c
+}
+
+func (c *cluster) start(id paxi.ID, options ...func(*Paxos)) *Paxos {
+	c.nodes[id] = newNode(id)
+	p := NewPaxos(c.nodes[id], options...)
+	p.memberships = []membership{{slot: 0, ids: c.ids}}
+	c.paxos[id] = p
+	return p
+}
+
+// restart brings crasher back with a fresh node that recovers from storage
+func (c *cluster) restart() *Paxos {
+	storage, err := NewFileStorage(c.path)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.down = false
+	return c.start(c.crasher, func(p *Paxos) { p.Storage = storage })
+}
+
+// run runs f and every message it causes, returns true if crasher crashes meanwhile
+func (c *cluster) run(f func()) (crashed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errCrash {
+				panic(r)
+			}
+			c.down = true
+			crashed = true
+			// messages sent before crash are already on the wire
+			c.run(c.pump)
+		}
+	}()
+	f()
+	c.pump()
+	return false
+}
+
+// pump delivers messages until every node is quiet
+func (c *cluster) pump() {
+	for quiet := false; !quiet; {
+		quiet = true
+		for _, id := range c.ids {
+			n := c.nodes[id]
+			sent := n.sent
+			n.sent = nil
+			if id == c.crasher {
+				c.sent = append(c.sent, sent...)
+			}
+			for _, m := range sent {
+				quiet = false
+				c.deliver(id, m)
+			}
+		}
+	}
+}
+
+// deliver sends phase 1a, 2a and 3 messages to every node but from, replies to the leader of their ballot
+func (c *cluster) deliver(from paxi.ID, m interface{}) {
+	for _, id := range c.ids {
+		if c.down && id == c.crasher {
+			continue
+		}
+		p := c.paxos[id]
+		switch m := m.(type) {
+		case P1a:
+			if id != from {
+				p.HandleP1a(m)
+			}
+		case P2a:
+			if id != from {
+				p.HandleP2a(m)
+			}
+		case P3:
+			if id != from {
+				p.HandleP3(m)
+			}
+		case P1b:
+			if id == m.Ballot.ID() {
+				p.HandleP1b(m)
+			}
+		case P2b:
+			if id == m.Ballot.ID() {
+				p.HandleP2b(m)
+			}
+		}
+	}
+}
+
+// chosen collects committed commands of every slot on running nodes and in P3 sent by crasher,
+// reporting slots committed with different commands
+func (c *cluster) chosen() map[int][]paxi.Command {
+	chosen := make(map[int][]paxi.Command)
+	choose := func(who string, s int, commands []paxi.Command) {
+		if v, exists := chosen[s]; exists && !equal(v, commands) {
+			c.t.Errorf("%s commits %v in slot %d chosen as %v", who, commands, s, v)
+			return
+		}
+		chosen[s] = commands
+	}
+	for _, m := range c.sent {
+		if m, ok := m.(P3); ok {
+			choose(fmt.Sprintf("P3 of %s", c.crasher), m.Slot, m.Commands)
+		}
+	}
+	for _, id := range c.ids {
+		if c.down && id == c.crasher {
+			continue
+		}
+		for s, e := range c.paxos[id].log {
+			if e.commit {
+				choose(fmt.Sprintf("replica %s", id), s, e.commands)
+			}
+		}
+	}
+	return chosen
+}
+
+// promised checks restarted crasher keeps ballot and accepted slots it told others about
+func (c *cluster) promised(p *Paxos) {
+	for _, m := range c.sent {
+		switch m := m.(type) {
+		case P1a:
+			if p.ballot < m.Ballot {
+				c.t.Errorf("ballot %v of sent P1a is lost, recovered %v", m.Ballot, p.ballot)
+			}
+		case P1b:
+			if p.ballot < m.Ballot {
+				c.t.Errorf("ballot %v of sent P1b is lost, recovered %v", m.Ballot, p.ballot)
+			}
+		case P2a:
+			if e, exists := p.log[m.Slot]; !exists || e.ballot < m.Ballot || (e.ballot == m.Ballot && !equal(e.commands, m.Commands)) {
+				c.t.Errorf("slot %d proposed in P2a %v is lost, recovered %+v", m.Slot, m, e)
+			}
+		case P2b:
+			if e, exists := p.log[m.Slot]; !exists || e.ballot < m.Ballot {
+				c.t.Errorf("slot %d accepted in P2b %v is lost, recovered %+v", m.Slot, m, e)
+			}
+		case P3:
+			if e, exists := p.log[m.Slot]; !exists || !e.commit || !equal(e.commands, m.Commands) {
+				c.t.Errorf("slot %d committed in P3 %v is lost, recovered %+v", m.Slot, m, e)
+			}
+		}
+	}
+}
+
+// crash runs script with crasher crashing before storage operation at, then restarts crasher,
+// which recovers from storage and leads again. Returns false if script finishes before crash point.
+func crash(t *testing.T, crasher paxi.ID, at int) bool {
+	c := newCluster(t, crasher, at)
+	leader := c.ids[0]
+	script := []func(){func() { c.paxos[leader].P1a() }}
+	for i := 0; i < 3; i++ {
+		cmd := paxi.Command{Key: paxi.Key(i), Value: paxi.Value(fmt.Sprintf("v%d", i)), ClientID: "9.1", CommandID: i}
+		script = append(script, func() { c.paxos[leader].P2a(&paxi.Request{Command: cmd}) })
+	}
+
+	crashed := false
+	for _, step := range script {
+		if c.down && leader == crasher {
+			// steps of crashed leader never happen
+			break
+		}
+		if c.run(step) {
+			crashed = true
+		}
+	}
+	if !crashed {
+		return false
+	}
+
+	chosen := c.chosen()
+	p := c.restart()
+	c.promised(p)
+	if c.run(p.P1a) {
+		t.Fatal("restarted node crashes again")
+	}
+	if !p.active {
+		t.Fatalf("restarted node %s is not elected", crasher)
+	}
+	for s, v := range c.chosen() {
+		if w, exists := chosen[s]; exists && !equal(v, w) {
+			t.Errorf("slot %d chosen as %v before restart, %v after", s, w, v)
+		}
+	}
+	for s, v := range chosen {
+		if e, exists := p.log[s]; !exists || !e.commit || !equal(e.commands, v) {
+			t.Errorf("slot %d committed as %v is lost after restart, got %+v", s, v, e)
+		}
+	}
+	return true
+}
+
+func TestCrashConsistency(t *testing.T) {
+	for _, crasher := range []paxi.ID{paxi.NewID(1, 1), paxi.NewID(1, 2)} {
+		points := 0
+		for at := 0; ; at++ {
+			done := true
+			t.Run(fmt.Sprintf("%s/%d", crasher, at), func(t *testing.T) {
+				done = !crash(t, crasher, at)
+			})
+			if done {
+				break
+			}
+			points++
+		}
+		if points < 4 {
+			t.Errorf("replica %s crashes at only %d points", crasher, points)
+		}
+	}
+}
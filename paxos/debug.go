@@ -0,0 +1,115 @@
+package paxos
+
+import (
+	"time"
+
+	"github.com/ailidani/paxi"
+)
+
+// LogEntry is a copy of one log entry for debugging
+type LogEntry struct {
+	Slot     int
+	Ballot   paxi.Ballot
+	Commit   bool
+	Commands []string
+}
+
+// Status is a copy of current protocol state of one node for operational checks
+type Status struct {
+	ID       paxi.ID
+	IsLeader bool        // this node is or tries to be the leader
+	Active   bool        // this node finished phase 1 of current ballot
+	Learner  bool        // this node only learns committed slots without voting
+	CaughtUp bool        // this node executed every slot it has seen and hears from the leader
+	Draining bool        // this node rejects new client requests
+	Ballot   paxi.Ballot // highest ballot seen
+	Leader   paxi.ID     // leader of current ballot, empty if none
+	Execute  int         // next slot to execute
+	Slot     int         // highest slot seen
+	InFlight int         // slots proposed or accepted but not executed
+	Backlog  int         // slots from execute up to the highest committed slot
+	Pending  int         // requests waiting for phase 1 or flow control
+	Reads    int         // reads waiting for confirmation or execution
+
+	Election time.Duration // duration of the last election won by this node, 0 if none
+
+	Links map[paxi.ID]paxi.Link // messages sent and failed to each peer, nil if node keeps no statistic
+}
+
+// Status returns current protocol state,
+// it waits for the message handling goroutine thus must not be called from a handler
+func (p *Paxos) Status() Status {
+	c := make(chan Status, 1)
+	p.After(0, func() {
+		c <- p.status()
+	})
+	return <-c
+}
+
+func (p *Paxos) status() Status {
+	var leader paxi.ID
+	if p.ballot != 0 {
+		leader = p.ballot.ID()
+	}
+	return Status{
+		ID:       p.ID(),
+		IsLeader: p.IsLeader(),
+		Active:   p.active,
+		Learner:  p.Learner,
+		CaughtUp: p.CaughtUp(),
+		Draining: p.draining,
+		Ballot:   p.ballot,
+		Leader:   leader,
+		Execute:  p.execute,
+		Slot:     p.slot,
+		InFlight: paxi.Max(p.slot-p.execute+1, 0),
+		Backlog:  p.Backlog(),
+		Pending:  len(p.requests) + len(p.batch) + len(p.txns),
+		Reads:    len(p.rounds) + len(p.polls) + len(p.waits) + len(p.reads),
+		Election: p.metrics.lastElection(),
+		Links:    p.peerLinks(),
+	}
+}
+
+// peerLinks returns sending statistic of node to each peer, nil if node keeps none
+func (p *Paxos) peerLinks() map[paxi.ID]paxi.Link {
+	if p.links == nil {
+		return nil
+	}
+	return p.links.Links()
+}
+
+// LogSnapshot returns copies of log entries ordered by slot, from the oldest kept within log window to the highest slot,
+// it waits for the message handling goroutine thus must not be called from a handler
+func (p *Paxos) LogSnapshot() []LogEntry {
+	c := make(chan []LogEntry, 1)
+	p.After(0, func() {
+		c <- p.logSnapshot()
+	})
+	return <-c
+}
+
+func (p *Paxos) logSnapshot() []LogEntry {
+	low := p.low
+	if window := p.LogWindow; window > 0 && p.execute-window > low {
+		low = p.execute - window
+	}
+	entries := make([]LogEntry, 0)
+	for s := low; s <= p.slot; s++ {
+		e, exists := p.log[s]
+		if !exists {
+			continue
+		}
+		commands := make([]string, len(e.commands))
+		for i, c := range e.commands {
+			commands[i] = c.String()
+		}
+		entries = append(entries, LogEntry{
+			Slot:     s,
+			Ballot:   e.ballot,
+			Commit:   e.commit,
+			Commands: commands,
+		})
+	}
+	return entries
+}
@@ -0,0 +1,99 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+)
+
+// dependencies returns slots that must be executed before slot s, the first one is a prefix every slot up to which
+// must be executed, i.e. last reconfiguration or snapshot, followed by the last earlier slot conflicting with each
+// command of s, it returns nil if s must execute in order, i.e. reconfiguration or some earlier slot is not committed yet
+func (p *Paxos) dependencies(s int) []int {
+	for i := p.execute; i < s; i++ {
+		if e, exists := p.log[i]; !exists || !e.commit {
+			return nil
+		}
+	}
+	deps := []int{paxi.Max(p.horizon-1, p.barrier)}
+	for _, cmd := range p.log[s].commands {
+		if IsReconfig(cmd) {
+			return nil
+		}
+		if cmd.IsNoOp() {
+			continue
+		}
+		dep := -1
+		if last, exists := p.keys[cmd.Key]; exists {
+			dep = last
+		}
+		if session, exists := p.sessions[cmd.ClientID]; exists && p.Dedup {
+			dep = paxi.Max(dep, session.Slot)
+		}
+		for i := s - 1; i >= p.execute && i > dep; i-- {
+			if p.conflict(p.log[i], cmd) {
+				dep = i
+				break
+			}
+		}
+		deps = append(deps, dep)
+	}
+	return deps
+}
+
+// conflict returns true if cmd cannot be reordered with commands of entry e
+func (p *Paxos) conflict(e *entry, cmd paxi.Command) bool {
+	for _, c := range e.commands {
+		if c.IsNoOp() {
+			continue
+		}
+		if IsReconfig(c) || c.Key == cmd.Key {
+			return true
+		}
+		if p.Dedup && c.ClientID != "" && c.ClientID == cmd.ClientID {
+			return true
+		}
+	}
+	return false
+}
+
+// execIndependent executes committed slots behind the first unexecuted slot whose dependencies are all executed
+func (p *Paxos) execIndependent() {
+	for s := p.execute + 1; s <= p.slot; s++ {
+		e, exists := p.log[s]
+		if !exists || !e.commit || e.executed || e.deps == nil || !p.ready(e.deps) {
+			continue
+		}
+		p.apply(s, e)
+	}
+}
+
+// ready returns true if every slot up to the prefix of deps and every other slot in deps are executed
+func (p *Paxos) ready(deps []int) bool {
+	if p.execute <= deps[0] {
+		return false
+	}
+	for _, s := range deps[1:] {
+		if s < p.execute {
+			continue
+		}
+		if e, exists := p.log[s]; !exists || !e.executed {
+			return false
+		}
+	}
+	return true
+}
+
+// executed returns entries after execute that are already executed out of order
+func (p *Paxos) executed() []Record {
+	records := make([]Record, 0)
+	for s := p.execute + 1; s <= p.slot; s++ {
+		if e, exists := p.log[s]; exists && e.executed {
+			records = append(records, Record{
+				Slot:     s,
+				Ballot:   e.ballot,
+				Commands: e.commands,
+				Commit:   true,
+			})
+		}
+	}
+	return records
+}
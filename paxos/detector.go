@@ -0,0 +1,120 @@
+package paxos
+
+import (
+	"math"
+	"time"
+
+	"github.com/ailidani/paxi"
+)
+
+// number of heartbeat inter-arrival times kept by failure detector
+const arrivals = 100
+
+// adaptive heartbeat interval in round trip times to the slowest measured peer
+const heartbeatRTTs = 4
+
+// default suspicion level at which follower presumes the leader failed
+const defaultPhi = 8
+
+// detector is phi accrual failure detector of the leader over inter-arrival times of its heartbeats.
+// Arrivals are taken as exponentially distributed, so the suspicion after silence t is
+// phi = -log10(P(no heartbeat within t)) = t / mean * log10(e).
+type detector struct {
+	ballot    paxi.Ballot // ballot of the leader measured
+	last      time.Time
+	intervals []time.Duration
+	next      int
+	sum       time.Duration
+}
+
+// heard records arrival of a heartbeat at now
+func (d *detector) heard(now time.Time) {
+	if !d.last.IsZero() {
+		i := now.Sub(d.last)
+		if len(d.intervals) < arrivals {
+			d.intervals = append(d.intervals, i)
+		} else {
+			d.sum -= d.intervals[d.next]
+			d.intervals[d.next] = i
+			d.next = (d.next + 1) % arrivals
+		}
+		d.sum += i
+	}
+	d.last = now
+}
+
+// reset forgets arrivals of the previous leader
+func (d *detector) reset() {
+	*d = detector{intervals: d.intervals[:0]}
+}
+
+// mean returns mean inter-arrival time, 0 if there is no sample yet
+func (d *detector) mean() time.Duration {
+	if len(d.intervals) == 0 {
+		return 0
+	}
+	return d.sum / time.Duration(len(d.intervals))
+}
+
+// phi returns suspicion level of leader silent for t with mean inter-arrival time mean
+func phi(t, mean time.Duration) float64 {
+	if mean <= 0 || t <= 0 {
+		return 0
+	}
+	return float64(t) / float64(mean) * math.Log10(math.E)
+}
+
+// timeout returns silence after which suspicion reaches threshold, 0 if there is no sample yet
+func (d *detector) timeout(threshold float64) time.Duration {
+	return time.Duration(float64(d.mean()) * threshold * math.Ln10)
+}
+
+// threshold returns configured suspicion level of leader failure
+func (p *Paxos) threshold() float64 {
+	if p.PhiThreshold <= 0 {
+		return defaultPhi
+	}
+	return p.PhiThreshold
+}
+
+// interval returns next heartbeat interval, heartbeatRTTs round trips to the slowest measured peer
+// within [MinHeartbeat, Heartbeat] with 10% jitter, or Heartbeat if not adaptive
+func (p *Paxos) interval() time.Duration {
+	if !p.AdaptiveHeartbeat {
+		return p.Heartbeat
+	}
+	d := p.Heartbeat
+	var slowest time.Duration
+	for _, rtt := range p.rtt {
+		if rtt > slowest {
+			slowest = rtt
+		}
+	}
+	if slowest > 0 && heartbeatRTTs*slowest < d {
+		d = heartbeatRTTs * slowest
+	}
+	if d < p.MinHeartbeat {
+		d = p.MinHeartbeat
+	}
+	if d/5 > 0 {
+		d = d - d/10 + time.Duration(p.random(int64(d/5)))
+	}
+	if d > p.Heartbeat {
+		d = p.Heartbeat
+	}
+	p.metrics.beat(d)
+	return d
+}
+
+// detect returns lower bound of election timeout, silence at which suspicion of the leader reaches threshold
+// within [MinTimeout, Timeout], or Timeout if not adaptive or no heartbeat is measured yet
+func (p *Paxos) detect() time.Duration {
+	t := p.detector.timeout(p.threshold())
+	if !p.AdaptiveHeartbeat || t <= 0 || t > p.Timeout {
+		return p.Timeout
+	}
+	if t < p.MinTimeout {
+		return p.MinTimeout
+	}
+	return t
+}
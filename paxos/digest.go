@@ -0,0 +1,53 @@
+package paxos
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// chain returns rolling hash of previous hash h followed by commands of the next slot
+func chain(h uint64, commands []paxi.Command) uint64 {
+	f := fnv.New64a()
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, h)
+	f.Write(b)
+	for _, c := range commands {
+		binary.BigEndian.PutUint64(b, c.Hash())
+		f.Write(b)
+	}
+	return f.Sum64()
+}
+
+// digest broadcasts hash of every executed slot every digest interval
+func (p *Paxos) digest() {
+	if p.execute > p.low {
+		p.Broadcast(Digest{
+			ID:   p.ID(),
+			Slot: p.execute - 1,
+			Hash: p.hash,
+		})
+	}
+	p.After(p.DigestInterval, p.digest)
+}
+
+// HandleDigest compares digest of other replica with own hash of the same slot,
+// a follower that diverges from any replica requests snapshot of the leader
+func (p *Paxos) HandleDigest(m Digest) {
+	if m.Slot < p.low || m.Slot >= p.execute {
+		return
+	}
+	e, exists := p.log[m.Slot]
+	if !exists || e.hash == m.Hash {
+		return
+	}
+	log.Errorw("replica diverges", log.Fields{"id": p.ID(), "slot": m.Slot, "hash": e.hash, "from": m.ID, "other": m.Hash})
+	leader := p.ballot.ID()
+	if p.active || p.ballot == 0 || leader == p.ID() {
+		return
+	}
+	p.diverged = true
+	p.fetch(leader, -1)
+}
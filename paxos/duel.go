@@ -0,0 +1,50 @@
+package paxos
+
+import (
+	"time"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// number of consecutive phase 1 of this node preempted by the same candidate that is taken as dueling leaders
+const duel = 2
+
+// contend records that phase 1 of this node is preempted by candidate id
+func (p *Paxos) contend(id paxi.ID) {
+	if id == p.rival {
+		p.duels++
+	} else {
+		p.rival = id
+		p.duels = 1
+	}
+	if p.duels == duel {
+		log.Infow("dueling leaders", log.Fields{"id": p.ID(), "ballot": p.ballot, "rival": id, "preferred": p.preferred()})
+	}
+}
+
+// settle forgets contention once any leader finishes phase 1
+func (p *Paxos) settle() {
+	p.rival = ""
+	p.duels = 0
+	p.started = time.Time{}
+}
+
+// preferred returns true if this node wins tie-break against its rival,
+// i.e. has the higher priority, or the higher id of the same priority
+func (p *Paxos) preferred() bool {
+	if a, b := p.priority(p.ID()), p.priority(p.rival); a != b {
+		return a > b
+	}
+	return p.ID() > p.rival
+}
+
+// tiebreak returns backoff delay while dueling with rival, the preferred node retries within [base, 2*base]
+// and the other one yields for at least twice of its exponential delay so that preferred node finishes phase 1 first
+func (p *Paxos) tiebreak() time.Duration {
+	base := paxi.Max(int(p.BackOff/time.Millisecond), 1)
+	if p.preferred() {
+		return time.Duration(int64(base)+p.random(int64(base+1))) * time.Millisecond
+	}
+	return 2 * p.delay(p.attempt)
+}
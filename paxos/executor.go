@@ -0,0 +1,103 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+)
+
+// executor applies committed commands to the state machine off the message handling goroutine,
+// commands of the same key run on the same worker in the order they are handed over
+type executor struct {
+	workers []chan func()
+}
+
+// newExecutor starts n workers, each queues up to size tasks before blocking the caller
+func newExecutor(n, size int) *executor {
+	x := &executor{workers: make([]chan func(), n)}
+	for i := range x.workers {
+		x.workers[i] = make(chan func(), size)
+		go func(tasks chan func()) {
+			for f := range tasks {
+				f()
+			}
+		}(x.workers[i])
+	}
+	return x
+}
+
+// run queues f on the worker of key k
+func (x *executor) run(k paxi.Key, f func()) {
+	i := int(k) % len(x.workers)
+	if i < 0 {
+		i += len(x.workers)
+	}
+	x.workers[i] <- f
+}
+
+// barrier waits until every task queued so far is done
+func (x *executor) barrier() {
+	done := make(chan struct{}, len(x.workers))
+	for _, tasks := range x.workers {
+		tasks <- func() { done <- struct{}{} }
+	}
+	for range x.workers {
+		<-done
+	}
+}
+
+// dispatch applies cmd executed in slot s of ballot b on its executor worker, which replies to waiting requests
+func (p *Paxos) dispatch(s int, b paxi.Ballot, cmd paxi.Command, waiting []*paxi.Request) {
+	// duplicates are detected right away, their cached value is filled once cmd is applied
+	p.record(s, cmd, nil, "")
+	p.executor.run(cmd.Key, func() {
+		var value paxi.Value
+		var rejected string
+		if !p.ShadowMode {
+			var err error
+			value, err = paxi.ApplyAt(p.StateMachine, cmd, s)
+			rejected = status(err)
+		}
+		for _, r := range waiting {
+			p.respond(s, b, r, value, rejected)
+		}
+		if p.Dedup && cmd.ClientID != "" {
+			p.After(0, func() { p.recorded(s, cmd, value, rejected) })
+		}
+		if len(p.subscribers) > 0 {
+			p.changed(Change{Slot: s, Command: cmd, Value: value, Status: rejected})
+		}
+	})
+}
+
+// recorded fills value and status of cmd executed in slot s into the session of its client, if still its last command
+func (p *Paxos) recorded(s int, cmd paxi.Command, value paxi.Value, rejected string) {
+	if session, exists := p.sessions[cmd.ClientID]; exists && session.CommandID == cmd.CommandID && session.Slot == s {
+		session.Value = value
+		session.Status = rejected
+		p.sessions[cmd.ClientID] = session
+	}
+}
+
+// query returns result of read command cmd from the state machine, after every command of its key handed to executor
+func (p *Paxos) query(cmd paxi.Command) (paxi.Value, error) {
+	if p.executor == nil {
+		return p.StateMachine.Apply(cmd)
+	}
+	type result struct {
+		value paxi.Value
+		err   error
+	}
+	c := make(chan result, 1)
+	p.executor.run(cmd.Key, func() {
+		v, err := p.StateMachine.Apply(cmd)
+		c <- result{v, err}
+	})
+	r := <-c
+	return r.value, r.err
+}
+
+// quiesce waits for executor to apply every handed command, before state machine is snapshot or restored
+func (p *Paxos) quiesce() {
+	if p.executor != nil {
+		p.executor.barrier()
+	}
+}
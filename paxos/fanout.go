@@ -0,0 +1,83 @@
+package paxos
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ailidani/paxi"
+)
+
+// fan-out strategies of P2a
+const (
+	FanOutZone = "zone" // closer zones first
+	FanOutRTT  = "rtt"  // lower measured round trip time first, unmeasured peers last by zone
+)
+
+// nearest returns every peer of slot s except this node, ordered nearest first by FanOut strategy,
+// zone distance is used by default and breaks ties
+func (p *Paxos) nearest(s int) []paxi.ID {
+	ids := p.Members(s)
+	if ids == nil {
+		ids = paxi.GetConfig().Voters()
+	}
+	peers := make([]paxi.ID, 0, len(ids))
+	for _, id := range ids {
+		if id != p.ID() {
+			peers = append(peers, id)
+		}
+	}
+	zone := p.ID().Zone()
+	distance := func(id paxi.ID) int {
+		d := id.Zone() - zone
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	rtt := func(id paxi.ID) time.Duration {
+		if d, exists := p.rtt[id]; exists && p.FanOut == FanOutRTT {
+			return d
+		}
+		return time.Duration(1<<63 - 1)
+	}
+	// suspected peers go last so that thrifty quorum routes around them
+	suspected := p.suspected()
+	sort.Slice(peers, func(i, j int) bool {
+		if suspected[peers[i]] != suspected[peers[j]] {
+			return suspected[peers[j]]
+		}
+		if rtt(peers[i]) != rtt(peers[j]) {
+			return rtt(peers[i]) < rtt(peers[j])
+		}
+		if distance(peers[i]) != distance(peers[j]) {
+			return distance(peers[i]) < distance(peers[j])
+		}
+		return peers[i].Node() < peers[j].Node()
+	})
+	return peers
+}
+
+// fanOut sends P2a m to every peer nearest first
+func (p *Paxos) fanOut(m P2a) {
+	for _, id := range p.nearest(m.Slot) {
+		p.Send(id, m)
+	}
+}
+
+// sample updates smoothed round trip time to id with sample d, weighted like TCP SRTT
+func (p *Paxos) sample(id paxi.ID, d time.Duration) {
+	if old, exists := p.rtt[id]; exists {
+		d = old - old/8 + d/8
+	}
+	p.rtt[id] = d
+}
+
+// acked returns true if id is already in quorum q
+func acked(q *paxi.Quorum, id paxi.ID) bool {
+	for _, a := range q.AckedIDs() {
+		if a == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// accepted replies P2b of slot to the leader, or to every replica in fast path so that each of them learns
+// commit without P3, conflict tells the leader that this acceptor replaced other commands of the slot
+func (p *Paxos) accepted(m P2a, conflict bool) {
+	reply := P2b{
+		Ballot:   p.ballot,
+		Slot:     m.Slot,
+		ID:       p.ID(),
+		TraceID:  m.TraceID,
+		Conflict: conflict,
+	}
+	e, exists := p.log[m.Slot]
+	if !m.Fast || !exists || e.commit || e.ballot != m.Ballot {
+		p.Send(m.Ballot.ID(), reply)
+		return
+	}
+	if e.fast == nil {
+		e.fast = p.newQuorum(m.Slot)
+	}
+	// leader accepted its own proposal
+	e.fast.ACK(m.Ballot.ID())
+	e.fast.ACK(p.ID())
+	p.Broadcast(reply)
+	p.learn(e, m.Slot)
+}
+
+// handleFastP2b counts P2b of the same ballot from other acceptors in fast path
+func (p *Paxos) handleFastP2b(m P2b) {
+	e, exists := p.log[m.Slot]
+	if !exists || e.commit || e.fast == nil || m.Ballot != e.ballot {
+		// conflicting ballot or unknown entry waits for P3 of classic path
+		return
+	}
+	e.fast.ACK(m.ID)
+	p.learn(e, m.Slot)
+}
+
+// learn commits entry of slot once a fast quorum accepted its ballot
+func (p *Paxos) learn(e *entry, slot int) {
+	if !e.fast.FastQuorum() {
+		return
+	}
+	e.commit = true
+	p.metrics.inc(&p.metrics.committed)
+	p.appendEntry(slot)
+	p.exec()
+}
+
+// fastAck counts P2b of proposal of this leader in slot s toward its fast quorum,
+// a conflicting ack makes the slot fall back to classic path
+func (p *Paxos) fastAck(s int, e *entry, m P2b) {
+	if m.Conflict {
+		e.classic = true
+		return
+	}
+	if e.fastacks == nil {
+		e.fastacks = paxi.QuorumFor(p.proposed(s).config())
+		e.fastacks.ACK(p.ID())
+	}
+	e.fastacks.ACK(m.ID)
+}
+
+// decide finishes slot s committed by a classic quorum of this leader. In fast path every replica learns the commit
+// from a fast quorum of P2b without conflict, so the leader skips P3 once such a quorum acks, and waits up to fast
+// timeout for it. Classic path, a conflicting ack or the timeout falls back to P3, since replicas that replaced other
+// commands of the slot, or missed P2b of the fast quorum, learn the commit from nothing else.
+func (p *Paxos) decide(s int) {
+	e := p.log[s]
+	fast := p.Fast && !e.classic && p.FastTimeout > 0
+	if fast && e.fastacks != nil && e.fastacks.FastQuorum() {
+		e.undecided = false
+		return
+	}
+	if fast {
+		if !e.undecided {
+			e.undecided = true
+			p.After(p.FastTimeout, func() { p.fallback(s) })
+		}
+		return
+	}
+	e.undecided = false
+	p.Broadcast(P3{
+		Ballot:   e.ballot,
+		Slot:     s,
+		Commands: e.commands,
+		TraceID:  e.trace,
+		Deps:     e.deps,
+	})
+}
+
+// fallback sends P3 of slot s if a fast quorum has not acked it within fast timeout
+func (p *Paxos) fallback(s int) {
+	e, exists := p.log[s]
+	if !exists || !e.undecided {
+		return
+	}
+	log.Debugw("fast path timeout", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": s})
+	e.classic = true
+	p.decide(s)
+}
@@ -0,0 +1,83 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// GroupStorage is Storage that makes appended records durable only on Sync,
+// so that records appended within one group commit share a single fsync
+type GroupStorage interface {
+	Storage
+
+	// Sync makes every record written so far durable
+	Sync() error
+}
+
+// group holds outgoing messages of node until records appended before them are durable
+type group struct {
+	paxi.Node
+	p *Paxos
+}
+
+func (g group) Send(to paxi.ID, m interface{}) {
+	g.p.durable(func() { g.Node.Send(to, m) })
+}
+
+func (g group) MulticastZone(zone int, m interface{}) {
+	g.p.durable(func() { g.Node.MulticastZone(zone, m) })
+}
+
+func (g group) MulticastQuorum(quorum int, m interface{}) {
+	g.p.durable(func() { g.Node.MulticastQuorum(quorum, m) })
+}
+
+func (g group) Broadcast(m interface{}) {
+	g.p.durable(func() { g.Node.Broadcast(m) })
+}
+
+// durable runs f right away if every appended record is durable, otherwise after the next group commit
+func (p *Paxos) durable(f func()) {
+	if p.unsynced == 0 {
+		f()
+		return
+	}
+	p.held = append(p.held, f)
+}
+
+// written counts record appended without fsync and schedules its group commit,
+// which happens once SyncBatch records are pending or SyncInterval passes, right away if neither is set
+func (p *Paxos) written() {
+	if p.group == nil {
+		return
+	}
+	p.unsynced++
+	if (p.SyncBatch > 0 && p.unsynced >= p.SyncBatch) || p.SyncInterval <= 0 {
+		p.sync()
+		return
+	}
+	if !p.flushing {
+		p.flushing = true
+		p.After(p.SyncInterval, func() {
+			p.flushing = false
+			p.sync()
+		})
+	}
+}
+
+// sync fsyncs records appended since last group commit and sends messages held for them
+func (p *Paxos) sync() {
+	if p.unsynced == 0 {
+		return
+	}
+	err := p.group.Sync()
+	if err != nil {
+		log.Fatalf("replica %s cannot sync %d records: %v", p.ID(), p.unsynced, err)
+	}
+	p.unsynced = 0
+	held := p.held
+	p.held = nil
+	for _, f := range held {
+		f()
+	}
+}
@@ -0,0 +1,95 @@
+package paxos
+
+import (
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// heartbeat broadcasts current ballot every heartbeat interval while being active leader,
+// together with P2a of its slots still uncommitted
+func (p *Paxos) heartbeat() {
+	if p.active {
+		p.Broadcast(Heartbeat{Ballot: p.ballot})
+		p.resend()
+	}
+	p.After(p.interval(), p.heartbeat)
+}
+
+// resend broadcasts P2a again for slots of current ballot not committed within one heartbeat interval,
+// so that execution does not stall on a lost P2a or P2b
+func (p *Paxos) resend() {
+	for s := p.execute; s <= p.slot; s++ {
+		e, exists := p.log[s]
+		if !exists || e.commit || e.ballot != p.ballot || p.since(e.timestamp) < p.Heartbeat {
+			continue
+		}
+		p.Broadcast(p.p2a(s, e.commands))
+	}
+}
+
+// watch starts phase 1 if nothing is heard from the leader within election timeout
+func (p *Paxos) watch() {
+	if !p.active && p.since(p.heard) >= p.timeout {
+		log.Debugw("leader timeout", log.Fields{"id": p.ID(), "ballot": p.ballot, "leader": p.ballot.ID(), "timeout": p.timeout})
+		p.heard = p.Clock.Now()
+		p.metrics.inc(&p.metrics.retries)
+		if p.election == preparing && p.outstanding() {
+			p.metrics.inc(&p.metrics.failures)
+		}
+		if p.Rejoin && p.election == preparing && p.outstanding() {
+			// own phase 1 is unanswered for a whole timeout, this node is likely partitioned
+			p.stale()
+		}
+		p.P1a()
+		// candidates of a split vote draw again so that they do not time out together next round
+		p.stagger()
+	}
+	d := p.timeout - p.since(p.heard)
+	if d <= 0 {
+		d = p.timeout
+	}
+	p.After(d, p.watch)
+}
+
+// stagger draws a new election timeout uniformly from [Timeout, MaxTimeout],
+// so that followers of the same leader rarely start phase 1 at the same time.
+// It is shifted by MaxTimeout for each node of higher priority, which then times out first.
+// With adaptive heartbeat both bounds are scaled down to the timeout measured by failure detector.
+func (p *Paxos) stagger() {
+	max := p.MaxTimeout
+	if max < p.Timeout {
+		max = 2 * p.Timeout
+	}
+	min := p.detect()
+	if min < p.Timeout {
+		max = time.Duration(float64(max) * float64(min) / float64(p.Timeout))
+	}
+	p.timeout = min + time.Duration(p.random(int64(max-min)+1)) + time.Duration(p.rank())*max
+	p.metrics.timeouts(min, max, p.timeout)
+}
+
+// HandleHeartbeat handles Heartbeat message
+func (p *Paxos) HandleHeartbeat(m Heartbeat) {
+	if p.returning(m.Ballot) || m.Ballot < p.ballot {
+		return
+	}
+	p.heard = p.Clock.Now()
+	p.settle()
+	if m.Ballot > p.ballot {
+		p.ballot = m.Ballot
+		p.active = false
+		p.observe()
+		p.forward()
+	}
+	if p.detector.ballot != m.Ballot {
+		p.detector.reset()
+		p.detector.ballot = m.Ballot
+	}
+	p.detector.heard(p.heard)
+	if p.AdaptiveHeartbeat && p.Timeout > 0 {
+		p.metrics.detected(p.heard, p.detector.mean())
+		p.stagger()
+	}
+	p.rerepair()
+}
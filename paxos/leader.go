@@ -0,0 +1,27 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// ReplyLeader replies client request r with the known leader, so the client retries there right away
+// instead of this node forwarding it or starting phase 1. This node handles r itself if it leads,
+// knows no leader yet, or the leader is silent for Timeout, since it would start phase 1 anyway.
+func (p *Paxos) ReplyLeader(r paxi.Request) {
+	leader := p.ballot.ID()
+	silent := !p.Learner && p.Timeout > 0 && p.since(p.heard) >= p.Timeout
+	if p.IsLeader() || p.ballot == 0 || silent {
+		p.HandleRequest(r)
+		return
+	}
+	log.Debugw("redirect", log.Fields{"id": p.ID(), "leader": leader, "command": r.Command})
+	reply := paxi.Reply{
+		Command:    r.Command,
+		Properties: make(map[string]string),
+		Timestamp:  p.Clock.Now().Unix(),
+		Leader:     leader,
+	}
+	reply.Properties[HTTPHeaderBallot] = p.ballot.String()
+	r.Reply(reply)
+}
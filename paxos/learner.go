@@ -0,0 +1,19 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+)
+
+// follow follows the leader of ballot b seen in P2a without accepting, since learner only applies committed slots,
+// pending requests are forwarded once a new leader is known
+func (p *Paxos) follow(b paxi.Ballot) {
+	if b < p.ballot {
+		return
+	}
+	p.heard = p.Clock.Now()
+	if b > p.ballot {
+		p.ballot = b
+		p.observe()
+		p.forward()
+	}
+}
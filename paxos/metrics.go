@@ -0,0 +1,271 @@
+package paxos
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ailidani/paxi"
+)
+
+// max number of latency samples kept for each histogram
+const samples = 10000
+
+// Metrics summarizes slot latency and leadership churn of one Paxos instance
+type Metrics struct {
+	Commit      paxi.Stat // latency in ms from proposal to commit of slots proposed by this node
+	Execute     paxi.Stat // latency in ms from proposal to execution of slots proposed by this node
+	Attempts    int       // number of phase 1 started
+	Preemptions int       // number of times own ballot is preempted by a higher ballot
+	Retries     int       // number of phase 1 restarted after backoff or leader timeout
+	Committed   int       // number of slots learned as committed
+	Executed    int       // number of slots executed
+	Election    paxi.Stat // latency in ms from first P1a to phase 1 quorum of elections won by this node
+	Elections   int       // number of elections won by this node
+	Failures    int       // number of own phase 1 preempted or timed out before reaching quorum
+	Coalesced   int       // number of requests attached to an identical command in flight
+
+	Ballot   paxi.Ballot // current ballot
+	Leader   bool        // whether this node is or tries to be the leader
+	InFlight int         // number of slots proposed but not executed yet
+	Priority int         // priority of the node of current ballot
+	Queued   int         // number of messages from peers waiting for handlers
+	Dropped  int         // number of messages from peers dropped while queue is full
+	Backlog  int         // number of slots from execute up to the highest committed slot
+
+	MinTimeout      time.Duration // lower bound of randomized election timeout, 0 if leader timeout is disabled
+	MaxTimeout      time.Duration // upper bound of randomized election timeout
+	ElectionTimeout time.Duration // election timeout currently drawn by this node
+	LastElection    time.Duration // duration of the last election won by this node, 0 if none
+
+	HeartbeatInterval time.Duration // current interval of adaptive heartbeat, 0 if not adaptive or not leading yet
+	Suspicion         float64       // phi of the leader failing after silence since its last heartbeat, 0 if not measured
+
+	Uncompressed int     // bytes of command values above compression threshold before compression
+	Compressed   int     // bytes of the same command values sent in P2a
+	Ratio        float64 // compressed over uncompressed bytes, 0 if nothing is compressed
+
+	BatchSize     int           // current max number of requests proposed in one slot
+	BatchInterval time.Duration // current max time a request waits for its batch
+
+	Backfilled int // bytes of repair replies and snapshot state served to recovering replicas
+
+	Links map[paxi.ID]paxi.Link // messages sent and failed to each peer, nil if node keeps no statistic
+}
+
+// histogram keeps the latest latency samples in a ring buffer
+type histogram struct {
+	data []time.Duration
+	next int
+}
+
+func (h *histogram) add(d time.Duration) {
+	if len(h.data) < samples {
+		h.data = append(h.data, d)
+		return
+	}
+	h.data[h.next] = d
+	h.next = (h.next + 1) % samples
+}
+
+// stat returns statistic of samples without raw data, empty if there is none
+func (h *histogram) stat() paxi.Stat {
+	if len(h.data) == 0 {
+		return paxi.Stat{}
+	}
+	s := paxi.Statistic(h.data)
+	s.Data = nil
+	return s
+}
+
+// metrics records latency samples and counters, safe to read from other goroutines
+type metrics struct {
+	sync.Mutex
+	commit      histogram
+	execute     histogram
+	attempts    int
+	preemptions int
+	retries     int
+	committed   int
+	executed    int
+	election    histogram
+	elections   int
+	failures    int
+	last        time.Duration // duration of the last election won
+	coalesced   int
+
+	ballot   paxi.Ballot
+	leader   bool
+	inflight int
+	priority int
+	backlog  int
+
+	minTimeout time.Duration
+	maxTimeout time.Duration
+	timeout    time.Duration
+
+	heartbeat time.Duration // current adaptive heartbeat interval
+	lastBeat  time.Time     // last heartbeat from the leader
+	arrival   time.Duration // mean inter-arrival time of heartbeats from the leader
+
+	uncompressed int
+	compressed   int
+
+	batchSize     int
+	batchInterval time.Duration
+
+	backfill int // bytes of backfill served
+}
+
+// since adds latency from proposal time t to now to h, ignores slots not proposed by this node
+func (m *metrics) since(h *histogram, t, now time.Time) {
+	if t.IsZero() {
+		return
+	}
+	m.Lock()
+	h.add(now.Sub(t))
+	m.Unlock()
+}
+
+// elected records duration d of an election won by this node, from its first P1a
+func (m *metrics) elected(d time.Duration) {
+	m.Lock()
+	m.election.add(d)
+	m.elections++
+	m.last = d
+	m.Unlock()
+}
+
+// lastElection returns duration of the last election won by this node
+func (m *metrics) lastElection() time.Duration {
+	m.Lock()
+	defer m.Unlock()
+	return m.last
+}
+
+// inc increments counter c
+func (m *metrics) inc(c *int) {
+	m.Lock()
+	*c++
+	m.Unlock()
+}
+
+// state records current ballot, leadership, number of in-flight slots and priority of the leader
+func (m *metrics) state(ballot paxi.Ballot, leader bool, inflight int, priority int) {
+	m.Lock()
+	m.ballot = ballot
+	m.leader = leader
+	m.inflight = paxi.Max(inflight, 0)
+	m.priority = priority
+	m.Unlock()
+}
+
+// timeouts records range of election timeout and the current draw
+func (m *metrics) timeouts(min, max, current time.Duration) {
+	m.Lock()
+	m.minTimeout = min
+	m.maxTimeout = max
+	m.timeout = current
+	m.Unlock()
+}
+
+// beat records current adaptive heartbeat interval
+func (m *metrics) beat(d time.Duration) {
+	m.Lock()
+	m.heartbeat = d
+	m.Unlock()
+}
+
+// detected records time of last heartbeat from the leader and mean inter-arrival time
+func (m *metrics) detected(last time.Time, mean time.Duration) {
+	m.Lock()
+	m.lastBeat = last
+	m.arrival = mean
+	m.Unlock()
+}
+
+// backfilled records size of one repair reply or snapshot chunk served
+func (m *metrics) backfilled(size int) {
+	m.Lock()
+	m.backfill += size
+	m.Unlock()
+}
+
+// deflated records size of one command value before and after compression
+func (m *metrics) deflated(before, after int) {
+	m.Lock()
+	m.uncompressed += before
+	m.compressed += after
+	m.Unlock()
+}
+
+// batched records current batch parameters
+func (m *metrics) batched(size int, interval time.Duration) {
+	m.Lock()
+	m.batchSize = size
+	m.batchInterval = interval
+	m.Unlock()
+}
+
+// observe records current protocol state for metrics readers and notifies leader change listeners
+func (p *Paxos) observe() {
+	p.metrics.state(p.ballot, p.IsLeader(), p.slot-p.execute+1, p.priority(p.ballot.ID()))
+	p.notify()
+}
+
+// Metrics returns the latency histograms and counters recorded so far
+func (p *Paxos) Metrics() Metrics {
+	p.metrics.Lock()
+	defer p.metrics.Unlock()
+	queued, dropped := 0, 0
+	if p.inbox != nil {
+		queued, dropped = p.inbox.Depth(), p.inbox.Dropped()
+	}
+	suspicion := 0.0
+	if !p.metrics.leader && !p.metrics.lastBeat.IsZero() {
+		suspicion = phi(p.Clock.Now().Sub(p.metrics.lastBeat), p.metrics.arrival)
+	}
+	ratio := 0.0
+	if p.metrics.uncompressed > 0 {
+		ratio = float64(p.metrics.compressed) / float64(p.metrics.uncompressed)
+	}
+	return Metrics{
+		Commit:      p.metrics.commit.stat(),
+		Execute:     p.metrics.execute.stat(),
+		Attempts:    p.metrics.attempts,
+		Preemptions: p.metrics.preemptions,
+		Retries:     p.metrics.retries,
+		Committed:   p.metrics.committed,
+		Executed:    p.metrics.executed,
+		Election:    p.metrics.election.stat(),
+		Elections:   p.metrics.elections,
+		Failures:    p.metrics.failures,
+		Coalesced:   p.metrics.coalesced,
+
+		Ballot:   p.metrics.ballot,
+		Leader:   p.metrics.leader,
+		InFlight: p.metrics.inflight,
+		Priority: p.metrics.priority,
+		Queued:   queued,
+		Dropped:  dropped,
+		Backlog:  p.metrics.backlog,
+
+		MinTimeout:      p.metrics.minTimeout,
+		MaxTimeout:      p.metrics.maxTimeout,
+		ElectionTimeout: p.metrics.timeout,
+		LastElection:    p.metrics.last,
+
+		HeartbeatInterval: p.metrics.heartbeat,
+		Suspicion:         suspicion,
+
+		Uncompressed: p.metrics.uncompressed,
+		Compressed:   p.metrics.compressed,
+		Ratio:        ratio,
+
+		BatchSize:     p.metrics.batchSize,
+		BatchInterval: p.metrics.batchInterval,
+
+		Backfilled: p.metrics.backfill,
+
+		Links: p.peerLinks(),
+	}
+}
@@ -1,37 +1,57 @@
 package paxos
 
 import (
-	"encoding/gob"
 	"fmt"
 
 	"github.com/ailidani/paxi"
 )
 
 func init() {
-	gob.Register(P1a{})
-	gob.Register(P1b{})
-	gob.Register(P2a{})
-	gob.Register(P2b{})
-	gob.Register(P3{})
+	paxi.RegisterMessage(P1a{})
+	paxi.RegisterMessage(P1b{})
+	paxi.RegisterMessage(P2a{})
+	paxi.RegisterMessage(P2b{})
+	paxi.RegisterMessage(P3{})
+	paxi.RegisterMessage(SnapshotRequest{})
+	paxi.RegisterMessage(SnapshotReply{})
+	paxi.RegisterMessage(Heartbeat{})
+	paxi.RegisterMessage(ReadIndex{})
+	paxi.RegisterMessage(ReadIndexAck{})
+	paxi.RegisterMessage(QuorumRead{})
+	paxi.RegisterMessage(QuorumReadAck{})
+	paxi.RegisterMessage(RepairRequest{})
+	paxi.RegisterMessage(RepairReply{})
+	paxi.RegisterMessage(TimeoutNow{})
+	paxi.RegisterMessage(PreVote{})
+	paxi.RegisterMessage(PreVoteReply{})
+	paxi.RegisterMessage(Digest{})
+	paxi.RegisterMessage(Rejoin{})
+	paxi.RegisterMessage(LeaderSlot{})
+	paxi.RegisterMessage(LeaderSlotAck{})
+	paxi.ReserveKey(ReconfigKey)
+	paxi.ReserveKey(RegisterKey)
+	paxi.ReserveKey(ResizeKey)
+	paxi.RegisterMessage(RejoinAck{})
 }
 
 // P1a prepare message
 type P1a struct {
-	Ballot paxi.Ballot
+	Ballot   paxi.Ballot
+	PreVoted bool // candidate won pre-vote or leadership transfer, current leader is presumed gone
 }
 
 func (m P1a) String() string {
-	return fmt.Sprintf("P1a {b=%v}", m.Ballot)
+	return fmt.Sprintf("P1a {b=%v prevoted=%t}", m.Ballot, m.PreVoted)
 }
 
-// CommandBallot conbines each command with its ballot number
+// CommandBallot conbines commands of each slot with its ballot number
 type CommandBallot struct {
-	Command paxi.Command
-	Ballot  paxi.Ballot
+	Commands []paxi.Command
+	Ballot   paxi.Ballot
 }
 
 func (cb CommandBallot) String() string {
-	return fmt.Sprintf("c=%v b=%v", cb.Command, cb.Ballot)
+	return fmt.Sprintf("c=%v b=%v", cb.Commands, cb.Ballot)
 }
 
 // P1b promise message
@@ -39,41 +59,249 @@ type P1b struct {
 	Ballot paxi.Ballot
 	ID     paxi.ID               // from node id
 	Log    map[int]CommandBallot // uncommitted logs
+	Low    int                   // compaction point of sender, slots before it are executed and no longer in Log
+
+	Epoch   int       // first slot of the last membership executed by sender
+	Members []paxi.ID // last membership executed by sender, nil if every node in config
+	Sizes   []int     // quorum sizes of the last membership executed by sender, nil follows config
+
+	Part  int // index of this part of Log split to fit max message size
+	Parts int // number of parts of Log, 0 if sent whole
 }
 
 func (m P1b) String() string {
-	return fmt.Sprintf("P1b {b=%v id=%s log=%v}", m.Ballot, m.ID, m.Log)
+	return fmt.Sprintf("P1b {b=%v id=%s log=%v low=%d epoch=%d members=%v part=%d/%d}", m.Ballot, m.ID, m.Log, m.Low, m.Epoch, m.Members, m.Part, m.Parts)
 }
 
 // P2a accept message
 type P2a struct {
-	Ballot  paxi.Ballot
-	Slot    int
-	Command paxi.Command
+	Ballot     paxi.Ballot
+	Slot       int
+	Commands   []paxi.Command // batch of commands in one slot
+	Commit     int            // every slot before Commit is committed by leader
+	Fast       bool           // acceptors broadcast P2b so every replica learns commit from a fast quorum
+	Compressed []int          // indices of commands whose value is deflated
+	TraceID    string         // trace of a traced request in slot, empty if none
 }
 
 func (m P2a) String() string {
-	return fmt.Sprintf("P2a {b=%v s=%d c=%v}", m.Ballot, m.Slot, m.Command)
+	return fmt.Sprintf("P2a {b=%v s=%d c=%v commit=%d fast=%t z=%v t=%s}", m.Ballot, m.Slot, m.Commands, m.Commit, m.Fast, m.Compressed, m.TraceID)
 }
 
 // P2b accepted message
 type P2b struct {
-	Ballot paxi.Ballot
-	ID     paxi.ID // from node id
-	Slot   int
+	Ballot   paxi.Ballot
+	ID       paxi.ID // from node id
+	Slot     int
+	TraceID  string // trace of P2a, empty if none
+	Conflict bool   // acceptor replaced different commands of an earlier ballot in the slot
 }
 
 func (m P2b) String() string {
-	return fmt.Sprintf("P2b {b=%v id=%s s=%d}", m.Ballot, m.ID, m.Slot)
+	return fmt.Sprintf("P2b {b=%v id=%s s=%d t=%s conflict=%t}", m.Ballot, m.ID, m.Slot, m.TraceID, m.Conflict)
 }
 
 // P3 commit message
 type P3 struct {
-	Ballot  paxi.Ballot
-	Slot    int
-	Command paxi.Command
+	Ballot   paxi.Ballot
+	Slot     int
+	Commands []paxi.Command
+	TraceID  string // trace of a traced request in slot, empty if none
+	// Deps are a prefix of slots and earlier slots conflicting with Commands that execute before this slot,
+	// nil if slot must execute in order
+	Deps []int
 }
 
 func (m P3) String() string {
-	return fmt.Sprintf("P3 {b=%v s=%d cmd=%v}", m.Ballot, m.Slot, m.Command)
+	return fmt.Sprintf("P3 {b=%v s=%d cmd=%v deps=%v t=%s}", m.Ballot, m.Slot, m.Commands, m.Deps, m.TraceID)
+}
+
+// SnapshotRequest asks the leader for state transfer when replica falls too far behind
+type SnapshotRequest struct {
+	ID          paxi.ID // from node id
+	LastExecute int
+}
+
+func (m SnapshotRequest) String() string {
+	return fmt.Sprintf("SnapshotRequest {id=%s e=%d}", m.ID, m.LastExecute)
+}
+
+// SnapshotReply carries state machine snapshot including every slot before Slot
+type SnapshotReply struct {
+	Ballot paxi.Ballot
+	Slot   int
+	State  paxi.Value
+	// Sessions is the de-duplication table at Slot
+	Sessions map[paxi.ID]session
+	// Members is the membership effective at Slot, nil if every node in config
+	Members []paxi.ID
+	// Sizes are quorum sizes of the membership effective at Slot, nil follows config
+	Sizes []int
+	// Executed are entries after Slot already executed out of order and included in State
+	Executed []Record
+	// Hash is the digest of commands executed before Slot
+	Hash uint64
+	// Offset of State in the whole state of Total bytes if snapshot is sent in chunks, Total is 0 otherwise
+	Offset int
+	Total  int
+	// Records and Clients are numbers of executed records and sessions of the whole snapshot if sent in chunks
+	Records int
+	Clients int
+}
+
+func (m SnapshotReply) String() string {
+	return fmt.Sprintf("SnapshotReply {b=%v s=%d size=%d offset=%d total=%d}", m.Ballot, m.Slot, len(m.State), m.Offset, m.Total)
+}
+
+// Heartbeat is broadcast by active leader to keep followers from starting phase 1
+type Heartbeat struct {
+	Ballot paxi.Ballot
+}
+
+func (m Heartbeat) String() string {
+	return fmt.Sprintf("Heartbeat {b=%v}", m.Ballot)
+}
+
+// ReadIndex asks followers to confirm leadership of Ballot for read round Seq
+type ReadIndex struct {
+	Ballot paxi.Ballot
+	Seq    int
+}
+
+func (m ReadIndex) String() string {
+	return fmt.Sprintf("ReadIndex {b=%v seq=%d}", m.Ballot, m.Seq)
+}
+
+// ReadIndexAck acknowledges ReadIndex with the highest ballot of ID
+type ReadIndexAck struct {
+	Ballot paxi.Ballot
+	ID     paxi.ID
+	Seq    int
+}
+
+func (m ReadIndexAck) String() string {
+	return fmt.Sprintf("ReadIndexAck {b=%v id=%s seq=%d}", m.Ballot, m.ID, m.Seq)
+}
+
+// QuorumRead asks for the highest accepted slot for quorum read round Seq of replica ID
+type QuorumRead struct {
+	ID  paxi.ID
+	Seq int
+}
+
+func (m QuorumRead) String() string {
+	return fmt.Sprintf("QuorumRead {id=%s seq=%d}", m.ID, m.Seq)
+}
+
+// QuorumReadAck replies QuorumRead with the highest slot accepted by ID
+type QuorumReadAck struct {
+	ID   paxi.ID
+	Seq  int
+	Slot int
+}
+
+func (m QuorumReadAck) String() string {
+	return fmt.Sprintf("QuorumReadAck {id=%s seq=%d s=%d}", m.ID, m.Seq, m.Slot)
+}
+
+// LeaderSlot asks the leader for its highest slot for barrier read Seq of replica ID
+type LeaderSlot struct {
+	ID  paxi.ID
+	Seq int
+}
+
+func (m LeaderSlot) String() string {
+	return fmt.Sprintf("LeaderSlot {id=%s seq=%d}", m.ID, m.Seq)
+}
+
+// LeaderSlotAck replies LeaderSlot with the highest slot of the leader
+type LeaderSlotAck struct {
+	Seq  int
+	Slot int
+}
+
+func (m LeaderSlotAck) String() string {
+	return fmt.Sprintf("LeaderSlotAck {seq=%d s=%d}", m.Seq, m.Slot)
+}
+
+// RepairRequest asks for the log entry of Slot that blocks execution of replica ID
+type RepairRequest struct {
+	ID   paxi.ID
+	Slot int
+}
+
+func (m RepairRequest) String() string {
+	return fmt.Sprintf("RepairRequest {id=%s s=%d}", m.ID, m.Slot)
+}
+
+// RepairReply carries the log entry of Slot
+type RepairReply struct {
+	Slot int
+	CommandBallot
+	Commit bool
+}
+
+func (m RepairReply) String() string {
+	return fmt.Sprintf("RepairReply {b=%v s=%d cmds=%v commit=%t}", m.Ballot, m.Slot, m.Commands, m.Commit)
+}
+
+// TimeoutNow asks the receiver to start phase 1 right away to take over leadership of Ballot
+type TimeoutNow struct {
+	Ballot paxi.Ballot
+}
+
+func (m TimeoutNow) String() string {
+	return fmt.Sprintf("TimeoutNow {b=%v}", m.Ballot)
+}
+
+// PreVote asks whether receiver would accept Ballot in phase 1
+type PreVote struct {
+	Ballot paxi.Ballot
+}
+
+func (m PreVote) String() string {
+	return fmt.Sprintf("PreVote {b=%v}", m.Ballot)
+}
+
+// PreVoteReply grants or denies pre-vote of Ballot, Leader is the current ballot of ID
+type PreVoteReply struct {
+	Ballot paxi.Ballot
+	ID     paxi.ID
+	Leader paxi.Ballot
+	Grant  bool
+}
+
+func (m PreVoteReply) String() string {
+	return fmt.Sprintf("PreVoteReply {b=%v id=%s leader=%v grant=%t}", m.Ballot, m.ID, m.Leader, m.Grant)
+}
+
+// Digest is the rolling hash of commands of every slot up to Slot executed by replica ID
+type Digest struct {
+	ID   paxi.ID
+	Slot int
+	Hash uint64
+}
+
+func (m Digest) String() string {
+	return fmt.Sprintf("Digest {id=%s s=%d hash=%x}", m.ID, m.Slot, m.Hash)
+}
+
+// Rejoin asks every node for its ballot and execute on behalf of returning replica ID
+type Rejoin struct {
+	ID paxi.ID
+}
+
+func (m Rejoin) String() string {
+	return fmt.Sprintf("Rejoin {id=%s}", m.ID)
+}
+
+// RejoinAck replies Rejoin with ballot and execute of replica ID, ballot is 0 if ID is stale itself
+type RejoinAck struct {
+	ID      paxi.ID
+	Ballot  paxi.Ballot
+	Execute int
+}
+
+func (m RejoinAck) String() string {
+	return fmt.Sprintf("RejoinAck {id=%s b=%v e=%d}", m.ID, m.Ballot, m.Execute)
 }
@@ -0,0 +1,23 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+)
+
+// OnLeaderChange registers f to be called whenever this node becomes or stops being the active leader, or the leader
+// of current ballot changes, it must be called before the node runs, f runs in message handling goroutine and must not block
+func (p *Paxos) OnLeaderChange(f func(isLeader bool, leader paxi.ID)) {
+	p.listeners = append(p.listeners, f)
+}
+
+// notify calls leader change listeners if leadership changed since last notification
+func (p *Paxos) notify() {
+	if p.active == p.leading && p.ballot.ID() == p.leader {
+		return
+	}
+	p.leading = p.active
+	p.leader = p.ballot.ID()
+	for _, f := range p.listeners {
+		f(p.leading, p.leader)
+	}
+}
@@ -0,0 +1,63 @@
+package paxos
+
+import (
+	"errors"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// ErrNotObserver is returned by Promote on a node that already votes
+var ErrNotObserver = errors.New("node is not an observer")
+
+// ErrNotCaughtUp is returned by Promote while observer still lags behind the leader
+var ErrNotCaughtUp = errors.New("observer is not caught up")
+
+// CaughtUp returns true if this node heard from a leader within leader timeout,
+// has no snapshot transfer pending and executed every slot it has seen committed or proposed.
+// An observer, which is a learner started to replace or add a voter, syncs by snapshot and repair
+// of slots it missed, then learns the stream of commits in P3 until it is caught up.
+func (p *Paxos) CaughtUp() bool {
+	if p.ballot == 0 || p.transfer || p.execute <= p.slot {
+		return false
+	}
+	return p.Timeout <= 0 || p.since(p.heard) < p.Timeout
+}
+
+// Promote proposes current membership with this observer added through the leader,
+// once executed the node votes in every following slot
+func (p *Paxos) Promote() error {
+	if !p.Learner {
+		return ErrNotObserver
+	}
+	if !p.CaughtUp() {
+		return ErrNotCaughtUp
+	}
+	ids := p.Members(p.execute)
+	if ids == nil {
+		ids = paxi.GetConfig().Voters()
+	}
+	for _, id := range ids {
+		if id == p.ID() {
+			// promotion is proposed already
+			return nil
+		}
+	}
+	log.Infow("promote", log.Fields{"id": p.ID(), "ballot": p.ballot, "execute": p.execute, "members": ids})
+	p.Reconfigure(append(ids, p.ID())...)
+	return nil
+}
+
+// promoted turns observer into a voter once membership effective from next slot includes it
+func (p *Paxos) promoted() {
+	if !p.Learner {
+		return
+	}
+	for _, id := range p.Members(p.execute + 1) {
+		if id == p.ID() {
+			log.Infow("observer promoted", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": p.execute + 1})
+			p.Learner = false
+			return
+		}
+	}
+}
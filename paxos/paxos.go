@@ -1,19 +1,32 @@
 package paxos
 
 import (
+	"math/rand"
 	"strconv"
 	"time"
 
 	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
 )
 
 // entry in log
 type entry struct {
 	ballot    paxi.Ballot
-	command   paxi.Command
+	commands  []paxi.Command // batch of commands in this slot, empty for no-op
 	commit    bool
-	request   *paxi.Request
+	requests  []*paxi.Request   // client request of each command followed by requests attached to identical commands, only kept by proposer
+	txn       *paxi.Transaction // client transaction of all commands, only kept by proposer
 	quorum    *paxi.Quorum
+	epoch     int          // first slot of membership the quorum is formed among
+	fast      *paxi.Quorum // acks of entry ballot seen by this replica in fast path
+	fastacks  *paxi.Quorum // acks without conflict of proposal of this leader in fast path
+	classic   bool         // proposal of this leader falls back to P3 of classic path
+	undecided bool         // committed by classic quorum, leader waits for fast quorum before sending P3
+	deps      []int        // earlier slots conflicting with commands, nil if slot executes in order
+	executed  bool         // executed out of order before every earlier slot
+	hash      uint64       // rolling hash of commands of every slot up to this one, set when execute passes it
+	trace     string       // trace carried by messages of this slot, empty if none
+	committed time.Time    // time this replica learns the commit, zero if unknown
 	timestamp time.Time
 }
 
@@ -25,34 +38,281 @@ type Paxos struct {
 
 	log     map[int]*entry // log ordered by slot
 	execute int            // next execute slot number
+	low     int            // compaction point, slots before it are executed and dropped from log
 	active  bool           // active leader
 	ballot  paxi.Ballot    // highest ballot number
 	slot    int            // highest slot number
+	lease   time.Time      // lease expiry of current leader
+	heard   time.Time      // last time a message from leader is received
+	elected time.Time      // last time this node became active leader
 
-	quorum   *paxi.Quorum    // phase 1 quorum
-	requests []*paxi.Request // phase 1 pending requests
+	quorum   *paxi.Quorum        // phase 1 quorum
+	joint    []phase1            // phase 1 quorums among memberships later than the executed one
+	granted  map[paxi.ID]bool    // nodes granted phase 1 of current ballot
+	promises map[paxi.ID][]bool  // parts of split promises of current ballot received from each node
+	requests []*paxi.Request     // phase 1 pending requests
+	batch    []*paxi.Request     // phase 2 requests waiting to be proposed in one slot
+	txns     []*paxi.Transaction // transactions waiting to be proposed
+	transfer bool                // waiting for snapshot reply
+	fetches  int                 // number of snapshot requests sent, retry timers of earlier requests are stale
+	fetched  paxi.ID             // node asked for snapshot by the latest request
+	progress time.Time           // time of the latest snapshot request or chunk received
+	sessions map[paxi.ID]session // last executed command of each client
+	attempt  int                 // number of consecutive failed phase 1 attempts
+	rival    paxi.ID             // candidate that preempted last phase 1 of this node
+	duels    int                 // number of consecutive phase 1 preempted by rival
+	election election            // phase 1 state of this node
+	prepared time.Time           // last time P1a or pre-vote is broadcast
+	started  time.Time           // first P1a of the election in progress, zero once any leader finishes phase 1
+	timeout  time.Duration       // current randomized election timeout
+	group    GroupStorage        // storage if it supports group commit, nil otherwise
+	unsynced int                 // number of records appended since last group commit
+	held     []func()            // messages held until the next group commit
+	flushing bool                // group commit is scheduled
+	metrics  metrics             // latency histograms and leadership counters
+	seq      int                 // sequence number of last read round
+	rounds   map[int]*read       // read rounds waiting for leadership confirmation
+	reads    []*read             // confirmed reads waiting for execution of read index
+	polls    map[int]*read       // quorum reads waiting for highest slot of a read quorum
+	waits    map[int]*read       // barrier reads waiting for highest slot of the leader
+	repair   int                 // slot of pending repair request, -1 if none
+	target   paxi.ID             // target of pending leadership transfer, empty if none
+	sweepAt  int64               // unix nano time of next scheduled sweep of expired pending requests, 0 if none
+	draining bool                // new client requests are rejected
+	drained  []chan struct{}     // closed once draining node executed every accepted request
+	reconfig int                 // slot of unexecuted reconfiguration, -1 if none
+	batcher  batcher             // adaptive batch size and interval
+	keys     map[paxi.Key]int    // slot of last executed command of each key, kept in out-of-order mode
+	barrier  int                 // slot of last executed reconfiguration, -1 if none
+	horizon  int                 // slots before horizon are installed from snapshot and their keys are unknown
+	hash     uint64              // rolling hash of commands of every slot before execute
+	diverged bool                // digest mismatch is found, next snapshot replaces state even if not ahead
+	rejoin   rejoin              // catching up with a quorum after restart or unanswered phase 1
+	executor *executor           // applies commands off the message handling goroutine, nil applies them inline
+	contents map[uint64]int      // slot of in-flight command of each content hash, kept when coalescing
+	inbox    paxi.Inbox          // queue of messages from peers of node, nil if node has none
+	floor    int                 // highest compaction point in P1b of current phase 1
+	donor    paxi.ID             // node reporting floor
+	stuck    bool                // backlog is above MaxBacklog and warned
+	links    paxi.Links          // sending statistic of node to each peer, nil if node keeps none
+	detector detector            // failure detector of heartbeats from the leader
+	paced    time.Time           // time backfill sent so far is paid for at Backfill rate
+	partial  paxi.Value          // state of snapshot chunks received so far
+	chunked  int                 // slot of snapshot being received in chunks
+	trailer  SnapshotReply       // executed records and sessions of snapshot chunks received so far
+
+	proxied map[string]*paxi.Request  // client requests forwarded to the leader and not executed yet
+	rtt     map[paxi.ID]time.Duration // smoothed round trip time from P2a to P2b of each peer, kept with rtt fan-out or adaptive heartbeat
+
+	listeners []func(isLeader bool, leader paxi.ID) // leader change callbacks
+	leading   bool                                  // active leadership last notified to listeners
+	leader    paxi.ID                               // leader last notified to listeners
+
+	subscribers []subscriber // callbacks of applied commands
+	changes     changes      // changes applied by executor workers, not yet delivered to subscribers
+
+	candidate paxi.Ballot  // ballot asked in pre-vote, 0 if none
+	votes     *paxi.Quorum // pre-vote grants
+	denied    bool         // pre-vote of candidate ballot is denied by some node
+	voted     bool         // pre-vote is granted, next phase 1 starts right away
+
+	memberships []membership // membership changes ordered by slot, empty if every node in config
 
 	Q1              func(*paxi.Quorum) bool
 	Q2              func(*paxi.Quorum) bool
 	ReplyWhenCommit bool
+	Learner         bool          // only learns committed slots from P3, never votes, proposes or starts phase 1
+	StateTransfer   bool          // request snapshot from leader when falling behind the log window
+	Repair          bool          // request missing slot from leader when execution is blocked
+	PreVote         bool          // probe with pre-vote before raising ballot in phase 1
+	Fast            bool          // replicas commit from a fast quorum of P2b without waiting for P3
+	FastTimeout     time.Duration // leader skips P3 of slot acked by a fast quorum without conflict within timeout, 0 always sends P3
+	MaxInflight     int           // max number of proposed but unexecuted slots, 0 is unlimited
+	MaxPending      int           // max number of requests waiting to be proposed, 0 is unlimited
+	RequestTimeout  time.Duration // default deadline of request, which fails if not committed within timeout, 0 waits forever
+	Heartbeat       time.Duration // interval of leader heartbeat, 0 disables heartbeat
+	Timeout         time.Duration // follower starts phase 1 if leader is silent for timeout, 0 disables
+	MaxTimeout      time.Duration // max election timeout, follower waits random timeout in [Timeout, MaxTimeout], twice Timeout if less than Timeout
+	Dedup           bool          // skip commands already executed for their client
+	SessionWindow   int           // number of slots an unregistered client session is kept after its last command, 0 keeps it within log window
+	ShadowMode      bool          // execute committed commands on a no-op state machine and reply to no client, advancing execute as usual
+	Rejoin          bool          // returning node adopts ballot and execution of a quorum before phase 1, after restart or unanswered phase 1
+	Coalesce        bool          // attach write request to an identical command in flight instead of proposing it in a new slot
+	DigestInterval  time.Duration // interval of broadcasting digest of executed slots, 0 disables
+	OutOfOrder      bool          // execute committed slot once its conflicting earlier slots are executed
+	AdaptiveBatch   bool          // tune batch size and interval to recent request arrival rate
+	Rand            *rand.Rand    // random source of backoff and timeout jitter, global source if nil
+	Clock           Clock         // source of current time
+	Grace           time.Duration // min leadership duration against phase 1 of candidates without pre-vote, 0 disables
+	MinElection     time.Duration // min interval between successive P1a or pre-vote broadcasts, 0 disables
+	FanOut          string        // order of sending P2a to peers, zone or rtt nearest first, any order if empty
+	Compress        int           // min size in bytes of command value compressed in P2a, 0 disables
+	QuorumRead      bool          // every node serves reads after asking a quorum for the highest slot, without leader or lease
+	ForwardTimeout  time.Duration // proxied request is handled locally if leader is silent for timeout, 0 waits forever
+	MaxRetry        int           // max number of times a request is retried after losing its slot, 0 is unlimited
+	Preemption      string        // handling of request that lost its slot to another leader, retry, fail or fifo, retry if empty
+	Storage         Storage       // persistent storage, nil if running in memory only
+	SyncInterval    time.Duration // max delay of group commit of group storage, 0 syncs every record
+	SyncBatch       int           // group storage syncs once this many records are pending, 0 waits for sync interval
+	Executors       int           // goroutines applying committed commands, 0 applies inline, more than 1 needs independent keys in state machine
+	Tracing         bool          // send spans of traced requests to Tracer as they pass each phase
+	QuorumTimeout   time.Duration // leader retries or steps down if slot has no phase 2 quorum within timeout, 0 disables
+	QuorumRetries   int           // number of P2a retries of a slot on quorum timeout before leader starts phase 1
+	MaxBacklog      int           // warn once committed but unexecuted slots exceed max backlog, 0 disables
+	Backfill        int           // max bytes per second of repair replies and snapshot chunks served to recovering replicas, 0 is unlimited
+	SnapshotChunk   int           // max bytes of state in one snapshot reply, 0 sends state in one reply
+	TransferTimeout time.Duration // snapshot request is sent again if no reply or chunk arrives within timeout, 0 disables
+	RepairTimeout   time.Duration // repair request is sent again if the slot is not repaired within timeout, 0 disables
+
+	StateMachine paxi.StateMachine // application applying committed commands, database of node by default
+	Priority     map[paxi.ID]int   // leader preference of each node, higher is preferred, 0 if not listed
+	Tracer       func(paxi.Span)   // receives spans when tracing, logs them by default
+
+	// buffer sizes and tunables default to global config, so that instances in one process are tuned independently
+	LogCapacity      int           // initial capacity of log, 0 grows on demand
+	ChanBufferSize   int           // buffer size of executor channels
+	BatchSize        int           // max number of requests proposed in one slot
+	BatchInterval    time.Duration // max time a request waits for its batch to fill
+	MaxMessageSize   int           // max bytes of commands with overhead of one phase 2 message, larger batches are split, 0 is unlimited
+	MinBatchSize     int           // min batch size of adaptive batching
+	MinBatchInterval time.Duration // min batch interval of adaptive batching
+	LogWindow        int           // number of executed entries kept in log, 0 keeps all
+	BackOff          time.Duration // base delay before retrying phase 1 after a failed attempt
+	MaxBackOff       time.Duration // max delay before retrying phase 1, multiplied by one plus the number of nodes of higher priority
+	Thrifty          bool          // send accept to the closest phase 2 quorum only
+	ThriftyTimeout   time.Duration // broadcast accept to every node if thrifty quorum does not ack within timeout
+	Lease            time.Duration // leader lease duration, 0 disables lease
+
+	// adaptive failure detection, Heartbeat and Timeout above stay the upper bounds
+	AdaptiveHeartbeat bool          // tune heartbeat interval to peer round trip time and election timeout to heartbeat arrivals
+	MinHeartbeat      time.Duration // min interval of adaptive heartbeat
+	MinTimeout        time.Duration // min election timeout of adaptive failure detection
+	PhiThreshold      float64       // suspicion level at which follower presumes the leader failed, 8 if 0
 }
 
 // NewPaxos creates new paxos instance
 func NewPaxos(n paxi.Node, options ...func(*Paxos)) *Paxos {
 	p := &Paxos{
 		Node:            n,
-		log:             make(map[int]*entry, paxi.GetConfig().BufferSize),
 		slot:            -1,
+		repair:          -1,
+		reconfig:        -1,
+		barrier:         -1,
+		keys:            make(map[paxi.Key]int),
 		quorum:          paxi.NewQuorum(),
 		requests:        make([]*paxi.Request, 0),
-		Q1:              func(q *paxi.Quorum) bool { return q.Majority() },
-		Q2:              func(q *paxi.Quorum) bool { return q.Majority() },
+		sessions:        make(map[paxi.ID]session),
+		rounds:          make(map[int]*read),
+		polls:           make(map[int]*read),
+		waits:           make(map[int]*read),
+		rtt:             make(map[paxi.ID]time.Duration),
+		proxied:         make(map[string]*paxi.Request),
+		contents:        make(map[uint64]int),
+		Q1:              func(q *paxi.Quorum) bool { return q.Q1() },
+		Q2:              func(q *paxi.Quorum) bool { return q.Q2() },
 		ReplyWhenCommit: false,
+		MaxInflight:     paxi.GetConfig().MaxInflight,
+		Learner:         paxi.GetConfig().IsLearner(n.ID()),
+		MaxPending:      paxi.GetConfig().MaxPending,
+		RequestTimeout:  time.Duration(paxi.GetConfig().RequestTimeout) * time.Millisecond,
+		FastTimeout:     time.Duration(paxi.GetConfig().FastTimeout) * time.Millisecond,
+		Dedup:           paxi.GetConfig().Dedup,
+		SessionWindow:   paxi.GetConfig().SessionWindow,
+		Coalesce:        paxi.GetConfig().Coalesce,
+		Rejoin:          paxi.GetConfig().Rejoin,
+		ShadowMode:      paxi.GetConfig().Shadow,
+		OutOfOrder:      paxi.GetConfig().OutOfOrder,
+		MaxRetry:        paxi.GetConfig().MaxRetry,
+		Preemption:      paxi.GetConfig().Preemption,
+		Grace:           time.Duration(paxi.GetConfig().Grace) * time.Millisecond,
+		MinElection:     time.Duration(paxi.GetConfig().MinElection) * time.Millisecond,
+		Compress:        paxi.GetConfig().Compress,
+		FanOut:          paxi.GetConfig().FanOut,
+		QuorumRead:      paxi.GetConfig().QuorumRead,
+		ForwardTimeout:  time.Duration(paxi.GetConfig().ForwardTimeout) * time.Millisecond,
+		SyncInterval:    time.Duration(paxi.GetConfig().SyncInterval) * time.Millisecond,
+		SyncBatch:       paxi.GetConfig().SyncBatch,
+		Executors:       paxi.GetConfig().Executors,
+		DigestInterval:  time.Duration(paxi.GetConfig().Digest) * time.Millisecond,
+		AdaptiveBatch:   paxi.GetConfig().AdaptiveBatch,
+		Priority:        paxi.GetConfig().Priority,
+		Tracing:         paxi.GetConfig().Tracing,
+		QuorumTimeout:   time.Duration(paxi.GetConfig().QuorumTimeout) * time.Millisecond,
+		QuorumRetries:   paxi.GetConfig().QuorumRetries,
+		MaxBacklog:      paxi.GetConfig().MaxBacklog,
+		Backfill:        paxi.GetConfig().Backfill,
+		SnapshotChunk:   paxi.GetConfig().SnapshotChunk,
+		TransferTimeout: time.Duration(paxi.GetConfig().Transfer) * time.Millisecond,
+		RepairTimeout:   time.Duration(paxi.GetConfig().RepairTimeout) * time.Millisecond,
+		Tracer:          paxi.Span.Export,
+		Clock:           realClock{},
+		StateMachine:    n,
+
+		LogCapacity:      paxi.GetConfig().LogCapacity,
+		ChanBufferSize:   paxi.GetConfig().ChanBufferSize,
+		BatchSize:        paxi.GetConfig().BatchSize,
+		BatchInterval:    time.Duration(paxi.GetConfig().BatchInterval) * time.Millisecond,
+		MaxMessageSize:   paxi.GetConfig().MaxMessageSize,
+		MinBatchSize:     paxi.GetConfig().MinBatchSize,
+		MinBatchInterval: time.Duration(paxi.GetConfig().MinBatchInterval) * time.Millisecond,
+		LogWindow:        paxi.GetConfig().LogWindow,
+		BackOff:          time.Duration(paxi.GetConfig().BackOff) * time.Millisecond,
+		MaxBackOff:       time.Duration(paxi.GetConfig().MaxBackOff) * time.Millisecond,
+		Thrifty:          paxi.GetConfig().Thrifty,
+		ThriftyTimeout:   time.Duration(paxi.GetConfig().ThriftyTimeout) * time.Millisecond,
+		Lease:            time.Duration(paxi.GetConfig().Lease) * time.Millisecond,
 	}
 
 	for _, opt := range options {
 		opt(p)
 	}
+	if p.log == nil {
+		p.log = make(map[int]*entry, p.LogCapacity)
+	}
+	if p.batcher.max == 0 {
+		p.batcher = newBatcher(p)
+	}
+
+	// config from master is not validated by Config.Load
+	config := paxi.GetConfig()
+	if n := len(config.Voters()); n > 0 && !paxi.Intersect(n, config.Q1Size, config.Q2Size) {
+		log.Fatalf("replica %s refuses to start: q1_size %d and q2_size %d do not intersect in %d nodes", p.ID(), config.Q1Size, config.Q2Size, n)
+	}
+	p.metrics.batched(p.batchSize(), p.batchInterval())
+
+	if p.Executors > 0 {
+		p.executor = newExecutor(p.Executors, p.ChanBufferSize)
+	}
+
+	if q, ok := p.Node.(paxi.Inbox); ok {
+		// before wrapping node that hides its queue
+		p.inbox = q
+	}
+	if l, ok := p.Node.(paxi.Links); ok {
+		p.links = l
+	}
+	if g, ok := p.Storage.(GroupStorage); ok {
+		p.group = g
+		p.Node = group{Node: p.Node, p: p}
+	}
+	if p.Storage != nil {
+		p.replay()
+		if p.Rejoin {
+			p.stale()
+		}
+	}
+
+	if p.Heartbeat > 0 {
+		p.After(p.Heartbeat, p.heartbeat)
+	}
+	if p.Timeout > 0 {
+		p.heard = p.Clock.Now()
+		p.stagger()
+		p.After(p.timeout, p.watch)
+	}
+	if p.DigestInterval > 0 {
+		p.After(p.DigestInterval, p.digest)
+	}
 
 	return p
 }
@@ -75,70 +335,326 @@ func (p *Paxos) Ballot() paxi.Ballot {
 // SetActive sets current paxos instance as active leader
 func (p *Paxos) SetActive(active bool) {
 	p.active = active
+	p.observe()
 }
 
 // SetBallot sets a new ballot number
 func (p *Paxos) SetBallot(b paxi.Ballot) {
 	p.ballot = b
+	p.observe()
 }
 
 // HandleRequest handles request and start phase 1 or phase 2
 func (p *Paxos) HandleRequest(r paxi.Request) {
 	// log.Debugf("Replica %s received %v\n", p.ID(), r)
+	if r.Timestamp == 0 {
+		r.Timestamp = p.Clock.Now().UnixNano()
+	}
+	if r.Deadline == 0 && p.RequestTimeout > 0 {
+		r.Deadline = r.Timestamp + int64(p.RequestTimeout)
+	}
+	if r.Barrier && r.Command.IsRead() {
+		p.HandleBarrierRead(r)
+		return
+	}
+	if p.rejectDraining(&r) || p.reserved(&r) || p.oversize(&r) {
+		return
+	}
+	if p.AdaptiveBatch {
+		p.tune(p.Clock.Now())
+	}
 	if !p.active {
-		p.requests = append(p.requests, &r)
+		p.hold(&r)
 		// current phase 1 pending
 		if p.ballot.ID() != p.ID() {
-			p.P1a()
+			p.campaign()
+		}
+	} else if p.blocked() {
+		// hold request until leadership transfer, reconfiguration or inflight slots drain
+		p.hold(&r)
+	} else if size := p.batchSize(); size > 1 {
+		p.batch = append(p.batch, &r)
+		if r.Priority > 0 {
+			// urgent request goes first in the batch and does not wait for it to fill
+			copy(p.batch[1:], p.batch)
+			p.batch[0] = &r
+			p.flush()
+		} else if len(p.batch) >= size {
+			p.flush()
+		} else if len(p.batch) == 1 {
+			p.After(p.batchInterval(), p.flush)
 		}
 	} else {
 		p.P2a(&r)
 	}
 }
 
+// full returns true if number of inflight slots reaches the limit
+func (p *Paxos) full() bool {
+	return p.MaxInflight > 0 && p.slot-p.execute+1 >= p.MaxInflight
+}
+
+// blocked returns true if active leader must not propose new slots for now
+func (p *Paxos) blocked() bool {
+	return p.target != "" || p.reconfig >= 0 || p.full()
+}
+
+// drain proposes requests held by flow control as inflight slots are executed
+func (p *Paxos) drain() {
+	if !p.active {
+		return
+	}
+	size := paxi.Max(p.BatchSize, 1)
+	for len(p.requests) > 0 && !p.blocked() {
+		n := size
+		if n > len(p.requests) {
+			n = len(p.requests)
+		}
+		p.P2a(p.requests[:n]...)
+		p.requests = p.requests[n:]
+	}
+	for len(p.txns) > 0 && !p.blocked() {
+		t := p.txns[0]
+		p.txns = p.txns[1:]
+		p.phase2(&entry{commands: t.Commands, txn: t})
+	}
+}
+
+// flush proposes current batch of requests in one slot
+func (p *Paxos) flush() {
+	if len(p.batch) == 0 {
+		return
+	}
+	if p.active && !p.blocked() {
+		p.P2a(p.batch...)
+	} else {
+		for _, r := range p.batch {
+			p.enqueue(r)
+		}
+	}
+	p.batch = nil
+}
+
 // P1a starts phase 1 prepare
 func (p *Paxos) P1a() {
-	if p.active {
+	if p.active || p.Learner || p.draining {
+		return
+	}
+	if p.rejoin.stale {
+		// one rejoin round per leader timeout while catching up
+		if p.rejoin.acks == nil || (p.Timeout > 0 && p.since(p.prepared) >= p.Timeout) {
+			p.probe()
+		}
+		return
+	}
+	if p.PreVote && !p.voted {
+		p.preVote()
+		return
+	}
+	voted := p.voted
+	p.voted = false
+	p.election = preparing
+	p.prepared = p.Clock.Now()
+	err := p.ballot.Next(p.ID())
+	if err != nil {
+		log.Errorf("replica %s cannot start phase 1 from ballot %v: %v", p.ID(), p.ballot, err)
+		return
+	}
+	if p.ballot.Headroom() < 1<<16 {
+		log.Warningf("replica %s ballot %v is close to overflow", p.ID(), p.ballot)
+	}
+	p.metrics.inc(&p.metrics.attempts)
+	if p.started.IsZero() {
+		p.started = p.Clock.Now()
+	}
+	p.observe()
+	p.saveBallot()
+	p.floor, p.donor = 0, ""
+	// new quorum picks up sizes changed at runtime
+	p.prepare()
+	p.Broadcast(P1a{Ballot: p.ballot, PreVoted: voted})
+}
+
+// election is the phase 1 state of a node that is not the active leader
+type election int
+
+const (
+	idle      election = iota // no phase 1 in progress
+	waiting                   // phase 1 is scheduled after backoff or min election interval
+	voting                    // pre-vote is broadcast, waiting for grants
+	preparing                 // P1a is broadcast, waiting for P1b
+	rejoining                 // rejoin is broadcast, waiting for ballot and execute of a quorum
+)
+
+// outstanding returns true if pre-vote or P1a of this node is still waiting for replies
+func (p *Paxos) outstanding() bool {
+	switch p.election {
+	case waiting:
+		return true
+	case voting:
+		return p.candidate > p.ballot && !p.denied
+	case preparing:
+		return !p.active && p.ballot.ID() == p.ID()
+	case rejoining:
+		return p.rejoin.stale
+	}
+	return false
+}
+
+// campaign starts phase 1 right away, or after backoff delay if previous attempts failed,
+// requests arriving while phase 1 is outstanding or scheduled join the same election.
+// Pending requests go to the known leader instead if this node yields to it.
+func (p *Paxos) campaign() {
+	if p.Learner {
+		// learner never leads, requests wait for a known leader
+		if p.ballot != 0 {
+			p.forward()
+		}
+		return
+	}
+	if p.outstanding() {
+		return
+	}
+	if p.yields() {
+		p.forward()
+		return
+	}
+	var wait time.Duration
+	if p.attempt > 0 {
+		wait = p.backoff()
+	}
+	if d := p.MinElection - p.since(p.prepared); d > wait {
+		wait = d
+	}
+	if wait <= 0 {
+		p.P1a()
 		return
 	}
-	p.ballot.Next(p.ID())
-	p.quorum.Reset()
-	p.quorum.ACK(p.ID())
-	p.Broadcast(P1a{Ballot: p.ballot})
+	p.election = waiting
+	p.After(wait, func() {
+		p.election = idle
+		if p.yields() {
+			p.forward()
+		} else if !p.active && p.ballot.ID() != p.ID() && len(p.requests) > 0 {
+			p.metrics.inc(&p.metrics.retries)
+			p.P1a()
+		}
+	})
 }
 
-// P2a starts phase 2 accept
-func (p *Paxos) P2a(r *paxi.Request) {
+// backoff returns truncated exponential delay of current attempt with random jitter, multiplied by one plus
+// the number of nodes of higher priority, or tie-break delay if dueling with another candidate
+func (p *Paxos) backoff() time.Duration {
+	if p.duels >= duel {
+		return p.tiebreak()
+	}
+	return time.Duration(1+p.rank()) * p.delay(p.attempt)
+}
+
+// delay returns exponential delay of given attempt with random jitter in [d/2, d], where d is base * 2^attempt
+// truncated to max, so that jitter never takes the delay beyond max
+func (p *Paxos) delay(attempt int) time.Duration {
+	base := paxi.Max(int(p.BackOff/time.Millisecond), 1)
+	max := paxi.Max(int(p.MaxBackOff/time.Millisecond), base)
+	d := max
+	if attempt <= 30 && base<<uint(attempt) < max {
+		d = base << uint(attempt)
+	}
+	low := paxi.Max(d/2, base)
+	jitter := p.random(int64(d - low + 1))
+	return time.Duration(int64(low)+jitter) * time.Millisecond
+}
+
+// random returns a random number in [0, n) from Rand, or the global source if Rand is not set
+func (p *Paxos) random(n int64) int64 {
+	if p.Rand == nil {
+		return rand.Int63n(n)
+	}
+	return p.Rand.Int63n(n)
+}
+
+// P2a starts phase 2 accept of requests in next slot
+func (p *Paxos) P2a(requests ...*paxi.Request) {
+	if p.Coalesce {
+		requests = p.coalesce(requests)
+		if len(requests) == 0 {
+			return
+		}
+	}
+	for _, batch := range p.split(requests) {
+		commands := make([]paxi.Command, len(batch))
+		for i, r := range batch {
+			commands[i] = r.Command
+		}
+		p.phase2(&entry{
+			commands: commands,
+			requests: batch,
+		})
+		if p.Coalesce {
+			p.index(p.slot, commands)
+		}
+	}
+}
+
+// phase2 starts phase 2 accept of entry e in next slot
+func (p *Paxos) phase2(e *entry) {
 	p.slot++
-	p.log[p.slot] = &entry{
-		ballot:    p.ballot,
-		command:   r.Command,
-		request:   r,
-		quorum:    paxi.NewQuorum(),
-		timestamp: time.Now(),
-	}
-	p.log[p.slot].quorum.ACK(p.ID())
-	m := P2a{
-		Ballot:  p.ballot,
-		Slot:    p.slot,
-		Command: r.Command,
+	p.accept(p.slot, e)
+}
+
+// accept starts phase 2 accept of entry e in slot s
+func (p *Paxos) accept(s int, e *entry) {
+	if p.overwrites(s, p.log[s], p.ballot, e.commands) {
+		return
+	}
+	e.ballot = p.ballot
+	p.join(s, e)
+	e.timestamp = p.Clock.Now()
+	p.log[s] = e
+	e.quorum.ACK(p.ID())
+	p.propose(s, e.commands)
+	if p.Tracing {
+		e.trace = traced(e.requests)
+		for _, r := range e.requests {
+			if r != nil {
+				p.trace(r.TraceID, "queue", s, time.Unix(0, r.Timestamp), e.timestamp, nil)
+			}
+		}
 	}
-	if paxi.GetConfig().Thrifty {
-		p.MulticastQuorum(paxi.GetConfig().N()/2+1, m)
+	p.appendEntry(s)
+	m := p.p2a(s, e.commands)
+	if p.Thrifty {
+		p.thrifty(m)
+	} else if p.FanOut != "" {
+		p.fanOut(m)
 	} else {
 		p.Broadcast(m)
 	}
+	p.deadline(m.Slot)
+	p.stall(m.Slot)
 }
 
 // HandleP1a handles P1a message
 func (p *Paxos) HandleP1a(m P1a) {
 	// log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, p.ID())
+	if p.returning(m.Ballot) {
+		return
+	}
 
-	// new leader
-	if m.Ballot > p.ballot {
+	// new leader, unless lease of current leader is still valid or this leader is just elected
+	if m.Ballot > p.ballot && !p.leased(m.Ballot) && !p.sticky(m) {
+		if p.ballot.ID() == p.ID() {
+			if !p.active {
+				p.metrics.inc(&p.metrics.failures)
+			}
+			p.attempt++
+			p.metrics.inc(&p.metrics.preemptions)
+			p.contend(m.Ballot.ID())
+		}
 		p.ballot = m.Ballot
 		p.active = false
-		// TODO use BackOff time or forward
+		p.observe()
+		p.saveBallot()
 		// forward pending requests to new leader
 		p.forward()
 		// if len(p.requests) > 0 {
@@ -146,34 +662,63 @@ func (p *Paxos) HandleP1a(m P1a) {
 		// }
 	}
 
+	if p.Learner {
+		return
+	}
+
+	// committed entries are included so that new leader never overwrites a chosen slot it missed
 	l := make(map[int]CommandBallot)
-	for s := p.execute; s <= p.slot; s++ {
-		if p.log[s] == nil || p.log[s].commit {
+	for s := p.low; s <= p.slot; s++ {
+		if p.log[s] == nil {
 			continue
 		}
-		l[s] = CommandBallot{p.log[s].command, p.log[s].ballot}
+		l[s] = CommandBallot{p.log[s].commands, p.log[s].ballot}
 	}
 
-	p.Send(m.Ballot.ID(), P1b{
-		Ballot: p.ballot,
-		ID:     p.ID(),
-		Log:    l,
-	})
+	executed := p.effective(p.execute)
+	for _, part := range p.parts(P1b{
+		Ballot:  p.ballot,
+		ID:      p.ID(),
+		Log:     l,
+		Low:     p.low,
+		Epoch:   executed.slot,
+		Members: executed.ids,
+		Sizes:   executed.sizes,
+	}) {
+		p.Send(m.Ballot.ID(), part)
+	}
 }
 
 func (p *Paxos) update(scb map[int]CommandBallot) {
 	for s, cb := range scb {
+		if s < p.execute {
+			continue
+		}
 		p.slot = paxi.Max(p.slot, s)
 		if e, exists := p.log[s]; exists {
 			if !e.commit && cb.Ballot > e.ballot {
+				if !equal(e.commands, cb.Commands) {
+					// own proposal lost the slot, propose requests again in new slots
+					for _, r := range e.requests {
+						if r != nil && p.bounce(r) {
+							request := *r
+							p.After(p.delay(r.Retries), func() { p.HandleRequest(request) })
+						}
+					}
+					e.requests = nil
+					if e.txn != nil {
+						p.txns = append(p.txns, e.txn)
+						e.txn = nil
+					}
+				}
 				e.ballot = cb.Ballot
-				e.command = cb.Command
+				e.commands = cb.Commands
 			}
 		} else {
 			p.log[s] = &entry{
-				ballot:  cb.Ballot,
-				command: cb.Command,
-				commit:  false,
+				ballot:   cb.Ballot,
+				commands: cb.Commands,
+				commit:   false,
 			}
 		}
 	}
@@ -193,8 +738,17 @@ func (p *Paxos) HandleP1b(m P1b) {
 
 	// reject message
 	if m.Ballot > p.ballot {
+		if p.ballot.ID() == p.ID() {
+			if !p.active {
+				p.metrics.inc(&p.metrics.failures)
+			}
+			p.attempt++
+			p.metrics.inc(&p.metrics.preemptions)
+			p.contend(m.Ballot.ID())
+		}
 		p.ballot = m.Ballot
 		p.active = false // not necessary
+		p.observe()
 		// forward pending requests to new leader
 		p.forward()
 		// p.P1a()
@@ -202,29 +756,52 @@ func (p *Paxos) HandleP1b(m P1b) {
 
 	// ack message
 	if m.Ballot.ID() == p.ID() && m.Ballot == p.ballot {
-		p.quorum.ACK(m.ID)
-		if p.Q1(p.quorum) {
+		if m.Low > p.floor {
+			p.floor, p.donor = m.Low, m.ID
+		}
+		// memberships the candidate has not executed need a phase 1 quorum as well
+		p.join1(membership{slot: m.Epoch, ids: m.Members, sizes: m.Sizes})
+		for s, cb := range m.Log {
+			if s >= p.execute {
+				p.discover(s, cb.Commands)
+			}
+		}
+		if !p.assembled(m) {
+			return
+		}
+		p.grant(m.ID)
+		if p.promised() {
 			p.active = true
+			p.elected = p.Clock.Now()
+			// lease renewed as follower of the old leader is not a lease of this ballot
+			p.lease = time.Time{}
+			p.observe()
+			p.attempt = 0
+			p.election = idle
+			p.metrics.elected(p.elected.Sub(p.started))
+			p.settle()
+			p.target = ""
 			// propose any uncommitted entries
-			for i := p.execute; i <= p.slot; i++ {
-				// TODO nil gap?
-				if p.log[i] == nil || p.log[i].commit {
+			for i := p.compaction(); i <= p.slot; i++ {
+				if p.log[i] == nil {
+					// fill the gap with no-op so execution can proceed
+					p.log[i] = &entry{commands: []paxi.Command{paxi.NoOp()}}
+				}
+				if p.log[i].commit {
 					continue
 				}
 				p.log[i].ballot = p.ballot
-				p.log[i].quorum = paxi.NewQuorum()
+				p.join(i, p.log[i])
 				p.log[i].quorum.ACK(p.ID())
-				p.Broadcast(P2a{
-					Ballot:  p.ballot,
-					Slot:    i,
-					Command: p.log[i].command,
-				})
-			}
-			// propose new commands
-			for _, req := range p.requests {
-				p.P2a(req)
+				p.propose(i, p.log[i].commands)
+				p.log[i].timestamp = p.Clock.Now()
+				p.appendEntry(i)
+				p.Broadcast(p.p2a(i, p.log[i].commands))
+				p.stall(i)
 			}
-			p.requests = make([]*paxi.Request, 0)
+			// propose new commands, except those already recovered in the log
+			p.recovered()
+			p.drain()
 		}
 	}
 }
@@ -233,43 +810,85 @@ func (p *Paxos) HandleP1b(m P1b) {
 func (p *Paxos) HandleP2a(m P2a) {
 	// log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, p.ID())
 
+	start := p.Clock.Now()
+	commands, err := inflate(m, p.MaxMessageSize)
+	if err != nil {
+		log.Errorf("replica %s cannot decompress slot %d from %s: %v", p.ID(), m.Slot, m.Ballot.ID(), err)
+		return
+	}
+	m.Commands = commands
+	m.Compressed = nil
+	if p.returning(m.Ballot) {
+		return
+	}
+	if p.Learner {
+		p.follow(m.Ballot)
+		return
+	}
+
+	conflict := false
 	if m.Ballot >= p.ballot {
 		p.ballot = m.Ballot
 		p.active = false
+		p.settle()
+		p.renew(p.Clock.Now())
+		p.heard = p.Clock.Now()
 		// update slot number
 		p.slot = paxi.Max(p.slot, m.Slot)
 		// update entry
 		if e, exists := p.log[m.Slot]; exists {
+			if m.Ballot > e.ballot && p.overwrites(m.Slot, e, m.Ballot, m.Commands) {
+				// proposal of a later ballot must carry the chosen commands
+				return
+			}
 			if !e.commit && m.Ballot > e.ballot {
-				// different command and request is not nil
-				if !e.command.Equal(m.Command) && e.request != nil {
-					p.Forward(m.Ballot.ID(), *e.request)
-					// p.Retry(*e.request)
-					e.request = nil
+				// different commands and requests are not nil
+				if !equal(e.commands, m.Commands) {
+					p.redirect(e, m.Ballot.ID())
+					conflict = true
 				}
-				e.command = m.Command
+				e.commands = m.Commands
 				e.ballot = m.Ballot
+				e.fast = nil
 			}
-		} else {
+		} else if m.Slot >= p.execute {
+			// executed slots might already be garbage collected
 			p.log[m.Slot] = &entry{
-				ballot:  m.Ballot,
-				command: m.Command,
-				commit:  false,
+				ballot:   m.Ballot,
+				commands: m.Commands,
+				commit:   false,
+			}
+		}
+		if e, exists := p.log[m.Slot]; exists && !e.commit {
+			if m.TraceID != "" {
+				e.trace = m.TraceID
 			}
+			p.appendEntry(m.Slot)
 		}
+		p.catchup(m.Slot, m.Ballot.ID())
+		p.commit(m.Ballot, m.Commit)
+		p.observe()
 	}
 
-	p.Send(m.Ballot.ID(), P2b{
-		Ballot: p.ballot,
-		Slot:   m.Slot,
-		ID:     p.ID(),
-	})
+	p.accepted(m, conflict)
+	p.trace(m.TraceID, "accept", m.Slot, start, p.Clock.Now(), map[string]interface{}{"leader": string(m.Ballot.ID())})
 }
 
-// HandleP2b handles P2b message
+// HandleP2b handles P2b message. Leader counts the ack toward the phase 2 quorum of the slot among the
+// membership the slot was proposed in, whose first slot is the epoch kept in the entry. If a reconfiguration
+// in an earlier slot commits or is proposed after this slot, the membership of the slot is no longer the one
+// the acks were counted in, so they are dropped and counted again among the new members, and its P2a is sent
+// again since the new members may never have received it. Acceptor of a higher ballot makes this node step down.
 func (p *Paxos) HandleP2b(m P2b) {
 	// old message
-	if m.Ballot < p.log[m.Slot].ballot || p.log[m.Slot].commit {
+	e, exists := p.log[m.Slot]
+	if exists && e.undecided && m.Ballot == e.ballot && m.Ballot.ID() == p.ID() {
+		// committed slot waits for the rest of its fast quorum
+		p.fastAck(m.Slot, e, m)
+		p.decide(m.Slot)
+		return
+	}
+	if !exists || m.Ballot < e.ballot || e.commit {
 		return
 	}
 
@@ -280,31 +899,51 @@ func (p *Paxos) HandleP2b(m P2b) {
 	if m.Ballot > p.ballot {
 		p.ballot = m.Ballot
 		p.active = false
+		p.observe()
 	}
 
 	// ack message
 	// the current slot might still be committed with q2
 	// if no q2 can be formed, this slot will be retried when received p2a or p3
 	if m.Ballot.ID() == p.ID() && m.Ballot == p.log[m.Slot].ballot {
+		if (p.FanOut == FanOutRTT || p.AdaptiveHeartbeat) && !e.timestamp.IsZero() && !acked(e.quorum, m.ID) {
+			p.sample(m.ID, p.since(e.timestamp))
+		}
+		p.trace(m.TraceID, "ack", m.Slot, e.timestamp, p.Clock.Now(), map[string]interface{}{"from": string(m.ID)})
+		if p.proposed(m.Slot).slot != e.epoch {
+			// membership of the slot changed since proposal, acks of removed members must not count
+			// and added members have not seen P2a, so the slot is counted and accepted again from scratch
+			p.join(m.Slot, e)
+			e.quorum.ACK(p.ID())
+			e.fastacks = nil
+			p.Broadcast(p.p2a(m.Slot, e.commands))
+		}
 		p.log[m.Slot].quorum.ACK(m.ID)
+		if p.Fast {
+			p.fastAck(m.Slot, e, m)
+		}
 		if p.Q2(p.log[m.Slot].quorum) {
 			p.log[m.Slot].commit = true
-			p.Broadcast(P3{
-				Ballot:  m.Ballot,
-				Slot:    m.Slot,
-				Command: p.log[m.Slot].command,
-			})
+			e.committed = p.Clock.Now()
+			p.traceAll(e, "commit", m.Slot, e.timestamp, e.committed)
+			p.metrics.inc(&p.metrics.committed)
+			p.metrics.since(&p.metrics.commit, p.log[m.Slot].timestamp, p.Clock.Now())
+			p.renew(p.log[m.Slot].timestamp)
+			p.appendEntry(m.Slot)
+			if p.OutOfOrder {
+				p.log[m.Slot].deps = p.dependencies(m.Slot)
+			}
+			p.decide(m.Slot)
 
 			if p.ReplyWhenCommit {
-				r := p.log[m.Slot].request
-				r.Reply(paxi.Reply{
-					Command:   r.Command,
-					Timestamp: r.Timestamp,
-				})
+				p.reply(m.Slot, p.log[m.Slot])
 			} else {
 				p.exec()
 			}
+			p.handoff()
 		}
+	} else if m.Ballot.ID() != p.ID() {
+		p.handleFastP2b(m)
 	}
 }
 
@@ -313,62 +952,333 @@ func (p *Paxos) HandleP3(m P3) {
 	// log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, p.ID())
 
 	p.slot = paxi.Max(p.slot, m.Slot)
+	if m.Ballot >= p.ballot {
+		p.heard = p.Clock.Now()
+	}
+	if p.overwrites(m.Slot, p.log[m.Slot], m.Ballot, m.Commands) || m.Slot < p.execute {
+		return
+	}
+	p.catchup(m.Slot, m.Ballot.ID())
 
 	e, exist := p.log[m.Slot]
 	if exist {
-		if !e.command.Equal(m.Command) && e.request != nil {
-			// p.Retry(*e.request)
-			p.Forward(m.Ballot.ID(), *e.request)
-			e.request = nil
+		if e.commit {
+			// already learned from P2b or earlier P3
+			return
+		}
+		if !equal(e.commands, m.Commands) {
+			p.redirect(e, m.Ballot.ID())
 		}
 	} else {
 		p.log[m.Slot] = &entry{}
 		e = p.log[m.Slot]
 	}
 
-	e.command = m.Command
+	e.commands = m.Commands
+	e.ballot = m.Ballot
+	e.deps = m.Deps
 	e.commit = true
+	e.committed = p.Clock.Now()
+	if m.TraceID != "" {
+		e.trace = m.TraceID
+	}
+	p.metrics.inc(&p.metrics.committed)
+	p.appendEntry(m.Slot)
 
 	if p.ReplyWhenCommit {
-		if e.request != nil {
-			e.request.Reply(paxi.Reply{
-				Command:   e.request.Command,
-				Timestamp: e.request.Timestamp,
-			})
-		}
+		p.reply(m.Slot, e)
 	} else {
 		p.exec()
 	}
 }
 
+// commit marks every slot before index accepted in ballot b as committed and executes them,
+// entry of a different ballot waits for P3 since its commands may not be the chosen ones
+func (p *Paxos) commit(b paxi.Ballot, index int) {
+	committed := false
+	for s := p.execute; s < index; s++ {
+		e, exists := p.log[s]
+		if !exists || e.commit || e.ballot != b {
+			continue
+		}
+		e.commit = true
+		e.committed = p.Clock.Now()
+		p.metrics.inc(&p.metrics.committed)
+		p.appendEntry(s)
+		committed = true
+	}
+	if committed {
+		p.exec()
+	}
+}
+
+// renew extends the lease of current leader started from time t
+func (p *Paxos) renew(t time.Time) {
+	if p.Lease <= 0 {
+		return
+	}
+	expiry := t.Add(p.Lease)
+	if expiry.After(p.lease) {
+		p.lease = expiry
+	}
+}
+
+// leased returns true if ballot b from a different leader must wait for the current lease to expire
+func (p *Paxos) leased(b paxi.Ballot) bool {
+	return b.ID() != p.ballot.ID() && p.Clock.Now().Before(p.lease)
+}
+
+// sticky returns true if this leader ignores phase 1 of m within grace period after its election,
+// unless the candidate has won pre-vote
+func (p *Paxos) sticky(m P1a) bool {
+	return p.active && p.Grace > 0 && !m.PreVoted && p.since(p.elected) < p.Grace
+}
+
 func (p *Paxos) exec() {
 	for {
 		e, ok := p.log[p.execute]
 		if !ok || !e.commit {
 			break
 		}
-		// log.Debugf("Replica %s execute [s=%d, cmd=%v]", p.ID(), p.execute, e.command)
-		value := p.Execute(e.command)
-		if e.request != nil {
-			reply := paxi.Reply{
-				Command:    e.command,
-				Value:      value,
-				Properties: make(map[string]string),
-			}
-			reply.Properties[HTTPHeaderSlot] = strconv.Itoa(p.execute)
-			reply.Properties[HTTPHeaderBallot] = e.ballot.String()
-			reply.Properties[HTTPHeaderExecute] = strconv.Itoa(p.execute)
-			e.request.Reply(reply)
-			e.request = nil
+		if !e.executed {
+			p.apply(p.execute, e)
 		}
-		// delete(p.log, p.execute)
+		if p.reconfig == p.execute {
+			p.reconfig = -1
+		}
+		p.hash = chain(p.hash, e.commands)
+		e.hash = p.hash
 		p.execute++
 	}
+	if p.OutOfOrder {
+		p.execIndependent()
+	}
+	p.backlogged()
+	p.observe()
+	p.rejoined()
+	p.gc()
+	p.repairHole()
+	p.serveReads()
+	p.drain()
+	p.retired()
+}
+
+// apply executes commands of committed slot s and replies to their clients
+func (p *Paxos) apply(s int, e *entry) {
+	if len(e.requests) > 0 || e.txn != nil {
+		// clients are replied only after own records are durable
+		p.sync()
+	}
+	if p.executor != nil && e.txn != nil {
+		// results of every command of transaction are replied together
+		p.executor.barrier()
+	}
+	results := make([]paxi.Command, 0)
+	ok := true // no command of transaction is rejected
+	for i, cmd := range e.commands {
+		// log.Debugf("Replica %s execute [s=%d, cmd=%v]", p.ID(), s, cmd)
+		if cmd.IsNoOp() {
+			continue
+		}
+		p.proxyDone(cmd)
+		if p.OutOfOrder && !IsReconfig(cmd) && !IsRegister(cmd) {
+			p.keys[cmd.Key] = s
+		}
+		waiting := p.waiting(e, i, cmd)
+		value, rejected, duplicate := p.duplicate(cmd)
+		if IsReconfig(cmd) {
+			p.reconfigure(cmd)
+			p.barrier = s
+		} else if IsRegister(cmd) {
+			value, rejected = paxi.Value(p.register(s, cmd)), ""
+		} else if !duplicate && p.executor != nil && e.txn == nil {
+			p.dispatch(s, e.ballot, cmd, waiting)
+			continue
+		} else if !duplicate {
+			if !p.ShadowMode {
+				var err error
+				value, err = paxi.ApplyAt(p.StateMachine, cmd, s)
+				rejected = status(err)
+			}
+			p.record(s, cmd, value, rejected)
+			p.publish(s, cmd, value, rejected)
+		}
+		if e.txn != nil {
+			result := cmd
+			result.Value = value
+			results = append(results, result)
+			ok = ok && rejected == ""
+		}
+		for _, r := range waiting {
+			p.respond(s, e.ballot, r, value, rejected)
+		}
+	}
+	if e.txn != nil && !p.ShadowMode {
+		e.txn.Reply(paxi.TransactionReply{
+			OK:        ok,
+			Commands:  results,
+			Timestamp: e.txn.Timestamp,
+		})
+	}
+	if p.Tracing {
+		start := e.committed
+		if start.IsZero() {
+			start = p.Clock.Now()
+		}
+		p.traceAll(e, "execute", s, start, p.Clock.Now())
+	}
+	e.requests = nil
+	e.txn = nil
+	e.executed = true
+	p.metrics.inc(&p.metrics.executed)
+	p.metrics.since(&p.metrics.execute, e.timestamp, p.Clock.Now())
+}
+
+// waiting returns client requests of command i of entry e, including requests attached to identical command
+func (p *Paxos) waiting(e *entry, i int, cmd paxi.Command) []*paxi.Request {
+	var requests []*paxi.Request
+	if i < len(e.requests) && e.requests[i] != nil {
+		requests = append(requests, e.requests[i])
+	}
+	for j := len(e.commands); j < len(e.requests); j++ {
+		if r := e.requests[j]; r != nil && identical(r.Command, cmd) {
+			requests = append(requests, r)
+			e.requests[j] = nil
+		}
+	}
+	return requests
+}
+
+// respond replies request r executed in slot s of ballot b with value, or error status of state machine
+// rejecting it, unless in shadow mode
+func (p *Paxos) respond(s int, b paxi.Ballot, r *paxi.Request, value paxi.Value, rejected string) {
+	if p.ShadowMode {
+		return
+	}
+	reply := paxi.Reply{
+		Command:    r.Command,
+		Value:      value,
+		Properties: make(map[string]string),
+		Slot:       s,
+		Session:    s + 1,
+		TraceID:    r.TraceID,
+		Status:     rejected,
+	}
+	reply.Properties[HTTPHeaderSlot] = strconv.Itoa(reply.Slot)
+	reply.Properties[HTTPHeaderBallot] = b.String()
+	reply.Properties[HTTPHeaderExecute] = strconv.Itoa(s)
+	r.Reply(reply)
+}
+
+// status returns reply status of state machine error err, empty if nil
+func status(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// gc deletes executed entries that fall out of the retention window
+func (p *Paxos) gc() {
+	p.uncoalesce()
+	if window := p.LogWindow; window > 0 {
+		for ; p.low < p.execute-window; p.low++ {
+			delete(p.log, p.low)
+		}
+	}
+	p.expire()
+}
+
+// reply replies committed entry of slot s to every client request without waiting for execution
+func (p *Paxos) reply(s int, e *entry) {
+	if p.ShadowMode {
+		return
+	}
+	for _, r := range e.requests {
+		if r != nil {
+			r.Reply(paxi.Reply{
+				Command:   r.Command,
+				Timestamp: r.Timestamp,
+				Slot:      s,
+			})
+		}
+	}
+	if e.txn != nil {
+		e.txn.Reply(paxi.TransactionReply{
+			OK:        true,
+			Commands:  e.txn.Commands,
+			Timestamp: e.txn.Timestamp,
+		})
+		e.txn = nil
+	}
+}
+
+// redirect forwards client requests of entry e which lost its slot to the new leader by Preemption policy
+func (p *Paxos) redirect(e *entry, leader paxi.ID) {
+	for _, r := range p.preempted(e) {
+		switch {
+		case p.expired(r):
+			r.Reply(paxi.Reply{
+				Command: r.Command,
+				Err:     ErrCommitTimeout,
+			})
+		case p.Preemption == PreemptFail:
+			r.Reply(paxi.Reply{
+				Command: r.Command,
+				Err:     ErrPreempted,
+			})
+		case !p.bounce(r):
+			// failed back to client after too many retries
+		case p.Preemption == PreemptFIFO:
+			p.Forward(leader, *r)
+		default:
+			request := *r
+			p.After(p.delay(r.Retries), func() { p.Forward(leader, request) })
+		}
+	}
+	e.requests = nil
+	// commands of transaction are retried together by client
+	if e.txn != nil {
+		abort(e.txn)
+		e.txn = nil
+	}
+}
+
+// equal returns true if two batches contain the same commands in order by paxi.Command.Equal
+func equal(a, b []paxi.Command) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
 }
 
 func (p *Paxos) forward() {
+	for _, r := range p.batch {
+		p.enqueue(r)
+	}
+	p.batch = nil
+	for seq, rd := range p.rounds {
+		p.enqueue(rd.request)
+		delete(p.rounds, seq)
+	}
 	for _, m := range p.requests {
+		if p.expired(m) {
+			m.Reply(paxi.Reply{
+				Command: m.Command,
+				Err:     ErrRequestTimeout,
+			})
+			continue
+		}
 		p.Forward(p.ballot.ID(), *m)
 	}
 	p.requests = make([]*paxi.Request, 0)
+	for _, t := range p.txns {
+		abort(t)
+	}
+	p.txns = nil
 }
@@ -1,11 +1,3507 @@
 package paxos
 
 import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ailidani/paxi"
 )
 
+// node is a fake paxi.Node that records outgoing messages instead of sending them
+type node struct {
+	paxi.Database
+	id        paxi.ID
+	sent      []interface{}
+	timers    []func()
+	forwarded []paxi.Request
+}
+
+func newNode(id paxi.ID) *node {
+	return &node{
+		Database: paxi.NewDatabase(),
+		id:       id,
+		sent:     make([]interface{}, 0),
+	}
+}
+
+func (n *node) ID() paxi.ID                               { return n.id }
+func (n *node) Run()                                      {}
+func (n *node) Retry(r paxi.Request)                      {}
+func (n *node) Forward(id paxi.ID, r paxi.Request)        { n.forwarded = append(n.forwarded, r) }
+func (n *node) Register(m interface{}, f interface{})     {}
+func (n *node) Send(to paxi.ID, m interface{})            { n.sent = append(n.sent, m) }
+func (n *node) MulticastZone(zone int, m interface{})     { n.sent = append(n.sent, m) }
+func (n *node) MulticastQuorum(quorum int, m interface{}) { n.sent = append(n.sent, m) }
+func (n *node) Broadcast(m interface{})                   { n.sent = append(n.sent, m) }
+func (n *node) Recv() interface{}                         { return nil }
+func (n *node) Close()                                    {}
+func (n *node) Drop(id paxi.ID, t int)                    {}
+func (n *node) Slow(id paxi.ID, d int, t int)             {}
+func (n *node) Flaky(id paxi.ID, p float32, t int)        {}
+func (n *node) Crash(t int)                               {}
+func (n *node) After(d time.Duration, f func())           { n.timers = append(n.timers, f) }
+func (n *node) HandleHTTP(string, http.HandlerFunc)       {}
+
+// fire runs all scheduled timers
+func (n *node) fire() {
+	timers := n.timers
+	n.timers = nil
+	for _, f := range timers {
+		f()
+	}
+}
+
+// proposeAt proposes cmd in slot s of current ballot regardless of next slot,
+// so that tests can create holes, out-of-order proposals and conflicting ballots
+func (p *Paxos) proposeAt(s int, cmd paxi.Command) P2a {
+	p.slot = paxi.Max(p.slot, s)
+	p.accept(s, &entry{commands: []paxi.Command{cmd}})
+	return p.p2a(s, p.log[s].commands)
+}
+
 func TestPaxos(t *testing.T) {
 	paxi.Simulation()
 }
+
+func TestP3Commit(t *testing.T) {
+	leader := paxi.NewID(1, 1)
+	b := paxi.NewBallot(1, leader)
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}})
+	if p.execute != 0 {
+		t.Fatalf("slot executed before commit, execute = %d", p.execute)
+	}
+
+	p.HandleP3(P3{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}})
+	if !p.log[0].commit {
+		t.Error("expected slot 0 to be committed by P3")
+	}
+	if p.execute != 1 {
+		t.Errorf("expected execute = 1 after P3, got %d", p.execute)
+	}
+	if string(p.Get(1)) != "v" {
+		t.Errorf("expected key 1 = v, got %s", p.Get(1))
+	}
+}
+
+func TestGC(t *testing.T) {
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+
+	window := paxi.GetConfig().LogWindow
+	n := window + 100
+	for s := 0; s < n; s++ {
+		cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: s}
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+
+	if p.execute != n {
+		t.Fatalf("expected execute = %d, got %d", n, p.execute)
+	}
+	if len(p.log) != window {
+		t.Errorf("expected %d entries kept in log, got %d", window, len(p.log))
+	}
+	if _, exists := p.log[n-window]; !exists {
+		t.Errorf("slot %d inside window is garbage collected", n-window)
+	}
+
+	// late messages for collected slots are ignored
+	p.HandleP2b(P2b{Ballot: b, ID: paxi.NewID(1, 3), Slot: 0})
+	p.HandleP2a(P2a{Ballot: b, Slot: 0})
+	if _, exists := p.log[0]; exists {
+		t.Error("executed slot 0 is recreated by late P2a")
+	}
+}
+
+func TestStorageRecovery(t *testing.T) {
+	storage, err := NewFileStorage(filepath.Join(t.TempDir(), "wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withStorage := func(p *Paxos) { p.Storage = storage }
+
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	id := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id), withStorage)
+	p.HandleP1a(P1a{Ballot: b})
+	for s := 0; s < 3; s++ {
+		cmd := paxi.Command{Key: paxi.Key(s), Value: paxi.Value("v"), ClientID: "1.1", CommandID: s}
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		if s < 2 {
+			p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		}
+	}
+
+	// crash and restart with a fresh node and state machine
+	p = NewPaxos(newNode(id), withStorage)
+
+	if p.ballot != b {
+		t.Errorf("expected ballot %v after recovery, got %v", b, p.ballot)
+	}
+	if p.slot != 2 {
+		t.Errorf("expected slot 2 after recovery, got %d", p.slot)
+	}
+	if p.execute != 2 {
+		t.Errorf("expected execute 2 after recovery, got %d", p.execute)
+	}
+	for s := 0; s < 2; s++ {
+		if !p.log[s].commit {
+			t.Errorf("committed slot %d is lost", s)
+		}
+		if string(p.Get(paxi.Key(s))) != "v" {
+			t.Errorf("committed slot %d is not executed after recovery", s)
+		}
+	}
+	if e, exists := p.log[2]; !exists || e.commit || e.commands[0].CommandID != 2 {
+		t.Errorf("accepted slot 2 is not recovered as uncommitted, got %+v", e)
+	}
+}
+
+func TestFillGap(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id))
+	p.P1a()
+
+	old := paxi.NewBallot(0, peer)
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.2", CommandID: 1}
+	p.HandleP1b(P1b{
+		Ballot: p.ballot,
+		ID:     peer,
+		Log: map[int]CommandBallot{
+			0: {[]paxi.Command{cmd}, old},
+			2: {[]paxi.Command{cmd}, old},
+		},
+	})
+	if !p.active {
+		t.Fatal("expected node to become active leader")
+	}
+	if p.log[1] == nil || !equal(p.log[1].commands, []paxi.Command{paxi.NoOp()}) {
+		t.Fatalf("expected slot 1 filled with no-op, got %+v", p.log[1])
+	}
+
+	for s := 0; s <= 2; s++ {
+		p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: s})
+	}
+	if p.execute != 3 {
+		t.Errorf("expected slots after the gap to execute, execute = %d", p.execute)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	b := paxi.NewBallot(1, id)
+	n1 := newNode(id)
+	leader := NewPaxos(n1)
+
+	window := paxi.GetConfig().LogWindow
+	n := window + 10
+	for s := 0; s < n; s++ {
+		cmd := paxi.Command{Key: paxi.Key(s % 10), Value: paxi.Value("v"), ClientID: "1.1", CommandID: s}
+		leader.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		leader.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+
+	n2 := newNode(paxi.NewID(1, 2))
+	follower := NewPaxos(n2, func(p *Paxos) { p.StateTransfer = true })
+	follower.HandleP3(P3{Ballot: b, Slot: n, Commands: []paxi.Command{{Key: 42, Value: paxi.Value("x")}}})
+
+	req, ok := n2.sent[len(n2.sent)-1].(SnapshotRequest)
+	if !ok {
+		t.Fatalf("expected lagging replica to request snapshot, sent %v", n2.sent)
+	}
+	leader.HandleSnapshotRequest(req)
+	reply, ok := n1.sent[len(n1.sent)-1].(SnapshotReply)
+	if !ok {
+		t.Fatalf("expected leader to reply snapshot, sent %v", n1.sent)
+	}
+	follower.HandleSnapshotReply(reply)
+
+	if follower.execute != n+1 {
+		t.Errorf("expected execute = %d after snapshot, got %d", n+1, follower.execute)
+	}
+	if string(follower.Get(3)) != "v" || string(follower.Get(42)) != "x" {
+		t.Error("state is not transferred by snapshot")
+	}
+}
+
+func TestSnapshotRetry(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	b := paxi.NewBallot(1, id)
+	c := &clock{now: time.Unix(0, 0)}
+	n2 := newNode(paxi.NewID(1, 2))
+	follower := NewPaxos(n2, func(p *Paxos) {
+		p.StateTransfer = true
+		p.TransferTimeout = time.Second
+		p.Clock = c
+	})
+	n := paxi.GetConfig().LogWindow + 10
+	follower.HandleP3(P3{Ballot: b, Slot: n, Commands: []paxi.Command{{Key: 42, Value: paxi.Value("x")}}})
+	if !follower.transfer || len(n2.timers) != 1 {
+		t.Fatalf("expected snapshot request with retry timer, transfer = %v, timers = %d", follower.transfer, len(n2.timers))
+	}
+
+	// request is lost, timer resends it once timeout passes
+	n2.sent = nil
+	c.now = c.now.Add(time.Second)
+	n2.fire()
+	if len(n2.sent) != 1 {
+		t.Fatalf("expected snapshot request to be sent again, sent %v", n2.sent)
+	}
+	req := n2.sent[0].(SnapshotRequest)
+
+	// leader not ahead of requester answers with empty snapshot, requester stops waiting
+	n1 := newNode(id)
+	leader := NewPaxos(n1)
+	leader.HandleSnapshotRequest(req)
+	reply, ok := n1.sent[len(n1.sent)-1].(SnapshotReply)
+	if !ok || reply.Slot != 0 {
+		t.Fatalf("expected empty snapshot reply, sent %v", n1.sent)
+	}
+	follower.HandleSnapshotReply(reply)
+	if follower.transfer || follower.execute != 0 {
+		t.Errorf("expected empty snapshot to end transfer without install, transfer = %v, execute = %d", follower.transfer, follower.execute)
+	}
+	n2.sent = nil
+	n2.fire()
+	if len(n2.sent) != 0 {
+		t.Errorf("expected answered request not to be sent again, sent %v", n2.sent)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	id := paxi.NewID(1, 1)
+	b := paxi.NewBallot(1, id)
+	n1 := newNode(id)
+	leader := NewPaxos(n1, func(p *Paxos) {
+		p.Clock = c
+		p.Backfill = 100
+		p.SnapshotChunk = 4
+	})
+	for s := 0; s < 10; s++ {
+		cmd := paxi.Command{Key: paxi.Key(s), Value: paxi.Value("value"), ClientID: "1.1", CommandID: s}
+		leader.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		leader.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+
+	// first reply is sent at once, the next waits until the first is paid for
+	n1.sent, n1.timers = nil, nil
+	peer := paxi.NewID(1, 2)
+	leader.HandleRepairRequest(RepairRequest{ID: peer, Slot: 0})
+	leader.HandleRepairRequest(RepairRequest{ID: peer, Slot: 1})
+	if len(n1.sent) != 1 || len(n1.timers) != 1 {
+		t.Fatalf("expected one repair reply sent and one paced, sent %v timers %d", n1.sent, len(n1.timers))
+	}
+	n1.fire()
+	if _, ok := n1.sent[1].(RepairReply); !ok {
+		t.Fatalf("expected paced repair reply, sent %v", n1.sent)
+	}
+
+	n1.sent = nil
+	c.now = c.now.Add(time.Minute)
+	leader.HandleSnapshotRequest(SnapshotRequest{ID: peer})
+	n1.fire()
+	chunks := make([]SnapshotReply, 0)
+	for _, m := range n1.sent {
+		chunks = append(chunks, m.(SnapshotReply))
+	}
+	if len(chunks) < 2 || chunks[0].Total != len(leader.StateMachine.Snapshot()) {
+		t.Fatalf("expected snapshot in chunks of %d bytes, got %v", leader.SnapshotChunk, chunks)
+	}
+
+	follower := NewPaxos(newNode(peer))
+	// out of order chunk drops the partial snapshot
+	follower.HandleSnapshotReply(chunks[0])
+	follower.HandleSnapshotReply(chunks[len(chunks)-1])
+	if follower.execute != 0 || follower.partial != nil {
+		t.Fatalf("expected out of order chunk ignored, execute %d", follower.execute)
+	}
+	for _, m := range chunks {
+		follower.HandleSnapshotReply(m)
+	}
+	if follower.execute != 10 || string(follower.Get(3)) != "value" {
+		t.Errorf("expected snapshot installed from chunks, execute %d", follower.execute)
+	}
+}
+
+func TestCompactionPoint(t *testing.T) {
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	n1 := newNode(paxi.NewID(1, 2))
+	peer := NewPaxos(n1)
+	window := paxi.GetConfig().LogWindow
+	n := window + 10
+	for s := 0; s < n; s++ {
+		cmd := paxi.Command{Key: paxi.Key(s % 10), Value: paxi.Value("v"), ClientID: "1.1", CommandID: s}
+		peer.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		peer.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+	if peer.low != 10 || !peer.compacted(9) || peer.compacted(10) {
+		t.Fatalf("expected compaction point 10, got %d", peer.low)
+	}
+
+	// candidate behind compaction point of its phase 1 quorum never fills compacted slots with no-op
+	n2 := newNode(paxi.NewID(1, 3))
+	p := NewPaxos(n2)
+	p.P1a()
+	peer.HandleP1a(n2.sent[len(n2.sent)-1].(P1a))
+	p1b := n1.sent[len(n1.sent)-1].(P1b)
+	if p1b.Low != 10 {
+		t.Fatalf("expected P1b reporting compaction point 10, got %v", p1b.Low)
+	}
+	p.HandleP1b(p1b)
+	if !p.active {
+		t.Fatal("expected candidate to become active leader")
+	}
+	var req *SnapshotRequest
+	for _, m := range n2.sent {
+		switch m := m.(type) {
+		case P2a:
+			if m.Slot < 10 {
+				t.Errorf("compacted slot %d is proposed again as %v", m.Slot, m.Commands)
+			}
+		case SnapshotRequest:
+			req = &m
+		}
+	}
+	if req == nil {
+		t.Fatalf("expected snapshot request to compacting node, sent %v", n2.sent)
+	}
+
+	peer.HandleSnapshotRequest(*req)
+	p.HandleSnapshotReply(n1.sent[len(n1.sent)-1].(SnapshotReply))
+	if p.execute != n || p.low != n || string(p.Get(3)) != "v" {
+		t.Errorf("expected snapshot installed up to %d, execute %d low %d", n, p.execute, p.low)
+	}
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("w"), ClientID: "1.1", CommandID: n}})
+	if m := n2.sent[len(n2.sent)-1].(P2a); m.Slot != n {
+		t.Errorf("expected next proposal at compaction point %d, got slot %d", n, m.Slot)
+	}
+
+	// repair of compacted slot is answered with snapshot
+	peer.HandleRepairRequest(RepairRequest{ID: p.ID(), Slot: 0})
+	if _, ok := n1.sent[len(n1.sent)-1].(SnapshotReply); !ok {
+		t.Errorf("expected snapshot reply to repair of compacted slot, sent %v", n1.sent[len(n1.sent)-1])
+	}
+}
+
+func TestMaxInflight(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id), func(p *Paxos) { p.MaxInflight = 2 })
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	if !p.active {
+		t.Fatal("expected node to become active leader")
+	}
+
+	for i := 0; i < 5; i++ {
+		p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}})
+	}
+	if p.slot != 1 || len(p.requests) != 3 {
+		t.Fatalf("expected 2 inflight slots and 3 held requests, got slot %d held %d", p.slot, len(p.requests))
+	}
+
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: 0})
+	if p.slot != 2 || len(p.requests) != 2 {
+		t.Errorf("expected one held request proposed after execution, got slot %d held %d", p.slot, len(p.requests))
+	}
+	if p.slot-p.execute+1 > p.MaxInflight {
+		t.Errorf("inflight window exceeded, slot %d execute %d", p.slot, p.execute)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p := NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) { p.Dedup = true })
+
+	put := paxi.Command{Key: 1, Value: paxi.Value("a"), ClientID: "1.1", CommandID: 1}
+	retry := put
+	retry.Value = paxi.Value("b") // same command id must not be applied again
+	for s, cmd := range []paxi.Command{put, retry} {
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+	if string(p.Get(1)) != "a" {
+		t.Errorf("duplicate command is executed twice, key 1 = %s", p.Get(1))
+	}
+	if s := p.sessions["1.1"]; s.CommandID != 1 || s.Slot != 0 {
+		t.Errorf("unexpected session %+v", s)
+	}
+
+	next := paxi.Command{Key: 1, Value: paxi.Value("c"), ClientID: "1.1", CommandID: 2}
+	p.HandleP2a(P2a{Ballot: b, Slot: 2, Commands: []paxi.Command{next}})
+	p.HandleP3(P3{Ballot: b, Slot: 2, Commands: []paxi.Command{next}})
+	if string(p.Get(1)) != "c" {
+		t.Errorf("new command is not executed, key 1 = %s", p.Get(1))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	n := newNode(id)
+	p := NewPaxos(n)
+	p.P1a()
+
+	// rejected by higher ballot of a rival proposer
+	rival := paxi.NewBallot(2, peer)
+	p.HandleP1b(P1b{Ballot: rival, ID: peer})
+	if p.attempt != 1 {
+		t.Fatalf("expected 1 failed attempt, got %d", p.attempt)
+	}
+
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}})
+	if p.ballot != rival || len(n.timers) != 1 {
+		t.Fatalf("expected phase 1 retry to be delayed, ballot %v timers %d", p.ballot, len(n.timers))
+	}
+	n.fire()
+	if p.ballot.ID() != id || p.ballot <= rival {
+		t.Errorf("expected phase 1 retry after backoff, ballot %v", p.ballot)
+	}
+
+	base := paxi.GetConfig().BackOff
+	max := paxi.GetConfig().MaxBackOff
+	for p.attempt = 1; p.attempt < 40; p.attempt++ {
+		d := p.backoff()
+		if d > time.Duration(max)*time.Millisecond {
+			t.Fatalf("backoff %v of attempt %d exceeds cap", d, p.attempt)
+		}
+		if p.attempt == 1 && d < time.Duration(base)*time.Millisecond {
+			t.Fatalf("backoff %v of first attempt is below base", d)
+		}
+	}
+
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	if !p.active || p.attempt != 0 {
+		t.Errorf("expected attempts reset after becoming active, got %d", p.attempt)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	n1 := newNode(id)
+	leader := NewPaxos(n1, func(p *Paxos) { p.Heartbeat = time.Millisecond })
+	leader.P1a()
+	leader.HandleP1b(P1b{Ballot: leader.ballot, ID: peer})
+	n1.fire()
+	m, ok := n1.sent[len(n1.sent)-1].(Heartbeat)
+	if !ok || m.Ballot != leader.ballot {
+		t.Fatalf("expected active leader to broadcast heartbeat, sent %v", n1.sent)
+	}
+
+	n2 := newNode(peer)
+	follower := NewPaxos(n2, func(p *Paxos) { p.Timeout = time.Hour })
+	follower.HandleHeartbeat(m)
+	n2.fire()
+	if follower.ballot != m.Ballot {
+		t.Fatalf("follower starts phase 1 while leader is alive, ballot %v", follower.ballot)
+	}
+
+	// leader went silent
+	follower.heard = time.Now().Add(-2 * time.Hour)
+	n2.fire()
+	if follower.ballot.ID() != peer || follower.ballot <= m.Ballot {
+		t.Errorf("expected follower to start phase 1 after timeout, ballot %v", follower.ballot)
+	}
+	if _, ok := n2.sent[len(n2.sent)-1].(P1a); !ok {
+		t.Errorf("expected P1a after timeout, sent %v", n2.sent)
+	}
+}
+
+func TestResend(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	peer := paxi.NewID(1, 2)
+	n := newNode(paxi.NewID(1, 1))
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Heartbeat = time.Second
+		p.Clock = c
+	})
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	for i := 0; i < 2; i++ {
+		p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}})
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: 0})
+
+	resent := func() []int {
+		var slots []int
+		for _, m := range n.sent {
+			if m, ok := m.(P2a); ok {
+				slots = append(slots, m.Slot)
+			}
+		}
+		return slots
+	}
+	n.sent = nil
+	n.fire()
+	if slots := resent(); len(slots) != 0 {
+		t.Fatalf("expected no P2a resent within one heartbeat of proposing, resent %v", slots)
+	}
+
+	// P2a or P2b of slot 1 is lost
+	c.now = c.now.Add(time.Second)
+	n.sent = nil
+	n.fire()
+	if slots := resent(); len(slots) != 1 || slots[0] != 1 {
+		t.Errorf("expected heartbeat to resend P2a of uncommitted slot 1 only, resent %v", slots)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id))
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+
+	for i := 0; i < 3; i++ {
+		p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}})
+		p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: i})
+	}
+	p.HandleP1a(P1a{Ballot: paxi.NewBallot(p.ballot.N()+1, peer)})
+
+	m := p.Metrics()
+	if m.Commit.Size != 3 || m.Execute.Size != 3 {
+		t.Errorf("expected 3 commit and execute samples, got %d and %d", m.Commit.Size, m.Execute.Size)
+	}
+	if m.Attempts != 1 || m.Preemptions != 1 || m.Retries != 0 || m.Committed != 3 || m.Executed != 3 {
+		t.Errorf("unexpected counters %+v", m)
+	}
+	if m.Leader || m.Ballot != p.ballot || m.InFlight != 0 {
+		t.Errorf("unexpected gauges %+v", m)
+	}
+
+	var b bytes.Buffer
+	err := p.WritePrometheus(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "# TYPE paxos_committed_slots_total counter\npaxos_committed_slots_total{id=\"1.1\"} 3\n") {
+		t.Errorf("unexpected exposition\n%s", b.String())
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	n := newNode(id)
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Q2 = func(q *paxi.Quorum) bool { return q.Size() >= 2 }
+	})
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+
+	get := paxi.Request{Command: paxi.Command{Key: 1, ClientID: "1.1", CommandID: 1}}
+	put := paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 2}}
+
+	// read waits for confirmation without taking a slot
+	p.HandleReadRequest(get)
+	if p.slot != -1 || len(p.rounds) != 1 {
+		t.Fatalf("expected read round without log slot, slot %d rounds %d", p.slot, len(p.rounds))
+	}
+	m, ok := n.sent[len(n.sent)-1].(ReadIndex)
+	if !ok {
+		t.Fatalf("expected ReadIndex broadcast, sent %v", n.sent)
+	}
+	p.HandleReadIndexAck(ReadIndexAck{Ballot: p.ballot, ID: peer, Seq: m.Seq})
+	if len(p.rounds) != 0 || len(p.reads) != 0 {
+		t.Errorf("expected read served after confirmation, rounds %d reads %d", len(p.rounds), len(p.reads))
+	}
+
+	// confirmed read waits for execution of in-progress slot
+	p.HandleRequest(put)
+	p.HandleReadRequest(get)
+	p.HandleReadIndexAck(ReadIndexAck{Ballot: p.ballot, ID: peer, Seq: p.seq})
+	if len(p.reads) != 1 {
+		t.Fatalf("expected read waiting for slot 0, reads %d", len(p.reads))
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: 0})
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: paxi.NewID(1, 3), Slot: 0})
+	if p.execute != 1 || len(p.reads) != 0 {
+		t.Errorf("expected read served after execution, execute %d reads %d", p.execute, len(p.reads))
+	}
+
+	// leadership cannot be confirmed
+	p.HandleReadRequest(get)
+	p.HandleReadIndexAck(ReadIndexAck{Ballot: paxi.NewBallot(p.ballot.N()+1, peer), ID: peer, Seq: p.seq})
+	if p.active || len(p.rounds) != 0 {
+		t.Errorf("expected read to fall back after preemption, active %v rounds %d", p.active, len(p.rounds))
+	}
+}
+
+func TestRepair(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	b := paxi.NewBallot(1, id)
+	n1 := newNode(id)
+	leader := NewPaxos(n1)
+	n2 := newNode(paxi.NewID(1, 2))
+	follower := NewPaxos(n2, func(p *Paxos) { p.Repair = true })
+
+	for s := 0; s < 2; s++ {
+		cmd := paxi.Command{Key: paxi.Key(s), Value: paxi.Value("v"), ClientID: "1.1", CommandID: s}
+		leader.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		leader.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		// accept and commit of slot 0 are lost
+		if s == 1 {
+			follower.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+			follower.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		}
+	}
+
+	req, ok := n2.sent[len(n2.sent)-1].(RepairRequest)
+	if !ok || req.Slot != 0 {
+		t.Fatalf("expected repair request of slot 0, sent %v", n2.sent)
+	}
+	leader.HandleRepairRequest(req)
+	reply, ok := n1.sent[len(n1.sent)-1].(RepairReply)
+	if !ok || !reply.Commit {
+		t.Fatalf("expected committed repair reply, sent %v", n1.sent)
+	}
+	// reply is lost, next heartbeat of the leader requests the slot again
+	n2.sent = nil
+	follower.HandleHeartbeat(Heartbeat{Ballot: b})
+	if len(n2.sent) == 0 {
+		t.Fatal("expected heartbeat to retry repair of slot 0")
+	}
+	if again, ok := n2.sent[len(n2.sent)-1].(RepairRequest); !ok || again.Slot != 0 {
+		t.Fatalf("expected heartbeat to retry repair of slot 0, sent %v", n2.sent)
+	}
+	follower.HandleRepairReply(reply)
+	if follower.execute != 2 || string(follower.Get(0)) != "v" {
+		t.Errorf("expected execution to resume after repair, execute %d", follower.execute)
+	}
+}
+
+func TestRepairRetry(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	b := paxi.NewBallot(1, id)
+	n2 := newNode(paxi.NewID(1, 2))
+	follower := NewPaxos(n2, func(p *Paxos) {
+		p.Repair = true
+		p.RepairTimeout = time.Second
+	})
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}
+	follower.HandleP2a(P2a{Ballot: b, Slot: 1, Commands: []paxi.Command{cmd}})
+	follower.HandleP3(P3{Ballot: b, Slot: 1, Commands: []paxi.Command{cmd}})
+	if _, ok := n2.sent[len(n2.sent)-1].(RepairRequest); !ok {
+		t.Fatalf("expected repair request of slot 0, sent %v", n2.sent)
+	}
+
+	// repair request is lost, timer sends it again
+	n2.sent = nil
+	n2.fire()
+	req, ok := n2.sent[len(n2.sent)-1].(RepairRequest)
+	if !ok || req.Slot != 0 {
+		t.Fatalf("expected repair request of slot 0 to be sent again, sent %v", n2.sent)
+	}
+
+	// donor dropped the executed slot from its log, and replies snapshot instead
+	n1 := newNode(id)
+	leader := NewPaxos(n1)
+	for s := 0; s < 2; s++ {
+		c := paxi.Command{Key: paxi.Key(s), Value: paxi.Value("v"), ClientID: "1.1", CommandID: s}
+		leader.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{c}})
+		leader.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{c}})
+	}
+	delete(leader.log, 0)
+	leader.HandleRepairRequest(req)
+	reply, ok := n1.sent[len(n1.sent)-1].(SnapshotReply)
+	if !ok {
+		t.Fatalf("expected snapshot reply for slot no longer in log, sent %v", n1.sent)
+	}
+	follower.HandleSnapshotReply(reply)
+	if follower.execute != 2 || string(follower.Get(0)) != "v" {
+		t.Errorf("expected execution to resume after snapshot, execute %d", follower.execute)
+	}
+	n2.sent = nil
+	n2.fire()
+	for _, m := range n2.sent {
+		if _, ok := m.(RepairRequest); ok {
+			t.Errorf("expected no repair request once slot is executed, sent %v", n2.sent)
+		}
+	}
+}
+
+func TestTransferLeadership(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	n1 := newNode(id)
+	n2 := newNode(peer)
+	leader := NewPaxos(n1)
+	target := NewPaxos(n2)
+	leader.P1a()
+	leader.HandleP1b(P1b{Ballot: leader.ballot, ID: peer})
+	put := func(i int) paxi.Request {
+		return paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}}
+	}
+	leader.HandleRequest(put(0))
+
+	start := time.Now()
+	leader.TransferLeadership(peer)
+	leader.HandleRequest(put(1))
+	if !leader.active || leader.slot != 0 || len(leader.requests) != 1 {
+		t.Fatalf("expected leader to stop proposing until slot 0 commits, slot %d held %d", leader.slot, len(leader.requests))
+	}
+
+	leader.HandleP2b(P2b{Ballot: leader.ballot, ID: peer, Slot: 0})
+	m, ok := n1.sent[len(n1.sent)-1].(TimeoutNow)
+	if !ok || leader.active {
+		t.Fatalf("expected leader to step down with TimeoutNow, sent %v", n1.sent)
+	}
+
+	target.HandleTimeoutNow(m)
+	p1a, ok := n2.sent[len(n2.sent)-1].(P1a)
+	if !ok || p1a.Ballot <= m.Ballot {
+		t.Fatalf("expected target to start phase 1 with higher ballot, sent %v", n2.sent)
+	}
+	leader.HandleP1a(p1a)
+	target.HandleP1b(n1.sent[len(n1.sent)-1].(P1b))
+	if !target.active {
+		t.Fatal("expected target to become active leader")
+	}
+	if len(leader.requests) != 0 {
+		t.Errorf("expected held requests forwarded to new leader, held %d", len(leader.requests))
+	}
+	t.Logf("leadership handoff takes %v", time.Since(start))
+}
+
+func TestStepDown(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	put := func(i int) paxi.Request {
+		return paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}}
+	}
+	for _, requeue := range []bool{false, true} {
+		p := NewPaxos(newNode(id))
+		if p.StepDown(requeue) {
+			t.Fatal("expected follower not to step down")
+		}
+		p.P1a()
+		p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+		p.HandleRequest(put(0))
+		b := p.ballot
+
+		if !p.StepDown(requeue) || p.active || p.ballot != b {
+			t.Fatalf("expected leader to step down in ballot %v, active %t ballot %v", b, p.active, p.ballot)
+		}
+		e := p.log[0]
+		if e == nil || e.commit || !equal(e.commands, []paxi.Command{put(0).Command}) {
+			t.Fatalf("expected uncommitted slot 0 left for next leader, got %v", e)
+		}
+		if requeue && (len(e.requests) != 0 || len(p.requests) != 1) {
+			t.Errorf("expected request of slot 0 requeued, slot %d pending %d", len(e.requests), len(p.requests))
+		}
+		if !requeue && (len(e.requests) != 1 || len(p.requests) != 0) {
+			t.Errorf("expected request to wait on slot 0, slot %d pending %d", len(e.requests), len(p.requests))
+		}
+		if p.StepDown(requeue) {
+			t.Error("expected second step down to do nothing")
+		}
+	}
+}
+
+func TestPendingRequests(t *testing.T) {
+	n := newNode(paxi.NewID(1, 1))
+	p := NewPaxos(n, func(p *Paxos) {
+		p.MaxPending = 2
+		p.RequestTimeout = time.Second
+	})
+	old := time.Now().Add(-time.Minute).UnixNano()
+	for i := 0; i < 3; i++ {
+		r := paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}}
+		if i == 0 {
+			r.Timestamp = old
+		}
+		p.HandleRequest(r)
+	}
+	if len(p.requests) != 2 {
+		t.Fatalf("expected pending requests capped at 2, got %d", len(p.requests))
+	}
+
+	// phase 1 never completes
+	n.fire()
+	if len(p.requests) != 1 || p.requests[0].Command.CommandID != 1 {
+		t.Fatalf("expected expired request failed back, pending %v", p.requests)
+	}
+	if len(n.timers) != 1 {
+		t.Errorf("expected next sweep scheduled for remaining request, timers %d", len(n.timers))
+	}
+}
+
+func TestEarlierDeadline(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	n := newNode(paxi.NewID(1, 1))
+	p := NewPaxos(n, func(p *Paxos) { p.Clock = c })
+	put := func(i int, timeout time.Duration) paxi.Request {
+		m := paxi.NewRequest(paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}, p.ID())
+		m.Deadline = c.now.Add(timeout).UnixNano()
+		return m
+	}
+	late, early := put(0, time.Hour), put(1, time.Second)
+	p.HandleRequest(late)
+	timers := len(n.timers)
+	p.HandleRequest(early)
+	if len(n.timers) != timers+1 || p.sweepAt != early.Deadline {
+		t.Fatalf("expected earlier deadline to schedule its own sweep, timers %d", len(n.timers)-timers)
+	}
+
+	// sweep of the later deadline fires first and is stale, the earlier one fails its request
+	c.now = c.now.Add(time.Second)
+	n.fire()
+	if reply := early.Wait(); reply.Err != ErrRequestTimeout {
+		t.Errorf("expected request failed at its earlier deadline, err %v", reply.Err)
+	}
+	if len(p.requests) != 1 || p.requests[0].Command.CommandID != 0 || p.sweepAt != late.Deadline {
+		t.Errorf("expected later request pending with its sweep, pending %v sweep at %d", p.requests, p.sweepAt)
+	}
+}
+
+func TestPiggybackCommit(t *testing.T) {
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+	cmd := func(i int) []paxi.Command {
+		return []paxi.Command{{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}}
+	}
+
+	// slot 1 accepted in an older ballot may not be the chosen value
+	p.HandleP2a(P2a{Ballot: paxi.NewBallot(0, paxi.NewID(1, 3)), Slot: 1, Commands: cmd(1)})
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: cmd(0)})
+	p.HandleP2a(P2a{Ballot: b, Slot: 2, Commands: cmd(2), Commit: 2})
+
+	if !p.log[0].commit || p.execute != 1 {
+		t.Errorf("expected slot 0 committed by piggybacked index, execute %d", p.execute)
+	}
+	if p.log[1].commit {
+		t.Error("slot 1 of a different ballot is committed by piggybacked index")
+	}
+	if p.log[2].commit {
+		t.Error("slot 2 beyond commit index is committed")
+	}
+}
+
+func TestReconfig(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id))
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+
+	p.Reconfigure(id, peer, paxi.NewID(1, 3))
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}})
+	if p.slot != 0 || len(p.requests) != 1 {
+		t.Fatalf("expected no slot proposed after unexecuted reconfiguration, slot %d held %d", p.slot, len(p.requests))
+	}
+
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: 0})
+	if p.execute != 1 || len(p.Members(1)) != 3 || p.Members(0) != nil {
+		t.Fatalf("expected membership of 3 nodes from slot 1, execute %d members %v", p.execute, p.Members(1))
+	}
+	if p.slot != 1 {
+		t.Fatalf("expected held request proposed after reconfiguration, slot %d", p.slot)
+	}
+
+	// acks from nodes outside the configuration do not count
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: paxi.NewID(1, 9), Slot: 1})
+	if p.log[1].commit {
+		t.Error("slot 1 committed by acks outside its configuration")
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: 1})
+	if !p.log[1].commit {
+		t.Error("expected slot 1 committed by majority of new configuration")
+	}
+
+	// new leader learns committed entries in phase 1
+	n := newNode(peer)
+	acceptor := NewPaxos(n)
+	acceptor.HandleP2a(P2a{Ballot: p.ballot, Slot: 0, Commands: []paxi.Command{NewReconfig(id, peer)}})
+	acceptor.HandleP3(P3{Ballot: p.ballot, Slot: 0, Commands: []paxi.Command{NewReconfig(id, peer)}})
+	acceptor.HandleP1a(P1a{Ballot: paxi.NewBallot(p.ballot.N()+1, id)})
+	p1b := n.sent[len(n.sent)-1].(P1b)
+	if _, exists := p1b.Log[0]; !exists {
+		t.Errorf("expected committed slot 0 in P1b, got %v", p1b.Log)
+	}
+
+	// clients cannot write reserved keys
+	for _, key := range []paxi.Key{ReconfigKey, RegisterKey} {
+		m := paxi.NewRequest(paxi.Command{Key: key, Value: paxi.Value("1.9")}, id)
+		if IsReconfig(m.Command) || IsRegister(m.Command) {
+			t.Errorf("client command on key %d taken for a protocol command", key)
+		}
+		slot := p.slot
+		p.HandleRequest(m)
+		if reply := m.Wait(); reply.Err != paxi.ErrReservedKey || p.slot != slot {
+			t.Errorf("expected client command on key %d rejected, got %v slot %d", key, reply.Err, p.slot)
+		}
+	}
+}
+
+// TestShrink checks slots after a recovered reconfiguration count acks among the smaller membership
+func TestShrink(t *testing.T) {
+	id := paxi.NewID(1, 2)
+	ids := make([]paxi.ID, 0)
+	for i := 1; i <= 5; i++ {
+		ids = append(ids, paxi.NewID(1, i))
+	}
+	p := NewPaxos(newNode(id))
+	p.memberships = []membership{{slot: 0, ids: ids}}
+
+	// new leader recovers reconfiguration to 3 nodes in slot 0 and a command proposed after it in slot 1
+	old := paxi.NewBallot(1, ids[0])
+	shrink := []paxi.Command{NewReconfig(ids[0], ids[1], ids[2])}
+	cmd := []paxi.Command{{Key: 1, Value: paxi.Value("v")}}
+	p.P1a()
+	for _, from := range ids[2:4] {
+		p.HandleP1b(P1b{Ballot: p.ballot, ID: from, Log: map[int]CommandBallot{
+			0: {Commands: shrink, Ballot: old},
+			1: {Commands: cmd, Ballot: old},
+		}})
+	}
+	if !p.active || p.slot != 1 {
+		t.Fatalf("expected leader proposing 2 recovered slots, active %v slot %d", p.active, p.slot)
+	}
+	if p.log[0].epoch != 0 || p.log[1].epoch != 1 {
+		t.Fatalf("expected epochs 0 and 1, got %d and %d", p.log[0].epoch, p.log[1].epoch)
+	}
+
+	// removed nodes no longer count for slot 1 but still count for slot 0
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[4], Slot: 1})
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[3], Slot: 1})
+	if p.log[1].commit {
+		t.Error("slot 1 committed by acks of removed nodes")
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[2], Slot: 1})
+	if !p.log[1].commit {
+		t.Error("expected slot 1 committed by majority of 3 nodes")
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[2], Slot: 0})
+	if p.log[0].commit {
+		t.Error("slot 0 committed by 2 of 5 nodes")
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[4], Slot: 0})
+	if !p.log[0].commit || p.execute != 2 {
+		t.Errorf("expected both slots committed and executed, commit %v execute %d", p.log[0].commit, p.execute)
+	}
+	if len(p.Members(1)) != 3 {
+		t.Errorf("expected membership of 3 nodes from slot 1, got %v", p.Members(1))
+	}
+}
+
+// TestResize checks quorum sizes change through the log at the slot after resize
+func TestResize(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	ids := make([]paxi.ID, 0)
+	for i := 1; i <= 5; i++ {
+		ids = append(ids, paxi.NewID(1, i))
+	}
+	p := NewPaxos(newNode(id))
+	p.memberships = []membership{{slot: 0, ids: ids}}
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[1]})
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[2]})
+
+	if p.Resize(2, 4) == nil {
+		t.Error("phase 1 quorum of 2 does not intersect current phase 2 majority")
+	}
+	if err := p.Resize(3, 4); err != nil {
+		t.Fatal(err)
+	}
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}})
+	if p.slot != 0 || !IsResize(p.log[0].commands[0]) {
+		t.Fatalf("expected resize proposed alone in slot 0, slot %d", p.slot)
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[1], Slot: 0})
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[2], Slot: 0})
+	if q1, q2 := p.Sizes(p.execute); p.execute != 1 || q1 != 3 || q2 != 4 {
+		t.Fatalf("expected sizes 3 and 4 from slot 1, execute %d sizes %d %d", p.execute, q1, q2)
+	}
+	if len(p.Members(1)) != 5 {
+		t.Errorf("expected resize to keep membership, got %v", p.Members(1))
+	}
+
+	// slot 1 needs 4 acks
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[1], Slot: 1})
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[2], Slot: 1})
+	if p.log[1].commit {
+		t.Error("slot 1 committed by 3 of 5 nodes after resize to 4")
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[3], Slot: 1})
+	if !p.log[1].commit {
+		t.Error("expected slot 1 committed by 4 nodes")
+	}
+
+	// replicas learn sizes from the log, and resize that no longer intersects is ignored by every replica
+	storage, err := NewFileStorage(filepath.Join(t.TempDir(), "wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withStorage := func(p *Paxos) {
+		p.Storage = storage
+		p.memberships = []membership{{slot: 0, ids: ids}}
+	}
+	n := newNode(ids[1])
+	follower := NewPaxos(n, withStorage)
+	commands := [][]paxi.Command{{NewResize(3, 4)}, {NewResize(2, 4)}, {NewResize(0, 0)}}
+	for s, c := range commands {
+		follower.HandleP2a(P2a{Ballot: p.ballot, Slot: s, Commands: c})
+		follower.HandleP3(P3{Ballot: p.ballot, Slot: s, Commands: c})
+	}
+	if q1, q2 := follower.Sizes(follower.execute); follower.execute != 3 || q1 != 2 || q2 != 4 {
+		t.Errorf("expected sizes 2 and 4 after ignored resize, execute %d sizes %d %d", follower.execute, q1, q2)
+	}
+	if q1, q2 := NewPaxos(newNode(ids[1]), withStorage).Sizes(3); q1 != 2 || q2 != 4 {
+		t.Errorf("expected sizes 2 and 4 recovered from write-ahead log, got %d %d", q1, q2)
+	}
+
+	// snapshot carries sizes
+	follower.HandleSnapshotRequest(SnapshotRequest{ID: id, LastExecute: 0})
+	reply := n.sent[len(n.sent)-1].(SnapshotReply)
+	if !reflect.DeepEqual(reply.Sizes, []int{2, 4}) {
+		t.Errorf("expected sizes in snapshot, got %v", reply.Sizes)
+	}
+}
+
+// TestLaggingCandidate checks a candidate that has not executed a reconfiguration needs a phase 1 quorum
+// in the new membership as well
+func TestLaggingCandidate(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	ids := make([]paxi.ID, 0)
+	for i := 1; i <= 5; i++ {
+		ids = append(ids, paxi.NewID(1, i))
+	}
+	p := NewPaxos(newNode(id))
+	p.memberships = []membership{{slot: 0, ids: ids[:3]}}
+
+	// 1.2 executed growth to 5 nodes in slot 0
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[1], Epoch: 1, Members: ids})
+	if p.active {
+		t.Fatal("elected by 2 of 5 nodes in the new membership")
+	}
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[3]})
+	if !p.active {
+		t.Fatal("expected elected by majority of both memberships")
+	}
+
+	// accepted reconfiguration in P1b counts as well
+	p = NewPaxos(newNode(id))
+	p.memberships = []membership{{slot: 0, ids: ids[:3]}}
+	p.P1a()
+	grow := []paxi.Command{NewReconfig(ids...)}
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[1], Log: map[int]CommandBallot{
+		0: {Commands: grow, Ballot: paxi.NewBallot(1, ids[1])},
+	}})
+	if p.active {
+		t.Fatal("elected by 2 of 5 nodes in the accepted membership")
+	}
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[4]})
+	if !p.active {
+		t.Fatal("expected elected by majority of both memberships")
+	}
+
+	// responders report the last membership they executed
+	n := newNode(ids[1])
+	acceptor := NewPaxos(n)
+	acceptor.memberships = []membership{{slot: 0, ids: ids[:3]}, {slot: 4, ids: ids}}
+	acceptor.execute = 4
+	acceptor.HandleP1a(P1a{Ballot: paxi.NewBallot(acceptor.ballot.N()+1, id)})
+	p1b := n.sent[len(n.sent)-1].(P1b)
+	if p1b.Epoch != 4 || len(p1b.Members) != 5 {
+		t.Errorf("expected membership of 5 nodes from slot 4 in P1b, got %d %v", p1b.Epoch, p1b.Members)
+	}
+}
+
+func TestThrifty(t *testing.T) {
+	id := paxi.NewID(2, 1)
+	n := newNode(id)
+	p := NewPaxos(n)
+	ids := []paxi.ID{id, paxi.NewID(2, 2), paxi.NewID(1, 1), paxi.NewID(3, 1), paxi.NewID(5, 1)}
+	p.memberships = []membership{{slot: 0, ids: ids}}
+
+	peers := p.peers(0)
+	if len(peers) != 2 || peers[0] != paxi.NewID(2, 2) || peers[1] != paxi.NewID(1, 1) {
+		t.Fatalf("expected closest 2 peers by zone, got %v", peers)
+	}
+
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(2, 2)})
+	n.sent = nil
+	p.slot++
+	p.log[p.slot] = &entry{ballot: p.ballot, quorum: p.newQuorum(p.slot)}
+	p.thrifty(P2a{Ballot: p.ballot, Slot: p.slot})
+	if len(n.sent) != 2 {
+		t.Fatalf("expected accept sent to 2 peers, sent %d", len(n.sent))
+	}
+	n.fire()
+	if len(n.sent) != 3 {
+		t.Errorf("expected fallback broadcast of uncommitted slot, sent %d", len(n.sent))
+	}
+}
+
+func TestPreVote(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	n := newNode(id)
+	p := NewPaxos(n, func(p *Paxos) {
+		p.PreVote = true
+		p.Q1 = func(q *paxi.Quorum) bool { return q.Size() >= 2 }
+	})
+	p.P1a()
+	m, ok := n.sent[len(n.sent)-1].(PreVote)
+	if !ok || p.ballot != 0 {
+		t.Fatalf("expected pre-vote without raising ballot, ballot %v sent %v", p.ballot, n.sent)
+	}
+
+	// follower of a live leader denies
+	leader := paxi.NewBallot(3, paxi.NewID(1, 3))
+	n2 := newNode(peer)
+	follower := NewPaxos(n2, func(p *Paxos) { p.Timeout = time.Hour })
+	follower.HandleHeartbeat(Heartbeat{Ballot: leader})
+	follower.HandlePreVote(m)
+	reply := n2.sent[0].(PreVoteReply)
+	if reply.Grant {
+		t.Fatal("pre-vote granted while leader is alive")
+	}
+	p.HandlePreVoteReply(reply)
+	if p.ballot != leader || p.active {
+		t.Fatalf("expected candidate to follow live leader, ballot %v", p.ballot)
+	}
+
+	// leader is gone
+	p.P1a()
+	m = n.sent[len(n.sent)-1].(PreVote)
+	p.HandlePreVoteReply(PreVoteReply{Ballot: m.Ballot, ID: peer, Leader: leader, Grant: true})
+	if p.ballot != m.Ballot {
+		t.Fatalf("expected phase 1 with pre-voted ballot %v, got %v", m.Ballot, p.ballot)
+	}
+	if _, ok := n.sent[len(n.sent)-1].(P1a); !ok {
+		t.Errorf("expected P1a after pre-vote, sent %v", n.sent)
+	}
+}
+
+func TestFastPath(t *testing.T) {
+	ids := []paxi.ID{paxi.NewID(1, 1), paxi.NewID(1, 2), paxi.NewID(1, 3), paxi.NewID(1, 4)}
+	b := paxi.NewBallot(1, ids[0])
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}
+
+	n := newNode(ids[1])
+	p := NewPaxos(n)
+	p.memberships = []membership{{slot: 0, ids: ids}}
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}, Fast: true})
+	if len(n.sent) != 1 || n.sent[0].(P2b).Ballot != b {
+		t.Fatalf("expected P2b broadcast in fast path, sent %v", n.sent)
+	}
+	// leader and self are 2 of fast quorum 3
+	if p.log[0].commit {
+		t.Fatal("slot committed before fast quorum")
+	}
+	p.HandleP2b(P2b{Ballot: b, ID: ids[2], Slot: 0})
+	if !p.log[0].commit || p.execute != 1 {
+		t.Fatalf("expected slot 0 committed by fast quorum without P3, execute %d", p.execute)
+	}
+
+	// acceptor of a different ballot conflicts and falls back to P3
+	p = NewPaxos(newNode(ids[1]))
+	p.memberships = []membership{{slot: 0, ids: ids}}
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}, Fast: true})
+	p.HandleP2b(P2b{Ballot: paxi.NewBallot(2, ids[3]), ID: ids[2], Slot: 0})
+	if p.log[0].commit {
+		t.Fatal("slot committed with conflicting ballot")
+	}
+	p.HandleP3(P3{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}})
+	if !p.log[0].commit || p.execute != 1 {
+		t.Errorf("expected slot 0 committed by P3 after conflict, execute %d", p.execute)
+	}
+}
+
+func TestFastDecision(t *testing.T) {
+	ids := []paxi.ID{paxi.NewID(1, 1), paxi.NewID(1, 2), paxi.NewID(1, 3), paxi.NewID(1, 4), paxi.NewID(1, 5)}
+	n := newNode(ids[0])
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Fast = true
+		p.FastTimeout = time.Second
+	})
+	p.memberships = []membership{{slot: 0, ids: ids}}
+	p.P1a()
+	for _, id := range ids[1:3] {
+		p.HandleP1b(P1b{Ballot: p.ballot, ID: id})
+	}
+	if !p.active {
+		t.Fatal("expected leader active")
+	}
+	p3 := func() bool {
+		for _, m := range n.sent {
+			if _, ok := m.(P3); ok {
+				return true
+			}
+		}
+		return false
+	}
+	put := func(i int) paxi.Request {
+		return paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}}
+	}
+
+	// non-conflicting workload: classic quorum commits, the fourth ack completes fast quorum and P3 is skipped
+	n.sent, n.timers = nil, nil
+	p.HandleRequest(put(0))
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[1], Slot: 0})
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[2], Slot: 0})
+	if !p.log[0].commit || !p.log[0].undecided || p3() {
+		t.Fatalf("expected slot 0 committed by classic quorum waiting for fast quorum, sent %v", n.sent)
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[3], Slot: 0})
+	n.fire()
+	if p.log[0].undecided || p3() {
+		t.Errorf("expected fast quorum to skip P3, sent %v", n.sent)
+	}
+
+	// conflicting workload: an acceptor replaced other commands of the slot, leader falls back to P3 at once
+	n.sent = nil
+	p.HandleRequest(put(1))
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[1], Slot: 1, Conflict: true})
+	for _, id := range ids[2:] {
+		p.HandleP2b(P2b{Ballot: p.ballot, ID: id, Slot: 1})
+	}
+	if !p.log[1].commit || !p3() {
+		t.Errorf("expected conflicting slot 1 committed with P3, sent %v", n.sent)
+	}
+
+	// fast quorum is not reached within timeout
+	n.sent, n.timers = nil, nil
+	p.HandleRequest(put(2))
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[1], Slot: 2})
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[2], Slot: 2})
+	if !p.log[2].commit || p3() {
+		t.Fatalf("expected slot 2 committed waiting for fast quorum, sent %v", n.sent)
+	}
+	n.fire()
+	if !p3() {
+		t.Errorf("expected P3 of slot 2 after fast timeout, sent %v", n.sent)
+	}
+
+	// acceptor reports conflict only if it replaced different commands
+	a := newNode(ids[1])
+	q := NewPaxos(a)
+	q.memberships = []membership{{slot: 0, ids: ids}}
+	old := paxi.NewBallot(1, ids[4])
+	q.HandleP2a(P2a{Ballot: old, Slot: 0, Commands: []paxi.Command{put(9).Command}, Fast: true})
+	q.HandleP2a(P2a{Ballot: old, Slot: 1, Commands: []paxi.Command{put(1).Command}, Fast: true})
+	a.sent = nil
+	b := paxi.NewBallot(2, ids[0])
+	q.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{put(0).Command}, Fast: true})
+	q.HandleP2a(P2a{Ballot: b, Slot: 1, Commands: []paxi.Command{put(1).Command}, Fast: true})
+	if len(a.sent) != 2 || !a.sent[0].(P2b).Conflict || a.sent[1].(P2b).Conflict {
+		t.Errorf("expected conflict on replaced slot 0 only, sent %v", a.sent)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	b := paxi.NewBallot(2, paxi.NewID(1, 1))
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}
+	for _, scheme := range []string{"gob", "json", "proto"} {
+		var send interface{} = P1b{
+			Ballot: b,
+			ID:     paxi.NewID(1, 2),
+			Log: map[int]CommandBallot{
+				0: {Commands: []paxi.Command{cmd}, Ballot: b},
+				3: {Commands: []paxi.Command{}, Ballot: b - 1},
+			},
+		}
+		var recv interface{}
+		c := paxi.NewCodec(scheme, new(bytes.Buffer))
+		if err := c.Encode(&send); err != nil {
+			t.Fatalf("%s encode: %v", scheme, err)
+		}
+		if err := c.Decode(&recv); err != nil {
+			t.Fatalf("%s decode: %v", scheme, err)
+		}
+		m, ok := recv.(P1b)
+		if !ok || m.Ballot != b || m.ID != paxi.NewID(1, 2) || len(m.Log) != 2 {
+			t.Fatalf("%s decoded %v, expected %v", scheme, recv, send)
+		}
+		if m.Log[0].Ballot != b || m.Log[0].Commands[0].String() != cmd.String() || m.Log[3].Ballot != b-1 {
+			t.Errorf("%s decoded log %v, expected %v", scheme, m.Log, send.(P1b).Log)
+		}
+	}
+}
+
+func TestAdaptiveBatch(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 1)), func(p *Paxos) {
+		p.AdaptiveBatch = true
+		p.batcher = batcher{min: 1, max: 8, maxInterval: 10 * time.Millisecond}
+	})
+	now := time.Now()
+	p.tune(now)
+	if p.batchSize() != 1 || p.batchInterval() != 0 {
+		t.Fatalf("expected no batching when idle, size %d interval %v", p.batchSize(), p.batchInterval())
+	}
+
+	// burst of one request per ms with an inflight slot
+	p.slot = 0
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Millisecond)
+		p.tune(now)
+	}
+	if p.batchSize() != 8 || p.batchInterval() != 7*time.Millisecond {
+		t.Errorf("expected batch of 8 in 7ms under load, size %d interval %v", p.batchSize(), p.batchInterval())
+	}
+	m := p.Metrics()
+	if m.BatchSize != 8 || m.BatchInterval != 7*time.Millisecond {
+		t.Errorf("expected batch parameters in metrics, got %d %v", m.BatchSize, m.BatchInterval)
+	}
+
+	// no wait without inflight slot
+	p.execute = 1
+	now = now.Add(time.Millisecond)
+	p.tune(now)
+	if p.batchInterval() != 0 {
+		t.Errorf("expected no wait without inflight slot, interval %v", p.batchInterval())
+	}
+
+	// load drops
+	for i := 0; i < 20; i++ {
+		now = now.Add(100 * time.Millisecond)
+		p.tune(now)
+	}
+	if p.batchSize() != 1 {
+		t.Errorf("expected batch size back to 1 at low load, got %d", p.batchSize())
+	}
+}
+
+func TestRandSource(t *testing.T) {
+	seeded := func(p *Paxos) { p.Rand = rand.New(rand.NewSource(42)) }
+	p1 := NewPaxos(newNode(paxi.NewID(1, 1)), seeded)
+	p2 := NewPaxos(newNode(paxi.NewID(1, 2)), seeded)
+	for attempt := 1; attempt < 10; attempt++ {
+		p1.attempt, p2.attempt = attempt, attempt
+		if d1, d2 := p1.backoff(), p2.backoff(); d1 != d2 {
+			t.Fatalf("expected same backoff from same seed at attempt %d, got %v and %v", attempt, d1, d2)
+		}
+	}
+}
+
+// clock is a fake Clock advanced by tests
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func TestClock(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	n := newNode(paxi.NewID(1, 1))
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Clock = c
+		p.Timeout = time.Second
+		p.MaxTimeout = time.Second
+	})
+
+	// leader is silent for less than timeout
+	c.now = c.now.Add(500 * time.Millisecond)
+	n.fire()
+	if p.ballot != 0 {
+		t.Fatalf("expected no phase 1 before timeout, ballot %v", p.ballot)
+	}
+	c.now = c.now.Add(time.Second)
+	n.fire()
+	if p.ballot.ID() != p.ID() {
+		t.Fatalf("expected phase 1 after timeout, ballot %v", p.ballot)
+	}
+
+	// lease of another leader expires by clock
+	p.lease = c.now.Add(time.Second)
+	other := paxi.NewBallot(p.ballot.N()+1, paxi.NewID(1, 2))
+	if !p.leased(other) {
+		t.Error("expected lease to hold before expiry")
+	}
+	c.now = c.now.Add(time.Second)
+	if p.leased(other) {
+		t.Error("expected lease expired")
+	}
+
+	// latency is measured by clock
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 2)})
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}})
+	c.now = c.now.Add(3 * time.Millisecond)
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: paxi.NewID(1, 2), Slot: 0})
+	if m := p.Metrics(); m.Commit.Size != 1 || m.Commit.Max != 3 {
+		t.Errorf("expected commit latency of 3ms, got %+v", m.Commit)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	p := NewPaxos(newNode(id), func(p *Paxos) {
+		p.MaxInflight = 1
+		p.Clock = &clock{now: time.Unix(0, 0)}
+	})
+	if s := p.status(); s.IsLeader || s.Leader != "" || s.Slot != -1 || s.InFlight != 0 {
+		t.Fatalf("unexpected status of new node %+v", s)
+	}
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 2)})
+	for i := 0; i < 3; i++ {
+		p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v")}})
+	}
+	want := Status{ID: id, IsLeader: true, Active: true, Ballot: p.ballot, Leader: id, Execute: 0, Slot: 0, InFlight: 1, Pending: 2}
+	if s := p.status(); !reflect.DeepEqual(s, want) {
+		t.Errorf("expected status %+v, got %+v", want, s)
+	}
+}
+
+func TestLogSnapshot(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	p := NewPaxos(newNode(id))
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 2)})
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}
+	p.HandleRequest(paxi.Request{Command: cmd})
+	p.HandleRequest(paxi.Request{Command: cmd})
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: paxi.NewID(1, 2), Slot: 0})
+
+	entries := p.logSnapshot()
+	if len(entries) != 2 || entries[0].Slot != 0 || entries[1].Slot != 1 {
+		t.Fatalf("expected slots 0 and 1 in order, got %v", entries)
+	}
+	if !entries[0].Commit || entries[1].Commit || entries[0].Ballot != p.ballot || entries[0].Commands[0] != cmd.String() {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
+
+func TestCommitDeadline(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	n := newNode(paxi.NewID(1, 1))
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Clock = c
+		p.Q2 = func(q *paxi.Quorum) bool { return q.Size() >= 2 }
+	})
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 2)})
+
+	deadline := c.now.Add(time.Second).UnixNano()
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}, Deadline: deadline})
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 2, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 2}})
+	if len(n.timers) != 1 {
+		t.Fatalf("expected timeout scheduled for request with deadline, timers %d", len(n.timers))
+	}
+
+	// no quorum forms before deadline
+	c.now = c.now.Add(time.Second)
+	n.fire()
+	if p.log[0].requests[0] != nil || p.log[1].requests[0] == nil {
+		t.Fatalf("expected only request past deadline released, slot 0 %v slot 1 %v", p.log[0].requests, p.log[1].requests)
+	}
+	if len(n.timers) != 0 {
+		t.Errorf("expected no more timeout, timers %d", len(n.timers))
+	}
+
+	// slot still commits for other replicas
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: paxi.NewID(1, 2), Slot: 0})
+	if p.execute != 1 {
+		t.Errorf("expected timed out slot executed after commit, execute %d", p.execute)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id), func(p *Paxos) {
+		p.Q2 = func(q *paxi.Quorum) bool { return q.Size() >= 2 }
+	})
+	txn := paxi.Transaction{Commands: []paxi.Command{
+		{Key: 1, Value: paxi.Value("a"), ClientID: "1.1", CommandID: 1},
+		{Key: 2, Value: paxi.Value("b"), ClientID: "1.1", CommandID: 2},
+	}}
+
+	// held until leader, then proposed in one slot
+	p.HandleTransaction(txn)
+	if len(p.txns) != 1 {
+		t.Fatalf("expected transaction held during phase 1, pending %d", len(p.txns))
+	}
+	b := p.ballot
+	p.HandleP1b(P1b{Ballot: b, ID: peer, Log: map[int]CommandBallot{}})
+	if p.slot != 0 || len(p.log[0].commands) != 2 || p.log[0].txn == nil {
+		t.Fatalf("expected transaction in slot 0, slot %d", p.slot)
+	}
+
+	p.HandleP2b(P2b{Ballot: b, ID: peer, Slot: 0})
+	if p.execute != 1 || string(p.Get(1)) != "a" || string(p.Get(2)) != "b" {
+		t.Fatalf("expected both commands executed, execute %d", p.execute)
+	}
+
+	// transaction that loses its slot to a higher ballot is proposed again as a unit
+	rival := paxi.Command{Key: 3, Value: paxi.Value("c"), ClientID: "2.1", CommandID: 1}
+	p.HandleTransaction(txn)
+	p.HandleP1a(P1a{Ballot: paxi.NewBallot(b.N()+1, peer)})
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer, Log: map[int]CommandBallot{
+		1: {Commands: []paxi.Command{rival}, Ballot: paxi.NewBallot(b.N()+1, peer)},
+	}})
+	if !equal(p.log[1].commands, []paxi.Command{rival}) || p.log[1].txn != nil {
+		t.Fatalf("expected rival command in slot 1, got %v", p.log[1].commands)
+	}
+	if p.slot != 2 || len(p.log[2].commands) != 2 || p.log[2].txn == nil {
+		t.Errorf("expected transaction proposed again in slot 2, slot %d", p.slot)
+	}
+}
+
+func TestGrace(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id), func(p *Paxos) {
+		p.Clock = c
+		p.Grace = time.Second
+	})
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	b := p.ballot
+
+	// flapping candidate within grace period
+	p.HandleP1a(P1a{Ballot: paxi.NewBallot(b.N()+1, peer)})
+	if !p.active || p.ballot != b {
+		t.Fatalf("expected new leader to keep leadership within grace, ballot %v", p.ballot)
+	}
+
+	// candidate that won pre-vote
+	p.HandleP1a(P1a{Ballot: paxi.NewBallot(b.N()+2, peer), PreVoted: true})
+	if p.active || p.ballot.N() != b.N()+2 {
+		t.Fatalf("expected leader to step down for pre-voted candidate, ballot %v", p.ballot)
+	}
+
+	// after grace period
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	c.now = c.now.Add(time.Second)
+	p.HandleP1a(P1a{Ballot: paxi.NewBallot(p.ballot.N()+1, peer)})
+	if p.active {
+		t.Error("expected leader to step down after grace period")
+	}
+}
+
+func TestMaxRetry(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	n := newNode(id)
+	p := NewPaxos(n, func(p *Paxos) {
+		p.MaxRetry = 1
+		p.Q2 = func(q *paxi.Quorum) bool { return q.Size() >= 2 }
+	})
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	rival := paxi.Command{Key: 2, Value: paxi.Value("r"), ClientID: "2.1", CommandID: 1}
+
+	// first loss is retried after backoff
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}})
+	r := p.log[0].requests[0]
+	p.HandleP2a(P2a{Ballot: paxi.NewBallot(p.ballot.N()+1, peer), Slot: 0, Commands: []paxi.Command{rival}})
+	if r.Retries != 1 || len(n.timers) != 1 {
+		t.Fatalf("expected request retried once after backoff, retries %d timers %d", r.Retries, len(n.timers))
+	}
+
+	// second loss fails back to client
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	n.timers = nil
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}, Retries: 1})
+	r = p.log[p.slot].requests[0]
+	p.HandleP2a(P2a{Ballot: paxi.NewBallot(p.ballot.N()+1, peer), Slot: p.slot, Commands: []paxi.Command{rival}})
+	if r.Retries != 2 || len(n.timers) != 0 {
+		t.Errorf("expected request failed after max retries, retries %d timers %d", r.Retries, len(n.timers))
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	leader := paxi.NewID(1, 1)
+	b := paxi.NewBallot(1, leader)
+	put := func(k int, v string) []paxi.Command {
+		return []paxi.Command{{Key: paxi.Key(k), Value: paxi.Value(v)}}
+	}
+	p := NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) { p.OutOfOrder = true })
+
+	// slot 1 does not conflict with missing slot 0
+	p.HandleP3(P3{Ballot: b, Slot: 1, Commands: put(2, "b"), Deps: []int{-1, -1}})
+	if !p.log[1].executed || p.execute != 0 || string(p.Get(2)) != "b" {
+		t.Fatalf("expected slot 1 executed before slot 0, execute %d", p.execute)
+	}
+	// slot 2 writes the same key as slot 0
+	p.HandleP3(P3{Ballot: b, Slot: 2, Commands: put(1, "c"), Deps: []int{-1, 0}})
+	// slot 4 has no dependencies and executes in order
+	p.HandleP3(P3{Ballot: b, Slot: 4, Commands: put(3, "d")})
+	if p.log[2].executed || p.log[4].executed {
+		t.Fatal("expected slot 2 and 4 wait")
+	}
+	p.HandleP3(P3{Ballot: b, Slot: 0, Commands: put(1, "a"), Deps: []int{-1, -1}})
+	if p.execute != 3 || string(p.Get(1)) != "c" || p.Metrics().Executed != 3 {
+		t.Errorf("expected slots 0 to 2 executed once, execute %d key 1 = %s", p.execute, p.Get(1))
+	}
+
+	// leader computes dependencies of slot it commits
+	n := newNode(leader)
+	l := NewPaxos(n, func(p *Paxos) { p.OutOfOrder = true })
+	l.P1a()
+	l.HandleP1b(P1b{Ballot: l.ballot, ID: paxi.NewID(1, 2)})
+	for i, k := range []int{1, 2, 1} {
+		l.HandleRequest(paxi.Request{Command: put(k, "v")[0]})
+		l.HandleP2b(P2b{Ballot: l.ballot, ID: paxi.NewID(1, 2), Slot: i})
+	}
+	deps := make([][]int, 0)
+	for _, m := range n.sent {
+		if m, ok := m.(P3); ok {
+			deps = append(deps, m.Deps)
+		}
+	}
+	if !reflect.DeepEqual(deps, [][]int{{-1, -1}, {-1, -1}, {-1, 0}}) {
+		t.Errorf("unexpected dependencies %v", deps)
+	}
+}
+
+// BenchmarkLog commits one slot per iteration on a leader whose log starts with different capacities
+func BenchmarkLog(b *testing.B) {
+	for _, capacity := range []int{0, 1024, 1 << 16} {
+		b.Run(strconv.Itoa(capacity), func(b *testing.B) {
+			peer := paxi.NewID(1, 2)
+			p := NewPaxos(newNode(paxi.NewID(1, 1)), func(p *Paxos) {
+				p.LogCapacity = capacity
+			})
+			p.P1a()
+			p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i % 1000), Value: paxi.Value("v")}})
+				p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: i})
+			}
+		})
+	}
+}
+
+func TestDigest(t *testing.T) {
+	leader := paxi.NewID(1, 1)
+	b := paxi.NewBallot(1, leader)
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("a")}
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n)
+	qn := newNode(paxi.NewID(1, 3))
+	q := NewPaxos(qn, func(p *Paxos) { p.DigestInterval = time.Second })
+	for _, r := range []*Paxos{p, q} {
+		r.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}})
+		r.HandleP3(P3{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}})
+	}
+	q.digest()
+	d, ok := qn.sent[len(qn.sent)-1].(Digest)
+	if !ok || d.Slot != 0 || d.Hash != q.hash {
+		t.Fatalf("expected digest of slot 0, sent %v", qn.sent)
+	}
+	n.sent = nil
+	p.HandleDigest(d)
+	if p.diverged || len(n.sent) != 0 {
+		t.Fatalf("unexpected divergence, sent %v", n.sent)
+	}
+
+	p.HandleDigest(Digest{ID: q.ID(), Slot: 0, Hash: q.hash + 1})
+	if !p.diverged || len(n.sent) != 1 || n.sent[0].(SnapshotRequest).LastExecute != -1 {
+		t.Fatalf("expected snapshot request from leader, sent %v", n.sent)
+	}
+	// snapshot of the same slot replaces diverged state
+	p.Put(1, paxi.Value("x"))
+	p.HandleSnapshotReply(SnapshotReply{Ballot: b, Slot: 1, State: q.Snapshot(), Hash: q.hash})
+	if p.diverged || string(p.Get(1)) != "a" || p.hash != q.hash {
+		t.Errorf("expected snapshot installed, key 1 = %s", p.Get(1))
+	}
+}
+
+func TestOnLeaderChange(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id))
+	type change struct {
+		leader bool
+		id     paxi.ID
+	}
+	changes := make([]change, 0)
+	p.OnLeaderChange(func(isLeader bool, leader paxi.ID) {
+		changes = append(changes, change{isLeader, leader})
+	})
+
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	p.HandleP1a(P1a{Ballot: paxi.NewBallot(p.ballot.N()+1, peer)})
+	p.HandleP2a(P2a{Ballot: p.ballot, Slot: 1})
+
+	expected := []change{{false, id}, {true, id}, {false, peer}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestDuel(t *testing.T) {
+	rival := paxi.NewID(1, 2)
+	base := time.Duration(paxi.GetConfig().BackOff) * time.Millisecond
+	duel := func(id paxi.ID) *Paxos {
+		p := NewPaxos(newNode(id))
+		for i := 0; i < 3; i++ {
+			p.P1a()
+			p.HandleP1a(P1a{Ballot: paxi.NewBallot(p.ballot.N()+1, rival)})
+		}
+		return p
+	}
+
+	low := duel(paxi.NewID(1, 1))
+	high := duel(paxi.NewID(1, 3))
+	if low.duels != 3 || high.duels != 3 {
+		t.Fatalf("expected 3 duels with %s, got %d and %d", rival, low.duels, high.duels)
+	}
+	for i := 0; i < 100; i++ {
+		if d := high.backoff(); d < base || d > 2*base {
+			t.Fatalf("preferred node backs off %v, expected within [%v, %v]", d, base, 2*base)
+		}
+		if d := low.backoff(); d < 2*base*4 {
+			t.Fatalf("yielding node backs off %v after %d attempts", d, low.attempt)
+		}
+	}
+
+	// duel ends once a leader is elected
+	low.HandleP2a(P2a{Ballot: low.ballot, Slot: 0})
+	if low.duels != 0 || low.rival != "" {
+		t.Errorf("expected contention settled, duels %d with %s", low.duels, low.rival)
+	}
+}
+
+func TestMinElection(t *testing.T) {
+	n := newNode(paxi.NewID(1, 1))
+	c := &clock{now: time.Unix(0, 0)}
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Clock = c
+		p.PreVote = true
+		p.MinElection = 100 * time.Millisecond
+	})
+	count := func(m interface{}) int {
+		k := 0
+		for _, s := range n.sent {
+			if reflect.TypeOf(s) == reflect.TypeOf(m) {
+				k++
+			}
+		}
+		return k
+	}
+
+	for i := 0; i < 10; i++ {
+		p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i)}})
+	}
+	// pre-vote is granted by self alone without config
+	if count(PreVote{}) != 1 || count(P1a{}) != 1 {
+		t.Fatalf("expected requests to share one pre-vote and P1a, got %v", n.sent)
+	}
+
+	// P1a is preempted by a higher ballot
+	p.HandleP1a(P1a{Ballot: paxi.NewBallot(p.ballot.N()+1, paxi.NewID(1, 2))})
+	n.sent = nil
+	for i := 0; i < 10; i++ {
+		p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i)}})
+	}
+	if len(n.timers) != 1 || len(n.sent) != 0 {
+		t.Fatalf("expected one scheduled phase 1, got %d timers and %d messages", len(n.timers), len(n.sent))
+	}
+
+	// waits at least min election interval since last broadcast
+	if d := p.MinElection - p.since(p.prepared); d <= 0 {
+		t.Errorf("expected phase 1 delayed by min election interval")
+	}
+	n.fire()
+	if k := count(PreVote{}); k != 1 {
+		t.Errorf("expected one pre-vote after delay, got %d", k)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	n := newNode(id)
+	p := NewPaxos(n, func(p *Paxos) { p.Compress = 64 })
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	if !p.active {
+		t.Fatal("expected node to become active leader")
+	}
+
+	large := paxi.Value(bytes.Repeat([]byte("value"), 100))
+	n.sent = nil
+	p.P2a(
+		&paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("small")}},
+		&paxi.Request{Command: paxi.Command{Key: 2, Value: large}},
+	)
+	m, ok := n.sent[0].(P2a)
+	if !ok || !reflect.DeepEqual(m.Compressed, []int{1}) {
+		t.Fatalf("expected only large value compressed, got %v", n.sent)
+	}
+	if len(m.Commands[1].Value) >= len(large) || !bytes.Equal(p.log[0].commands[1].Value, large) {
+		t.Errorf("expected compressed value in P2a and original value in log")
+	}
+	if r := p.Metrics().Ratio; r <= 0 || r >= 1 {
+		t.Errorf("expected compression ratio in (0, 1), got %f", r)
+	}
+
+	f := NewPaxos(newNode(peer))
+	f.HandleP2a(m)
+	if e := f.log[0]; e == nil || !bytes.Equal(e.commands[1].Value, large) || string(e.commands[0].Value) != "small" {
+		t.Errorf("expected acceptor to restore values, got %v", f.log[0])
+	}
+
+	// value inflating beyond max message size is refused
+	f = NewPaxos(newNode(peer), func(p *Paxos) { p.MaxMessageSize = len(large) - 1 })
+	f.HandleP2a(m)
+	if _, exists := f.log[0]; exists {
+		t.Error("expected acceptor to refuse value inflating beyond max message size")
+	}
+}
+
+func TestStaggerTimeout(t *testing.T) {
+	timeouts := make(map[time.Duration]bool)
+	for i := 1; i <= 5; i++ {
+		c := &clock{now: time.Unix(0, 0)}
+		n := newNode(paxi.NewID(1, i))
+		p := NewPaxos(n, func(p *Paxos) {
+			p.Clock = c
+			p.Rand = rand.New(rand.NewSource(int64(i)))
+			p.Timeout = 100 * time.Millisecond
+			p.MaxTimeout = 300 * time.Millisecond
+		})
+		if p.timeout < p.Timeout || p.timeout > p.MaxTimeout {
+			t.Fatalf("election timeout %v out of range [%v, %v]", p.timeout, p.Timeout, p.MaxTimeout)
+		}
+		m := p.Metrics()
+		if m.ElectionTimeout != p.timeout || m.MinTimeout != p.Timeout || m.MaxTimeout != p.MaxTimeout {
+			t.Errorf("expected timeout %v in [%v, %v] in metrics, got %+v", p.timeout, p.Timeout, p.MaxTimeout, m)
+		}
+		timeouts[p.timeout] = true
+
+		// phase 1 starts exactly when drawn timeout expires
+		c.now = c.now.Add(p.timeout - time.Millisecond)
+		n.fire()
+		if p.ballot != 0 {
+			t.Fatalf("expected no phase 1 before timeout %v, ballot %v", p.timeout, p.ballot)
+		}
+		c.now = c.now.Add(time.Millisecond)
+		n.fire()
+		if p.ballot.ID() != p.ID() {
+			t.Fatalf("expected phase 1 after timeout %v, ballot %v", p.timeout, p.ballot)
+		}
+	}
+	if len(timeouts) < 2 {
+		t.Errorf("expected followers to draw different election timeouts, got %v", timeouts)
+	}
+}
+
+func TestAdaptiveHeartbeat(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	leader := NewPaxos(newNode(paxi.NewID(1, 1)), func(p *Paxos) {
+		p.Clock = c
+		p.AdaptiveHeartbeat = true
+		p.Heartbeat = 100 * time.Millisecond
+		p.MinHeartbeat = 5 * time.Millisecond
+	})
+	if d := leader.interval(); d < 90*time.Millisecond || d > leader.Heartbeat {
+		t.Errorf("expected heartbeat near max interval without rtt, got %v", d)
+	}
+	leader.sample(paxi.NewID(1, 2), 2*time.Millisecond)
+	if d := leader.interval(); d < 7*time.Millisecond || d > 9*time.Millisecond {
+		t.Errorf("expected heartbeat of 4 round trips with jitter, got %v", d)
+	}
+	leader.sample(paxi.NewID(1, 3), time.Second)
+	if d := leader.interval(); d < 90*time.Millisecond || d > leader.Heartbeat {
+		t.Errorf("expected heartbeat capped near max interval, got %v", d)
+	}
+
+	follower := NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) {
+		p.Clock = c
+		p.Rand = rand.New(rand.NewSource(1))
+		p.AdaptiveHeartbeat = true
+		p.Timeout = time.Second
+		p.MaxTimeout = 2 * time.Second
+		p.MinTimeout = 50 * time.Millisecond
+	})
+	if follower.timeout < time.Second {
+		t.Fatalf("expected configured timeout before any heartbeat, got %v", follower.timeout)
+	}
+	b := paxi.NewBallot(1, leader.ID())
+	for i := 0; i < 20; i++ {
+		follower.HandleHeartbeat(Heartbeat{Ballot: b})
+		c.now = c.now.Add(10 * time.Millisecond)
+	}
+	// 10ms arrivals reach phi 8 after 8 ln 10 intervals
+	min := follower.detect()
+	if min < 180*time.Millisecond || min > 190*time.Millisecond {
+		t.Errorf("expected failure detection after about 184ms, got %v", min)
+	}
+	if follower.timeout < min || follower.timeout > 2*min {
+		t.Errorf("expected election timeout in [%v, %v], got %v", min, 2*min, follower.timeout)
+	}
+	m := follower.Metrics()
+	if m.Suspicion < 0.4 || m.Suspicion > 0.5 || m.MinTimeout != min {
+		t.Errorf("expected suspicion of one interval and timeout %v in metrics, got %g %v", min, m.Suspicion, m.MinTimeout)
+	}
+	c.now = c.now.Add(min)
+	if m := follower.Metrics(); m.Suspicion < 8 {
+		t.Errorf("expected suspicion above threshold after %v, got %g", min, m.Suspicion)
+	}
+
+	// detection never goes below min timeout
+	for i := 0; i < 200; i++ {
+		follower.HandleHeartbeat(Heartbeat{Ballot: b})
+		c.now = c.now.Add(time.Millisecond)
+	}
+	if d := follower.detect(); d != follower.MinTimeout {
+		t.Errorf("expected detection bounded by min timeout %v, got %v", follower.MinTimeout, d)
+	}
+}
+
+func TestQuorumRead(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	n := newNode(id)
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Q1 = func(q *paxi.Quorum) bool { return q.Size() >= 2 }
+	})
+	get := paxi.Request{Command: paxi.Command{Key: 1, ClientID: "1.1", CommandID: 1}}
+
+	// follower in minority partition never replies
+	p.ReadFromQuorum(get)
+	m, ok := n.sent[len(n.sent)-1].(QuorumRead)
+	if !ok || len(p.polls) != 1 || len(p.reads) != 0 {
+		t.Fatalf("expected QuorumRead broadcast and pending read, sent %v", n.sent)
+	}
+
+	// quorum has accepted slot 0 that this follower has not executed
+	p.HandleQuorumReadAck(QuorumReadAck{ID: peer, Seq: m.Seq, Slot: 0})
+	if len(p.polls) != 0 || len(p.reads) != 1 {
+		t.Fatalf("expected read waiting for slot 0, polls %d reads %d", len(p.polls), len(p.reads))
+	}
+	b := paxi.NewBallot(1, peer)
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("v")}}, Commit: 1})
+	if p.execute != 1 || len(p.reads) != 0 {
+		t.Errorf("expected read served after execution, execute %d reads %d", p.execute, len(p.reads))
+	}
+
+	// responders report their highest accepted slot
+	n.sent = nil
+	p.HandleQuorumRead(QuorumRead{ID: peer, Seq: 7})
+	if ack, ok := n.sent[0].(QuorumReadAck); !ok || ack.Slot != 0 || ack.Seq != 7 {
+		t.Errorf("expected ack of slot 0, sent %v", n.sent)
+	}
+
+	// read quorum never answers, read fails after request timeout
+	p.RequestTimeout = time.Second
+	n.timers = nil
+	m2 := paxi.NewRequest(get.Command, id)
+	p.ReadFromQuorum(m2)
+	if len(p.polls) != 1 || len(n.timers) != 1 {
+		t.Fatalf("expected pending read with timeout, polls %d timers %d", len(p.polls), len(n.timers))
+	}
+	n.fire()
+	if reply := m2.Wait(); reply.Err != ErrReadTimeout || len(p.polls) != 0 {
+		t.Errorf("expected read timeout and poll removed, err %v polls %d", reply.Err, len(p.polls))
+	}
+}
+
+func TestNoOp(t *testing.T) {
+	noop := paxi.NoOp()
+	if noop.IsRead() || noop.Empty() || !noop.Equal(paxi.Command{Key: paxi.NoOpKey, Value: paxi.Value{}, Protocol: true}) {
+		t.Errorf("expected no-op neither read nor empty and equal to every no-op")
+	}
+	if noop.Equal(paxi.Command{Key: 1}) || (paxi.Command{Key: 1}).Equal(noop) {
+		t.Errorf("expected no-op not equal to other commands")
+	}
+
+	// write of a client on the reserved key is neither a no-op nor accepted
+	write := paxi.Command{Key: paxi.NoOpKey, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}
+	if write.IsNoOp() || write.Equal(noop) || noop.Equal(write) || write.Hash() == noop.Hash() {
+		t.Errorf("expected client write on no-op key told apart from no-op")
+	}
+	m := paxi.NewRequest(write, paxi.NewID(1, 2))
+	NewPaxos(newNode(paxi.NewID(1, 2))).HandleRequest(m)
+	if reply := m.Wait(); reply.Err != paxi.ErrReservedKey {
+		t.Errorf("expected client write on no-op key rejected, got %v", reply.Err)
+	}
+
+	// no-op advances execution without touching state machine
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n, func(p *Paxos) { p.OutOfOrder = true })
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{noop}, Commit: 1})
+	p.HandleP2a(P2a{Ballot: b, Slot: 1, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("v")}}, Commit: 2})
+	if p.execute != 2 || string(p.Get(1)) != "v" || p.Get(paxi.NoOpKey) != nil {
+		t.Errorf("expected no-op skipped by state machine, execute %d", p.execute)
+	}
+	if _, exists := p.keys[paxi.NoOpKey]; exists {
+		t.Errorf("expected no-op not tracked as key")
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	storage, err := NewGroupFileStorage(filepath.Join(t.TempDir(), "wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Storage = storage
+		p.SyncBatch = 3
+		p.SyncInterval = time.Hour
+	})
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p2b := func() int {
+		k := 0
+		for _, m := range n.sent {
+			if _, ok := m.(P2b); ok {
+				k++
+			}
+		}
+		return k
+	}
+
+	// ballot and first accept wait for group commit
+	p.HandleP1a(P1a{Ballot: b})
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("v")}}})
+	if len(n.sent) != 0 || len(n.timers) != 1 {
+		t.Fatalf("expected replies held until sync with one scheduled flush, sent %v", n.sent)
+	}
+	p.HandleP2a(P2a{Ballot: b, Slot: 1, Commands: []paxi.Command{{Key: 2, Value: paxi.Value("v")}}})
+	if len(n.sent) != 3 || p2b() != 2 {
+		t.Fatalf("expected P1b and both P2b sent in order after batch of 3 records, sent %v", n.sent)
+	}
+	if _, ok := n.sent[0].(P1b); !ok {
+		t.Errorf("expected P1b sent first, sent %v", n.sent)
+	}
+
+	// interval flushes partial batch
+	p.HandleP2a(P2a{Ballot: b, Slot: 2, Commands: []paxi.Command{{Key: 3, Value: paxi.Value("v")}}})
+	if p2b() != 2 {
+		t.Fatalf("expected P2b of slot 2 held, sent %v", n.sent)
+	}
+	n.fire()
+	if p2b() != 3 {
+		t.Errorf("expected P2b of slot 2 sent after sync interval, sent %v", n.sent)
+	}
+
+	p = NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) { p.Storage = storage })
+	if p.ballot != b || p.slot != 2 {
+		t.Errorf("expected ballot %v and slot 2 recovered, got %v and %d", b, p.ballot, p.slot)
+	}
+}
+
+func BenchmarkWAL(b *testing.B) {
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("value"), ClientID: "1.1", CommandID: 1}
+	for _, batch := range []int{1, 16, 256} {
+		b.Run(strconv.Itoa(batch), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "wal")
+			storage, err := NewFileStorage(path)
+			if batch > 1 {
+				storage, err = NewGroupFileStorage(path)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			n := newNode(paxi.NewID(1, 2))
+			p := NewPaxos(n, func(p *Paxos) {
+				p.Storage = storage
+				p.SyncBatch = batch
+				p.SyncInterval = time.Hour
+			})
+			ballot := paxi.NewBallot(1, paxi.NewID(1, 1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.HandleP2a(P2a{Ballot: ballot, Slot: i, Commands: []paxi.Command{cmd}})
+				n.sent = n.sent[:0]
+			}
+		})
+	}
+}
+
+func TestSessionRead(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("a")}}, Commit: 1})
+
+	// client wrote slot 1 at the leader, which is not executed here yet
+	p.HandleSessionRead(paxi.Request{Command: paxi.Command{Key: 1}, Session: 2})
+	p.HandleSessionRead(paxi.Request{Command: paxi.Command{Key: 1}, Session: 1})
+	if len(p.reads) != 1 || p.reads[0].index != 1 {
+		t.Fatalf("expected only read of session 2 waiting, reads %d", len(p.reads))
+	}
+	p.HandleP2a(P2a{Ballot: b, Slot: 1, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("b")}}, Commit: 2})
+	if p.execute != 2 || len(p.reads) != 0 {
+		t.Errorf("expected session read served after its write executed, execute %d reads %d", p.execute, len(p.reads))
+	}
+}
+
+func TestProposeAt(t *testing.T) {
+	old := NewPaxos(newNode(paxi.NewID(1, 1)))
+	old.P1a()
+	old.HandleP1b(P1b{Ballot: old.ballot, ID: paxi.NewID(1, 3)})
+	a := paxi.Command{Key: 1, Value: paxi.Value("a")}
+	b := paxi.Command{Key: 2, Value: paxi.Value("b")}
+
+	// acceptor sees slot 2 before slot 0 and never slot 1
+	n := newNode(paxi.NewID(1, 3))
+	f := NewPaxos(n)
+	f.HandleP2a(old.proposeAt(2, b))
+	f.HandleP2a(old.proposeAt(0, a))
+	if old.slot != 2 || f.slot != 2 || f.log[1] != nil {
+		t.Fatalf("expected hole at slot 1, leader slot %d acceptor slot %d", old.slot, f.slot)
+	}
+
+	// new leader recovers both slots and fills the hole
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+	p.P1a()
+	ballot := p.ballot
+	n.sent = nil
+	f.HandleP1a(P1a{Ballot: ballot})
+	p.HandleP1b(n.sent[0].(P1b))
+	if !p.active || p.log[0] == nil || p.log[2] == nil {
+		t.Fatalf("expected new leader %v active with slots 0 and 2, got %v", ballot, n.sent)
+	}
+	if !equal(p.log[0].commands, []paxi.Command{a}) || !equal(p.log[2].commands, []paxi.Command{b}) {
+		t.Errorf("expected slots 0 and 2 recovered, got %v and %v", p.log[0].commands, p.log[2].commands)
+	}
+	if !equal(p.log[1].commands, []paxi.Command{paxi.NoOp()}) {
+		t.Errorf("expected hole filled with no-op, got %v", p.log[1].commands)
+	}
+
+	// old leader proposing in the same slot with lower ballot is rejected
+	n.sent = nil
+	f.HandleP2a(old.proposeAt(1, a))
+	if m, ok := n.sent[0].(P2b); !ok || m.Ballot != ballot || f.log[1] != nil {
+		t.Errorf("expected stale proposal rejected with ballot %v, sent %v", ballot, n.sent)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Clock = c
+		p.ForwardTimeout = time.Second
+	})
+	leader := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleHeartbeat(Heartbeat{Ballot: leader})
+	put := func(i int) paxi.Request {
+		return paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.3", CommandID: i}}
+	}
+
+	// forwarded request waits while leader is alive and is done once executed
+	p.Proxy(put(1))
+	if len(n.forwarded) != 1 || len(p.proxied) != 1 || p.ballot != leader {
+		t.Fatalf("expected request forwarded to %s without phase 1, ballot %v", leader.ID(), p.ballot)
+	}
+	c.now = c.now.Add(time.Second / 2)
+	n.fire()
+	if len(p.proxied) != 1 || len(n.timers) != 1 {
+		t.Fatalf("expected forward to keep waiting for live leader, proxied %d timers %d", len(p.proxied), len(n.timers))
+	}
+	p.HandleP2a(P2a{Ballot: leader, Slot: 0, Commands: []paxi.Command{put(1).Command}, Commit: 1})
+	if len(p.proxied) != 0 {
+		t.Fatalf("expected executed request no longer proxied, proxied %d", len(p.proxied))
+	}
+
+	// silent leader, request falls back to phase 1 on this node
+	p.Proxy(put(2))
+	c.now = c.now.Add(2 * time.Second)
+	n.fire()
+	n.fire()
+	if len(p.proxied) != 0 || p.ballot.ID() != p.ID() || len(p.requests) != 1 {
+		t.Errorf("expected request held for own phase 1 after forward timeout, ballot %v requests %d", p.ballot, len(p.requests))
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 1)), func(p *Paxos) { p.Coalesce = true })
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 2)})
+	put := func(client paxi.ID, v string) *paxi.Request {
+		return &paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value(v), ClientID: client, CommandID: 1}}
+	}
+
+	// identical write of another client joins the slot in flight, different value does not
+	p.P2a(put("1.3", "a"))
+	p.P2a(put("1.4", "a"), put("1.4", "b"))
+	if p.slot != 1 || len(p.log[0].requests) != 2 || len(p.log[1].commands) != 1 {
+		t.Fatalf("expected second request attached to slot 0, slot %d", p.slot)
+	}
+	if m := p.Metrics(); m.Coalesced != 1 {
+		t.Errorf("expected 1 coalesced request, got %d", m.Coalesced)
+	}
+	p.P2a(&paxi.Request{Command: paxi.Command{Key: 1}})
+	if p.slot != 2 {
+		t.Errorf("expected read proposed in its own slot, slot %d", p.slot)
+	}
+
+	// executed command is no longer in flight
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: paxi.NewID(1, 2), Slot: 0})
+	if p.execute != 1 || len(p.log[0].requests) != 0 || len(p.contents) != 1 {
+		t.Fatalf("expected slot 0 executed and forgotten, execute %d contents %d", p.execute, len(p.contents))
+	}
+	p.P2a(put("1.5", "a"))
+	if p.slot != 3 {
+		t.Errorf("expected identical write after execution proposed again, slot %d", p.slot)
+	}
+
+	// write of the same value with a different ttl keeps its own slot
+	p.P2a(&paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("a"), ClientID: "1.6", CommandID: 1, TTL: 5}})
+	if p.slot != 4 || len(p.log[3].requests) != 1 || p.log[4].commands[0].TTL != 5 {
+		t.Errorf("expected write with ttl proposed in its own slot, slot %d", p.slot)
+	}
+}
+
+func TestShadowMode(t *testing.T) {
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n, func(p *Paxos) { p.ShadowMode = true })
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("a")}}})
+	if len(n.sent) != 1 {
+		t.Fatalf("expected shadow node to accept P2a, sent %v", n.sent)
+	}
+	p.HandleP2a(P2a{Ballot: b, Slot: 1, Commands: []paxi.Command{{Key: 2, Value: paxi.Value("b")}}, Commit: 2})
+	if p.execute != 2 {
+		t.Fatalf("expected execute advanced to 2, got %d", p.execute)
+	}
+	if v := p.Get(1); v != nil {
+		t.Errorf("expected state machine untouched in shadow mode, key 1 is %q", v)
+	}
+}
+
+// counter is a state machine that counts applied writes, except writes of value "reject" that it rejects
+type counter struct{ n int }
+
+func (c *counter) Apply(cmd paxi.Command) (paxi.Value, error) {
+	if string(cmd.Value) == "reject" {
+		return nil, errors.New("rejected")
+	}
+	if !cmd.IsRead() {
+		c.n++
+	}
+	return paxi.Value(strconv.Itoa(c.n)), nil
+}
+func (c *counter) Snapshot() paxi.Value     { return paxi.Value(strconv.Itoa(c.n)) }
+func (c *counter) Restore(state paxi.Value) { c.n, _ = strconv.Atoi(string(state)) }
+
+func TestStateMachine(t *testing.T) {
+	sm := new(counter)
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n, func(p *Paxos) { p.StateMachine = sm })
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("a")}, {Key: 2, Value: paxi.Value("b")}}, Commit: 1})
+	if sm.n != 2 || p.Get(1) != nil {
+		t.Fatalf("expected commands applied to plugged state machine only, count %d", sm.n)
+	}
+
+	n.sent = nil
+	p.HandleSnapshotRequest(SnapshotRequest{ID: paxi.NewID(1, 3)})
+	if len(n.sent) != 1 || string(n.sent[0].(SnapshotReply).State) != "2" {
+		t.Fatalf("expected snapshot of state machine, sent %v", n.sent)
+	}
+	m := n.sent[0].(SnapshotReply)
+	m.Slot = 5
+	sm.n = 0
+	p.InstallSnapshot(m)
+	if sm.n != 2 {
+		t.Errorf("expected state machine restored to 2, got %d", sm.n)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) { p.Dedup = true })
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	// two clients race to take the same lock, the one ordered first in the log wins
+	for s, c := range []paxi.ID{paxi.NewID(9, 1), paxi.NewID(9, 2)} {
+		cmd := paxi.CompareAndSwap(1, nil, paxi.Value(c))
+		cmd.ClientID, cmd.CommandID = c, 1
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+	if p.execute != 2 || string(p.Get(1)) != string(paxi.NewID(9, 1)) {
+		t.Fatalf("expected lock held by first client, execute %d, value %q", p.execute, p.Get(1))
+	}
+	if _, status, _ := p.duplicate(paxi.Command{Key: 1, ClientID: paxi.NewID(9, 1), CommandID: 1}); status != "" {
+		t.Errorf("expected first swap applied, got %q", status)
+	}
+	v, status, _ := p.duplicate(paxi.Command{Key: 1, ClientID: paxi.NewID(9, 2), CommandID: 1})
+	if status != paxi.ErrCompareFailed.Error() || string(v) != string(paxi.NewID(9, 1)) {
+		t.Errorf("expected second swap rejected with current value, got %q %q", v, status)
+	}
+}
+
+func TestCommittedOverwrite(t *testing.T) {
+	violations := 0
+	defer func(f func(string, ...interface{})) { violation = f }(violation)
+	violation = func(string, ...interface{}) { violations++ }
+
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+	a := []paxi.Command{{Key: 1, Value: paxi.Value("a")}}
+	b := []paxi.Command{{Key: 1, Value: paxi.Value("b")}}
+	old := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleP2a(P2a{Ballot: old, Slot: 0, Commands: a})
+	p.HandleP3(P3{Ballot: old, Slot: 0, Commands: a})
+	p.HandleP3(P3{Ballot: old, Slot: 0, Commands: a})
+	if !p.log[0].commit || violations != 0 {
+		t.Fatalf("expected slot 0 committed without violation, got %d", violations)
+	}
+
+	// stale proposal of a lower ballot that lost the slot is not a violation
+	p.HandleP2a(P2a{Ballot: paxi.NewBallot(0, paxi.NewID(1, 3)), Slot: 0, Commands: b})
+	if violations != 0 {
+		t.Errorf("expected stale P2a ignored, got %d violations", violations)
+	}
+
+	p.HandleP2a(P2a{Ballot: paxi.NewBallot(2, paxi.NewID(1, 3)), Slot: 0, Commands: b})
+	p.HandleP3(P3{Ballot: paxi.NewBallot(2, paxi.NewID(1, 3)), Slot: 0, Commands: b})
+	p.accept(0, &entry{commands: b})
+	if violations != 3 {
+		t.Errorf("expected every overwrite of committed slot reported, got %d", violations)
+	}
+	if !equal(p.log[0].commands, a) {
+		t.Errorf("expected committed commands kept, got %v", p.log[0].commands)
+	}
+}
+
+func TestLearner(t *testing.T) {
+	n := newNode(paxi.NewID(1, 4))
+	p := NewPaxos(n, func(p *Paxos) { p.Learner = true })
+	p.P1a()
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 2, Value: paxi.Value("w")}})
+	if len(n.sent) != 0 || p.ballot != 0 || len(p.requests) != 1 {
+		t.Fatalf("expected learner to hold request without phase 1, sent %v", n.sent)
+	}
+
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleP1a(P1a{Ballot: b})
+	if len(n.sent) != 0 || p.ballot != b {
+		t.Errorf("expected learner to follow ballot without P1b, sent %v", n.sent)
+	}
+	if len(n.forwarded) != 1 || len(p.requests) != 0 {
+		t.Errorf("expected held request forwarded to leader, forwarded %v", n.forwarded)
+	}
+
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("a")}
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}})
+	if len(n.sent) != 0 || p.log[0] != nil {
+		t.Errorf("expected learner not to accept, sent %v", n.sent)
+	}
+	p.HandleP3(P3{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}})
+	if p.execute != 1 || string(p.Get(1)) != "a" {
+		t.Errorf("expected learner to execute committed slot, execute %d", p.execute)
+	}
+	if !p.status().Learner {
+		t.Error("expected learner role in status")
+	}
+}
+
+func TestObserver(t *testing.T) {
+	id := paxi.NewID(1, 4)
+	n := newNode(id)
+	p := NewPaxos(n, func(p *Paxos) { p.Learner = true })
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	cmd := func(i int) paxi.Command {
+		return paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}
+	}
+
+	p.HandleP1a(P1a{Ballot: b})
+	p.HandleP3(P3{Ballot: b, Slot: 2, Commands: []paxi.Command{cmd(2)}})
+	if p.CaughtUp() || p.Promote() != ErrNotCaughtUp {
+		t.Fatal("expected observer with missing slots not caught up")
+	}
+	p.HandleP3(P3{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd(0)}})
+	p.HandleP3(P3{Ballot: b, Slot: 1, Commands: []paxi.Command{cmd(1)}})
+	if !p.CaughtUp() || !p.status().CaughtUp {
+		t.Fatalf("expected observer caught up after executing every slot, execute %d", p.execute)
+	}
+
+	if err := p.Promote(); err != nil || len(n.forwarded) != 1 {
+		t.Fatalf("expected reconfiguration forwarded to leader, forwarded %v: %v", n.forwarded, err)
+	}
+	reconfig := n.forwarded[0].Command
+	ids := members(reconfig)
+	if !IsReconfig(reconfig) || ids[len(ids)-1] != id {
+		t.Fatalf("expected membership with observer added, got %v", reconfig)
+	}
+
+	// observer votes once reconfiguration executes
+	p.HandleP3(P3{Ballot: b, Slot: 3, Commands: []paxi.Command{reconfig}})
+	if p.Learner || p.Promote() != ErrNotObserver {
+		t.Fatal("expected observer promoted to voter")
+	}
+	n.sent = nil
+	p.HandleP1a(P1a{Ballot: paxi.NewBallot(2, paxi.NewID(1, 1))})
+	if len(n.sent) != 1 {
+		t.Fatalf("expected promoted node to reply P1b, sent %v", n.sent)
+	}
+	if _, ok := n.sent[0].(P1b); !ok {
+		t.Errorf("expected P1b, sent %v", n.sent[0])
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	storage, err := NewFileStorage(filepath.Join(t.TempDir(), "wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make([]Change, 0)
+	subscribe := func(from int) func(*Paxos) {
+		return func(p *Paxos) {
+			p.Storage = storage
+			p.Dedup = true
+			p.Subscribe(from, func(c Change) { changes = append(changes, c) })
+		}
+	}
+
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	id := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id), subscribe(0))
+	p.HandleP1a(P1a{Ballot: b})
+	commit := func(s int, cmd paxi.Command) {
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+	commit(0, paxi.Command{Key: 1, Value: paxi.Value("a"), ClientID: "1.1", CommandID: 1})
+	commit(1, paxi.NoOp())
+	commit(2, paxi.Command{Key: 1})
+	commit(3, paxi.Command{Key: 1, Value: paxi.Value("a"), ClientID: "1.1", CommandID: 1})
+	commit(4, paxi.CompareAndSwap(1, paxi.Value("x"), paxi.Value("b")))
+	if len(changes) != 2 || changes[0].Slot != 0 || changes[1].Slot != 4 {
+		t.Fatalf("expected changes of slot 0 and 4 without no-op, read and duplicate, got %v", changes)
+	}
+	if changes[1].Status == "" {
+		t.Errorf("expected rejected compare-and-swap in change, got %v", changes[1])
+	}
+
+	// restarted subscriber resumes after the last change it processed
+	changes = changes[:0]
+	p = NewPaxos(newNode(id), subscribe(1))
+	commit(5, paxi.Command{Key: 2, Value: paxi.Value("c"), ClientID: "1.1", CommandID: 2})
+	if len(changes) != 2 || changes[0].Slot != 4 || changes[1].Slot != 5 {
+		t.Errorf("expected replayed slot 4 and new slot 5, got %v", changes)
+	}
+}
+
+func TestSubscribeExecutors(t *testing.T) {
+	n := newNode(paxi.NewID(1, 2))
+	changes := make([]Change, 0)
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Executors = 2
+		p.Subscribe(0, func(c Change) { changes = append(changes, c) })
+	})
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleP1a(P1a{Ballot: b})
+	for s := 0; s < 4; s++ {
+		cmd := paxi.Command{Key: 1, Value: paxi.Value(strconv.Itoa(s))}
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+	p.quiesce()
+
+	// scheduled deliveries run in reverse, changes of the key still arrive in slot order
+	timers := n.timers
+	n.timers = nil
+	for i := len(timers) - 1; i >= 0; i-- {
+		timers[i]()
+	}
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes, got %v", changes)
+	}
+	for s, c := range changes {
+		if c.Slot != s {
+			t.Errorf("expected change of slot %d, got %v", s, c)
+		}
+	}
+}
+
+func TestRejoin(t *testing.T) {
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n, func(p *Paxos) { p.Rejoin = true })
+	leader := paxi.NewBallot(1, paxi.NewID(1, 1))
+	// isolated node raises its ballot over current leader with unanswered phase 1
+	for i := 0; i < 3; i++ {
+		p.P1a()
+	}
+	p.stale()
+
+	// lower ballot of the cluster is not preempted but starts rejoin
+	n.sent = nil
+	a := paxi.Command{Key: 1, Value: paxi.Value("a")}
+	p.HandleP2a(P2a{Ballot: leader, Slot: 2, Commands: []paxi.Command{a}, Commit: 2})
+	if len(n.sent) != 1 || p.election != rejoining {
+		t.Fatalf("expected only rejoin broadcast, sent %v", n.sent)
+	}
+	if _, ok := n.sent[0].(Rejoin); !ok {
+		t.Fatalf("expected Rejoin, sent %v", n.sent[0])
+	}
+	n.sent = nil
+	p.P1a()
+	if len(n.sent) != 0 {
+		t.Fatalf("expected phase 1 gated while rejoining, sent %v", n.sent)
+	}
+
+	// adopt ballot of the quorum and repair missing slots from the most up to date node
+	p.HandleRejoinAck(RejoinAck{ID: paxi.NewID(1, 3), Ballot: leader, Execute: 2})
+	if p.ballot != leader || !p.rejoin.stale || len(n.sent) != 1 {
+		t.Fatalf("expected ballot %v adopted and repair requested, ballot %v sent %v", leader, p.ballot, n.sent)
+	}
+	if m, ok := n.sent[0].(RepairRequest); !ok || m.Slot != 0 || p.rejoin.source != paxi.NewID(1, 3) {
+		t.Fatalf("expected repair of slot 0 from 1.3, sent %v", n.sent[0])
+	}
+	p.HandleRepairReply(RepairReply{Slot: 0, CommandBallot: CommandBallot{[]paxi.Command{a}, leader}, Commit: true})
+	p.HandleRepairReply(RepairReply{Slot: 1, CommandBallot: CommandBallot{[]paxi.Command{a}, leader}, Commit: true})
+	if p.execute != 2 || p.rejoin.stale {
+		t.Errorf("expected rejoin done after catching up to execute 2, execute %d", p.execute)
+	}
+}
+
+func TestRecoveredRequest(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+	old := paxi.NewBallot(1, paxi.NewID(1, 1))
+	a := paxi.Command{Key: 1, Value: paxi.Value("a"), ClientID: "1.4", CommandID: 1}
+	b := paxi.Command{Key: 2, Value: paxi.Value("b"), ClientID: "1.4", CommandID: 2}
+
+	// client retries a at new leader after old leader proposed it in slot 0
+	p.hold(&paxi.Request{Command: a})
+	p.hold(&paxi.Request{Command: b})
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 3), Log: map[int]CommandBallot{0: {[]paxi.Command{a}, old}}})
+	if !p.active || p.slot != 1 || len(p.requests) != 0 {
+		t.Fatalf("expected only b proposed in a new slot, slot %d pending %d", p.slot, len(p.requests))
+	}
+	if !equal(p.log[0].commands, []paxi.Command{a}) || len(p.log[0].requests) != 1 || p.log[0].requests[0] == nil {
+		t.Errorf("expected pending request of a attached to recovered slot 0, requests %v", p.log[0].requests)
+	}
+	if !equal(p.log[1].commands, []paxi.Command{b}) {
+		t.Errorf("expected b in slot 1, got %v", p.log[1].commands)
+	}
+}
+
+func TestRejectedCommand(t *testing.T) {
+	sm := new(counter)
+	p := NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) {
+		p.StateMachine = sm
+		p.Dedup = true
+	})
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	for s, v := range []string{"a", "reject", "b"} {
+		cmd := paxi.Command{Key: 1, Value: paxi.Value(v), ClientID: paxi.NewID(9, s), CommandID: 1}
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+	if p.execute != 3 || sm.n != 2 {
+		t.Fatalf("expected rejected slot executed without effect, execute %d, applied %d", p.execute, sm.n)
+	}
+	if _, status, duplicate := p.duplicate(paxi.Command{Key: 1, ClientID: paxi.NewID(9, 1), CommandID: 1}); !duplicate || status != "rejected" {
+		t.Errorf("expected retry of rejected command replied with its error, got %q", status)
+	}
+	if _, status, _ := p.duplicate(paxi.Command{Key: 1, ClientID: paxi.NewID(9, 2), CommandID: 1}); status != "" {
+		t.Errorf("expected no error of applied command, got %q", status)
+	}
+}
+
+// gated is a state machine whose Apply waits for gate to open
+type gated struct {
+	gate    chan struct{}
+	applied []paxi.Command
+}
+
+func (g *gated) Apply(cmd paxi.Command) (paxi.Value, error) {
+	<-g.gate
+	g.applied = append(g.applied, cmd)
+	return nil, nil
+}
+func (g *gated) Snapshot() paxi.Value { return paxi.Value(strconv.Itoa(len(g.applied))) }
+func (g *gated) Restore(paxi.Value)   {}
+
+func TestExecutor(t *testing.T) {
+	sm := &gated{gate: make(chan struct{})}
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n, func(p *Paxos) {
+		p.StateMachine = sm
+		p.Executors = 1
+	})
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	a := paxi.Command{Key: 1, Value: paxi.Value("a")}
+	c := paxi.Command{Key: 1, Value: paxi.Value("c")}
+
+	// slow state machine does not block commit and execute
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{a}})
+	p.HandleP2a(P2a{Ballot: b, Slot: 1, Commands: []paxi.Command{c}, Commit: 2})
+	if p.execute != 2 {
+		t.Fatalf("expected execute 2 while state machine is blocked, got %d", p.execute)
+	}
+	close(sm.gate)
+
+	// snapshot waits for every handed command
+	n.sent = nil
+	p.HandleSnapshotRequest(SnapshotRequest{ID: paxi.NewID(1, 3)})
+	if len(n.sent) != 1 || string(n.sent[0].(SnapshotReply).State) != "2" {
+		t.Fatalf("expected snapshot after 2 applied commands, sent %v", n.sent)
+	}
+	if !equal(sm.applied, []paxi.Command{a, c}) {
+		t.Errorf("expected commands applied in slot order, got %v", sm.applied)
+	}
+}
+
+func TestBarrierRead(t *testing.T) {
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n)
+	leader := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleHeartbeat(Heartbeat{Ballot: leader})
+
+	// follower asks the leader for its highest slot
+	n.sent = nil
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1}, Barrier: true})
+	if len(n.sent) != 1 || len(p.waits) != 1 {
+		t.Fatalf("expected LeaderSlot sent, sent %v", n.sent)
+	}
+	m, ok := n.sent[0].(LeaderSlot)
+	if !ok {
+		t.Fatalf("expected LeaderSlot, sent %v", n.sent[0])
+	}
+	p.HandleLeaderSlotAck(LeaderSlotAck{Seq: m.Seq, Slot: 1})
+	if len(p.waits) != 0 || len(p.reads) != 1 || p.reads[0].index != 1 {
+		t.Fatalf("expected read waiting for slot 1, reads %d", len(p.reads))
+	}
+
+	// read is served only after every slot of the leader is executed
+	p.HandleP2a(P2a{Ballot: leader, Slot: 0, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("a")}}, Commit: 1})
+	if len(p.reads) != 1 {
+		t.Fatalf("expected read still waiting at execute %d", p.execute)
+	}
+	p.HandleP2a(P2a{Ballot: leader, Slot: 1, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("b")}}, Commit: 2})
+	if len(p.reads) != 0 {
+		t.Errorf("expected read served after execute reaches 2, execute %d", p.execute)
+	}
+}
+
+func TestElectionMetrics(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	p := NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) { p.Clock = c })
+
+	// first attempt is preempted by a concurrent candidate, second one wins
+	p.P1a()
+	c.now = c.now.Add(10 * time.Millisecond)
+	p.HandleP1b(P1b{Ballot: paxi.NewBallot(5, paxi.NewID(1, 3)), ID: paxi.NewID(1, 3)})
+	c.now = c.now.Add(20 * time.Millisecond)
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 1)})
+	if !p.active {
+		t.Fatal("expected second attempt to win")
+	}
+	m := p.Metrics()
+	if m.Elections != 1 || m.Failures != 1 || m.Attempts != 2 {
+		t.Errorf("expected 1 election after 1 failed attempt, elections %d failures %d attempts %d", m.Elections, m.Failures, m.Attempts)
+	}
+	if m.LastElection != 30*time.Millisecond || p.status().Election != 30*time.Millisecond {
+		t.Errorf("expected election of 30ms from first P1a, got %v", m.LastElection)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	p := NewPaxos(newNode(paxi.NewID(1, 1)), func(p *Paxos) {
+		p.Clock = c
+		p.FanOut = FanOutRTT
+	})
+	ids := []paxi.ID{paxi.NewID(1, 1), paxi.NewID(1, 2), paxi.NewID(1, 3), paxi.NewID(2, 1), paxi.NewID(2, 2)}
+	p.memberships = []membership{{slot: 0, ids: ids}}
+	order := func(want ...paxi.ID) {
+		t.Helper()
+		got := p.nearest(0)
+		if len(got) != len(want) {
+			t.Fatalf("expected order %v, got %v", want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("expected order %v, got %v", want, got)
+			}
+		}
+	}
+
+	// nothing measured yet, closer zone first
+	order(ids[1], ids[2], ids[3], ids[4])
+
+	// synthetic latency of P2b from a remote zone puts it first
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[1]})
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[2]})
+	p.P2a(&paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v")}})
+	c.now = c.now.Add(4 * time.Millisecond)
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[3], Slot: 0})
+	if p.rtt[ids[3]] != 4*time.Millisecond {
+		t.Fatalf("expected rtt of 4ms to %s, got %v", ids[3], p.rtt[ids[3]])
+	}
+	order(ids[3], ids[1], ids[2], ids[4])
+
+	p.sample(ids[2], 2*time.Millisecond)
+	p.sample(ids[4], 10*time.Millisecond)
+	order(ids[2], ids[3], ids[4], ids[1])
+	if peers := p.peers(0); len(peers) != 2 || peers[0] != ids[2] || peers[1] != ids[3] {
+		t.Errorf("expected thrifty quorum of the two nearest peers, got %v", peers)
+	}
+	p.sample(ids[2], 34*time.Millisecond)
+	if p.rtt[ids[2]] != 6*time.Millisecond {
+		t.Errorf("expected smoothed rtt of 6ms, got %v", p.rtt[ids[2]])
+	}
+}
+
+func TestPriority(t *testing.T) {
+	priority := map[paxi.ID]int{paxi.NewID(1, 1): 2, paxi.NewID(1, 2): 1}
+	c := &clock{now: time.Unix(0, 0)}
+	start := func(id paxi.ID) (*node, *Paxos) {
+		n := newNode(id)
+		return n, NewPaxos(n, func(p *Paxos) {
+			p.Clock = c
+			p.Rand = rand.New(rand.NewSource(1))
+			p.Priority = priority
+			p.Timeout = 100 * time.Millisecond
+			p.MaxTimeout = 300 * time.Millisecond
+		})
+	}
+	p1a := func(n *node) bool {
+		for _, m := range n.sent {
+			if _, ok := m.(P1a); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	// less preferred node times out after every preferred one
+	_, high := start(paxi.NewID(1, 1))
+	_, low := start(paxi.NewID(1, 3))
+	if high.timeout > high.MaxTimeout {
+		t.Errorf("preferred node draws timeout %v above %v", high.timeout, high.MaxTimeout)
+	}
+	if low.timeout < high.Timeout+2*high.MaxTimeout {
+		t.Errorf("node below 2 preferred nodes draws timeout %v", low.timeout)
+	}
+	base := time.Duration(paxi.GetConfig().BackOff) * time.Millisecond
+	low.attempt = 1
+	if d := low.backoff(); d < 3*base {
+		t.Errorf("node below 2 preferred nodes backs off %v", d)
+	}
+
+	// tie-break of duel goes to priority before id
+	high.rival = low.ID()
+	low.rival = high.ID()
+	if !high.preferred() || low.preferred() {
+		t.Errorf("expected %s preferred over %s", high.ID(), low.ID())
+	}
+
+	// follower yields to live preferred leader and forwards request to it
+	n, p := start(paxi.NewID(1, 2))
+	b := paxi.NewBallot(1, high.ID())
+	p.HandleP2a(P2a{Ballot: b, Slot: 0})
+	if m := p.Metrics(); m.Priority != 2 {
+		t.Errorf("expected leader priority 2 in metrics, got %d", m.Priority)
+	}
+	r := paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}}
+	p.HandleRequest(r)
+	if p1a(n) || len(n.forwarded) != 1 {
+		t.Fatalf("expected request forwarded to live preferred leader, sent %v, forwarded %v", n.sent, n.forwarded)
+	}
+
+	// silent leader is challenged
+	c.now = c.now.Add(p.Timeout)
+	r.Command.CommandID = 2
+	p.HandleRequest(r)
+	if !p1a(n) {
+		t.Errorf("expected phase 1 against silent leader, sent %v", n.sent)
+	}
+
+	// live leader of lower priority is challenged
+	n, p = start(paxi.NewID(1, 2))
+	p.HandleP2a(P2a{Ballot: paxi.NewBallot(1, low.ID()), Slot: 0})
+	p.HandleRequest(r)
+	if !p1a(n) || len(n.forwarded) != 0 {
+		t.Errorf("expected phase 1 against less preferred leader, sent %v, forwarded %v", n.sent, n.forwarded)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	c := &clock{now: time.Unix(100, 0)}
+	ids := []paxi.ID{paxi.NewID(1, 1), paxi.NewID(1, 2), paxi.NewID(1, 3)}
+	spans := make(map[string]time.Duration)
+	tracing := func(p *Paxos) {
+		p.Clock = c
+		p.Tracing = true
+		p.Tracer = func(s paxi.Span) {
+			if s.TraceID != "t1" || s.Attributes["node"] != string(p.ID()) {
+				t.Errorf("unexpected span %+v", s)
+			}
+			spans[s.Name] = s.End.Sub(s.Start)
+		}
+	}
+
+	n := newNode(ids[0])
+	p := NewPaxos(n, tracing)
+	p.memberships = []membership{{slot: 0, ids: ids}}
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[1]})
+	r := paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}, TraceID: "t1"}
+	r.Timestamp = c.now.UnixNano()
+	c.now = c.now.Add(5 * time.Millisecond)
+	p.HandleRequest(r)
+	if m, ok := n.sent[len(n.sent)-1].(P2a); !ok || m.TraceID != "t1" {
+		t.Fatalf("expected P2a carrying trace, sent %v", n.sent)
+	}
+	c.now = c.now.Add(3 * time.Millisecond)
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: ids[1], Slot: 0, TraceID: "t1"})
+	if m, ok := n.sent[len(n.sent)-1].(P3); !ok || m.TraceID != "t1" {
+		t.Fatalf("expected P3 carrying trace, sent %v", n.sent)
+	}
+	expected := map[string]time.Duration{"queue": 5 * time.Millisecond, "ack": 3 * time.Millisecond, "commit": 3 * time.Millisecond, "execute": 0}
+	if !reflect.DeepEqual(spans, expected) {
+		t.Errorf("expected leader spans %v, got %v", expected, spans)
+	}
+
+	// follower
+	spans = make(map[string]time.Duration)
+	n = newNode(ids[1])
+	p = NewPaxos(n, tracing)
+	b := paxi.NewBallot(1, ids[0])
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{r.Command}, TraceID: "t1"})
+	if m, ok := n.sent[len(n.sent)-1].(P2b); !ok || m.TraceID != "t1" {
+		t.Fatalf("expected P2b carrying trace, sent %v", n.sent)
+	}
+	c.now = c.now.Add(time.Millisecond)
+	p.HandleP3(P3{Ballot: b, Slot: 0, Commands: []paxi.Command{r.Command}})
+	if _, exists := spans["accept"]; !exists || len(spans) != 2 || p.execute != 1 {
+		t.Errorf("expected accept and execute spans on follower, got %v", spans)
+	}
+
+	// untraced when tracing is disabled
+	spans = make(map[string]time.Duration)
+	n = newNode(ids[2])
+	p = NewPaxos(n, tracing, func(p *Paxos) { p.Tracing = false })
+	p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{r.Command}, TraceID: "t1"})
+	p.HandleP3(P3{Ballot: b, Slot: 0, Commands: []paxi.Command{r.Command}})
+	if len(spans) != 0 {
+		t.Errorf("expected no span without tracing, got %v", spans)
+	}
+}
+
+// queued is a node with a queue of messages from peers
+type queued struct{ *node }
+
+func (queued) Depth() int   { return 3 }
+func (queued) Dropped() int { return 2 }
+
+func TestQueueMetrics(t *testing.T) {
+	p := NewPaxos(queued{newNode(paxi.NewID(1, 1))})
+	if m := p.Metrics(); m.Queued != 3 || m.Dropped != 2 {
+		t.Errorf("expected 3 queued and 2 dropped messages in metrics, got %d and %d", m.Queued, m.Dropped)
+	}
+	var b bytes.Buffer
+	p.WritePrometheus(&b)
+	if !strings.Contains(b.String(), `paxos_queued_messages{id="1.1"} 3`) {
+		t.Errorf("expected queue depth in prometheus metrics, got %s", b.String())
+	}
+}
+
+func TestQuorumTimeout(t *testing.T) {
+	ids := []paxi.ID{paxi.NewID(1, 1), paxi.NewID(1, 2), paxi.NewID(1, 3)}
+	n := newNode(ids[0])
+	p := NewPaxos(n, func(p *Paxos) {
+		p.QuorumTimeout = 10 * time.Millisecond
+		p.QuorumRetries = 1
+	})
+	p.memberships = []membership{{slot: 0, ids: ids}}
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[1]})
+	b := p.ballot
+	count := func(m interface{}) int {
+		c := 0
+		for _, s := range n.sent {
+			if reflect.TypeOf(s) == reflect.TypeOf(m) {
+				c++
+			}
+		}
+		return c
+	}
+
+	// committed slot needs no retry
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}})
+	p.HandleP2b(P2b{Ballot: b, ID: ids[1], Slot: 0})
+	n.fire()
+	if count(P2a{}) != 1 {
+		t.Fatalf("expected no retry of committed slot, sent %v", n.sent)
+	}
+
+	// slot without quorum is retried, then leader starts phase 1
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 2, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 2}})
+	n.fire()
+	if count(P2a{}) != 3 || !p.active {
+		t.Fatalf("expected P2a of stalled slot broadcast again, sent %v", n.sent)
+	}
+	n.fire()
+	if p.active || p.ballot <= b || count(P1a{}) != 2 {
+		t.Fatalf("expected leader to start phase 1 after retries, active %t ballot %v", p.active, p.ballot)
+	}
+
+	// new phase 1 proposes the stalled slot again
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: ids[2]})
+	if m := n.sent[len(n.sent)-1].(P2a); m.Slot != 1 || m.Ballot != p.ballot {
+		t.Errorf("expected slot 1 proposed in new ballot, got %v", m)
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+	p.MaxBacklog = 2
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	cmd := []paxi.Command{{Key: 1, Value: paxi.Value("v")}}
+
+	// slot 0 is missing, execution is blocked behind it
+	p.HandleP3(P3{Ballot: b, Slot: 2, Commands: cmd})
+	if n := p.Backlog(); n != 3 {
+		t.Fatalf("expected backlog of 3 slots, got %d", n)
+	}
+	if !p.stuck || p.Metrics().Backlog != 3 || p.status().Backlog != 3 {
+		t.Errorf("expected warned backlog of 3 in metrics and status, stuck %v metrics %d", p.stuck, p.Metrics().Backlog)
+	}
+
+	p.HandleP3(P3{Ballot: b, Slot: 0, Commands: cmd})
+	p.HandleP3(P3{Ballot: b, Slot: 1, Commands: cmd})
+	if p.execute != 3 || p.Backlog() != 0 || p.stuck || p.Metrics().Backlog != 0 {
+		t.Errorf("expected no backlog once executed, execute %d backlog %d", p.execute, p.Backlog())
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	id := paxi.NewID(1, 1)
+	p := NewPaxos(newNode(id), func(p *Paxos) {
+		p.AdaptiveBatch = true
+		p.batcher = batcher{min: 4, max: 4, maxInterval: 10 * time.Millisecond}
+	})
+	request := func(key, priority int) paxi.Request {
+		return paxi.Request{Command: paxi.Command{Key: paxi.Key(key), Value: paxi.Value("v")}, Priority: priority}
+	}
+
+	// pending requests are proposed by priority once leadership is acquired
+	p.HandleRequest(request(1, 0))
+	p.HandleRequest(request(2, 2))
+	p.HandleRequest(request(3, 1))
+	p.HandleRequest(request(4, 2))
+	p.HandleRequest(request(5, 0))
+	order := make([]paxi.Key, 0)
+	for _, r := range p.requests {
+		order = append(order, r.Command.Key)
+	}
+	if !reflect.DeepEqual(order, []paxi.Key{2, 4, 3, 1, 5}) {
+		t.Fatalf("expected pending requests ordered by priority, got %v", order)
+	}
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 2)})
+	if !p.active || len(p.log[0].commands) == 0 || p.log[0].commands[0].Key != 2 {
+		t.Fatalf("expected urgent request proposed first, got %v", p.log[0])
+	}
+
+	// urgent request flushes partial batch with itself first
+	p.HandleRequest(request(6, 0))
+	if len(p.batch) != 1 {
+		t.Fatalf("expected request waiting for batch, got %d", len(p.batch))
+	}
+	s := p.slot
+	p.HandleRequest(request(7, 1))
+	if p.slot != s+1 || len(p.batch) != 0 {
+		t.Fatalf("expected batch proposed right away, slot %d batch %d", p.slot, len(p.batch))
+	}
+	if c := p.log[p.slot].commands; len(c) != 2 || c[0].Key != 7 || c[1].Key != 6 {
+		t.Errorf("expected urgent command first in batch, got %v", c)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) { p.Dedup = true })
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	commit := func(s int, cmd paxi.Command) {
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+
+	// every replica assigns the same id to the same nonce
+	commit(0, NewRegister("", 3, "nonce"))
+	id := assign(paxi.Value("nonce"))
+	if id.Zone() != 0 || !p.sessions[id].Registered || p.sessions[id].CommandID != 3 {
+		t.Fatalf("expected registered id %s with baseline 3, got %v", id, p.sessions[id])
+	}
+	commit(1, NewRegister("", 1, "nonce"))
+	if len(p.sessions) != 1 || p.sessions[id].CommandID != 3 {
+		t.Errorf("expected retry to keep id %s and baseline 3, got %v", id, p.sessions)
+	}
+
+	// commands up to baseline are duplicates of commands executed before reconnect
+	commit(2, paxi.Command{Key: 1, Value: paxi.Value("old"), ClientID: id, CommandID: 3})
+	commit(3, paxi.Command{Key: 2, Value: paxi.Value("new"), ClientID: id, CommandID: 4})
+	if p.execute != 4 || p.sessions[id].CommandID != 4 || !p.sessions[id].Registered {
+		t.Fatalf("expected session of command 4 still registered, execute %d session %v", p.execute, p.sessions[id])
+	}
+	if v, _ := p.StateMachine.Apply(paxi.Command{Key: 1}); v != nil {
+		t.Errorf("expected command within baseline skipped, key 1 is %q", v)
+	}
+
+	// known client registers again with a higher baseline after reconnect
+	commit(4, NewRegister(id, 7, "other"))
+	if p.sessions[id].CommandID != 7 {
+		t.Errorf("expected baseline 7, got %d", p.sessions[id].CommandID)
+	}
+
+	// registered session outlives the log window
+	p.low = 10
+	p.expire()
+	if _, exists := p.sessions[id]; !exists {
+		t.Error("expected registered session kept past the log window")
+	}
+}
+
+func TestSessionWindow(t *testing.T) {
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p := NewPaxos(newNode(paxi.NewID(1, 2)), func(p *Paxos) {
+		p.Dedup = true
+		p.LogWindow = 1
+		p.SessionWindow = 4
+	})
+	commit := func(s int, c paxi.Command) {
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{c}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{c}})
+	}
+	commit(0, paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1})
+	for s := 1; s < 4; s++ {
+		commit(s, paxi.Command{Key: 2, Value: paxi.Value("w"), ClientID: "1.2", CommandID: s})
+	}
+	if _, exists := p.sessions["1.1"]; !exists || p.low == 0 {
+		t.Fatalf("expected session kept within session window past log window, low %d", p.low)
+	}
+
+	// slow client retries after its slot left the log window, retry is still a duplicate
+	commit(4, paxi.Command{Key: 1, Value: paxi.Value("retry"), ClientID: "1.1", CommandID: 1})
+	if v := p.Get(1); string(v) != "v" {
+		t.Errorf("expected retry within session window skipped, key 1 is %q", v)
+	}
+
+	// retry is no activity, the session expires once the client has been silent for the session window
+	if _, exists := p.sessions["1.1"]; exists {
+		t.Errorf("expected session of client silent for %d slots expired", p.SessionWindow)
+	}
+}
+
+// linked is a node with sending statistic of each peer
+type linked struct {
+	*node
+	links map[paxi.ID]paxi.Link
+}
+
+func (n linked) Links() map[paxi.ID]paxi.Link { return n.links }
+
+func TestSuspectedPeer(t *testing.T) {
+	id := paxi.NewID(2, 1)
+	near, far := paxi.NewID(2, 2), paxi.NewID(3, 1)
+	links := map[paxi.ID]paxi.Link{
+		near: {Sent: 5, Failed: 3, LastError: "broken pipe", Suspected: true},
+		far:  {Sent: 5},
+	}
+	p := NewPaxos(linked{newNode(id), links})
+	p.memberships = []membership{{slot: 0, ids: []paxi.ID{id, near, far}}}
+
+	if peers := p.peers(0); len(peers) != 1 || peers[0] != far {
+		t.Errorf("expected thrifty quorum routed around suspected peer, got %v", peers)
+	}
+	if s := p.status(); s.Links[near].Failed != 3 || s.Links[near].LastError != "broken pipe" {
+		t.Errorf("expected peer statistic in status, got %v", s.Links)
+	}
+	var b bytes.Buffer
+	p.WritePrometheus(&b)
+	for _, sample := range []string{
+		`paxos_peer_send_failures_total{id="2.1",peer="2.2"} 3`,
+		`paxos_peer_suspected{id="2.1",peer="2.2"} 1`,
+		`paxos_peer_sent_messages_total{id="2.1",peer="3.1"} 5`,
+	} {
+		if !strings.Contains(b.String(), sample) {
+			t.Errorf("expected %s in prometheus metrics, got %s", sample, b.String())
+		}
+	}
+
+	links[near] = paxi.Link{Sent: 6, Failed: 3}
+	if peers := p.peers(0); peers[0] != near {
+		t.Errorf("expected nearest peer once it recovers, got %v", peers)
+	}
+}
+
+func TestInstanceTuning(t *testing.T) {
+	peer := paxi.NewID(1, 3)
+	tuned := NewPaxos(newNode(paxi.NewID(1, 1)), func(p *Paxos) {
+		p.BatchSize = 3
+		p.LogWindow = 1
+	})
+	plain := NewPaxos(newNode(paxi.NewID(1, 2)))
+	if plain.BatchSize != paxi.GetConfig().BatchSize || plain.LogWindow != paxi.GetConfig().LogWindow {
+		t.Fatalf("expected defaults of global config, batch %d window %d", plain.BatchSize, plain.LogWindow)
+	}
+
+	for _, p := range []*Paxos{tuned, plain} {
+		for i := 0; i < 6; i++ {
+			p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v")}})
+		}
+		p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+		for s := 0; s <= p.slot; s++ {
+			p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: s})
+		}
+	}
+	if tuned.slot != 1 || plain.slot != 5 {
+		t.Errorf("expected pending requests drained in batches of each instance, slots %d and %d", tuned.slot, plain.slot)
+	}
+	if tuned.low != 1 || len(tuned.log) != 1 || plain.low != 0 || len(plain.log) != 6 {
+		t.Errorf("expected log window of each instance, low %d and %d", tuned.low, plain.low)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 1)), func(p *Paxos) {
+		p.BatchSize = 4
+		p.MaxMessageSize = messageOverhead + 2*(100+commandOverhead)
+	})
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 2)})
+	s := p.slot
+
+	// oversize request is rejected before it joins a batch
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 0, Value: make(paxi.Value, 1000)}})
+	for i := 1; i <= 4; i++ {
+		p.HandleRequest(paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: make(paxi.Value, 100), ClientID: "1.1", CommandID: i}})
+	}
+	if p.slot != s+2 || len(p.log[s+1].commands) != 2 || len(p.log[s+2].commands) != 2 {
+		t.Fatalf("expected batch of 4 split into 2 slots, slot %d", p.slot)
+	}
+	if p.log[s+1].commands[0].Key != 1 || p.log[s+2].commands[1].Key != 4 {
+		t.Errorf("expected split batches in request order, got %v %v", p.log[s+1].commands, p.log[s+2].commands)
+	}
+}
+
+// TestSplitReplies checks promises and snapshots larger than max message size are sent in parts
+func TestSplitReplies(t *testing.T) {
+	max := messageOverhead + 2*(100+2*commandOverhead)
+	withMax := func(p *Paxos) { p.MaxMessageSize = max }
+	peer := paxi.NewID(1, 2)
+	n := newNode(peer)
+	acceptor := NewPaxos(n, withMax, func(p *Paxos) { p.Dedup = true })
+	b := paxi.NewBallot(1, peer)
+	for s := 0; s < 5; s++ {
+		cmd := paxi.Command{Key: paxi.Key(s), Value: make(paxi.Value, 100), ClientID: paxi.ID("1." + strconv.Itoa(s)), CommandID: 1}
+		acceptor.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+
+	// candidate counts the promise once every part of the log arrives
+	id := paxi.NewID(1, 1)
+	p := NewPaxos(newNode(id), withMax)
+	p.ballot = b
+	p.P1a()
+	n.sent = nil
+	acceptor.HandleP1a(P1a{Ballot: p.ballot})
+	if len(n.sent) != 3 {
+		t.Fatalf("expected log of 5 slots in 3 promises, sent %v", n.sent)
+	}
+	for i, m := range n.sent {
+		if p.active {
+			t.Fatalf("elected before promise part %d arrived", i)
+		}
+		p.HandleP1b(m.(P1b))
+	}
+	if !p.active || p.slot != 4 {
+		t.Fatalf("expected elected with every slot of the log, active %v slot %d", p.active, p.slot)
+	}
+
+	// snapshot of large state, executed records and sessions is sent in chunks that fit
+	for s := 0; s < 5; s++ {
+		acceptor.HandleP3(P3{Ballot: b, Slot: s, Commands: acceptor.log[s].commands})
+	}
+	n.sent = nil
+	acceptor.HandleSnapshotRequest(SnapshotRequest{ID: id})
+	follower := NewPaxos(newNode(id))
+	sessions := 0
+	for i, m := range n.sent {
+		c := m.(SnapshotReply)
+		size := len(c.State)
+		for id, s := range c.Sessions {
+			size += sessionSize(id, s)
+		}
+		if size+messageOverhead > max {
+			t.Errorf("snapshot chunk of %d bytes exceeds max message size", size)
+		}
+		sessions += len(c.Sessions)
+		if follower.HandleSnapshotReply(c); i < len(n.sent)-1 && follower.execute != 0 {
+			t.Fatalf("snapshot installed before chunk %d arrived", i+1)
+		}
+	}
+	if len(n.sent) < 2 || follower.execute != 5 || sessions != 5 {
+		t.Errorf("expected snapshot of 5 sessions installed from %d chunks, execute %d sessions %d", len(n.sent), follower.execute, sessions)
+	}
+}
+
+func TestPreemption(t *testing.T) {
+	peer := paxi.NewID(1, 2)
+	preempt := func(policy string) (*node, *Paxos) {
+		n := newNode(paxi.NewID(1, 1))
+		p := NewPaxos(n, func(p *Paxos) { p.Preemption = policy })
+		p.P1a()
+		p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+		late := &paxi.Request{Command: paxi.Command{Key: 2, Value: paxi.Value("b"), ClientID: "1.1", CommandID: 2}, Timestamp: 2}
+		early := &paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("a"), ClientID: "1.1", CommandID: 1}, Timestamp: 1}
+		p.P2a(late, early)
+		n.timers = nil
+		b := paxi.NewBallot(2, peer)
+		p.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{{Key: 3, Value: paxi.Value("c")}}})
+		return n, p
+	}
+
+	n, _ := preempt(PreemptRetry)
+	if len(n.forwarded) != 0 || len(n.timers) != 2 {
+		t.Errorf("expected retries after backoff, forwarded %v timers %d", n.forwarded, len(n.timers))
+	}
+	n, _ = preempt(PreemptFail)
+	if len(n.forwarded) != 0 || len(n.timers) != 0 {
+		t.Errorf("expected requests failed back to client, forwarded %v timers %d", n.forwarded, len(n.timers))
+	}
+	n, p := preempt(PreemptFIFO)
+	if len(n.forwarded) != 2 || n.forwarded[0].Command.Key != 1 || n.forwarded[1].Command.Key != 2 {
+		t.Fatalf("expected requests forwarded right away in arrival order, forwarded %v", n.forwarded)
+	}
+
+	// retried request is queued ahead of later arrivals
+	p.enqueue(&paxi.Request{Command: paxi.Command{Key: 4}, Timestamp: 3})
+	retried := n.forwarded[1]
+	p.enqueue(&retried)
+	if p.requests[0].Command.Key != 2 {
+		t.Errorf("expected retried request ahead of later arrival, got %v", p.requests[0])
+	}
+}
+
+func TestDrain(t *testing.T) {
+	n := newNode(paxi.NewID(1, 1))
+	p := NewPaxos(n)
+	p.memberships = []membership{{ids: []paxi.ID{paxi.NewID(1, 1), paxi.NewID(1, 2), paxi.NewID(1, 3)}}}
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: paxi.NewID(1, 2)})
+	put := func(i int) paxi.Request {
+		return paxi.Request{Command: paxi.Command{Key: paxi.Key(i), Value: paxi.Value("v"), ClientID: "1.1", CommandID: i}}
+	}
+	p.HandleRequest(put(0))
+
+	done := make(chan struct{})
+	p.retire(done)
+	p.HandleRequest(put(1))
+	if !p.status().Draining || p.slot != 0 || len(p.requests) != 0 {
+		t.Fatalf("expected new request rejected while draining, slot %d pending %d", p.slot, len(p.requests))
+	}
+	select {
+	case <-done:
+		t.Fatal("drained before inflight slot is executed")
+	default:
+	}
+
+	n.sent = nil
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: paxi.NewID(1, 2), Slot: 0})
+	select {
+	case <-done:
+	default:
+		t.Fatalf("expected drained once inflight slot is executed, execute %d", p.execute)
+	}
+	if p.active {
+		t.Error("expected draining leader to step down")
+	}
+	transferred := false
+	for _, m := range n.sent {
+		_, ok := m.(TimeoutNow)
+		transferred = transferred || ok
+	}
+	if !transferred || p.target != "" {
+		t.Errorf("expected leadership handed over, sent %v", n.sent)
+	}
+	n.sent = nil
+	p.P1a()
+	if len(n.sent) != 0 {
+		t.Errorf("expected drained node never to start phase 1, sent %v", n.sent)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "record")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}
+	now := time.Now()
+	r.Record(now, P1a{Ballot: b})
+	r.Record(now.Add(time.Millisecond), P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{cmd}})
+	r.Record(now.Add(2*time.Millisecond), P2a{Ballot: b, Slot: 1, Commands: []paxi.Command{cmd}, Commit: 1})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := LoadRecording(path)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("expected 3 recorded messages, got %d: %v", len(records), err)
+	}
+	if !records[1].Time.Equal(now.Add(time.Millisecond)) {
+		t.Fatalf("expected recorded time %v, got %v", now.Add(time.Millisecond), records[1].Time)
+	}
+
+	p := Replay(paxi.NewID(1, 2), records)
+	if p.Ballot() != b || p.Leader() != b.ID() {
+		t.Fatalf("expected replayed ballot %v, got %v", b, p.Ballot())
+	}
+	entries := p.LogSnapshot()
+	if len(entries) != 2 || !entries[0].Commit || entries[1].Commit {
+		t.Fatalf("expected slot 0 committed and slot 1 accepted, got %v", entries)
+	}
+	if s := p.Status(); s.Slot != 1 || s.Execute != 1 {
+		t.Fatalf("expected replayed slot 1 and execute 1, got %+v", s)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	p := NewPaxos(newNode(paxi.NewID(1, 2)))
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	commit := func(s int, cmd paxi.Command) {
+		p.HandleP2a(P2a{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+		p.HandleP3(P3{Ballot: b, Slot: s, Commands: []paxi.Command{cmd}})
+	}
+
+	// key written in slot 0 with ttl 2 expires in slot 2, whenever slots are executed
+	commit(0, paxi.PutTTL(1, paxi.Value("v"), 2))
+	commit(1, paxi.Command{Key: 2, Value: paxi.Value("w")})
+	if v := p.StateMachine.(paxi.Database).Get(1); string(v) != "v" {
+		t.Fatalf("expected key 1 before expiry, got %q", v)
+	}
+	commit(3, paxi.PutTTL(2, paxi.Value("x"), 5))
+	commit(2, paxi.Command{Key: 3, Value: paxi.Value("y")})
+	if v := p.StateMachine.(paxi.Database).Get(1); p.execute != 4 || v != nil {
+		t.Errorf("expected key 1 expired after slot 2, execute %d value %q", p.execute, v)
+	}
+	if v := p.StateMachine.(paxi.Database).Get(2); string(v) != "x" {
+		t.Errorf("expected key 2 until slot 8, got %q", v)
+	}
+}
+
+func TestReplyLeader(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	n := newNode(paxi.NewID(1, 2))
+	p := NewPaxos(n, func(p *Paxos) {
+		p.Clock = c
+		p.Timeout = time.Second
+	})
+	b := paxi.NewBallot(1, paxi.NewID(1, 1))
+	p.HandleP1a(P1a{Ballot: b})
+	n.sent = nil
+
+	// follower hearing from the leader replies the leader instead of handling the request
+	p.ReplyLeader(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v")}})
+	if len(p.requests) != 0 || len(n.forwarded) != 0 || len(n.sent) != 0 || p.ballot != b {
+		t.Fatalf("expected request redirected to leader, pending %d forwarded %v sent %v", len(p.requests), n.forwarded, n.sent)
+	}
+
+	// silent leader is not worth the round trip, follower takes over
+	c.now = c.now.Add(2 * time.Second)
+	p.ReplyLeader(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v")}})
+	if len(p.requests) != 1 {
+		t.Errorf("expected request held by follower of silent leader, pending %d", len(p.requests))
+	}
+}
+
+func TestLeaseReadAfterElection(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	p := NewPaxos(newNode(id), func(p *Paxos) {
+		p.Clock = c
+		p.Lease = 10 * time.Second
+	})
+
+	// lease renewed as follower of the old leader does not carry over to the new ballot
+	old := paxi.NewBallot(1, peer)
+	cmd := paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.2", CommandID: 1}
+	p.HandleP2a(P2a{Ballot: old, Slot: 0, Commands: []paxi.Command{cmd}})
+	c.now = c.now.Add(time.Second)
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer, Log: map[int]CommandBallot{0: {[]paxi.Command{cmd}, old}}})
+	if !p.active || !p.lease.IsZero() {
+		t.Fatalf("expected new leader without lease, active %t lease %v", p.active, p.lease)
+	}
+
+	// commit in the new ballot renews the lease while recovered slot 0 is not executed yet
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 2, Value: paxi.Value("w"), ClientID: "1.1", CommandID: 1}})
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: 1})
+	if !c.now.Before(p.lease) || p.execute != 0 {
+		t.Fatalf("expected lease renewed with slot 0 unexecuted, lease %v execute %d", p.lease, p.execute)
+	}
+	p.HandleReadRequest(paxi.Request{Command: paxi.Command{Key: 1}})
+	if len(p.reads) != 1 || len(p.rounds) != 0 {
+		t.Fatalf("expected lease read waiting for slot 0 without read index round, reads %d rounds %d", len(p.reads), len(p.rounds))
+	}
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: 0})
+	if p.execute != 2 || len(p.reads) != 0 {
+		t.Errorf("expected lease read served once slot 0 executed, execute %d reads %d", p.execute, len(p.reads))
+	}
+}
+
+func TestLease(t *testing.T) {
+	c := &clock{now: time.Unix(0, 0)}
+	id := paxi.NewID(1, 1)
+	peer := paxi.NewID(1, 2)
+	lease := func(p *Paxos) {
+		p.Clock = c
+		p.Lease = 5 * time.Second
+	}
+	p := NewPaxos(newNode(id), lease)
+	p.P1a()
+	p.HandleP1b(P1b{Ballot: p.ballot, ID: peer})
+	if !p.active || c.now.Before(p.lease) {
+		t.Fatalf("expected active leader without lease, active %t lease %v", p.active, p.lease)
+	}
+
+	// phase 2 quorum renews lease from the time the slot is proposed
+	p.HandleRequest(paxi.Request{Command: paxi.Command{Key: 1, Value: paxi.Value("v"), ClientID: "1.1", CommandID: 1}})
+	c.now = c.now.Add(time.Second)
+	p.HandleP2b(P2b{Ballot: p.ballot, ID: peer, Slot: 0})
+	if !p.lease.Equal(time.Unix(5, 0)) {
+		t.Fatalf("expected lease until proposal time plus lease, got %v", p.lease)
+	}
+
+	// valid lease serves read without a slot or read index round
+	p.HandleReadRequest(paxi.Request{Command: paxi.Command{Key: 1}})
+	if p.slot != 0 || len(p.rounds) != 0 || len(p.reads) != 0 {
+		t.Errorf("expected read served under lease, slot %d rounds %d reads %d", p.slot, len(p.rounds), len(p.reads))
+	}
+
+	// follower refuses other candidates until the lease of its leader expires
+	q := NewPaxos(newNode(peer), lease)
+	b := paxi.NewBallot(1, id)
+	q.HandleP2a(P2a{Ballot: b, Slot: 0, Commands: []paxi.Command{{Key: 1, Value: paxi.Value("v")}}})
+	rival := paxi.NewBallot(2, paxi.NewID(1, 3))
+	q.HandleP1a(P1a{Ballot: rival})
+	if q.ballot != b {
+		t.Fatalf("expected P1a refused under lease, ballot %v", q.ballot)
+	}
+	c.now = c.now.Add(5 * time.Second)
+	q.HandleP1a(P1a{Ballot: rival})
+	if q.ballot != rival {
+		t.Errorf("expected P1a accepted after lease expires, ballot %v", q.ballot)
+	}
+}
@@ -0,0 +1,180 @@
+package paxos
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ailidani/paxi"
+)
+
+// ErrRequestTimeout is replied to pending request that is not proposed before its deadline
+var ErrRequestTimeout = errors.New("request timeout waiting for leader")
+
+// ErrCommitTimeout is replied to proposed request whose slot is not committed before its deadline
+var ErrCommitTimeout = errors.New("request timeout waiting for commit")
+
+// ErrTooManyRetries is replied to request that lost its slot to other commands more than MaxRetry times
+var ErrTooManyRetries = errors.New("request retried too many times")
+
+// ErrTooManyPending is replied to new request when pending requests reach the limit
+var ErrTooManyPending = errors.New("too many pending requests")
+
+// hold appends request r to pending requests, or rejects it if pending requests are full
+func (p *Paxos) hold(r *paxi.Request) {
+	if p.MaxPending > 0 && len(p.requests) >= p.MaxPending {
+		r.Reply(paxi.Reply{
+			Command: r.Command,
+			Err:     ErrTooManyPending,
+		})
+		return
+	}
+	p.enqueue(r)
+	if r.Deadline > 0 && (p.sweepAt == 0 || r.Deadline < p.sweepAt) {
+		p.schedule(r.Deadline)
+	}
+}
+
+// enqueue inserts request r into pending requests after every request of the same or higher priority,
+// so pending requests are proposed by priority and in arrival order within one priority
+func (p *Paxos) enqueue(r *paxi.Request) {
+	i := len(p.requests)
+	for i > 0 && (p.requests[i-1].Priority < r.Priority || p.overtakes(r, p.requests[i-1])) {
+		i--
+	}
+	p.requests = append(p.requests, nil)
+	copy(p.requests[i+1:], p.requests[i:])
+	p.requests[i] = r
+}
+
+// recovered attaches every pending request whose command, keyed by client and command id, is already in
+// an unexecuted slot recovered by phase 1, so that the new leader does not propose it again in a new slot
+func (p *Paxos) recovered() {
+	if len(p.requests) == 0 {
+		return
+	}
+	type id struct {
+		client  paxi.ID
+		command int
+	}
+	type at struct {
+		slot, index int
+	}
+	slots := make(map[id]at)
+	for s := p.execute; s <= p.slot; s++ {
+		e, exists := p.log[s]
+		if !exists || e.executed {
+			continue
+		}
+		for i, c := range e.commands {
+			if c.ClientID != "" && !c.IsNoOp() {
+				slots[id{c.ClientID, c.CommandID}] = at{s, i}
+			}
+		}
+	}
+	i := 0
+	for _, r := range p.requests {
+		a, exists := slots[id{r.Command.ClientID, r.Command.CommandID}]
+		if !exists {
+			p.requests[i] = r
+			i++
+			continue
+		}
+		e := p.log[a.slot]
+		for len(e.requests) < len(e.commands) {
+			e.requests = append(e.requests, nil)
+		}
+		if e.requests[a.index] == nil {
+			e.requests[a.index] = r
+		} else {
+			// replied with the same result as the request already waiting on this command
+			e.requests = append(e.requests, r)
+		}
+	}
+	p.requests = p.requests[:i]
+}
+
+// bounce counts one more retry of request r that lost its slot,
+// and fails r back to client if it exceeds max retries, returns true if r is retried
+func (p *Paxos) bounce(r *paxi.Request) bool {
+	r.Retries++
+	if p.MaxRetry > 0 && r.Retries > p.MaxRetry {
+		r.Reply(paxi.Reply{
+			Command: r.Command,
+			Err:     ErrTooManyRetries,
+		})
+		return false
+	}
+	return true
+}
+
+// expired returns true if request r passed its deadline
+func (p *Paxos) expired(r *paxi.Request) bool {
+	return r.Deadline > 0 && r.Deadline <= p.Clock.Now().UnixNano()
+}
+
+// until returns duration from now to deadline in unix nano
+func (p *Paxos) until(deadline int64) time.Duration {
+	return time.Duration(deadline - p.Clock.Now().UnixNano())
+}
+
+// schedule moves next sweep of pending requests to deadline, timers of sweeps scheduled before are stale
+func (p *Paxos) schedule(deadline int64) {
+	p.sweepAt = deadline
+	p.After(p.until(deadline), func() {
+		if p.sweepAt == deadline {
+			p.sweep()
+		}
+	})
+}
+
+// sweep fails pending requests that passed their deadline back to clients
+func (p *Paxos) sweep() {
+	p.sweepAt = 0
+	i := 0
+	var next int64
+	for _, r := range p.requests {
+		if p.expired(r) {
+			r.Reply(paxi.Reply{
+				Command: r.Command,
+				Err:     ErrRequestTimeout,
+			})
+			continue
+		}
+		if r.Deadline > 0 && (next == 0 || r.Deadline < next) {
+			next = r.Deadline
+		}
+		p.requests[i] = r
+		i++
+	}
+	p.requests = p.requests[:i]
+	if next > 0 {
+		p.schedule(next)
+	}
+}
+
+// deadline schedules timeout of requests proposed in slot s that are not committed by their deadline
+func (p *Paxos) deadline(s int) {
+	e, exists := p.log[s]
+	if !exists || e.commit {
+		return
+	}
+	var next int64
+	for i, r := range e.requests {
+		if r == nil || r.Deadline == 0 {
+			continue
+		}
+		if p.expired(r) {
+			r.Reply(paxi.Reply{
+				Command: r.Command,
+				Err:     ErrCommitTimeout,
+			})
+			// the command stays in slot, only the client stops waiting
+			e.requests[i] = nil
+		} else if next == 0 || r.Deadline < next {
+			next = r.Deadline
+		}
+	}
+	if next > 0 {
+		p.After(p.until(next), func() { p.deadline(s) })
+	}
+}
@@ -0,0 +1,38 @@
+package paxos
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/ailidani/paxi"
+)
+
+// policies of requests that lost their slot to the commands of another leader
+const (
+	PreemptRetry = "retry" // forward to the new leader after backoff delay growing with retries
+	PreemptFail  = "fail"  // fail back to client right away
+	PreemptFIFO  = "fifo"  // forward to the new leader right away in arrival order, queued ahead of later arrivals
+)
+
+// ErrPreempted is replied to request that lost its slot to another leader with fail preemption policy
+var ErrPreempted = errors.New("request lost its slot to another leader")
+
+// preempted returns client requests of entry e which lost its slot, in arrival order with fifo policy
+func (p *Paxos) preempted(e *entry) []*paxi.Request {
+	requests := make([]*paxi.Request, 0, len(e.requests))
+	for _, r := range e.requests {
+		if r != nil {
+			requests = append(requests, r)
+		}
+	}
+	if p.Preemption == PreemptFIFO {
+		sort.SliceStable(requests, func(i, j int) bool { return requests[i].Timestamp < requests[j].Timestamp })
+	}
+	return requests
+}
+
+// overtakes returns true if retried request r arrived before pending request q of the same priority with fifo policy,
+// so requests that lost their slot are not starved by newer ones
+func (p *Paxos) overtakes(r, q *paxi.Request) bool {
+	return p.Preemption == PreemptFIFO && r.Retries > 0 && q.Priority == r.Priority && q.Timestamp > r.Timestamp
+}
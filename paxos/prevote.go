@@ -0,0 +1,72 @@
+package paxos
+
+// preVote asks every node whether it would accept the next ballot of this node without raising the ballot
+func (p *Paxos) preVote() {
+	b := p.ballot
+	if err := b.Next(p.ID()); err != nil {
+		return
+	}
+	p.election = voting
+	p.prepared = p.Clock.Now()
+	if b == p.candidate {
+		// pre-vote in progress, ask again and keep granted votes
+		p.Broadcast(PreVote{Ballot: b})
+		return
+	}
+	p.candidate = b
+	p.denied = false
+	p.votes = p.newQuorum(p.slot + 1)
+	p.votes.ACK(p.ID())
+	p.Broadcast(PreVote{Ballot: b})
+	p.vote()
+}
+
+// vote starts real phase 1 once a phase 1 quorum grants the pre-vote
+func (p *Paxos) vote() {
+	if !p.Q1(p.votes) {
+		return
+	}
+	p.candidate = 0
+	p.voted = true
+	p.P1a()
+}
+
+// alive returns true if this node heard from the current leader recently
+func (p *Paxos) alive() bool {
+	if p.active || p.Clock.Now().Before(p.lease) {
+		return true
+	}
+	return p.Timeout > 0 && p.since(p.heard) < p.Timeout
+}
+
+// HandlePreVote grants the pre-vote if the ballot is higher and no live leader is known
+func (p *Paxos) HandlePreVote(m PreVote) {
+	p.Send(m.Ballot.ID(), PreVoteReply{
+		Ballot: m.Ballot,
+		ID:     p.ID(),
+		Leader: p.ballot,
+		Grant:  m.Ballot > p.ballot && !p.alive(),
+	})
+}
+
+// HandlePreVoteReply counts pre-vote grants,
+// a denial from node that follows a live leader forwards pending requests to that leader
+func (p *Paxos) HandlePreVoteReply(m PreVoteReply) {
+	if m.Ballot != p.candidate || p.active {
+		return
+	}
+	if m.Grant {
+		p.votes.ACK(m.ID)
+		p.vote()
+		return
+	}
+	if !p.denied {
+		p.denied = true
+		p.attempt++
+	}
+	if m.Leader > p.ballot {
+		p.ballot = m.Leader
+		p.observe()
+		p.forward()
+	}
+}
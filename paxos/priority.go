@@ -0,0 +1,31 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+)
+
+// priority returns leader preference of node id, higher is preferred, 0 if not configured
+func (p *Paxos) priority(id paxi.ID) int {
+	return p.Priority[id]
+}
+
+// rank returns number of nodes preferred over this node as leader
+func (p *Paxos) rank() int {
+	r := 0
+	for id, w := range p.Priority {
+		if id != p.ID() && w > p.priority(p.ID()) {
+			r++
+		}
+	}
+	return r
+}
+
+// yields returns true if this node declines to challenge the known leader,
+// which is preferred over this node and heard from within leader timeout
+func (p *Paxos) yields() bool {
+	leader := p.ballot.ID()
+	if p.Timeout <= 0 || p.ballot == 0 || leader == p.ID() {
+		return false
+	}
+	return p.priority(leader) > p.priority(p.ID()) && p.since(p.heard) < p.Timeout
+}
@@ -0,0 +1,109 @@
+package paxos
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// metric is one sample in Prometheus text exposition format
+type metric struct {
+	name  string
+	kind  string // counter or gauge
+	help  string
+	value float64
+}
+
+// WritePrometheus writes protocol counters and gauges in Prometheus text exposition format,
+// every sample is labeled with id of this node
+func (p *Paxos) WritePrometheus(w io.Writer) error {
+	m := p.Metrics()
+	leader := 0.0
+	if m.Leader {
+		leader = 1
+	}
+	metrics := []metric{
+		{"paxos_committed_slots_total", "counter", "Number of slots learned as committed.", float64(m.Committed)},
+		{"paxos_executed_slots_total", "counter", "Number of slots executed.", float64(m.Executed)},
+		{"paxos_coalesced_requests_total", "counter", "Number of requests attached to an identical command in flight.", float64(m.Coalesced)},
+		{"paxos_elections_total", "counter", "Number of elections won by this node.", float64(m.Elections)},
+		{"paxos_phase1_failures_total", "counter", "Number of own phase 1 preempted or timed out before reaching quorum.", float64(m.Failures)},
+		{"paxos_last_election_seconds", "gauge", "Duration of the last election won by this node, from its first P1a to phase 1 quorum.", m.LastElection.Seconds()},
+		{"paxos_phase1_attempts_total", "counter", "Number of phase 1 started.", float64(m.Attempts)},
+		{"paxos_phase1_retries_total", "counter", "Number of phase 1 restarted after backoff or leader timeout.", float64(m.Retries)},
+		{"paxos_preemptions_total", "counter", "Number of times own ballot is preempted by a higher ballot.", float64(m.Preemptions)},
+		{"paxos_ballot", "gauge", "Round number of current ballot.", float64(m.Ballot.N())},
+		{"paxos_leader", "gauge", "Whether this node is or tries to be the leader.", leader},
+		{"paxos_leader_priority", "gauge", "Priority of the node of current ballot.", float64(m.Priority)},
+		{"paxos_inflight_slots", "gauge", "Number of slots proposed but not executed yet.", float64(m.InFlight)},
+		{"paxos_execution_backlog_slots", "gauge", "Number of slots from execute up to the highest committed slot.", float64(m.Backlog)},
+		{"paxos_queued_messages", "gauge", "Number of messages from peers waiting for handlers.", float64(m.Queued)},
+		{"paxos_dropped_messages_total", "counter", "Number of messages from peers dropped while queue is full.", float64(m.Dropped)},
+		{"paxos_election_timeout_min_seconds", "gauge", "Lower bound of randomized election timeout.", m.MinTimeout.Seconds()},
+		{"paxos_election_timeout_max_seconds", "gauge", "Upper bound of randomized election timeout.", m.MaxTimeout.Seconds()},
+		{"paxos_election_timeout_seconds", "gauge", "Election timeout currently drawn by this node.", m.ElectionTimeout.Seconds()},
+		{"paxos_heartbeat_interval_seconds", "gauge", "Current interval of adaptive leader heartbeat.", m.HeartbeatInterval.Seconds()},
+		{"paxos_leader_suspicion_phi", "gauge", "Phi accrual suspicion of the leader failing since its last heartbeat.", m.Suspicion},
+		{"paxos_p2a_uncompressed_bytes_total", "counter", "Bytes of command values above compression threshold before compression.", float64(m.Uncompressed)},
+		{"paxos_p2a_compressed_bytes_total", "counter", "Bytes of the same command values sent in P2a.", float64(m.Compressed)},
+		{"paxos_backfill_bytes_total", "counter", "Bytes of repair replies and snapshot state served to recovering replicas.", float64(m.Backfilled)},
+	}
+	for _, s := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{id=%q} %g\n", s.name, s.help, s.name, s.kind, s.name, p.ID(), s.value)
+		if err != nil {
+			return err
+		}
+	}
+	return p.writeLinks(w, m.Links)
+}
+
+// writeLinks writes sending statistic of each peer, every sample is labeled with id of this node and the peer
+func (p *Paxos) writeLinks(w io.Writer, links map[paxi.ID]paxi.Link) error {
+	if len(links) == 0 {
+		return nil
+	}
+	peers := make([]paxi.ID, 0, len(links))
+	for id := range links {
+		peers = append(peers, id)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	families := []struct {
+		name, kind, help string
+		value            func(paxi.Link) float64
+	}{
+		{"paxos_peer_sent_messages_total", "counter", "Number of messages handed to transport of the peer.", func(l paxi.Link) float64 { return float64(l.Sent) }},
+		{"paxos_peer_send_failures_total", "counter", "Number of messages transport failed to write to the peer.", func(l paxi.Link) float64 { return float64(l.Failed) }},
+		{"paxos_peer_suspected", "gauge", "Whether the peer is suspected down after consecutive failed writes.", func(l paxi.Link) float64 {
+			if l.Suspected {
+				return 1
+			}
+			return 0
+		}},
+	}
+	for _, f := range families {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		if err != nil {
+			return err
+		}
+		for _, id := range peers {
+			_, err = fmt.Fprintf(w, "%s{id=%q,peer=%q} %g\n", f.name, p.ID(), id, f.value(links[id]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleMetrics serves metrics to Prometheus scraper
+func (r *Replica) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	err := r.WritePrometheus(w)
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,47 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// Proxy forwards client request r to the current leader, which replies to the client through this node.
+// If the request is not executed and nothing is heard from the leader within ForwardTimeout,
+// this node handles the request itself, which starts phase 1.
+func (p *Paxos) Proxy(r paxi.Request) {
+	leader := p.ballot.ID()
+	if p.IsLeader() || p.ballot == 0 {
+		p.HandleRequest(r)
+		return
+	}
+	p.Forward(leader, r)
+	if p.ForwardTimeout <= 0 {
+		return
+	}
+	key := r.Command.String()
+	p.proxied[key] = &r
+	p.After(p.ForwardTimeout, func() { p.unproxy(key) })
+}
+
+// unproxy handles forwarded request of key locally if leader is silent since it is forwarded,
+// otherwise waits another timeout
+func (p *Paxos) unproxy(key string) {
+	r, exists := p.proxied[key]
+	if !exists {
+		return
+	}
+	if !p.IsLeader() && p.since(p.heard) < p.ForwardTimeout {
+		p.After(p.ForwardTimeout, func() { p.unproxy(key) })
+		return
+	}
+	delete(p.proxied, key)
+	log.Debugw("forward timeout", log.Fields{"id": p.ID(), "leader": p.ballot.ID(), "command": key})
+	p.HandleRequest(*r)
+}
+
+// proxyDone stops waiting for forwarded request of cmd once it is executed
+func (p *Paxos) proxyDone(cmd paxi.Command) {
+	if len(p.proxied) > 0 {
+		delete(p.proxied, cmd.String())
+	}
+}
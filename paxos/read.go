@@ -0,0 +1,269 @@
+package paxos
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/ailidani/paxi"
+)
+
+// ErrReadTimeout is replied to quorum read that does not hear from a read quorum before its deadline
+var ErrReadTimeout = errors.New("request timeout waiting for read quorum")
+
+// read is a read request waiting for leadership confirmation and execution of its read index
+type read struct {
+	index   int          // highest slot at the time the read arrives
+	quorum  *paxi.Quorum // acknowledgements of current ballot
+	request *paxi.Request
+}
+
+// HandleReadRequest serves read command from executed state without a log slot.
+// Active leader with valid lease skips confirming its ballot with a quorum, and either way
+// replies once every slot up to the read index is executed, including slots recovered in phase 1.
+// Request falls back to the log if this node is not the leader.
+func (p *Paxos) HandleReadRequest(r paxi.Request) {
+	if !p.active || !r.Command.IsRead() {
+		p.HandleRequest(r)
+		return
+	}
+	if p.Clock.Now().Before(p.lease) {
+		p.reads = append(p.reads, &read{index: p.slot, request: &r})
+		p.serveReads()
+		return
+	}
+	p.seq++
+	rd := &read{
+		index:   p.slot,
+		quorum:  paxi.NewQuorum(),
+		request: &r,
+	}
+	rd.quorum.ACK(p.ID())
+	p.rounds[p.seq] = rd
+	p.Broadcast(ReadIndex{
+		Ballot: p.ballot,
+		Seq:    p.seq,
+	})
+	p.confirm(p.seq)
+}
+
+// HandleReadIndex handles ReadIndex message
+func (p *Paxos) HandleReadIndex(m ReadIndex) {
+	if m.Ballot >= p.ballot {
+		p.heard = p.Clock.Now()
+		if m.Ballot > p.ballot {
+			p.ballot = m.Ballot
+			p.active = false
+			p.observe()
+			p.forward()
+		}
+	}
+	p.Send(m.Ballot.ID(), ReadIndexAck{
+		Ballot: p.ballot,
+		ID:     p.ID(),
+		Seq:    m.Seq,
+	})
+}
+
+// HandleReadIndexAck handles ReadIndexAck message
+func (p *Paxos) HandleReadIndexAck(m ReadIndexAck) {
+	rd, exists := p.rounds[m.Seq]
+	if !exists {
+		return
+	}
+	// leadership cannot be confirmed, pending reads go through the new leader
+	if m.Ballot > p.ballot {
+		p.ballot = m.Ballot
+		p.active = false
+		p.observe()
+		p.forward()
+		return
+	}
+	if m.Ballot == p.ballot {
+		rd.quorum.ACK(m.ID)
+		p.confirm(m.Seq)
+	}
+}
+
+// confirm moves read round seq to confirmed reads once a phase 2 quorum acknowledged current ballot
+func (p *Paxos) confirm(seq int) {
+	rd := p.rounds[seq]
+	if !p.Q2(rd.quorum) {
+		return
+	}
+	delete(p.rounds, seq)
+	p.reads = append(p.reads, rd)
+	p.serveReads()
+}
+
+// ReadFromQuorum serves read command on any node without a leader or lease.
+// It asks a phase 1 quorum for their highest accepted slot and replies once every slot up to
+// the highest one is executed locally, so a node in a minority partition never replies stale value.
+func (p *Paxos) ReadFromQuorum(r paxi.Request) {
+	if !r.Command.IsRead() {
+		p.HandleRequest(r)
+		return
+	}
+	if r.Deadline == 0 && p.RequestTimeout > 0 {
+		r.Deadline = p.Clock.Now().UnixNano() + int64(p.RequestTimeout)
+	}
+	p.seq++
+	rd := &read{
+		index:   paxi.Max(p.slot, r.Session-1),
+		quorum:  p.newQuorum(p.slot + 1),
+		request: &r,
+	}
+	rd.quorum.ACK(p.ID())
+	p.polls[p.seq] = rd
+	if r.Deadline > 0 {
+		seq := p.seq
+		p.After(p.until(r.Deadline), func() { p.unpoll(seq) })
+	}
+	p.Broadcast(QuorumRead{
+		ID:  p.ID(),
+		Seq: p.seq,
+	})
+	p.poll(p.seq)
+}
+
+// unpoll fails quorum read seq back to client if it still waits for its read quorum
+func (p *Paxos) unpoll(seq int) {
+	rd, exists := p.polls[seq]
+	if !exists {
+		return
+	}
+	delete(p.polls, seq)
+	rd.request.Reply(paxi.Reply{
+		Command: rd.request.Command,
+		Err:     ErrReadTimeout,
+	})
+}
+
+// HandleQuorumRead handles QuorumRead message
+func (p *Paxos) HandleQuorumRead(m QuorumRead) {
+	p.Send(m.ID, QuorumReadAck{
+		ID:   p.ID(),
+		Seq:  m.Seq,
+		Slot: p.slot,
+	})
+}
+
+// HandleQuorumReadAck handles QuorumReadAck message
+func (p *Paxos) HandleQuorumReadAck(m QuorumReadAck) {
+	rd, exists := p.polls[m.Seq]
+	if !exists {
+		return
+	}
+	rd.quorum.ACK(m.ID)
+	rd.index = paxi.Max(rd.index, m.Slot)
+	p.poll(m.Seq)
+}
+
+// poll moves quorum read seq to confirmed reads once a phase 1 quorum replied its highest slot,
+// any value committed before the read is accepted by at least one node of the quorum
+func (p *Paxos) poll(seq int) {
+	rd := p.polls[seq]
+	if !p.Q1(rd.quorum) {
+		return
+	}
+	delete(p.polls, seq)
+	p.reads = append(p.reads, rd)
+	p.serveReads()
+}
+
+// HandleSessionRead serves read command from local state once every slot before its client session is executed,
+// so that the client reads its own writes from any replica
+func (p *Paxos) HandleSessionRead(r paxi.Request) {
+	p.reads = append(p.reads, &read{
+		index:   r.Session - 1,
+		request: &r,
+	})
+	p.serveReads()
+}
+
+// serveReads replies confirmed reads whose read index is executed
+func (p *Paxos) serveReads() {
+	i := 0
+	for _, rd := range p.reads {
+		if rd.index < p.execute {
+			p.serve(rd.request)
+		} else {
+			p.reads[i] = rd
+			i++
+		}
+	}
+	p.reads = p.reads[:i]
+}
+
+// serve replies read request r from local state machine, from executor worker of its key if any
+func (p *Paxos) serve(r *paxi.Request) {
+	if p.ShadowMode {
+		return
+	}
+	reply := paxi.Reply{
+		Command:    r.Command,
+		Properties: make(map[string]string),
+		Timestamp:  p.Clock.Now().Unix(),
+		Session:    r.Session,
+	}
+	reply.Properties[HTTPHeaderBallot] = p.ballot.String()
+	reply.Properties[HTTPHeaderExecute] = strconv.Itoa(p.execute - 1)
+	if p.executor != nil {
+		p.executor.run(r.Command.Key, func() {
+			v, err := p.StateMachine.Apply(r.Command)
+			reply.Value, reply.Status = v, status(err)
+			r.Reply(reply)
+		})
+		return
+	}
+	v, err := p.StateMachine.Apply(r.Command)
+	reply.Value, reply.Status = v, status(err)
+	r.Reply(reply)
+}
+
+// HandleBarrierRead serves read command once this node executes every slot the leader has when the read arrives,
+// which covers every write committed before the read. Leader uses its own highest slot, other nodes ask the leader,
+// and the read goes through the log if no other leader is known.
+func (p *Paxos) HandleBarrierRead(r paxi.Request) {
+	if p.active {
+		p.reads = append(p.reads, &read{
+			index:   p.slot,
+			request: &r,
+		})
+		p.serveReads()
+		return
+	}
+	if p.ballot == 0 || p.ballot.ID() == p.ID() {
+		r.Barrier = false
+		p.HandleRequest(r)
+		return
+	}
+	p.seq++
+	p.waits[p.seq] = &read{
+		index:   r.Session - 1,
+		request: &r,
+	}
+	p.Send(p.ballot.ID(), LeaderSlot{
+		ID:  p.ID(),
+		Seq: p.seq,
+	})
+}
+
+// HandleLeaderSlot handles LeaderSlot message
+func (p *Paxos) HandleLeaderSlot(m LeaderSlot) {
+	p.Send(m.ID, LeaderSlotAck{
+		Seq:  m.Seq,
+		Slot: p.slot,
+	})
+}
+
+// HandleLeaderSlotAck handles LeaderSlotAck message
+func (p *Paxos) HandleLeaderSlotAck(m LeaderSlotAck) {
+	rd, exists := p.waits[m.Seq]
+	if !exists {
+		return
+	}
+	delete(p.waits, m.Seq)
+	rd.index = paxi.Max(rd.index, m.Slot)
+	p.reads = append(p.reads, rd)
+	p.serveReads()
+}
@@ -0,0 +1,247 @@
+package paxos
+
+import (
+	"strings"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// ReconfigKey is the reserved key of reconfiguration commands
+const ReconfigKey paxi.Key = -1
+
+// membership is the set of nodes forming quorums from slot onwards
+type membership struct {
+	slot  int
+	ids   []paxi.ID
+	sizes []int // phase 1 and phase 2 quorum sizes, nil follows config
+}
+
+// NewReconfig returns the command that changes membership to ids once executed
+func NewReconfig(ids ...paxi.ID) paxi.Command {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = string(id)
+	}
+	return paxi.Command{
+		Key:      ReconfigKey,
+		Value:    paxi.Value(strings.Join(s, ",")),
+		Protocol: true,
+	}
+}
+
+// IsReconfig returns true if command c changes membership or its quorum sizes
+func IsReconfig(c paxi.Command) bool {
+	return c.Protocol && (c.Key == ReconfigKey || c.Key == ResizeKey) && c.Value != nil
+}
+
+// reserved returns true and replies error if request r of a client is on a key reserved for protocol commands
+func (p *Paxos) reserved(r *paxi.Request) bool {
+	if r.Command.Protocol || !paxi.Reserved(r.Command.Key) {
+		return false
+	}
+	r.Reply(paxi.Reply{
+		Command: r.Command,
+		Err:     paxi.ErrReservedKey,
+	})
+	return true
+}
+
+// change returns membership from slot s onwards after reconfiguration c of membership m,
+// resize keeps nodes of m and a membership change keeps quorum sizes of m.
+// Resize that does not intersect sizes of m leaves m unchanged.
+func change(m membership, s int, c paxi.Command) membership {
+	if !IsResize(c) {
+		return membership{slot: s, ids: members(c), sizes: m.sizes}
+	}
+	q := sizes(c)
+	if q == nil || m.config().Resizable(q[0], q[1]) != nil {
+		return m
+	}
+	return membership{slot: s, ids: m.ids, sizes: q}
+}
+
+// members decodes membership of reconfiguration command c
+func members(c paxi.Command) []paxi.ID {
+	ids := make([]paxi.ID, 0)
+	for _, s := range strings.Split(string(c.Value), ",") {
+		if s != "" {
+			ids = append(ids, paxi.ID(s))
+		}
+	}
+	return ids
+}
+
+// Reconfigure proposes new membership through the log,
+// every slot after the reconfiguration slot forms quorums among ids only.
+// Nodes joining the cluster must already be in the address book of config.
+func (p *Paxos) Reconfigure(ids ...paxi.ID) {
+	p.HandleRequest(paxi.Request{Command: NewReconfig(ids...)})
+}
+
+// Members returns membership effective at given slot, nil if membership is every node in config
+func (p *Paxos) Members(slot int) []paxi.ID {
+	return p.effective(slot).ids
+}
+
+// effective returns the last executed membership change at or before slot, zero membership if none
+func (p *Paxos) effective(slot int) membership {
+	var m membership
+	for _, c := range p.memberships {
+		if c.slot > slot {
+			break
+		}
+		m = c
+	}
+	return m
+}
+
+// proposed returns membership of slot s in phase 2, which also follows the unexecuted reconfiguration
+// proposed in an earlier slot of current ballot, such as one recovered and proposed again in phase 1
+func (p *Paxos) proposed(s int) membership {
+	m := p.effective(s)
+	if p.reconfig < m.slot || p.reconfig >= s || p.log[p.reconfig] == nil {
+		return m
+	}
+	for _, c := range p.log[p.reconfig].commands {
+		if IsReconfig(c) {
+			m = change(m, p.reconfig+1, c)
+		}
+	}
+	return m
+}
+
+// newQuorum returns an empty quorum among members effective at slot
+func (p *Paxos) newQuorum(slot int) *paxi.Quorum {
+	return paxi.QuorumFor(p.effective(slot).config())
+}
+
+// join sets phase 2 quorum of entry e in slot s among membership of the slot and remembers its epoch
+func (p *Paxos) join(s int, e *entry) {
+	m := p.proposed(s)
+	e.epoch = m.slot
+	e.quorum = paxi.QuorumFor(m.config())
+}
+
+// phase1 is quorum of phase 1 among a membership later than the one executed by this node
+type phase1 struct {
+	epoch  int
+	quorum *paxi.Quorum
+}
+
+// prepare starts phase 1 quorums of current ballot among the executed membership and every later one
+// accepted in the log, so that phase 1 intersects phase 2 quorums of slots chosen in a membership
+// this node has not executed yet
+func (p *Paxos) prepare() {
+	p.quorum = p.newQuorum(p.slot + 1)
+	p.joint = nil
+	p.granted = make(map[paxi.ID]bool)
+	p.promises = nil
+	for s := p.execute; s <= p.slot; s++ {
+		if e, exists := p.log[s]; exists {
+			p.discover(s, e.commands)
+		}
+	}
+	p.grant(p.ID())
+}
+
+// discover adds phase 1 quorum among membership of every reconfiguration in commands accepted in slot s
+func (p *Paxos) discover(s int, commands []paxi.Command) {
+	for _, c := range commands {
+		if IsReconfig(c) {
+			p.join1(change(p.effective(s), s+1, c))
+		}
+	}
+}
+
+// join1 adds phase 1 quorum among membership m, unless it is executed by this node or known already
+func (p *Paxos) join1(m membership) {
+	if m.slot <= p.effective(p.slot+1).slot {
+		return
+	}
+	for _, j := range p.joint {
+		if j.epoch == m.slot {
+			return
+		}
+	}
+	q := paxi.QuorumFor(m.config())
+	for id := range p.granted {
+		q.ACK(id)
+	}
+	p.joint = append(p.joint, phase1{epoch: m.slot, quorum: q})
+}
+
+// grant counts phase 1 acknowledgement of node id in every quorum of current ballot
+func (p *Paxos) grant(id paxi.ID) {
+	if p.granted == nil {
+		p.granted = make(map[paxi.ID]bool)
+	}
+	p.granted[id] = true
+	p.quorum.ACK(id)
+	for _, j := range p.joint {
+		j.quorum.ACK(id)
+	}
+}
+
+// assembled returns true once every part of promise m arrived, promise sent whole is complete at once
+func (p *Paxos) assembled(m P1b) bool {
+	if m.Parts <= 1 {
+		return true
+	}
+	if p.promises == nil {
+		p.promises = make(map[paxi.ID][]bool)
+	}
+	parts := p.promises[m.ID]
+	if len(parts) != m.Parts {
+		parts = make([]bool, m.Parts)
+		p.promises[m.ID] = parts
+	}
+	if m.Part >= 0 && m.Part < m.Parts {
+		parts[m.Part] = true
+	}
+	for _, received := range parts {
+		if !received {
+			return false
+		}
+	}
+	return true
+}
+
+// promised returns true once phase 1 of current ballot reaches a quorum in every membership it knows of
+func (p *Paxos) promised() bool {
+	if !p.Q1(p.quorum) {
+		return false
+	}
+	for _, j := range p.joint {
+		if !p.Q1(j.quorum) {
+			return false
+		}
+	}
+	return true
+}
+
+// config returns membership m in the form of paxi
+func (m membership) config() paxi.Membership {
+	return paxi.Membership{Epoch: m.slot, IDs: m.ids, Sizes: m.sizes}
+}
+
+// reconfigure applies reconfiguration command c executed in current slot to every following slot
+func (p *Paxos) reconfigure(c paxi.Command) {
+	m := change(p.effective(p.execute), p.execute+1, c)
+	if m.slot != p.execute+1 {
+		log.Warningw("resize ignored, sizes do not intersect current quorums", log.Fields{"id": p.ID(), "slot": p.execute, "sizes": string(c.Value)})
+		return
+	}
+	log.Infow("membership change", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": m.slot, "members": m.ids, "sizes": m.sizes})
+	p.memberships = append(p.memberships, m)
+	p.promoted()
+}
+
+// propose records the slot of reconfiguration in commands so no slot is proposed after it until executed
+func (p *Paxos) propose(slot int, commands []paxi.Command) {
+	for _, c := range commands {
+		if IsReconfig(c) {
+			p.reconfig = slot
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package paxos
+
+import (
+	"encoding/gob"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// Recorded is one inbound phase 1 or phase 2 message with the time it was handled
+type Recorded struct {
+	Time    time.Time
+	Message interface{}
+}
+
+// Recorder appends inbound messages to a file in gob for post-mortem replay
+type Recorder struct {
+	sync.Mutex
+	file    *os.File
+	encoder *gob.Encoder
+}
+
+// NewRecorder creates recorder appending to file of path
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: f, encoder: gob.NewEncoder(f)}, nil
+}
+
+// Record appends message m handled at time t, recording stops at the first failed write
+func (r *Recorder) Record(t time.Time, m interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	if r.encoder == nil {
+		return
+	}
+	if err := r.encoder.Encode(Recorded{Time: t, Message: m}); err != nil {
+		log.Errorf("recording to %s stopped: %v", r.file.Name(), err)
+		r.encoder = nil
+	}
+}
+
+// Close closes the recording file
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	r.encoder = nil
+	return r.file.Close()
+}
+
+// LoadRecording reads every message recorded in file of path in order
+func LoadRecording(path string) ([]Recorded, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := make([]Recorded, 0)
+	decoder := gob.NewDecoder(f)
+	for {
+		var r Recorded
+		err := decoder.Decode(&r)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			// recording cut short by crash keeps every complete record
+			return records, err
+		}
+		records = append(records, r)
+	}
+}
+
+// Replay feeds recorded messages in order into a fresh Paxos of node id with given options,
+// its clock follows recorded time and its node drops every message it sends and never fires timers,
+// so that state transitions driven by the messages are reproduced without timeouts interfering.
+// Once replayed, Status and LogSnapshot of the returned Paxos run right away on the calling goroutine.
+func Replay(id paxi.ID, records []Recorded, options ...func(*Paxos)) *Paxos {
+	c := &replayClock{}
+	if len(records) > 0 {
+		c.now = records[0].Time
+	}
+	n := &offline{Database: paxi.NewDatabase(), id: id}
+	p := NewPaxos(n, append(options, func(p *Paxos) { p.Clock = c })...)
+	defer func() { n.live = true }()
+	for _, r := range records {
+		n.run()
+		c.now = r.Time
+		switch m := r.Message.(type) {
+		case P1a:
+			p.HandleP1a(m)
+		case P1b:
+			p.HandleP1b(m)
+		case P2a:
+			p.HandleP2a(m)
+		case P2b:
+			p.HandleP2b(m)
+		default:
+			log.Warningf("replay skips unknown message %v", m)
+		}
+	}
+	n.run()
+	return p
+}
+
+// replayClock is the clock of replay set to time of recorded message being replayed
+type replayClock struct {
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time { return c.now }
+
+// offline is node of replay without network, http or timers, functions scheduled without delay run between messages
+type offline struct {
+	paxi.Database
+	id    paxi.ID
+	queue []func()
+	live  bool // replay is done, scheduled functions run right away
+}
+
+// run runs functions scheduled without delay, including the ones they schedule
+func (n *offline) run() {
+	for len(n.queue) > 0 {
+		f := n.queue[0]
+		n.queue = n.queue[1:]
+		f()
+	}
+}
+
+func (n *offline) After(d time.Duration, f func()) {
+	if d > 0 {
+		return
+	}
+	if n.live {
+		f()
+		return
+	}
+	n.queue = append(n.queue, f)
+}
+
+func (n *offline) ID() paxi.ID                           { return n.id }
+func (n *offline) Run()                                  {}
+func (n *offline) Retry(r paxi.Request)                  {}
+func (n *offline) Forward(id paxi.ID, r paxi.Request)    {}
+func (n *offline) Register(m interface{}, f interface{}) {}
+func (n *offline) Send(to paxi.ID, m interface{})        {}
+func (n *offline) MulticastZone(int, interface{})        {}
+func (n *offline) MulticastQuorum(int, interface{})      {}
+func (n *offline) Broadcast(m interface{})               {}
+func (n *offline) Recv() interface{}                     { return nil }
+func (n *offline) Close()                                {}
+func (n *offline) Drop(paxi.ID, int)                     {}
+func (n *offline) Slow(paxi.ID, int, int)                {}
+func (n *offline) Flaky(paxi.ID, float32, int)           {}
+func (n *offline) Crash(int)                             {}
+func (n *offline) HandleHTTP(string, http.HandlerFunc)   {}
+
+// record records inbound message m if this replica has a recorder
+func (r *Replica) record(m interface{}) {
+	if r.recorder != nil {
+		r.recorder.Record(r.Clock.Now(), m)
+	}
+}
+
+func (r *Replica) recordP1a(m P1a) {
+	r.record(m)
+	r.HandleP1a(m)
+}
+
+func (r *Replica) recordP1b(m P1b) {
+	r.record(m)
+	r.HandleP1b(m)
+}
+
+func (r *Replica) recordP2a(m P2a) {
+	r.record(m)
+	r.HandleP2a(m)
+}
+
+func (r *Replica) recordP2b(m P2b) {
+	r.record(m)
+	r.HandleP2b(m)
+}
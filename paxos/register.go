@@ -0,0 +1,55 @@
+package paxos
+
+import (
+	"hash/fnv"
+
+	"github.com/ailidani/paxi"
+)
+
+// RegisterKey is the reserved key of client registration commands
+const RegisterKey paxi.Key = -2
+
+// NewRegister returns the command that registers client id with baseline, the last command id the client
+// has finished, so that commands up to baseline are never executed again for it.
+// Empty id asks replicas to assign one derived from nonce, which the client keeps across retries,
+// nonce must not be empty so that the command is not taken for a read.
+func NewRegister(id paxi.ID, baseline int, nonce string) paxi.Command {
+	return paxi.Command{
+		Key:       RegisterKey,
+		Value:     paxi.Value(nonce),
+		ClientID:  id,
+		CommandID: baseline,
+		Protocol:  true,
+	}
+}
+
+// IsRegister returns true if command c registers a client
+func IsRegister(c paxi.Command) bool {
+	return c.Protocol && c.Key == RegisterKey && c.Value != nil
+}
+
+// assign returns client id of registration nonce, in zone 0 which no node uses
+func assign(nonce paxi.Value) paxi.ID {
+	h := fnv.New64a()
+	h.Write(nonce)
+	return paxi.NewID(0, int(h.Sum64()>>1))
+}
+
+// register executes registration command c in slot s and returns the registered client id.
+// Registering again is idempotent, baseline only moves forward and the session is kept past the log window.
+func (p *Paxos) register(s int, c paxi.Command) paxi.ID {
+	id := c.ClientID
+	if id == "" {
+		id = assign(c.Value)
+	}
+	session, exists := p.sessions[id]
+	if !exists || c.CommandID > session.CommandID {
+		session.CommandID = c.CommandID
+		session.Value = nil
+		session.Status = ""
+	}
+	session.Slot = s
+	session.Registered = true
+	p.sessions[id] = session
+	return id
+}
@@ -0,0 +1,113 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// rejoin tracks a node returning from restart or partition, whose ballot and log may be stale,
+// until it adopts the ballot of a quorum and catches up to the execution of that quorum
+type rejoin struct {
+	stale   bool         // phase 1 and local reads wait for rejoin
+	acks    *paxi.Quorum // RejoinAck of current round
+	ballot  paxi.Ballot  // highest ballot in acks
+	execute int          // highest execute in acks
+	source  paxi.ID      // node of highest execute in acks, missing slots are repaired from it
+	last    int          // own execute when previous round completed, -1 if none
+}
+
+// stale marks ballot and log of this node as stale, so that next phase 1 rejoins the cluster first
+func (p *Paxos) stale() {
+	if p.rejoin.stale {
+		return
+	}
+	log.Infow("rejoin", log.Fields{"id": p.ID(), "ballot": p.ballot, "execute": p.execute})
+	p.rejoin = rejoin{stale: true, last: -1}
+}
+
+// probe broadcasts a rejoin round asking every node for its ballot and execute
+func (p *Paxos) probe() {
+	p.election = rejoining
+	p.prepared = p.Clock.Now()
+	p.rejoin.acks = p.newQuorum(p.slot + 1)
+	p.rejoin.acks.ACK(p.ID())
+	p.rejoin.ballot = 0
+	p.rejoin.execute = p.execute
+	p.rejoin.source = ""
+	p.Broadcast(Rejoin{ID: p.ID()})
+}
+
+// returning returns true if message of lower ballot b comes from the cluster this stale node returns to,
+// such message is not answered with the stale ballot of own phase 1, which would preempt current leader
+func (p *Paxos) returning(b paxi.Ballot) bool {
+	if !p.rejoin.stale || b >= p.ballot || p.ballot.ID() != p.ID() {
+		return false
+	}
+	if p.election != rejoining {
+		p.probe()
+	}
+	return true
+}
+
+// HandleRejoin replies current ballot and execute to returning node
+func (p *Paxos) HandleRejoin(m Rejoin) {
+	b := p.ballot
+	if p.rejoin.stale && b.ID() == p.ID() {
+		// ballot of own unanswered phase 1 is no ballot of the cluster
+		b = 0
+	}
+	p.Send(m.ID, RejoinAck{
+		ID:      p.ID(),
+		Ballot:  b,
+		Execute: p.execute,
+	})
+}
+
+// HandleRejoinAck adopts the highest ballot of a quorum and catches up to its highest execute
+func (p *Paxos) HandleRejoinAck(m RejoinAck) {
+	if p.election != rejoining {
+		return
+	}
+	p.rejoin.acks.ACK(m.ID)
+	if m.Ballot > p.rejoin.ballot {
+		p.rejoin.ballot = m.Ballot
+	}
+	if m.Execute > p.rejoin.execute {
+		p.rejoin.execute = m.Execute
+		p.rejoin.source = m.ID
+	}
+	if !p.Q1(p.rejoin.acks) {
+		return
+	}
+	p.election = idle
+	if b := p.rejoin.ballot; b != 0 && !p.active && (b > p.ballot || p.ballot.ID() == p.ID()) {
+		// own phase 1 of a higher ballot never completed, so no value is chosen by it and it is safe to give up
+		p.ballot = b
+		p.saveBallot()
+		p.observe()
+	}
+	p.heard = p.Clock.Now()
+	if p.execute >= p.rejoin.execute || p.execute == p.rejoin.last {
+		// caught up, or missing slots cannot be repaired since last round and phase 1 recovers them
+		p.fresh()
+		return
+	}
+	p.rejoin.last = p.execute
+	p.slot = paxi.Max(p.slot, p.rejoin.execute-1)
+	p.repair = -1
+	p.repairHole()
+	p.catchup(p.rejoin.execute, p.rejoin.source)
+}
+
+// rejoined ends rejoin once this node executes every slot executed by its rejoin quorum
+func (p *Paxos) rejoined() {
+	if p.rejoin.stale && p.rejoin.acks != nil && p.election != rejoining && p.execute >= p.rejoin.execute {
+		p.fresh()
+	}
+}
+
+// fresh ends rejoin, phase 1 and local reads are allowed again
+func (p *Paxos) fresh() {
+	log.Infow("rejoined", log.Fields{"id": p.ID(), "ballot": p.ballot, "execute": p.execute})
+	p.rejoin = rejoin{}
+}
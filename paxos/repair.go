@@ -0,0 +1,94 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi/log"
+)
+
+// repairHole requests missing or uncommitted slot that blocks execution from the leader
+func (p *Paxos) repairHole() {
+	if (!p.Repair && !p.rejoin.stale) || p.active || p.execute > p.slot || p.repair == p.execute {
+		return
+	}
+	if e, exists := p.log[p.execute]; exists && e.commit {
+		return
+	}
+	leader := p.ballot.ID()
+	if p.rejoin.stale && p.rejoin.source != "" {
+		// returning node catches up from the most up to date node of its rejoin quorum
+		leader = p.rejoin.source
+	} else if p.ballot == 0 {
+		return
+	}
+	if leader == p.ID() {
+		return
+	}
+	log.Debugw("repair", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": p.execute, "type": "RepairRequest", "to": leader})
+	p.repair = p.execute
+	p.Send(leader, RepairRequest{
+		ID:   p.ID(),
+		Slot: p.execute,
+	})
+	if p.RepairTimeout > 0 {
+		s := p.execute
+		p.After(p.RepairTimeout, func() { p.unrepaired(s) })
+	}
+}
+
+// unrepaired requests slot s again if its repair request or reply is lost
+func (p *Paxos) unrepaired(s int) {
+	if p.repair != s {
+		return
+	}
+	log.Debugw("repair timeout", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": s})
+	p.rerepair()
+}
+
+// rerepair forgets the pending repair and requests the slot blocking execution again, on every
+// leader heartbeat as its repair request or reply might be lost, and on repair timeout
+func (p *Paxos) rerepair() {
+	p.repair = -1
+	p.repairHole()
+}
+
+// HandleRepairRequest replies the requested log entry if it is still in the log,
+// or snapshot if the entry is compacted or otherwise gone after execution
+func (p *Paxos) HandleRepairRequest(m RepairRequest) {
+	e, exists := p.log[m.Slot]
+	if p.compacted(m.Slot) || !exists && m.Slot < p.execute {
+		p.HandleSnapshotRequest(SnapshotRequest{ID: m.ID, LastExecute: m.Slot})
+		return
+	}
+	if !exists {
+		return
+	}
+	p.backfill(m.ID, RepairReply{
+		Slot:          m.Slot,
+		CommandBallot: CommandBallot{e.commands, e.ballot},
+		Commit:        e.commit,
+	}, footprint(e.commands))
+}
+
+// HandleRepairReply installs the missing entry and resumes execution
+func (p *Paxos) HandleRepairReply(m RepairReply) {
+	if m.Slot == p.repair {
+		p.repair = -1
+	}
+	if m.Slot < p.execute {
+		return
+	}
+	p.update(map[int]CommandBallot{m.Slot: m.CommandBallot})
+	e := p.log[m.Slot]
+	if m.Commit && p.overwrites(m.Slot, e, m.Ballot, m.Commands) {
+		return
+	}
+	if m.Commit && !e.commit {
+		e.commands = m.Commands
+		e.ballot = m.Ballot
+		e.commit = true
+		p.metrics.inc(&p.metrics.committed)
+	}
+	p.appendEntry(m.Slot)
+	if e.commit {
+		p.exec()
+	}
+}
@@ -1,8 +1,13 @@
 package paxos
 
 import (
+	"encoding/json"
 	"flag"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ailidani/paxi"
@@ -12,6 +17,7 @@ import (
 var ephemeralLeader = flag.Bool("ephemeral_leader", false, "stable leader, if true paxos forward request to current leader")
 var readQuorum = flag.Bool("read_quorum", false, "read from quorum of replicas")
 var readLeader = flag.Bool("read_leader", false, "read from leader of current ballot")
+var walDir = flag.String("wal_dir", "", "directory for write-ahead log files, persistence is disabled if empty")
 
 const (
 	HTTPHeaderSlot    = "Slot"
@@ -23,48 +29,292 @@ const (
 type Replica struct {
 	paxi.Node
 	*Paxos
+
+	recorder *Recorder // recorder of inbound phase 1 and phase 2 messages, nil if not recording
 }
 
 // NewReplica generates new Paxos replica
 func NewReplica(id paxi.ID) *Replica {
+	return newReplica(paxi.NewNode(id))
+}
+
+// newReplica generates Paxos replica on node n, extra options are applied after the ones from config
+func newReplica(n paxi.Node, extra ...func(*Paxos)) *Replica {
+	return NewReplicaOn(n, "wal."+string(n.ID()), extra...)
+}
+
+// NewReplicaOn generates Paxos replica on node n, which may be shared with other instances,
+// with write-ahead log file wal under -wal_dir. Extra options are applied after the ones from config.
+func NewReplicaOn(n paxi.Node, wal string, extra ...func(*Paxos)) *Replica {
 	r := new(Replica)
-	r.Node = paxi.NewNode(id)
-	r.Paxos = NewPaxos(r)
+	r.Node = n
+	config := paxi.GetConfig()
+	options := []func(*Paxos){
+		func(p *Paxos) { p.StateTransfer = true },
+		func(p *Paxos) { p.Repair = true },
+		func(p *Paxos) {
+			p.Heartbeat = time.Duration(config.Heartbeat) * time.Millisecond
+			p.Timeout = time.Duration(config.Timeout) * time.Millisecond
+			p.MaxTimeout = time.Duration(config.MaxTimeout) * time.Millisecond
+			p.AdaptiveHeartbeat = config.AdaptiveHeartbeat
+			p.MinHeartbeat = time.Duration(config.MinHeartbeat) * time.Millisecond
+			p.MinTimeout = time.Duration(config.MinTimeout) * time.Millisecond
+			p.PhiThreshold = config.PhiThreshold
+			p.PreVote = config.PreVote
+			p.Fast = config.Fast
+		},
+	}
+	if *walDir != "" {
+		path := filepath.Join(*walDir, wal)
+		var storage Storage
+		var err error
+		if config.SyncInterval > 0 || config.SyncBatch > 1 {
+			storage, err = NewGroupFileStorage(path)
+		} else {
+			storage, err = NewFileStorage(path)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, func(p *Paxos) { p.Storage = storage })
+	}
+	if config.Record != "" {
+		path := filepath.Join(config.Record, "record"+strings.TrimPrefix(wal, "wal"))
+		recorder, err := NewRecorder(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.recorder = recorder
+	}
+	options = append(options, extra...)
+	r.Paxos = NewPaxos(r, options...)
 	r.Register(paxi.Request{}, r.handleRequest)
-	r.Register(P1a{}, r.HandleP1a)
-	r.Register(P1b{}, r.HandleP1b)
-	r.Register(P2a{}, r.HandleP2a)
-	r.Register(P2b{}, r.HandleP2b)
+	r.Register(paxi.Transaction{}, r.HandleTransaction)
+	r.Register(P1a{}, r.recordP1a)
+	r.Register(P1b{}, r.recordP1b)
+	r.Register(P2a{}, r.recordP2a)
+	r.Register(P2b{}, r.recordP2b)
 	r.Register(P3{}, r.HandleP3)
+	r.Register(SnapshotRequest{}, r.HandleSnapshotRequest)
+	r.Register(SnapshotReply{}, r.HandleSnapshotReply)
+	r.Register(Heartbeat{}, r.HandleHeartbeat)
+	r.Register(ReadIndex{}, r.HandleReadIndex)
+	r.Register(ReadIndexAck{}, r.HandleReadIndexAck)
+	r.Register(QuorumRead{}, r.HandleQuorumRead)
+	r.Register(QuorumReadAck{}, r.HandleQuorumReadAck)
+	r.Register(RepairRequest{}, r.HandleRepairRequest)
+	r.Register(RepairReply{}, r.HandleRepairReply)
+	r.Register(TimeoutNow{}, r.HandleTimeoutNow)
+	r.Register(PreVote{}, r.HandlePreVote)
+	r.Register(PreVoteReply{}, r.HandlePreVoteReply)
+	r.Register(Digest{}, r.HandleDigest)
+	r.Register(Rejoin{}, r.HandleRejoin)
+	r.Register(RejoinAck{}, r.HandleRejoinAck)
+	r.Register(LeaderSlot{}, r.HandleLeaderSlot)
+	r.Register(LeaderSlotAck{}, r.HandleLeaderSlotAck)
+	r.HandleHTTP("/log", r.handleLog)
+	r.HandleHTTP("/metrics", r.handleMetrics)
+	r.HandleHTTP("/status", r.handleStatus)
+	r.HandleHTTP("/quorum", r.handleQuorum)
+	r.HandleHTTP("/stepdown", r.handleStepDown)
+	r.HandleHTTP("/promote", r.handlePromote)
+	r.HandleHTTP("/drain", r.handleDrain)
+	r.HandleHTTP("/register", r.handleRegister)
 	return r
 }
 
+// handleLog replies log entries in json for debugging
+func (r *Replica) handleLog(w http.ResponseWriter, req *http.Request) {
+	err := json.NewEncoder(w).Encode(r.LogSnapshot())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleStatus replies current protocol state in json
+func (r *Replica) handleStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(r.Status())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleQuorum replies phase 1 and phase 2 quorum sizes of the executed membership, a PUT with q1 and q2
+// query parameters proposes the resize through the log first, which takes effect once executed
+func (r *Replica) handleQuorum(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPut || req.Method == http.MethodPost {
+		q1, err1 := strconv.Atoi(req.URL.Query().Get("q1"))
+		q2, err2 := strconv.Atoi(req.URL.Query().Get("q2"))
+		if err1 != nil || err2 != nil {
+			http.Error(w, "invalid q1 or q2", http.StatusBadRequest)
+			return
+		}
+		c := make(chan error, 1)
+		r.After(0, func() {
+			c <- r.Paxos.Resize(q1, q2)
+		})
+		if err := <-c; err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Infof("replica %s proposed quorums of q1 %d q2 %d", r.ID(), q1, q2)
+	}
+	c := make(chan []int, 1)
+	r.After(0, func() {
+		q1, q2 := r.Paxos.Sizes(r.Paxos.execute)
+		c <- []int{q1, q2}
+	})
+	sizes := <-c
+	err := json.NewEncoder(w).Encode(map[string]int{"q1": sizes[0], "q2": sizes[1]})
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleStepDown makes the leader step down on POST, requests of uncommitted slots are requeued if requeue=true
+func (r *Replica) handleStepDown(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut && req.Method != http.MethodPost {
+		http.Error(w, "step down requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	requeue := req.URL.Query().Get("requeue") == "true"
+	c := make(chan bool, 1)
+	r.After(0, func() {
+		c <- r.Paxos.StepDown(requeue)
+	})
+	if !<-c {
+		http.Error(w, "not leader", http.StatusConflict)
+		return
+	}
+}
+
+// handlePromote adds this caught up observer to the membership through the leader
+func (r *Replica) handlePromote(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut && req.Method != http.MethodPost {
+		http.Error(w, "promote requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := make(chan error, 1)
+	r.After(0, func() {
+		c <- r.Paxos.Promote()
+	})
+	if err := <-c; err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+}
+
+// handleDrain drains this node and replies once every accepted request is executed and leadership is handed over
+func (r *Replica) handleDrain(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut && req.Method != http.MethodPost {
+		http.Error(w, "drain requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.Paxos.Drain(req.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+}
+
+// handleRegister registers client of Id header through the log with Cid header as baseline and body as nonce,
+// and replies the registered client id, key of registration is reserved so clients cannot write it on root
+func (r *Replica) handleRegister(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut && req.Method != http.MethodPost {
+		http.Error(w, "register requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	baseline := 0
+	if cid := req.Header.Get(paxi.HTTPCommandID); cid != "" {
+		var err error
+		baseline, err = strconv.Atoi(cid)
+		if err != nil || baseline < 0 {
+			http.Error(w, "invalid baseline", http.StatusBadRequest)
+			return
+		}
+	}
+	nonce, err := ioutil.ReadAll(req.Body)
+	if err != nil || len(nonce) == 0 {
+		http.Error(w, "register requires nonce", http.StatusBadRequest)
+		return
+	}
+	m := paxi.NewRequest(NewRegister(paxi.ID(req.Header.Get(paxi.HTTPClientID)), baseline, string(nonce)), r.ID())
+	r.After(0, func() {
+		r.handleRequest(m)
+	})
+	reply := m.Wait()
+	if reply.Err != nil {
+		http.Error(w, reply.Err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reply.Leader != "" {
+		w.Header().Set(paxi.HTTPLeader, string(reply.Leader))
+		if addr, exists := paxi.GetConfig().HTTPAddrs[reply.Leader]; exists {
+			w.Header().Set("Location", addr+req.URL.RequestURI())
+		}
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+	_, err = w.Write(reply.Value)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
 func (r *Replica) handleRequest(m paxi.Request) {
 	log.Debugf("Replica %s received %v\n", r.ID(), m)
 
-	if m.Command.IsRead() && (*readQuorum || (*readLeader && r.Paxos.IsLeader())) {
-		v, s := r.read(m)
+	if r.Paxos.rejectDraining(&m) {
+		return
+	}
+
+	if m.Barrier && m.Command.IsRead() {
+		r.Paxos.HandleBarrierRead(m)
+		return
+	}
+
+	if m.Command.IsRead() && !r.Paxos.rejoin.stale && (*readQuorum || r.Paxos.Learner || (*readLeader && r.Paxos.IsLeader())) {
+		if m.Session > r.Paxos.execute {
+			// wait for earlier writes of the client session
+			r.Paxos.HandleSessionRead(m)
+			return
+		}
+		v, s, err := r.read(m)
 		reply := paxi.Reply{
 			Command:    m.Command,
 			Value:      v,
 			Properties: make(map[string]string),
-			Timestamp:  time.Now().Unix(),
+			Timestamp:  r.Clock.Now().Unix(),
+			Slot:       s,
+			Status:     status(err),
 		}
-		reply.Properties[HTTPHeaderSlot] = strconv.Itoa(s)
+		reply.Properties[HTTPHeaderSlot] = strconv.Itoa(reply.Slot)
 		reply.Properties[HTTPHeaderBallot] = r.Paxos.ballot.String()
 		reply.Properties[HTTPHeaderExecute] = strconv.Itoa(r.Paxos.execute - 1)
 		m.Reply(reply)
 		return
 	}
 
-	if *ephemeralLeader || r.Paxos.IsLeader() || r.Paxos.Ballot() == 0 {
+	if m.Command.IsRead() && r.Paxos.QuorumRead {
+		r.Paxos.ReadFromQuorum(m)
+		return
+	}
+
+	if m.Command.IsRead() && r.Paxos.IsLeader() {
+		r.Paxos.HandleReadRequest(m)
+		return
+	}
+
+	if (*ephemeralLeader || paxi.GetConfig().Ephemeral) && !r.Paxos.Learner {
 		r.Paxos.HandleRequest(m)
+	} else if paxi.GetConfig().Proxy {
+		r.Paxos.Proxy(m)
 	} else {
-		go r.Forward(r.Paxos.Leader(), m)
+		r.Paxos.ReplyLeader(m)
 	}
 }
 
-func (r *Replica) read(m paxi.Request) (paxi.Value, int) {
+func (r *Replica) read(m paxi.Request) (paxi.Value, int, error) {
 	// TODO
 	// (1) last slot is read?
 	// (2) entry in log over writen
@@ -73,11 +323,17 @@ func (r *Replica) read(m paxi.Request) (paxi.Value, int) {
 	// is in progress
 	for i := r.Paxos.execute; i <= r.Paxos.slot; i++ {
 		entry, exist := r.Paxos.log[i]
-		if exist && entry.command.Key == m.Command.Key {
-			return entry.command.Value, r.Paxos.slot
+		if !exist {
+			continue
+		}
+		for _, cmd := range entry.commands {
+			if cmd.Key == m.Command.Key {
+				return cmd.Value, r.Paxos.slot, nil
+			}
 		}
 	}
 
 	// not in progress key
-	return r.Node.Execute(m.Command), 0
+	v, err := r.Paxos.query(m.Command)
+	return v, 0, err
 }
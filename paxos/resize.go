@@ -0,0 +1,60 @@
+package paxos
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ailidani/paxi"
+)
+
+// ResizeKey is the reserved key of commands that resize quorums of the membership
+const ResizeKey paxi.Key = -3
+
+// NewResize returns the command that changes phase 1 and phase 2 quorum sizes of the membership to q1 and q2
+// once executed, zero size means majority
+func NewResize(q1, q2 int) paxi.Command {
+	return paxi.Command{
+		Key:      ResizeKey,
+		Value:    paxi.Value(strconv.Itoa(q1) + "," + strconv.Itoa(q2)),
+		Protocol: true,
+	}
+}
+
+// IsResize returns true if command c resizes quorums
+func IsResize(c paxi.Command) bool {
+	return c.Protocol && c.Key == ResizeKey && c.Value != nil
+}
+
+// sizes decodes quorum sizes of resize command c, nil if malformed
+func sizes(c paxi.Command) []int {
+	s := strings.Split(string(c.Value), ",")
+	if len(s) != 2 {
+		return nil
+	}
+	q1, err1 := strconv.Atoi(s[0])
+	q2, err2 := strconv.Atoi(s[1])
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	return []int{q1, q2}
+}
+
+// Resize proposes new quorum sizes through the log like a reconfiguration, so every replica changes sizes
+// at the same slot and keeps them across restarts. Sizes must intersect each other and the current sizes,
+// which is checked again when the command executes in case another change is ordered before it.
+func (p *Paxos) Resize(q1, q2 int) error {
+	if err := p.effective(p.slot+1).config().Resizable(q1, q2); err != nil {
+		return err
+	}
+	p.HandleRequest(paxi.Request{Command: NewResize(q1, q2)})
+	return nil
+}
+
+// Sizes returns phase 1 and phase 2 quorum sizes effective at given slot, zero size means majority
+func (p *Paxos) Sizes(slot int) (int, int) {
+	if s := p.effective(slot).sizes; s != nil {
+		return s[0], s[1]
+	}
+	config := paxi.GetConfig()
+	return config.Q1Size, config.Q2Size
+}
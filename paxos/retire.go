@@ -0,0 +1,87 @@
+package paxos
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// ErrDraining is replied to new client request while node is draining
+var ErrDraining = errors.New("node is draining")
+
+// Drain stops accepting new client requests, waits until requests already accepted are executed,
+// and hands leadership over to the most preferred nearest peer if this node leads, so that the node
+// can exit without failing any request. It returns once drained or ctx is done, the node keeps rejecting
+// new requests in either case and never starts phase 1 again.
+// It waits for the message handling goroutine thus must not be called from a handler.
+func (p *Paxos) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	p.After(0, func() { p.retire(done) })
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Draining returns true if node rejects new client requests
+func (p *Paxos) Draining() bool {
+	return p.draining
+}
+
+// retire starts draining, done is closed once drained
+func (p *Paxos) retire(done chan struct{}) {
+	if !p.draining {
+		log.Infow("drain", log.Fields{"id": p.ID(), "ballot": p.ballot, "active": p.active, "slot": p.slot, "execute": p.execute})
+	}
+	p.draining = true
+	p.drained = append(p.drained, done)
+	p.flush()
+	p.retired()
+}
+
+// retired closes drained channel and transfers leadership once every accepted request is executed
+func (p *Paxos) retired() {
+	if len(p.drained) == 0 || len(p.requests) > 0 || len(p.batch) > 0 || len(p.txns) > 0 || p.execute <= p.slot {
+		return
+	}
+	if p.active {
+		if target := p.successor(); target != "" {
+			p.TransferLeadership(target)
+		} else {
+			p.active = false
+			p.observe()
+		}
+	}
+	for _, c := range p.drained {
+		close(c)
+	}
+	p.drained = nil
+}
+
+// successor returns the peer of highest priority to take over leadership, nearest first among equals,
+// empty if there is no other voter
+func (p *Paxos) successor() paxi.ID {
+	var target paxi.ID
+	for _, id := range p.nearest(p.slot + 1) {
+		if target == "" || p.priority(id) > p.priority(target) {
+			target = id
+		}
+	}
+	return target
+}
+
+// rejectDraining replies ErrDraining to request r and returns true while draining
+func (p *Paxos) rejectDraining(r *paxi.Request) bool {
+	if !p.draining {
+		return false
+	}
+	r.Reply(paxi.Reply{
+		Command: r.Command,
+		Err:     ErrDraining,
+	})
+	return true
+}
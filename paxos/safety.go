@@ -0,0 +1,23 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// violation is called when a committed slot is about to change to different commands,
+// the log can no longer be trusted so the replica exits, debug builds panic instead
+var violation = func(format string, args ...interface{}) {
+	log.Fatalf(format, args...)
+}
+
+// overwrites reports chosen commands of committed entry e in slot s being replaced with different commands,
+// which means two values are chosen for one slot, returns true if commands differ
+func (p *Paxos) overwrites(s int, e *entry, b paxi.Ballot, commands []paxi.Command) bool {
+	if e == nil || !e.commit || equal(e.commands, commands) {
+		return false
+	}
+	violation("safety violation: replica %s slot %d committed %v in ballot %v, overwritten with %v in ballot %v",
+		p.ID(), s, e.commands, e.ballot, commands, b)
+	return true
+}
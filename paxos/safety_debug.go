@@ -0,0 +1,14 @@
+//go:build debug
+// +build debug
+
+package paxos
+
+import (
+	"fmt"
+)
+
+func init() {
+	violation = func(format string, args ...interface{}) {
+		panic(fmt.Sprintf(format, args...))
+	}
+}
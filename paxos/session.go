@@ -0,0 +1,62 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+)
+
+// session is the last executed command of one client
+type session struct {
+	CommandID  int
+	Value      paxi.Value
+	Status     string // error of state machine rejecting the command, empty if applied
+	Slot       int
+	Registered bool // client is registered through the log, its session is kept past the log window
+}
+
+// duplicate returns the cached value and status if cmd has already been executed for its client
+func (p *Paxos) duplicate(cmd paxi.Command) (paxi.Value, string, bool) {
+	if !p.Dedup || cmd.ClientID == "" {
+		return nil, "", false
+	}
+	s, exists := p.sessions[cmd.ClientID]
+	if !exists || cmd.CommandID > s.CommandID {
+		return nil, "", false
+	}
+	if cmd.CommandID < s.CommandID {
+		// older result is gone, client has moved on
+		return nil, "", true
+	}
+	return s.Value, s.Status, true
+}
+
+// record remembers value and status of the last executed command of each client, executed in slot s
+func (p *Paxos) record(s int, cmd paxi.Command, value paxi.Value, rejected string) {
+	if !p.Dedup || cmd.ClientID == "" {
+		return
+	}
+	p.sessions[cmd.ClientID] = session{
+		CommandID:  cmd.CommandID,
+		Value:      value,
+		Status:     rejected,
+		Slot:       s,
+		Registered: p.sessions[cmd.ClientID].Registered,
+	}
+}
+
+// expire deletes sessions of unregistered clients inactive for session window slots, or whose last command
+// is no longer in the log window if session window is 0. A client retrying a command after its session
+// expired has it executed again, clients that can be slower than that get exactly once only by Register.
+func (p *Paxos) expire() {
+	horizon := p.low
+	if p.SessionWindow > 0 {
+		horizon = p.execute - p.SessionWindow
+	}
+	if horizon <= 0 {
+		return
+	}
+	for id, s := range p.sessions {
+		if s.Slot < horizon && !s.Registered {
+			delete(p.sessions, id)
+		}
+	}
+}
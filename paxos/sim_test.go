@@ -0,0 +1,320 @@
+package paxos
+
+import (
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ailidani/paxi"
+)
+
+// max delay of a message in simulated network
+const latency = 10 * time.Millisecond
+
+// simulation runs replicas in one goroutine over a simulated network that delays, drops and duplicates messages,
+// network delivery, faults and client load are drawn from a seeded random source so that the seed reported by a failure replays it
+type simulation struct {
+	t     *testing.T
+	seed  int64
+	rand  *rand.Rand
+	clock *clock
+
+	ids    []paxi.ID
+	nodes  map[paxi.ID]*simNode
+	queue  []envelope
+	timers []*timer
+	seq    int
+
+	drop      float64                // chance a message is lost
+	dup       float64                // chance a message is delivered and sent again to be delivered later
+	partition map[paxi.ID]int        // messages between different groups are lost
+	crashed   map[paxi.ID]bool       // crashed node receives nothing and its timers wait until restart
+	chosen    map[int][]paxi.Command // committed commands of every slot seen so far
+	hashes    map[int]uint64         // digest of every executed slot seen so far
+	cid       int
+}
+
+type envelope struct {
+	due time.Time
+	to  paxi.ID
+	m   interface{}
+}
+
+type timer struct {
+	due time.Time
+	seq int
+	id  paxi.ID
+	f   func()
+}
+
+// simNode is paxi.Node of one replica in simulation
+type simNode struct {
+	paxi.Database
+	id      paxi.ID
+	sim     *simulation
+	handles map[string]reflect.Value
+	replica *Replica
+}
+
+func newSimulation(t *testing.T, seed int64, n int) *simulation {
+	s := &simulation{
+		t:         t,
+		seed:      seed,
+		rand:      rand.New(rand.NewSource(seed)),
+		clock:     &clock{now: time.Unix(0, 0)},
+		nodes:     make(map[paxi.ID]*simNode),
+		partition: make(map[paxi.ID]int),
+		crashed:   make(map[paxi.ID]bool),
+		chosen:    make(map[int][]paxi.Command),
+		hashes:    make(map[int]uint64),
+	}
+	for i := 1; i <= n; i++ {
+		s.ids = append(s.ids, paxi.NewID(1, i))
+	}
+	for _, id := range s.ids {
+		node := &simNode{
+			Database: paxi.NewDatabase(),
+			id:       id,
+			sim:      s,
+			handles:  make(map[string]reflect.Value),
+		}
+		s.nodes[id] = node
+		node.replica = newReplica(node, func(p *Paxos) {
+			p.memberships = []membership{{slot: 0, ids: s.ids}}
+			p.Clock = s.clock
+			p.Rand = rand.New(rand.NewSource(s.rand.Int63()))
+			p.Heartbeat = 20 * time.Millisecond
+			p.Timeout = 100 * time.Millisecond
+			p.OutOfOrder = seed%2 == 0
+		})
+	}
+	return s
+}
+
+// send queues message m to be delivered after random delay within latency, so messages are reordered
+func (s *simulation) send(to paxi.ID, m interface{}) {
+	d := time.Millisecond + time.Duration(s.rand.Int63n(int64(latency)))
+	s.queue = append(s.queue, envelope{due: s.clock.now.Add(d), to: to, m: m})
+}
+
+func (s *simulation) after(id paxi.ID, d time.Duration, f func()) {
+	s.seq++
+	t := &timer{due: s.clock.now.Add(d), seq: s.seq, id: id, f: f}
+	i := sort.Search(len(s.timers), func(i int) bool {
+		return s.timers[i].due.After(t.due)
+	})
+	s.timers = append(s.timers, nil)
+	copy(s.timers[i+1:], s.timers[i:])
+	s.timers[i] = t
+}
+
+// step advances clock by 1ms, fires due timers and delivers due messages in random order
+func (s *simulation) step() {
+	s.clock.now = s.clock.now.Add(time.Millisecond)
+	for len(s.timers) > 0 && !s.timers[0].due.After(s.clock.now) {
+		t := s.timers[0]
+		s.timers = s.timers[1:]
+		if s.crashed[t.id] {
+			s.after(t.id, 10*time.Millisecond, t.f)
+			continue
+		}
+		t.f()
+		s.check()
+	}
+
+	due := make([]envelope, 0)
+	queue := s.queue[:0]
+	for _, e := range s.queue {
+		if e.due.After(s.clock.now) {
+			queue = append(queue, e)
+		} else {
+			due = append(due, e)
+		}
+	}
+	s.queue = queue
+	s.rand.Shuffle(len(due), func(i, j int) { due[i], due[j] = due[j], due[i] })
+	for _, e := range due {
+		if s.rand.Float64() < s.dup {
+			s.send(e.to, e.m)
+		}
+		if s.rand.Float64() < s.drop || s.crashed[e.to] {
+			continue
+		}
+		s.deliver(e)
+		s.check()
+	}
+}
+
+func (s *simulation) deliver(e envelope) {
+	node := s.nodes[e.to]
+	if r, ok := e.m.(paxi.Request); ok {
+		// replica handler forwards in a new goroutine
+		node.replica.Paxos.HandleRequest(r)
+		return
+	}
+	if _, ok := e.m.(paxi.Reply); ok {
+		return
+	}
+	f, exists := node.handles[reflect.TypeOf(e.m).String()]
+	if !exists {
+		s.t.Fatalf("seed %d: no handler of %T", s.seed, e.m)
+	}
+	f.Call([]reflect.Value{reflect.ValueOf(e.m)})
+}
+
+// check asserts no two replicas commit different commands in the same slot or execute different history up to it
+func (s *simulation) check() {
+	for _, id := range s.ids {
+		p := s.nodes[id].replica.Paxos
+		for slot, e := range p.log {
+			if slot < p.execute {
+				h, exists := s.hashes[slot]
+				if !exists {
+					s.hashes[slot] = e.hash
+				} else if h != e.hash {
+					s.t.Fatalf("seed %d: replica %s executes history of digest %x up to slot %d, expected %x", s.seed, id, e.hash, slot, h)
+				}
+			}
+			if !e.commit {
+				continue
+			}
+			c, exists := s.chosen[slot]
+			if !exists {
+				s.chosen[slot] = e.commands
+			} else if !equal(c, e.commands) {
+				s.t.Fatalf("seed %d: replica %s commits %v in slot %d chosen as %v", s.seed, id, e.commands, slot, c)
+			}
+		}
+	}
+}
+
+// submit sends a new client request to random replica that is up
+func (s *simulation) submit() {
+	id := s.ids[s.rand.Intn(len(s.ids))]
+	if s.crashed[id] {
+		return
+	}
+	s.cid++
+	s.nodes[id].replica.Paxos.HandleRequest(paxi.Request{Command: paxi.Command{
+		Key:       paxi.Key(s.rand.Intn(10)),
+		Value:     paxi.Value("v"),
+		ClientID:  "9.1",
+		CommandID: s.cid,
+	}})
+}
+
+// split assigns every node to one of two random groups
+func (s *simulation) split() {
+	for _, id := range s.ids {
+		s.partition[id] = s.rand.Intn(2)
+	}
+}
+
+func (s *simulation) heal() {
+	s.partition = make(map[paxi.ID]int)
+}
+
+func (s *simulation) crash() {
+	s.crashed[s.ids[s.rand.Intn(len(s.ids))]] = true
+}
+
+func (s *simulation) restart() {
+	s.crashed = make(map[paxi.ID]bool)
+}
+
+func (n *simNode) ID() paxi.ID          { return n.id }
+func (n *simNode) Run()                 {}
+func (n *simNode) Retry(r paxi.Request) { n.sim.send(n.id, r) }
+func (n *simNode) Register(m interface{}, f interface{}) {
+	n.handles[reflect.TypeOf(m).String()] = reflect.ValueOf(f)
+}
+func (n *simNode) After(d time.Duration, f func())     { n.sim.after(n.id, d, f) }
+func (n *simNode) HandleHTTP(string, http.HandlerFunc) {}
+func (n *simNode) Recv() interface{}                   { return nil }
+func (n *simNode) Close()                              {}
+func (n *simNode) Drop(id paxi.ID, t int)              {}
+func (n *simNode) Slow(id paxi.ID, d int, t int)       {}
+func (n *simNode) Flaky(id paxi.ID, p float32, t int)  {}
+func (n *simNode) Crash(t int)                         {}
+
+func (n *simNode) Forward(id paxi.ID, r paxi.Request) {
+	r.NodeID = n.id
+	n.Send(id, r)
+}
+
+func (n *simNode) Send(to paxi.ID, m interface{}) {
+	if n.sim.partition[n.id] != n.sim.partition[to] {
+		return
+	}
+	n.sim.send(to, m)
+}
+
+func (n *simNode) MulticastZone(zone int, m interface{}) {
+	for _, id := range n.sim.ids {
+		if id != n.id && id.Zone() == zone {
+			n.Send(id, m)
+		}
+	}
+}
+
+func (n *simNode) MulticastQuorum(quorum int, m interface{}) {
+	i := 0
+	for _, id := range n.sim.ids {
+		if id == n.id {
+			continue
+		}
+		if i == quorum {
+			return
+		}
+		n.Send(id, m)
+		i++
+	}
+}
+
+func (n *simNode) Broadcast(m interface{}) {
+	for _, id := range n.sim.ids {
+		if id != n.id {
+			n.Send(id, m)
+		}
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		s := newSimulation(t, seed, 5)
+		s.drop, s.dup = 0.05, 0.05
+		for i := 0; i < 5000; i++ {
+			switch r := s.rand.Intn(1000); {
+			case r < 50:
+				s.submit()
+			case r < 52:
+				s.split()
+			case r < 56:
+				s.heal()
+			case r < 58:
+				s.crash()
+			case r < 62:
+				s.restart()
+			}
+			s.step()
+		}
+
+		// stable network commits again
+		s.heal()
+		s.restart()
+		s.drop, s.dup = 0, 0
+		committed := len(s.chosen)
+		for i := 0; i < 2000; i++ {
+			if i%20 == 0 {
+				s.submit()
+			}
+			s.step()
+		}
+		if len(s.chosen) <= committed {
+			t.Errorf("seed %d: no slot committed after network heals, %d committed", seed, committed)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package paxos
+
+import (
+	"sort"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// bytes of phase 2 message besides its commands, kept free when splitting batches below MaxMessageSize
+const messageOverhead = 256
+
+// oversize returns true and replies error if request r alone cannot fit in one phase 2 message
+func (p *Paxos) oversize(r *paxi.Request) bool {
+	if p.MaxMessageSize <= 0 || footprint([]paxi.Command{r.Command})+messageOverhead <= p.MaxMessageSize {
+		return false
+	}
+	log.Warningw("request exceeds max message size", log.Fields{"id": p.ID(), "key": r.Command.Key, "size": len(r.Command.Value), "max": p.MaxMessageSize})
+	r.Reply(paxi.Reply{
+		Command: r.Command,
+		Err:     paxi.ErrMessageTooLarge,
+	})
+	return true
+}
+
+// parts splits log of promise m into promises whose commands fit in one message each, so that a long log
+// still reaches the candidate, which counts the promise once every part arrives
+func (p *Paxos) parts(m P1b) []P1b {
+	if p.MaxMessageSize <= 0 {
+		return []P1b{m}
+	}
+	slots := make([]int, 0, len(m.Log))
+	for s := range m.Log {
+		slots = append(slots, s)
+	}
+	sort.Ints(slots)
+	logs := []map[int]CommandBallot{make(map[int]CommandBallot)}
+	size := messageOverhead
+	for _, s := range slots {
+		n := footprint(m.Log[s].Commands) + commandOverhead
+		if len(logs[len(logs)-1]) > 0 && size+n > p.MaxMessageSize {
+			logs = append(logs, make(map[int]CommandBallot))
+			size = messageOverhead
+		}
+		logs[len(logs)-1][s] = m.Log[s]
+		size += n
+	}
+	if len(logs) == 1 {
+		return []P1b{m}
+	}
+	parts := make([]P1b, len(logs))
+	for i, l := range logs {
+		parts[i] = m
+		parts[i].Log, parts[i].Part, parts[i].Parts = l, i, len(logs)
+	}
+	return parts
+}
+
+// split splits requests into batches in order, so that commands of each batch fit in one phase 2 message
+func (p *Paxos) split(requests []*paxi.Request) [][]*paxi.Request {
+	if p.MaxMessageSize <= 0 {
+		return [][]*paxi.Request{requests}
+	}
+	batches := make([][]*paxi.Request, 0, 1)
+	start, size := 0, messageOverhead
+	for i, r := range requests {
+		n := footprint([]paxi.Command{r.Command})
+		if i > start && size+n > p.MaxMessageSize {
+			batches = append(batches, requests[start:i])
+			start, size = i, messageOverhead
+		}
+		size += n
+	}
+	return append(batches, requests[start:])
+}
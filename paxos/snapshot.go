@@ -0,0 +1,168 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// catchup requests state transfer from leader if slot is out of the log window of current execute
+func (p *Paxos) catchup(slot int, leader paxi.ID) {
+	window := p.LogWindow
+	if !p.StateTransfer || p.transfer || window <= 0 || leader == p.ID() {
+		return
+	}
+	if slot-p.execute > window {
+		log.Debugw("catchup", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": p.execute, "type": "SnapshotRequest", "to": leader})
+		p.fetch(leader, p.execute)
+	}
+}
+
+// fetch requests snapshot of every slot after last from node id, and sends the request again
+// if neither the snapshot nor any of its chunks arrives within transfer timeout
+func (p *Paxos) fetch(id paxi.ID, last int) {
+	p.transfer = true
+	p.fetches++
+	p.fetched = id
+	p.progress = p.Clock.Now()
+	p.Send(id, SnapshotRequest{
+		ID:          p.ID(),
+		LastExecute: last,
+	})
+	if p.TransferTimeout > 0 {
+		n := p.fetches
+		p.After(p.TransferTimeout, func() { p.refetch(n) })
+	}
+}
+
+// refetch resends snapshot request n unless it was answered or superseded, or waits longer while chunks arrive
+func (p *Paxos) refetch(n int) {
+	if !p.transfer || n != p.fetches {
+		return
+	}
+	if wait := p.TransferTimeout - p.since(p.progress); wait > 0 {
+		p.After(wait, func() { p.refetch(n) })
+		return
+	}
+	last := p.execute
+	if p.diverged {
+		last = -1
+	}
+	log.Warningw("snapshot request timeout", log.Fields{"id": p.ID(), "ballot": p.ballot, "execute": p.execute, "to": p.fetched})
+	p.partial = nil
+	p.fetch(p.fetched, last)
+}
+
+// compacted returns true if slot s is before the compaction point, i.e. executed and dropped from the log
+func (p *Paxos) compacted(s int) bool {
+	return s < p.low
+}
+
+// compaction returns first slot new leader proposes again after phase 1. Slots before the highest
+// compaction point of its phase 1 quorum are chosen but their commands are no longer reported,
+// so they are never filled with no-op, the leader installs snapshot of the compacting node instead.
+func (p *Paxos) compaction() int {
+	if p.floor <= p.execute {
+		return p.execute
+	}
+	log.Infow("behind compaction point", log.Fields{"id": p.ID(), "ballot": p.ballot, "execute": p.execute, "floor": p.floor, "donor": p.donor})
+	p.slot = paxi.Max(p.slot, p.floor-1)
+	p.fetch(p.donor, p.execute)
+	return p.floor
+}
+
+// HandleSnapshotRequest replies executed state to lagging replica, or an empty snapshot of its own
+// execute if the requester is not behind, so that the requester stops waiting and asks again later
+func (p *Paxos) HandleSnapshotRequest(m SnapshotRequest) {
+	if m.LastExecute >= p.execute {
+		p.Send(m.ID, SnapshotReply{Ballot: p.ballot, Slot: p.execute})
+		return
+	}
+	p.quiesce()
+	sessions := make(map[paxi.ID]session, len(p.sessions))
+	for id, s := range p.sessions {
+		sessions[id] = s
+	}
+	reply := SnapshotReply{
+		Ballot:   p.ballot,
+		Slot:     p.execute,
+		State:    p.StateMachine.Snapshot(),
+		Sessions: sessions,
+		Members:  p.Members(p.execute),
+		Sizes:    p.effective(p.execute).sizes,
+		Executed: p.executed(),
+		Hash:     p.hash,
+	}
+	for _, c := range p.chunks(reply) {
+		size := len(c.State)
+		for _, r := range c.Executed {
+			size += footprint(r.Commands)
+		}
+		p.backfill(m.ID, c, size)
+	}
+}
+
+// HandleSnapshotReply installs received snapshot, or buffers its state until the last chunk arrives
+func (p *Paxos) HandleSnapshotReply(m SnapshotReply) {
+	if m.Total > 0 {
+		var complete bool
+		if m, complete = p.assemble(m); !complete {
+			return
+		}
+	}
+	p.InstallSnapshot(m)
+}
+
+// InstallSnapshot replaces state machine, client sessions, membership and digest with snapshot of every slot before
+// m.Slot and the executed slots after it, and drops obsolete log entries
+func (p *Paxos) InstallSnapshot(m SnapshotReply) {
+	p.transfer = false
+	if m.Slot <= p.execute && !p.diverged {
+		return
+	}
+	p.diverged = false
+	log.Infow("install snapshot", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": m.Slot, "execute": p.execute})
+	p.quiesce()
+	p.StateMachine.Restore(m.State)
+	p.sessions = m.Sessions
+	if p.sessions == nil {
+		p.sessions = make(map[paxi.ID]session)
+	}
+	for s := range p.log {
+		if s < m.Slot {
+			delete(p.log, s)
+		}
+	}
+	if m.Members != nil || m.Sizes != nil {
+		p.memberships = []membership{{slot: m.Slot, ids: m.Members, sizes: m.Sizes}}
+	}
+	if p.reconfig < m.Slot {
+		p.reconfig = -1
+	}
+	p.skipped(m.Slot)
+	p.execute = m.Slot
+	p.promoted()
+	p.low = m.Slot
+	p.slot = paxi.Max(p.slot, m.Slot-1)
+	p.hash = m.Hash
+	p.horizon = m.Slot
+	for _, e := range p.log {
+		// state of later slots is replaced as well, they execute again
+		e.executed = false
+	}
+	for _, r := range m.Executed {
+		e, exists := p.log[r.Slot]
+		if !exists {
+			e = &entry{}
+			p.log[r.Slot] = e
+		}
+		e.ballot = r.Ballot
+		e.commands = r.Commands
+		e.commit = true
+		e.executed = true
+		p.slot = paxi.Max(p.slot, r.Slot)
+		p.horizon = paxi.Max(p.horizon, r.Slot+1)
+	}
+	// keys executed before horizon are unknown
+	p.keys = make(map[paxi.Key]int)
+	p.exec()
+}
@@ -0,0 +1,36 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// stall schedules quorum timeout of slot s proposed in current ballot
+func (p *Paxos) stall(s int) {
+	if p.QuorumTimeout <= 0 {
+		return
+	}
+	b := p.ballot
+	p.After(p.QuorumTimeout, func() { p.stalled(s, b, 0) })
+}
+
+// stalled handles quorum timeout of slot s proposed in ballot b, after given number of retries.
+// The leader broadcasts P2a again up to QuorumRetries times, then gives up leadership and starts phase 1,
+// which proposes the slot again in a higher ballot or learns the command chosen by another leader.
+func (p *Paxos) stalled(s int, b paxi.Ballot, retries int) {
+	e, exists := p.log[s]
+	if !exists || e.commit || e.ballot != b || p.ballot != b || !p.active {
+		// committed, or slot is already resolved by another phase 1
+		return
+	}
+	if retries < p.QuorumRetries {
+		log.Debugw("quorum timeout", log.Fields{"id": p.ID(), "ballot": b, "slot": s, "retries": retries})
+		p.Broadcast(p.p2a(s, e.commands))
+		p.After(p.QuorumTimeout, func() { p.stalled(s, b, retries+1) })
+		return
+	}
+	log.Infow("step down", log.Fields{"id": p.ID(), "ballot": b, "slot": s, "retries": retries})
+	p.active = false
+	p.observe()
+	p.P1a()
+}
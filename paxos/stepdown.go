@@ -0,0 +1,41 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi/log"
+)
+
+// StepDown gives up leadership right away without waiting for a higher ballot, returns false if not leading.
+// Batched requests go back to pending requests, which are forwarded once another leader is known.
+// Uncommitted slots stay in the log for the next leader to recover in phase 1,
+// and if requeue is true their requests are detached and pending again instead of waiting on the slot,
+// so the client is answered by whichever slot executes the command, a duplicate is answered by its session.
+func (p *Paxos) StepDown(requeue bool) bool {
+	if !p.active {
+		return false
+	}
+	log.Infow("step down", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": p.slot, "requeue": requeue})
+	p.active = false
+	p.target = ""
+	for _, r := range p.batch {
+		p.enqueue(r)
+	}
+	p.batch = nil
+	if requeue {
+		for s := p.execute; s <= p.slot; s++ {
+			e, exists := p.log[s]
+			if !exists || e.commit {
+				continue
+			}
+			for _, r := range e.requests {
+				if r != nil {
+					p.enqueue(r)
+				}
+			}
+			e.requests = nil
+		}
+	}
+	// other replicas get a full leader timeout to take over before this node contends again
+	p.heard = p.Clock.Now()
+	p.observe()
+	return true
+}
@@ -0,0 +1,185 @@
+package paxos
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// Record is the persistent form of one log entry
+type Record struct {
+	Slot     int
+	Ballot   paxi.Ballot
+	Commands []paxi.Command
+	Commit   bool
+}
+
+// Storage persists paxos state so that a restarted node does not lose accepted or committed slots
+type Storage interface {
+	// AppendEntry durably records the accepted or committed entry
+	AppendEntry(r Record) error
+
+	// SaveBallot durably records the highest promised ballot
+	SaveBallot(b paxi.Ballot) error
+
+	// LoadAll returns the last saved ballot and every entry record in append order
+	LoadAll() (paxi.Ballot, []Record, error)
+}
+
+// wal is a write-ahead log file implementing Storage, one json record per line
+type wal struct {
+	sync.Mutex
+	path  string
+	file  *os.File
+	group bool // fsync only on Sync
+}
+
+// walRecord is either a ballot record or an entry record
+type walRecord struct {
+	Ballot paxi.Ballot `json:"b,omitempty"`
+	Entry  *Record     `json:"e,omitempty"`
+}
+
+// NewFileStorage opens or creates the write-ahead log file in path
+func NewFileStorage(path string) (Storage, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{
+		path: path,
+		file: file,
+	}, nil
+}
+
+// NewGroupFileStorage opens or creates the write-ahead log file in path that fsyncs only on Sync
+func NewGroupFileStorage(path string) (GroupStorage, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{
+		path:  path,
+		file:  file,
+		group: true,
+	}, nil
+}
+
+func (w *wal) write(r walRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	w.Lock()
+	defer w.Unlock()
+	_, err = w.file.Write(b)
+	if err != nil || w.group {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *wal) Sync() error {
+	w.Lock()
+	defer w.Unlock()
+	return w.file.Sync()
+}
+
+func (w *wal) AppendEntry(r Record) error {
+	return w.write(walRecord{Entry: &r})
+}
+
+func (w *wal) SaveBallot(b paxi.Ballot) error {
+	return w.write(walRecord{Ballot: b})
+}
+
+func (w *wal) LoadAll() (paxi.Ballot, []Record, error) {
+	file, err := os.Open(w.path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	var ballot paxi.Ballot
+	records := make([]Record, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var r walRecord
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			// torn write of the last record before crash
+			log.Warningf("wal %s skips broken record: %v", w.path, err)
+			continue
+		}
+		if r.Entry != nil {
+			records = append(records, *r.Entry)
+		} else if r.Ballot > ballot {
+			ballot = r.Ballot
+		}
+	}
+	return ballot, records, scanner.Err()
+}
+
+// saveBallot persists current ballot before it is promised to anyone
+func (p *Paxos) saveBallot() {
+	if p.Storage == nil {
+		return
+	}
+	err := p.Storage.SaveBallot(p.ballot)
+	if err != nil {
+		log.Fatalf("replica %s cannot save ballot %v: %v", p.ID(), p.ballot, err)
+	}
+	p.written()
+}
+
+// appendEntry persists log entry of slot s before any message about it is sent
+func (p *Paxos) appendEntry(s int) {
+	if p.Storage == nil {
+		return
+	}
+	e := p.log[s]
+	err := p.Storage.AppendEntry(Record{
+		Slot:     s,
+		Ballot:   e.ballot,
+		Commands: e.commands,
+		Commit:   e.commit,
+	})
+	if err != nil {
+		log.Fatalf("replica %s cannot append slot %d: %v", p.ID(), s, err)
+	}
+	p.written()
+}
+
+// replay rebuilds the log, ballot, slot and execute state from storage
+func (p *Paxos) replay() {
+	ballot, records, err := p.Storage.LoadAll()
+	if err != nil {
+		log.Fatalf("replica %s cannot load storage: %v", p.ID(), err)
+	}
+	p.ballot = ballot
+	for _, r := range records {
+		p.slot = paxi.Max(p.slot, r.Slot)
+		if r.Ballot > p.ballot {
+			p.ballot = r.Ballot
+		}
+		e, exists := p.log[r.Slot]
+		if !exists {
+			e = &entry{}
+			p.log[r.Slot] = e
+		}
+		if e.commit {
+			continue
+		}
+		e.ballot = r.Ballot
+		e.commands = r.Commands
+		e.commit = r.Commit
+	}
+	log.Infow("recovered", log.Fields{"id": p.ID(), "ballot": p.ballot, "slot": p.slot, "records": len(records)})
+	p.exec()
+}
@@ -0,0 +1,79 @@
+package paxos
+
+import (
+	"sync"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// Change is one committed command applied to the state machine, delivered to subscribers in execution order
+type Change struct {
+	Slot    int
+	Command paxi.Command
+	Value   paxi.Value // value returned by state machine
+	Status  string     // error of state machine rejecting the command, empty if applied
+}
+
+// changes is queue of changes applied by executor workers, each worker appends in slot order of its keys
+type changes struct {
+	sync.Mutex
+	queue []Change
+}
+
+// subscriber is callback of changes executed in slot from onwards
+type subscriber struct {
+	from int
+	f    func(Change)
+}
+
+// Subscribe registers f to be called with every write applied from slot from onwards, skipping reads, no-ops,
+// duplicates and membership changes. It must be called before the node runs, i.e. as an option of NewPaxos,
+// so that commands replayed from storage after restart are delivered too and a subscriber resumes from the slot
+// after the last change it processed. f runs in message handling goroutine and must not block. With executors,
+// changes of the same key are delivered in slot order, but changes of different keys may be delivered
+// out of slot order, as they are applied in parallel.
+func (p *Paxos) Subscribe(from int, f func(Change)) {
+	p.subscribers = append(p.subscribers, subscriber{from: from, f: f})
+}
+
+// publish delivers cmd applied in slot s with its value or rejection to subscribers
+func (p *Paxos) publish(s int, cmd paxi.Command, value paxi.Value, rejected string) {
+	if cmd.IsRead() {
+		return
+	}
+	for _, sub := range p.subscribers {
+		if s >= sub.from {
+			sub.f(Change{Slot: s, Command: cmd, Value: value, Status: rejected})
+		}
+	}
+}
+
+// changed queues change c applied by executor worker, for message handling goroutine to deliver.
+// Scheduled deliveries may run in any order, each one delivers every change queued so far in queue order.
+func (p *Paxos) changed(c Change) {
+	p.changes.Lock()
+	p.changes.queue = append(p.changes.queue, c)
+	p.changes.Unlock()
+	p.After(0, p.deliver)
+}
+
+// deliver publishes changes queued by executor workers in queue order
+func (p *Paxos) deliver() {
+	p.changes.Lock()
+	queue := p.changes.queue
+	p.changes.queue = nil
+	p.changes.Unlock()
+	for _, c := range queue {
+		p.publish(c.Slot, c.Command, c.Value, c.Status)
+	}
+}
+
+// skipped warns subscribers that commands from execute up to slot are restored by snapshot and never delivered
+func (p *Paxos) skipped(slot int) {
+	for _, sub := range p.subscribers {
+		if slot > p.execute && slot > sub.from {
+			log.Warningw("subscriber misses changes restored by snapshot", log.Fields{"id": p.ID(), "from": sub.from, "slot": slot})
+		}
+	}
+}
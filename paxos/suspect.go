@@ -0,0 +1,22 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+)
+
+// suspected returns peers whose last writes failed in a row, nil if none or node keeps no statistic
+func (p *Paxos) suspected() map[paxi.ID]bool {
+	if p.links == nil {
+		return nil
+	}
+	var ids map[paxi.ID]bool
+	for id, l := range p.links.Links() {
+		if l.Suspected {
+			if ids == nil {
+				ids = make(map[paxi.ID]bool)
+			}
+			ids[id] = true
+		}
+	}
+	return ids
+}
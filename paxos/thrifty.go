@@ -0,0 +1,44 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+)
+
+// peers returns the nearest nodes that form a phase 2 quorum of slot together with this node
+func (p *Paxos) peers(slot int) []paxi.ID {
+	ids := p.Members(slot)
+	if ids == nil {
+		ids = paxi.GetConfig().Voters()
+	}
+	size := len(ids)/2 + 1
+	if _, q := p.Sizes(slot); q > 0 {
+		size = q
+	}
+
+	peers := p.nearest(slot)
+	if len(peers) < len(ids) {
+		// this node acks its own proposal
+		size--
+	}
+	if size < 0 {
+		size = 0
+	}
+	if size > len(peers) {
+		size = len(peers)
+	}
+	return peers[:size]
+}
+
+// thrifty sends accept to the closest quorum only,
+// and broadcasts it to every node if the slot is not committed within thrifty timeout
+func (p *Paxos) thrifty(m P2a) {
+	for _, id := range p.peers(m.Slot) {
+		p.Send(id, m)
+	}
+	p.After(p.ThriftyTimeout, func() {
+		e, exists := p.log[m.Slot]
+		if exists && !e.commit && e.ballot == m.Ballot {
+			p.Broadcast(m)
+		}
+	})
+}
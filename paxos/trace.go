@@ -0,0 +1,68 @@
+package paxos
+
+import (
+	"time"
+
+	"github.com/ailidani/paxi"
+)
+
+// traces returns trace of slot entry e followed by other traces of its requests
+func traces(e *entry) []string {
+	ids := make([]string, 0)
+	if e.trace != "" {
+		ids = append(ids, e.trace)
+	}
+	for _, r := range e.requests {
+		if r == nil || r.TraceID == "" {
+			continue
+		}
+		exists := false
+		for _, id := range ids {
+			exists = exists || id == r.TraceID
+		}
+		if !exists {
+			ids = append(ids, r.TraceID)
+		}
+	}
+	return ids
+}
+
+// traced returns trace of the first traced request, which is carried by messages of their slot
+func traced(requests []*paxi.Request) string {
+	for _, r := range requests {
+		if r != nil && r.TraceID != "" {
+			return r.TraceID
+		}
+	}
+	return ""
+}
+
+// trace sends span of name in slot s from start to end to Tracer if tracing and id is not empty
+func (p *Paxos) trace(id, name string, s int, start, end time.Time, attributes map[string]interface{}) {
+	if !p.Tracing || id == "" {
+		return
+	}
+	if attributes == nil {
+		attributes = make(map[string]interface{})
+	}
+	attributes["node"] = string(p.ID())
+	attributes["slot"] = s
+	p.Tracer(paxi.Span{
+		TraceID:    id,
+		SpanID:     paxi.NewSpanID(),
+		Name:       name,
+		Start:      start,
+		End:        end,
+		Attributes: attributes,
+	})
+}
+
+// traceAll sends span of name for every trace of entry e in slot s
+func (p *Paxos) traceAll(e *entry, name string, s int, start, end time.Time) {
+	if !p.Tracing {
+		return
+	}
+	for _, id := range traces(e) {
+		p.trace(id, name, s, start, end, nil)
+	}
+}
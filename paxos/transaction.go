@@ -0,0 +1,38 @@
+package paxos
+
+import (
+	"errors"
+
+	"github.com/ailidani/paxi"
+)
+
+// ErrTransactionAborted is replied to transaction whose slot is taken by another leader, client may retry
+var ErrTransactionAborted = errors.New("transaction aborted by leader change")
+
+// HandleTransaction proposes every command of the transaction in one slot,
+// so that commands commit and execute together or not at all
+func (p *Paxos) HandleTransaction(t paxi.Transaction) {
+	if t.Timestamp == 0 {
+		t.Timestamp = p.Clock.Now().UnixNano()
+	}
+	if !p.active {
+		p.txns = append(p.txns, &t)
+		if p.ballot.ID() != p.ID() {
+			p.campaign()
+		}
+	} else if p.blocked() {
+		p.txns = append(p.txns, &t)
+	} else {
+		p.phase2(&entry{commands: t.Commands, txn: &t})
+	}
+}
+
+// abort fails transaction t back to client
+func abort(t *paxi.Transaction) {
+	t.Reply(paxi.TransactionReply{
+		OK:        false,
+		Commands:  t.Commands,
+		Timestamp: t.Timestamp,
+		Err:       ErrTransactionAborted,
+	})
+}
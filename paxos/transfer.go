@@ -0,0 +1,45 @@
+package paxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// TransferLeadership stops proposing new commands and hands leadership over to target
+// once every proposed slot is committed.
+// Followers holding a lease of this leader accept the target only after the lease expires.
+func (p *Paxos) TransferLeadership(target paxi.ID) {
+	if !p.active || target == p.ID() {
+		return
+	}
+	log.Infow("leadership transfer", log.Fields{"id": p.ID(), "ballot": p.ballot, "type": "TimeoutNow", "to": target})
+	p.target = target
+	p.handoff()
+}
+
+// handoff sends TimeoutNow to transfer target if there is no uncommitted slot
+func (p *Paxos) handoff() {
+	if !p.active || p.target == "" {
+		return
+	}
+	for s := p.execute; s <= p.slot; s++ {
+		if e, exists := p.log[s]; !exists || !e.commit {
+			return
+		}
+	}
+	p.active = false
+	p.observe()
+	p.Send(p.target, TimeoutNow{Ballot: p.ballot})
+	p.target = ""
+}
+
+// HandleTimeoutNow starts phase 1 right away on request of current leader
+func (p *Paxos) HandleTimeoutNow(m TimeoutNow) {
+	if m.Ballot < p.ballot {
+		return
+	}
+	p.heard = p.Clock.Now()
+	// current leader asks for it, no pre-vote is needed
+	p.voted = true
+	p.P1a()
+}
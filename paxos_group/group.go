@@ -0,0 +1,75 @@
+package paxos_group
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+	"github.com/ailidani/paxi/paxos"
+)
+
+// Group is one independent Paxos instance among the groups of a node, with its own log and leader
+type Group struct {
+	*paxos.Replica
+	ID      int
+	handles map[string]reflect.Value
+}
+
+// newGroup generates group id on node n with its own database, so that snapshots of the group
+// carry and replace only keys of the group, and keys expire in slots of its own log
+func newGroup(n paxi.Node, id int, options ...func(*paxos.Paxos)) *Group {
+	g := &Group{
+		ID:      id,
+		handles: make(map[string]reflect.Value),
+	}
+	wal := "wal." + string(n.ID()) + "." + strconv.Itoa(id)
+	db := paxi.NewDatabase()
+	options = append([]func(*paxos.Paxos){func(p *paxos.Paxos) { p.StateMachine = db }}, options...)
+	g.Replica = paxos.NewReplicaOn(member{Node: n, group: g}, wal, options...)
+	return g
+}
+
+// handle calls the handler of message m registered by replica of the group
+func (g *Group) handle(m interface{}) {
+	v := reflect.ValueOf(m)
+	f, exists := g.handles[v.Type().String()]
+	if !exists {
+		log.Errorf("group %d has no handle function for message type %v", g.ID, v.Type())
+		return
+	}
+	f.Call([]reflect.Value{v})
+}
+
+// member is the node as seen by one group, it wraps outgoing messages with id of the group
+// and keeps handlers of the group apart from the other groups sharing the node
+type member struct {
+	paxi.Node
+	group *Group
+}
+
+func (m member) Send(to paxi.ID, msg interface{}) {
+	m.Node.Send(to, Message{m.group.ID, msg})
+}
+
+func (m member) MulticastZone(zone int, msg interface{}) {
+	m.Node.MulticastZone(zone, Message{m.group.ID, msg})
+}
+
+func (m member) MulticastQuorum(quorum int, msg interface{}) {
+	m.Node.MulticastQuorum(quorum, Message{m.group.ID, msg})
+}
+
+func (m member) Broadcast(msg interface{}) {
+	m.Node.Broadcast(Message{m.group.ID, msg})
+}
+
+func (m member) Register(msg interface{}, f interface{}) {
+	m.group.handles[reflect.TypeOf(msg).String()] = reflect.ValueOf(f)
+}
+
+// HandleHTTP serves endpoints of the group under /group/<id>
+func (m member) HandleHTTP(pattern string, handler http.HandlerFunc) {
+	m.Node.HandleHTTP("/group/"+strconv.Itoa(m.group.ID)+pattern, handler)
+}
@@ -2,39 +2,20 @@ package paxos_group
 
 import (
 	"encoding/gob"
-
-	"github.com/ailidani/paxi/paxos"
+	"fmt"
 )
 
 func init() {
-	gob.Register(Prepare{})
-	gob.Register(Promise{})
-	gob.Register(Accept{})
-	gob.Register(Accepted{})
-	gob.Register(Commit{})
-}
-
-type Prepare struct {
-	GroupID int
-	paxos.P1a
+	gob.Register(Message{})
 }
 
-type Promise struct {
+// Message is a message between Paxos instances of the same group on different nodes,
+// wrapped messages are registered by package paxos
+type Message struct {
 	GroupID int
-	paxos.P1b
+	Message interface{}
 }
 
-type Accept struct {
-	GroupID int
-	paxos.P2a
-}
-
-type Accepted struct {
-	GroupID int
-	paxos.P2b
-}
-
-type Commit struct {
-	GroupID int
-	paxos.P3
+func (m Message) String() string {
+	return fmt.Sprintf("Message {group=%d %v}", m.GroupID, m.Message)
 }
@@ -1,7 +1,10 @@
 package paxos_group
 
 import (
+	"errors"
 	"flag"
+	"hash/fnv"
+	"strconv"
 
 	"github.com/ailidani/paxi"
 	"github.com/ailidani/paxi/log"
@@ -10,95 +13,84 @@ import (
 
 var groups = flag.Int("groups", 5, "Number of Paxos groups")
 
+// ErrCrossGroup is replied to transaction whose keys belong to more than one group
+var ErrCrossGroup = errors.New("transaction keys span multiple groups")
+
+// Replica runs independent Paxos groups on one node,
+// client requests go to the group of their key and peer messages to the group they carry
 type Replica struct {
 	paxi.Node
-	paxi map[int]*paxos.Paxos
-
-	gid int // current working group id
+	groups []*Group
 }
 
 func NewReplica(id paxi.ID) *Replica {
-	r := new(Replica)
-	r.Node = paxi.NewNode(id)
-	r.paxi = make(map[int]*paxos.Paxos)
-
-	r.Register(paxi.Request{}, r.handleReqeust)
-
-	return r
-}
-
-// static paxos groups
-func index(key paxi.Key) int {
-	return int(key) % *groups
+	return newReplica(paxi.NewNode(id), *groups)
 }
 
-func (r *Replica) paxos(gid int) *paxos.Paxos {
-	r.gid = gid
-	if _, exists := r.paxi[gid]; !exists {
-		r.paxi[gid] = paxos.NewPaxos(r.Node)
+// newReplica generates replica of size groups on node n, options apply to every group
+func newReplica(n paxi.Node, size int, options ...func(*paxos.Paxos)) *Replica {
+	if size <= 0 {
+		log.Fatalf("number of groups %d must be positive", size)
 	}
-	return r.paxi[gid]
-}
-
-func (r *Replica) handleReqeust(m paxi.Request) {
-	log.Debugf("Replica %s received %v\n", r.ID(), m)
-	k := m.Command.Key
-	gid := index(k)
-	p := r.paxos(gid)
-	if p.IsLeader() || p.Ballot() == 0 {
-		p.HandleRequest(m)
-	} else {
-		go r.Forward(p.Leader(), m)
+	r := &Replica{Node: n}
+	for i := 0; i < size; i++ {
+		r.groups = append(r.groups, newGroup(n, i, options...))
 	}
+	r.Register(paxi.Request{}, r.handleRequest)
+	r.Register(paxi.Transaction{}, r.handleTransaction)
+	r.Register(Message{}, r.handleMessage)
+	return r
 }
 
-func (r *Replica) handlePrepare(m Prepare) {
-	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, r.ID())
-	r.paxos(m.GroupID).HandleP1a(m.P1a)
-}
-
-func (r *Replica) handlePromise(m Promise) {
-	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, r.ID())
-	r.paxos(m.GroupID).HandleP1b(m.P1b)
+// index returns group of key by hash, the same on every node
+func (r *Replica) index(key paxi.Key) int {
+	h := fnv.New32a()
+	h.Write([]byte(strconv.Itoa(int(key))))
+	return int(h.Sum32() % uint32(len(r.groups)))
 }
 
-func (r *Replica) handleAccept(m Accept) {
-	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, r.ID())
-	r.paxos(m.GroupID).HandleP2a(m.P2a)
+// Group returns the group owning key
+func (r *Replica) Group(key paxi.Key) *Group {
+	return r.groups[r.index(key)]
 }
 
-func (r *Replica) handleAccepted(m Accepted) {
-	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, r.ID())
-	r.paxos(m.GroupID).HandleP2b(m.P2b)
+func (r *Replica) handleRequest(m paxi.Request) {
+	log.Debugf("Replica %s received %v\n", r.ID(), m)
+	r.Group(m.Command.Key).handle(m)
 }
 
-func (r *Replica) handleCommit(m Commit) {
-	log.Debugf("Replica ===[%v]===>>> Replica %s\n", m, r.ID())
-	r.paxos(m.GroupID).HandleP3(m.P3)
+// group returns index of the group owning all keys of transaction t, false if keys span groups
+func (r *Replica) group(t paxi.Transaction) (int, bool) {
+	if len(t.Commands) == 0 {
+		return 0, true
+	}
+	g := r.index(t.Commands[0].Key)
+	for _, c := range t.Commands {
+		if r.index(c.Key) != g {
+			return g, false
+		}
+	}
+	return g, true
 }
 
-// Broadcast overrides Socket interface in Node
-func (r *Replica) Broadcast(msg interface{}) {
-	switch m := msg.(type) {
-	case paxos.P1a:
-		r.Node.MulticastZone(r.ID().Zone(), Prepare{r.gid, m})
-	case paxos.P2a:
-		r.Node.MulticastZone(r.ID().Zone(), Accept{r.gid, m})
-	case paxos.P3:
-		r.Node.MulticastZone(r.ID().Zone(), Commit{r.gid, m})
-	default:
-		r.Node.Broadcast(msg)
+func (r *Replica) handleTransaction(t paxi.Transaction) {
+	g, ok := r.group(t)
+	if !ok {
+		t.Reply(paxi.TransactionReply{
+			OK:        false,
+			Commands:  t.Commands,
+			Timestamp: t.Timestamp,
+			Err:       ErrCrossGroup,
+		})
+		return
 	}
+	r.groups[g].handle(t)
 }
 
-// Send overrides Socket interface in Node
-func (r *Replica) Send(to paxi.ID, msg interface{}) {
-	switch m := msg.(type) {
-	case paxos.P1b:
-		r.Node.Send(to, Promise{r.gid, m})
-	case paxos.P2b:
-		r.Node.Send(to, Accepted{r.gid, m})
-	default:
-		r.Node.Send(to, msg)
+func (r *Replica) handleMessage(m Message) {
+	if m.GroupID < 0 || m.GroupID >= len(r.groups) {
+		log.Errorf("replica %s has no group %d for %v", r.ID(), m.GroupID, m.Message)
+		return
 	}
+	r.groups[m.GroupID].handle(m.Message)
 }
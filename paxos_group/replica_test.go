@@ -0,0 +1,154 @@
+package paxos_group
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/paxos"
+)
+
+// node is fake node keeping sent messages and handlers in memory
+type node struct {
+	paxi.Database
+	id      paxi.ID
+	sent    []interface{}
+	handles map[string]reflect.Value
+}
+
+func newNode(id paxi.ID) *node {
+	return &node{
+		Database: paxi.NewDatabase(),
+		id:       id,
+		handles:  make(map[string]reflect.Value),
+	}
+}
+
+func (n *node) ID() paxi.ID                               { return n.id }
+func (n *node) Run()                                      {}
+func (n *node) Retry(r paxi.Request)                      {}
+func (n *node) Forward(id paxi.ID, r paxi.Request)        {}
+func (n *node) Send(to paxi.ID, m interface{})            { n.sent = append(n.sent, m) }
+func (n *node) MulticastZone(zone int, m interface{})     { n.sent = append(n.sent, m) }
+func (n *node) MulticastQuorum(quorum int, m interface{}) { n.sent = append(n.sent, m) }
+func (n *node) Broadcast(m interface{})                   { n.sent = append(n.sent, m) }
+func (n *node) Recv() interface{}                         { return nil }
+func (n *node) Close()                                    {}
+func (n *node) Drop(id paxi.ID, t int)                    {}
+func (n *node) Slow(id paxi.ID, d int, t int)             {}
+func (n *node) Flaky(id paxi.ID, p float32, t int)        {}
+func (n *node) Crash(t int)                               {}
+func (n *node) After(d time.Duration, f func())           {}
+func (n *node) HandleHTTP(string, http.HandlerFunc)       {}
+
+func (n *node) Register(m interface{}, f interface{}) {
+	n.handles[reflect.TypeOf(m).String()] = reflect.ValueOf(f)
+}
+
+// deliver calls handler of m registered on node
+func (n *node) deliver(m interface{}) {
+	n.handles[reflect.TypeOf(m).String()].Call([]reflect.Value{reflect.ValueOf(m)})
+}
+
+func TestGroupRouting(t *testing.T) {
+	r := newReplica(newNode(paxi.NewID(1, 1)), 3)
+	other := newReplica(newNode(paxi.NewID(1, 2)), 3)
+
+	if len(r.groups) != 3 {
+		t.Fatalf("groups = %d, want 3", len(r.groups))
+	}
+	for k := paxi.Key(0); k < 100; k++ {
+		i := r.index(k)
+		if i < 0 || i >= 3 {
+			t.Fatalf("key %d in group %d", k, i)
+		}
+		if i != other.index(k) {
+			t.Errorf("key %d in group %d and %d on different nodes", k, i, other.index(k))
+		}
+		if r.Group(k).ID != i {
+			t.Errorf("Group(%d) = %d, want %d", k, r.Group(k).ID, i)
+		}
+	}
+}
+
+func TestGroupMessage(t *testing.T) {
+	n := newNode(paxi.NewID(1, 1))
+	r := newReplica(n, 3)
+
+	// outgoing messages carry id of the sending group
+	r.groups[1].P1a()
+	if len(n.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(n.sent))
+	}
+	m, ok := n.sent[0].(Message)
+	if !ok || m.GroupID != 1 {
+		t.Fatalf("sent %v, want message of group 1", n.sent[0])
+	}
+	if _, ok := m.Message.(paxos.P1a); !ok {
+		t.Errorf("group 1 sent %v, want P1a", m.Message)
+	}
+
+	// incoming messages only reach their group
+	b := paxi.NewBallot(9, paxi.NewID(1, 2))
+	n.deliver(Message{GroupID: 2, Message: paxos.P1a{Ballot: b}})
+	if r.groups[2].Ballot() != b {
+		t.Errorf("group 2 ballot = %v, want %v", r.groups[2].Ballot(), b)
+	}
+	if r.groups[0].Ballot() == b {
+		t.Errorf("group 0 handled message of group 2")
+	}
+
+	// unknown group is ignored
+	n.deliver(Message{GroupID: 3, Message: paxos.P1a{Ballot: b}})
+}
+
+func TestCrossGroupTransaction(t *testing.T) {
+	r := newReplica(newNode(paxi.NewID(1, 1)), 3)
+	var a, b paxi.Key
+	for k := paxi.Key(1); k < 100; k++ {
+		if r.index(k) != r.index(a) {
+			b = k
+			break
+		}
+	}
+	if _, ok := r.group(paxi.Transaction{Commands: []paxi.Command{{Key: a}, {Key: b}}}); ok {
+		t.Errorf("keys %d and %d of different groups accepted in one transaction", a, b)
+	}
+	if g, ok := r.group(paxi.Transaction{Commands: []paxi.Command{{Key: b}, {Key: b}}}); !ok || g != r.index(b) {
+		t.Errorf("group = %d %t, want %d true", g, ok, r.index(b))
+	}
+}
+
+func TestGroupSnapshot(t *testing.T) {
+	r := newReplica(newNode(paxi.NewID(1, 1)), 2)
+	var a, b paxi.Key
+	for k := paxi.Key(1); k < 100; k++ {
+		if r.index(k) == 0 && a == 0 {
+			a = k
+		}
+		if r.index(k) == 1 && b == 0 {
+			b = k
+		}
+	}
+	r.groups[1].StateMachine.Apply(paxi.Command{Key: b, Value: paxi.Value("b")})
+
+	// snapshot installed by group 0 replaces keys of group 0 only
+	state := paxi.NewDatabase()
+	state.Put(a, paxi.Value("a"))
+	r.groups[0].InstallSnapshot(paxos.SnapshotReply{Slot: 1, State: state.Snapshot()})
+	if v, _ := r.groups[1].StateMachine.Apply(paxi.Command{Key: b}); string(v) != "b" {
+		t.Errorf("group 1 key %d = %q after snapshot of group 0, want b", b, v)
+	}
+	if v, _ := r.groups[0].StateMachine.Apply(paxi.Command{Key: a}); string(v) != "a" {
+		t.Errorf("group 0 key %d = %q, want a", a, v)
+	}
+
+	// snapshot of group 0 carries no key of group 1
+	restored := paxi.NewDatabase()
+	restored.Restore(r.groups[0].StateMachine.Snapshot())
+	if v := restored.Get(b); v != nil {
+		t.Errorf("snapshot of group 0 has key %d of group 1", b)
+	}
+}
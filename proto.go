@@ -0,0 +1,476 @@
+package paxi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// protocol buffers wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// ErrProtoMalformed is returned by proto codec decoding bytes that are not in protocol buffers wire format
+var ErrProtoMalformed = errors.New("proto codec: malformed message")
+
+// codecProto writes each message in protocol buffers wire format, prefixed by its length as varint like delimited
+// protobuf streams. The schema follows the Go type, so that observers in other languages decode messages with a
+// .proto file written from it:
+//   - the nth exported field of a struct is field number n, fields of channel and function types are never written
+//   - signed integers are sint64 of zigzag encoding, unsigned integers and bool are uint64, floats are fixed
+//   - strings, byte slices and structs are length delimited, other slices are repeated and maps are repeated
+//     entries of key as field 1 and value as field 2
+//   - interface values, including the top level message, are an envelope of the type name registered by
+//     RegisterMessage as field 1 and the value as field 2
+//
+// Zero values are omitted like in proto3, so empty and nil slices are decoded as nil, as gob does.
+type codecProto struct {
+	rw io.ReadWriter
+}
+
+func (p *codecProto) Scheme() string {
+	return "proto"
+}
+
+func (p *codecProto) Encode(m interface{}) error {
+	b, err := p.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// one write of length and message, so that a partial message is never written between two others
+	frame := appendVarint(make([]byte, 0, len(b)+binary.MaxVarintLen64), uint64(len(b)))
+	_, err = p.rw.Write(append(frame, b...))
+	return err
+}
+
+func (p *codecProto) Decode(m interface{}) error {
+	size, err := binary.ReadUvarint(byteReader{p.rw})
+	if err != nil {
+		return err
+	}
+	if config.MaxMessageSize > 0 && size > uint64(config.MaxMessageSize) {
+		return ErrMessageTooLarge
+	}
+	if size > math.MaxInt32 {
+		return ErrProtoMalformed
+	}
+	b := make([]byte, size)
+	_, err = io.ReadFull(p.rw, b)
+	if err != nil {
+		return err
+	}
+	return p.Unmarshal(b, m)
+}
+
+// Marshal returns message m in protocol buffers wire format without length prefix
+func (p *codecProto) Marshal(m interface{}) ([]byte, error) {
+	if v, ok := m.(*interface{}); ok {
+		return appendEnvelope(nil, reflect.ValueOf(*v))
+	}
+	v := reflect.ValueOf(m)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("proto codec encodes struct messages, not %v", v.Type())
+	}
+	return appendStruct(nil, v)
+}
+
+// Unmarshal decodes message b in protocol buffers wire format into m, a pointer to struct or interface value
+func (p *codecProto) Unmarshal(b []byte, m interface{}) error {
+	if v, ok := m.(*interface{}); ok {
+		value, err := unmarshalEnvelope(b)
+		if err != nil {
+			return err
+		}
+		*v = value.Interface()
+		return nil
+	}
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("proto codec decodes into pointer to struct, not %T", m)
+	}
+	return unmarshalStruct(b, v.Elem())
+}
+
+// byteReader reads varint of length prefix one byte at a time, so nothing after the prefix is consumed
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
+
+// fields of struct types in the order of their field numbers
+var protoFields sync.Map
+
+// fieldsOf returns index of the struct field of each field number, starting from field number 1
+func fieldsOf(t reflect.Type) []int {
+	if f, ok := protoFields.Load(t); ok {
+		return f.([]int)
+	}
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			fields = append(fields, i)
+		}
+	}
+	protoFields.Store(t, fields)
+	return fields
+}
+
+func appendVarint(b []byte, x uint64) []byte {
+	for x >= 0x80 {
+		b = append(b, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(b, byte(x))
+}
+
+func appendTag(b []byte, num, wire int) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(wire))
+}
+
+func appendBytes(b []byte, num int, data []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func zigzag(x int64) uint64 {
+	return uint64(x<<1) ^ uint64(x>>63)
+}
+
+func unzigzag(x uint64) int64 {
+	return int64(x>>1) ^ -int64(x&1)
+}
+
+func appendStruct(b []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i, f := range fieldsOf(v.Type()) {
+		b, err = appendField(b, i+1, v.Field(f), false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// appendEnvelope appends type name and value of message v as fields 1 and 2
+func appendEnvelope(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return nil, errors.New("proto codec cannot encode nil message")
+	}
+	b = appendBytes(b, 1, []byte(v.Type().String()))
+	return appendField(b, 2, v, true)
+}
+
+// appendField appends v as field num, zero value is skipped unless forced as an element of repeated field or map entry
+func appendField(b []byte, num int, v reflect.Value, force bool) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() || force {
+			b = appendTag(b, num, wireVarint)
+			if v.Bool() {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() != 0 || force {
+			b = appendVarint(appendTag(b, num, wireVarint), zigzag(v.Int()))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() != 0 || force {
+			b = appendVarint(appendTag(b, num, wireVarint), v.Uint())
+		}
+	case reflect.Float32:
+		if v.Float() != 0 || force {
+			b = binary.LittleEndian.AppendUint32(appendTag(b, num, wireFixed32), math.Float32bits(float32(v.Float())))
+		}
+	case reflect.Float64:
+		if v.Float() != 0 || force {
+			b = binary.LittleEndian.AppendUint64(appendTag(b, num, wireFixed64), math.Float64bits(v.Float()))
+		}
+	case reflect.String:
+		if v.Len() > 0 || force {
+			b = appendBytes(b, num, []byte(v.String()))
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Len() > 0 || force {
+				b = appendBytes(b, num, v.Bytes())
+			}
+			break
+		}
+		if k := v.Type().Elem().Kind(); k == reflect.Slice || k == reflect.Map {
+			return nil, fmt.Errorf("proto codec cannot encode repeated %v", v.Type().Elem())
+		}
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendField(b, num, v.Index(i), true); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			entry, err := appendField(nil, 1, iter.Key(), true)
+			if err != nil {
+				return nil, err
+			}
+			if entry, err = appendField(entry, 2, iter.Value(), true); err != nil {
+				return nil, err
+			}
+			b = appendBytes(b, num, entry)
+		}
+	case reflect.Struct:
+		message, err := appendStruct(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		if len(message) > 0 || force {
+			b = appendBytes(b, num, message)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return appendField(b, num, v.Elem(), true)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			envelope, err := appendEnvelope(nil, v.Elem())
+			if err != nil {
+				return nil, err
+			}
+			b = appendBytes(b, num, envelope)
+		}
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+	default:
+		return nil, fmt.Errorf("proto codec cannot encode %v", v.Type())
+	}
+	return b, nil
+}
+
+// scan calls f with number, wire type and content of every field of message b,
+// x holds varint and fixed values and data holds length delimited ones
+func scan(b []byte, f func(num, wire int, x uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrProtoMalformed
+		}
+		b = b[n:]
+		num, wire := int(key>>3), int(key&7)
+		var x uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			if x, n = binary.Uvarint(b); n <= 0 {
+				return ErrProtoMalformed
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return ErrProtoMalformed
+			}
+			x, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return ErrProtoMalformed
+			}
+			x, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return ErrProtoMalformed
+			}
+			data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return ErrProtoMalformed
+		}
+		if err := f(num, wire, x, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshalStruct(b []byte, v reflect.Value) error {
+	fields := fieldsOf(v.Type())
+	return scan(b, func(num, wire int, x uint64, data []byte) error {
+		if num < 1 || num > len(fields) {
+			// unknown field of a newer schema
+			return nil
+		}
+		return decodeField(v.Field(fields[num-1]), wire, x, data)
+	})
+}
+
+// unmarshalEnvelope returns the message of registered type in envelope b
+func unmarshalEnvelope(b []byte) (reflect.Value, error) {
+	var name string
+	var value func(v reflect.Value) error
+	err := scan(b, func(num, wire int, x uint64, data []byte) error {
+		switch num {
+		case 1:
+			name = string(data)
+		case 2:
+			value = func(v reflect.Value) error { return decodeField(v, wire, x, data) }
+		}
+		return nil
+	})
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	typesLock.RLock()
+	t, exists := types[name]
+	typesLock.RUnlock()
+	if !exists {
+		return reflect.Value{}, fmt.Errorf("proto codec cannot decode unregistered type %s", name)
+	}
+	v := reflect.New(t).Elem()
+	if value != nil {
+		if err := value(v); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	return v, nil
+}
+
+// decodeField sets v to field content of wire type, or appends it to v if v is repeated
+func decodeField(v reflect.Value, wire int, x uint64, data []byte) error {
+	want := wireBytes
+	switch v.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		want = wireVarint
+	case reflect.Float32:
+		want = wireFixed32
+	case reflect.Float64:
+		want = wireFixed64
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return decodeElement(v, wire, x, data)
+		}
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return nil
+	}
+	if wire != want {
+		return fmt.Errorf("proto codec cannot decode wire type %d into %v", wire, v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(x != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(unzigzag(x))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(x)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(x))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(x))
+	case reflect.String:
+		v.SetString(string(data))
+	case reflect.Slice:
+		v.SetBytes(append([]byte(nil), data...))
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.New(v.Type().Key()).Elem()
+		value := reflect.New(v.Type().Elem()).Elem()
+		err := scan(data, func(num, wire int, x uint64, data []byte) error {
+			switch num {
+			case 1:
+				return decodeField(key, wire, x, data)
+			case 2:
+				return decodeField(value, wire, x, data)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(key, value)
+	case reflect.Struct:
+		return unmarshalStruct(data, v)
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeField(v.Elem(), wire, x, data)
+	case reflect.Interface:
+		value, err := unmarshalEnvelope(data)
+		if err != nil {
+			return err
+		}
+		v.Set(value)
+	default:
+		return fmt.Errorf("proto codec cannot decode %v", v.Type())
+	}
+	return nil
+}
+
+// decodeElement appends element of repeated field v, or every element of a packed repeated field of scalars
+func decodeElement(v reflect.Value, wire int, x uint64, data []byte) error {
+	t := v.Type().Elem()
+	packed := wire == wireBytes
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		for packed && len(data) > 0 {
+			x, n := binary.Uvarint(data)
+			if n <= 0 {
+				return ErrProtoMalformed
+			}
+			data = data[n:]
+			if err := decodeElement(v, wireVarint, x, nil); err != nil {
+				return err
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		size := 8
+		if t.Kind() == reflect.Float32 {
+			size = 4
+		}
+		for packed && len(data) >= size {
+			var x uint64
+			if size == 4 {
+				x, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+				if err := decodeElement(v, wireFixed32, x, nil); err != nil {
+					return err
+				}
+			} else {
+				x, data = binary.LittleEndian.Uint64(data), data[8:]
+				if err := decodeElement(v, wireFixed64, x, nil); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		packed = false
+	}
+	if packed {
+		return nil
+	}
+	e := reflect.New(t).Elem()
+	if err := decodeField(e, wire, x, data); err != nil {
+		return err
+	}
+	v.Set(reflect.Append(v, e))
+	return nil
+}
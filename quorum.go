@@ -1,37 +1,126 @@
 package paxi
 
+import (
+	"fmt"
+	"sort"
+)
+
 // Quorum records each acknowledgement and check for different types of quorum satisfied
 type Quorum struct {
-	size  int
-	acks  map[ID]bool
-	zones map[int]int
-	nacks map[ID]bool
+	size    int
+	q1      int         // phase 1 quorum size, 0 is majority
+	q2      int         // phase 2 quorum size, 0 is majority
+	zoneMin map[int]int // min number of acks from each zone in phase 2
+	members map[ID]bool // members of the configuration counted in quorum, nil counts every node
+	total   int         // number of nodes quorum is formed among if members is nil, 0 is every node in config
+	acks    map[ID]bool
+	zones   map[int]int // acks per zone, each zone is a grid column
+	rows    map[int]int // acks per node number, each node number is a grid row
+	nacks   map[ID]bool // nodes that rejected the ballot
+	sizes   []int       // phase 1 and phase 2 sizes of membership replacing sizes of config, nil follows config
+
+	configured bool // sizes follow config and are read again on reset
 }
 
-// NewQuorum returns a new Quorum
+// NewQuorum returns a new Quorum with phase 1 and phase 2 sizes from config
 func NewQuorum() *Quorum {
+	q := NewFlexibleQuorum(config.Q1Size, config.Q2Size)
+	q.configured = true
+	return q
+}
+
+// Membership is the set of nodes forming quorums from slot Epoch onwards, nil IDs is every node in config
+type Membership struct {
+	Epoch int
+	IDs   []ID
+	Sizes []int // phase 1 and phase 2 quorum sizes committed through the log, nil follows config
+}
+
+// QuorumFor returns a new Quorum among members of configuration m.
+// Configured quorum sizes only apply while they still intersect among its nodes,
+// otherwise the quorum falls back to majority of the membership.
+func QuorumFor(m Membership) *Quorum {
+	q := NewQuorum()
+	q.SetMembers(m.IDs)
+	q.sizes = m.Sizes
+	q.configure()
+	return q
+}
+
+// configure reads quorum sizes from membership or config, falling back to majority if they do not intersect
+// among the members or given number of nodes of this quorum
+func (q *Quorum) configure() {
+	q.q1, q.q2 = config.Q1Size, config.Q2Size
+	if q.sizes != nil {
+		q.q1, q.q2 = q.sizes[0], q.sizes[1]
+	}
+	q.zoneMin = config.ZoneMin
+	if (q.members != nil || q.total > 0 || q.sizes != nil) && !Intersect(q.Total(), q.q1, q.q2) {
+		q.q1, q.q2 = 0, 0
+	}
+}
+
+// NewFlexibleQuorum returns a new Quorum that requires q1 acks in phase 1 and q2 acks in phase 2
+func NewFlexibleQuorum(q1, q2 int) *Quorum {
 	q := &Quorum{
-		size:  0,
-		acks:  make(map[ID]bool),
-		zones: make(map[int]int),
+		size:    0,
+		q1:      q1,
+		q2:      q2,
+		zoneMin: config.ZoneMin,
+		acks:    make(map[ID]bool),
+		zones:   make(map[int]int),
+		rows:    make(map[int]int),
+		nacks:   make(map[ID]bool),
 	}
 	return q
 }
 
-// ACK adds id to quorum ack records
+// SetMembers limits quorum to given configuration members, acks from other nodes are ignored
+func (q *Quorum) SetMembers(ids []ID) {
+	q.total = 0
+	if ids == nil {
+		q.members = nil
+		return
+	}
+	q.members = make(map[ID]bool, len(ids))
+	for _, id := range ids {
+		q.members[id] = true
+	}
+}
+
+// ACK adds id to quorum ack records, acks of learners only count once membership includes them
 func (q *Quorum) ACK(id ID) {
+	if !q.counts(id) {
+		return
+	}
 	if !q.acks[id] {
 		q.acks[id] = true
 		q.size++
 		q.zones[id.Zone()]++
+		q.rows[id.Node()]++
 	}
 }
 
 // NACK adds id to quorum nack records
 func (q *Quorum) NACK(id ID) {
-	if !q.nacks[id] {
-		q.nacks[id] = true
+	if !q.counts(id) {
+		return
 	}
+	q.nacks[id] = true
+}
+
+// counts returns true if id is a member of this quorum, learners of config are members only if named by membership
+func (q *Quorum) counts(id ID) bool {
+	if q.members != nil {
+		return q.members[id]
+	}
+	return !config.IsLearner(id)
+}
+
+// Rejected returns true if majority of nodes rejected the ballot,
+// so the round cannot succeed and may be abandoned right away
+func (q *Quorum) Rejected() bool {
+	return len(q.nacks) >= q.Threshold()
 }
 
 // ADD increase ack size by one
@@ -44,22 +133,70 @@ func (q *Quorum) Size() int {
 	return q.size
 }
 
-// Reset resets the quorum to empty
+// Reset resets the quorum to empty, quorum created from config reads the current sizes again
 func (q *Quorum) Reset() {
+	q.clear()
+	if q.configured {
+		q.configure()
+	}
+}
+
+// ResetFor resets the quorum to empty among size nodes, e.g. after membership changes the number of nodes,
+// acks from any node count from now on
+func (q *Quorum) ResetFor(size int) {
+	q.members = nil
+	q.total = size
+	q.Reset()
+}
+
+// clear drops every ack and nack
+func (q *Quorum) clear() {
 	q.size = 0
 	q.acks = make(map[ID]bool)
 	q.zones = make(map[int]int)
+	q.rows = make(map[int]int)
 	q.nacks = make(map[ID]bool)
 }
 
+// Total returns number of nodes this quorum is formed among
+func (q *Quorum) Total() int {
+	if q.members != nil {
+		return len(q.members)
+	}
+	if q.total > 0 {
+		return q.total
+	}
+	return config.n
+}
+
+// Threshold returns number of acks required by majority quorum
+func (q *Quorum) Threshold() int {
+	return q.Total()/2 + 1
+}
+
 // Majority quorum satisfied
 func (q *Quorum) Majority() bool {
-	return q.size > config.n/2
+	return q.size >= q.Threshold()
+}
+
+// All returns true if every node this quorum is formed among acked
+func (q *Quorum) All() bool {
+	return len(q.acks) >= q.Total()
 }
 
-// FastQuorum from fast paxos
+// AckedIDs returns ids of nodes acked so far in sorted order
+func (q *Quorum) AckedIDs() []ID {
+	ids := make([]ID, 0, len(q.acks))
+	for id := range q.acks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// FastQuorum from fast paxos requires ceil(3N/4) acks
 func (q *Quorum) FastQuorum() bool {
-	return q.size >= config.n*3/4
+	return q.size >= (q.Total()*3+3)/4
 }
 
 // AllZones returns true if there is at one ack from each zone
@@ -77,9 +214,16 @@ func (q *Quorum) ZoneMajority() bool {
 	return false
 }
 
-// GridRow == AllZones
+// GridRow returns true if every node of one grid row acked,
+// a row is node i of every zone, so any full row intersects any full column
+// as long as every zone has the same number of nodes
 func (q *Quorum) GridRow() bool {
-	return q.AllZones()
+	for r, n := range q.rows {
+		if n == config.npr[r] {
+			return true
+		}
+	}
+	return false
 }
 
 // GridColumn == all nodes in one zone
@@ -114,42 +258,53 @@ func (q *Quorum) FGridQ2(Fz int) bool {
 	return zone >= Fz+1
 }
 
-/*
-// Q1 returns true if config.Quorum type is satisfied
+// Q1 returns true if phase 1 quorum size is satisfied, majority by default
 func (q *Quorum) Q1() bool {
-	switch config.Quorum {
-	case "majority":
-		return q.Majority()
-	case "grid":
-		return q.GridRow()
-	case "fgrid":
-		return q.FGridQ1()
-	case "group":
-		return q.ZoneMajority()
-	case "count":
-		return q.size >= config.n-config.F
-	default:
-		log.Error("Unknown quorum type")
-		return false
-	}
-}
-
-// Q2 returns true if config.Quorum type is satisfied
+	if q.q1 > 0 {
+		return q.size >= q.q1
+	}
+	return q.Majority()
+}
+
+// Q2 returns true if phase 2 quorum size is satisfied, majority by default,
+// and every zone acked at least its configured minimum
 func (q *Quorum) Q2() bool {
-	switch config.Quorum {
-	case "majority":
-		return q.Majority()
-	case "grid":
-		return q.GridColumn()
-	case "fgrid":
-		return q.FGridQ2()
-	case "group":
-		return q.ZoneMajority()
-	case "count":
-		return q.size > config.F
-	default:
-		log.Error("Unknown quorum type")
-		return false
-	}
-}
-*/
+	for z, min := range q.zoneMin {
+		if q.zones[z] < min {
+			return false
+		}
+	}
+	if q.q2 > 0 {
+		return q.size >= q.q2
+	}
+	return q.Majority()
+}
+
+// Intersect returns true if any phase 1 quorum of size q1 overlaps with
+// any phase 2 quorum of size q2 among n nodes, zero size means majority
+func Intersect(n, q1, q2 int) bool {
+	q1, q2 = quorumSize(n, q1), quorumSize(n, q2)
+	return q1 <= n && q2 <= n && q1+q2 > n
+}
+
+// Resizable returns error unless phase 1 and phase 2 quorum sizes q1 and q2 intersect each other and each
+// current size among nodes of membership m, zero size means majority, so that leaders still using current sizes
+// during the change overlap with the ones using new sizes. To trade phase 2 availability for phase 1
+// while nodes are known down, raise q2 first then lower q1.
+func (m Membership) Resizable(q1, q2 int) error {
+	q := QuorumFor(m)
+	n := q.Total()
+	if !Intersect(n, q1, q2) || !Intersect(n, q1, q.q2) || !Intersect(n, q.q1, q2) {
+		return fmt.Errorf("phase 1 quorum of %d and phase 2 quorum of %d among %d nodes do not intersect current quorums of %d and %d",
+			quorumSize(n, q1), quorumSize(n, q2), n, quorumSize(n, q.q1), quorumSize(n, q.q2))
+	}
+	return nil
+}
+
+// quorumSize returns configured quorum size q among n nodes, zero size means majority
+func quorumSize(n, q int) int {
+	if q <= 0 {
+		return n/2 + 1
+	}
+	return q
+}
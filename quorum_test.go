@@ -0,0 +1,334 @@
+package paxi
+
+import (
+	"testing"
+)
+
+func TestFlexibleQuorum(t *testing.T) {
+	q := NewFlexibleQuorum(3, 2)
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 2))
+	if !q.Q2() {
+		t.Error("expected phase 2 quorum of 2 acks")
+	}
+	if q.Q1() {
+		t.Error("phase 1 quorum satisfied with only 2 acks")
+	}
+	q.ACK(NewID(1, 3))
+	if !q.Q1() {
+		t.Error("expected phase 1 quorum of 3 acks")
+	}
+
+	q.Reset()
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 2))
+	if q.Q1() || !q.Q2() {
+		t.Error("quorum sizes are lost after reset")
+	}
+}
+
+func TestRejected(t *testing.T) {
+	q := NewQuorum()
+	q.SetMembers([]ID{NewID(1, 1), NewID(1, 2), NewID(1, 3)})
+	q.ACK(NewID(1, 1))
+	q.NACK(NewID(1, 2))
+	q.NACK(NewID(1, 4))
+	if q.Rejected() {
+		t.Error("rejected by 1 of 3 members")
+	}
+	q.NACK(NewID(1, 3))
+	if !q.Rejected() {
+		t.Error("expected rejected by 2 of 3 members")
+	}
+	q.Reset()
+	if q.Rejected() {
+		t.Error("nacks are kept after reset")
+	}
+}
+
+func TestAll(t *testing.T) {
+	q := NewQuorum()
+	q.SetMembers([]ID{NewID(1, 1), NewID(1, 2), NewID(2, 1)})
+	q.ACK(NewID(2, 1))
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 1))
+	if q.All() {
+		t.Error("all satisfied with 2 of 3 members")
+	}
+	if ids := q.AckedIDs(); len(ids) != 2 || ids[0] != NewID(1, 1) || ids[1] != NewID(2, 1) {
+		t.Errorf("unexpected acked ids %v", ids)
+	}
+	q.ACK(NewID(1, 2))
+	if !q.All() {
+		t.Error("expected all 3 members acked")
+	}
+}
+
+// TestIntersect checks every accepted q1 and q2 size against every pair of node subsets
+func TestIntersect(t *testing.T) {
+	count := func(set int) int {
+		c := 0
+		for ; set > 0; set >>= 1 {
+			c += set & 1
+		}
+		return c
+	}
+
+	for n := 1; n <= 7; n++ {
+		for q1 := 0; q1 <= n+1; q1++ {
+			for q2 := 0; q2 <= n+1; q2++ {
+				if !Intersect(n, q1, q2) {
+					continue
+				}
+				s1, s2 := q1, q2
+				if s1 == 0 {
+					s1 = n/2 + 1
+				}
+				if s2 == 0 {
+					s2 = n/2 + 1
+				}
+				for a := 0; a < 1<<uint(n); a++ {
+					if count(a) != s1 {
+						continue
+					}
+					for b := 0; b < 1<<uint(n); b++ {
+						if count(b) == s2 && a&b == 0 {
+							t.Fatalf("n=%d q1=%d q2=%d: quorums %b and %b do not overlap", n, q1, q2, a, b)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if Intersect(5, 2, 3) {
+		t.Error("q1=2 q2=3 of 5 nodes should not intersect")
+	}
+	if !Intersect(5, 5, 1) {
+		t.Error("q1=5 q2=1 of 5 nodes should intersect")
+	}
+	if Intersect(3, 4, 1) {
+		t.Error("quorum larger than cluster should be rejected")
+	}
+}
+
+func TestQuorumSize(t *testing.T) {
+	q := NewQuorum()
+	q.SetMembers([]ID{NewID(1, 1), NewID(1, 2), NewID(1, 3), NewID(2, 1), NewID(2, 2)})
+	if q.Total() != 5 || q.Threshold() != 3 {
+		t.Fatalf("expected threshold 3 of 5, got %d of %d", q.Threshold(), q.Total())
+	}
+
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(3, 1)) // not a member
+	q.ACK(NewID(2, 1))
+	if q.Size() != 2 || q.Majority() {
+		t.Errorf("expected 2/5 acks without majority, got %d/%d", q.Size(), q.Total())
+	}
+	q.ACK(NewID(2, 2))
+	if q.Size() != 3 || !q.Majority() {
+		t.Errorf("expected majority with 3/5 acks, got %d/%d", q.Size(), q.Total())
+	}
+}
+
+func TestResize(t *testing.T) {
+	n, q1, q2 := config.n, config.Q1Size, config.Q2Size
+	defer func() { config.n, config.Q1Size, config.Q2Size = n, q1, q2 }()
+	config.n, config.Q1Size, config.Q2Size = 5, 0, 0
+
+	m := Membership{}
+	if m.Resizable(2, 4) == nil {
+		t.Error("phase 1 quorum of 2 does not intersect current phase 2 majority")
+	}
+	if err := m.Resizable(3, 4); err != nil {
+		t.Fatal(err)
+	}
+	m.Sizes = []int{3, 4}
+	if err := m.Resizable(2, 4); err != nil {
+		t.Fatal(err)
+	}
+	m.Sizes = []int{2, 4}
+	q := QuorumFor(m)
+	for i := 1; i <= 2; i++ {
+		q.ACK(NewID(1, i))
+	}
+	if !q.Q1() || q.Q2() {
+		t.Errorf("expected quorums 2 and 4 of membership, q1 %v q2 %v", q.Q1(), q.Q2())
+	}
+	if m.Resizable(0, 0) == nil {
+		t.Error("phase 2 majority does not intersect current phase 1 quorum of 2")
+	}
+}
+
+func TestQuorumFor(t *testing.T) {
+	n, q1, q2 := config.n, config.Q1Size, config.Q2Size
+	defer func() { config.n, config.Q1Size, config.Q2Size = n, q1, q2 }()
+	config.n, config.Q1Size, config.Q2Size = 5, 2, 4
+
+	ids := []ID{NewID(1, 1), NewID(1, 2), NewID(1, 3)}
+	q := QuorumFor(Membership{Epoch: 3, IDs: ids})
+	q.ACK(NewID(1, 4))
+	q.ACK(NewID(1, 1))
+	if q.Q2() {
+		t.Error("quorum formed with ack outside membership")
+	}
+	q.ACK(NewID(1, 2))
+	if !q.Q2() {
+		t.Error("expected sizes of 5 nodes fall back to majority of 3 members")
+	}
+
+	q = QuorumFor(Membership{})
+	for i := 1; i <= 3; i++ {
+		q.ACK(NewID(1, i))
+	}
+	if q.Q2() {
+		t.Error("expected configured phase 2 size of 4 among every node")
+	}
+}
+
+func TestResetFor(t *testing.T) {
+	n, q1, q2 := config.n, config.Q1Size, config.Q2Size
+	defer func() { config.n, config.Q1Size, config.Q2Size = n, q1, q2 }()
+	config.n, config.Q1Size, config.Q2Size = 5, 0, 0
+
+	q := NewQuorum()
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 2))
+	if q.Q1() {
+		t.Fatal("phase 1 quorum satisfied with 2 of 5 nodes")
+	}
+
+	// one node leaves, then another joins with larger phase 2 quorum
+	q.ResetFor(3)
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 2))
+	if !q.Q1() || q.Total() != 3 {
+		t.Errorf("expected majority of 3 nodes after membership change, total %d", q.Total())
+	}
+	config.Q1Size, config.Q2Size = 2, 4
+	q.ResetFor(4)
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 2))
+	q.ACK(NewID(1, 3))
+	if !q.Q1() || q.Q2() {
+		t.Error("expected configured quorums of 2 and 4 read again on reset")
+	}
+
+	// explicit sizes survive reset
+	f := NewFlexibleQuorum(3, 2)
+	f.ResetFor(3)
+	if f.q1 != 3 || f.q2 != 2 {
+		t.Errorf("expected flexible sizes kept, got %d and %d", f.q1, f.q2)
+	}
+}
+
+func TestLearnerQuorum(t *testing.T) {
+	n, addrs, learners := config.n, config.Addrs, config.Learners
+	defer func() { config.n, config.Addrs, config.Learners = n, addrs, learners }()
+	config.Addrs = map[ID]string{NewID(1, 1): "", NewID(1, 2): "", NewID(1, 3): "", NewID(1, 4): ""}
+	config.Learners = []ID{NewID(1, 4)}
+	config.n = 3
+
+	if len(config.Voters()) != 3 || !config.IsLearner(NewID(1, 4)) || config.IsLearner(NewID(1, 1)) {
+		t.Fatalf("expected 3 voters without learner 1.4, got %v", config.Voters())
+	}
+	q := NewQuorum()
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 4))
+	if q.Majority() || q.Size() != 1 {
+		t.Errorf("expected ack of learner not counted, size %d", q.Size())
+	}
+	q.NACK(NewID(1, 2))
+	q.NACK(NewID(1, 3))
+	q.NACK(NewID(1, 4))
+	if !q.Rejected() {
+		t.Error("expected rejection by majority of voters")
+	}
+}
+
+// TestGrid checks every acking set forming a full row against every set forming a full column
+func TestGrid(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	for zones := 1; zones <= 3; zones++ {
+		for nodes := 1; nodes <= 3; nodes++ {
+			ids := make([]ID, 0)
+			config.npz = make(map[int]int)
+			config.npr = make(map[int]int)
+			for z := 1; z <= zones; z++ {
+				for n := 1; n <= nodes; n++ {
+					ids = append(ids, NewID(z, n))
+					config.npz[z]++
+					config.npr[n]++
+				}
+			}
+			config.n = len(ids)
+			config.z = zones
+
+			quorum := func(set int) *Quorum {
+				q := NewQuorum()
+				for i, id := range ids {
+					if set&(1<<uint(i)) != 0 {
+						q.ACK(id)
+					}
+				}
+				return q
+			}
+			rows := make([]int, 0)
+			columns := make([]int, 0)
+			for set := 0; set < 1<<uint(len(ids)); set++ {
+				q := quorum(set)
+				if q.GridRow() {
+					rows = append(rows, set)
+				}
+				if q.GridColumn() {
+					columns = append(columns, set)
+				}
+			}
+			if len(rows) == 0 || len(columns) == 0 {
+				t.Fatalf("%dx%d grid: no row or column quorum", zones, nodes)
+			}
+			for _, r := range rows {
+				for _, c := range columns {
+					if r&c == 0 {
+						t.Fatalf("%dx%d grid: row %b and column %b do not intersect", zones, nodes, r, c)
+					}
+				}
+			}
+
+			// node 1 of every zone is a full row
+			row := NewQuorum()
+			for z := 1; z <= zones; z++ {
+				row.ACK(NewID(z, 1))
+			}
+			if !row.GridRow() {
+				t.Errorf("%dx%d grid: row 1 is not a row quorum", zones, nodes)
+			}
+		}
+	}
+}
+
+func TestZoneQuorum(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.n = 6
+	config.ZoneMin = map[int]int{1: 1, 2: 1, 3: 1}
+
+	q := NewQuorum()
+	q.ACK(NewID(1, 1))
+	q.ACK(NewID(1, 2))
+	q.ACK(NewID(1, 3))
+	q.ACK(NewID(2, 1))
+	if q.Q2() {
+		t.Error("phase 2 quorum satisfied without zone 3")
+	}
+	q.ACK(NewID(3, 1))
+	if !q.Q2() {
+		t.Error("expected phase 2 quorum with every zone acked")
+	}
+	if !q.Q1() {
+		t.Error("zone minimum should not constrain phase 1")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+	"github.com/ailidani/paxi/paxos"
+)
+
+var file = flag.String("record", "record", "file of messages recorded by the node")
+var id = flag.String("id", "", "ID of the recorded node in format of Zone.Node.")
+
+// replay feeds messages recorded by one node into a fresh paxos and prints the state it ends in
+func main() {
+	paxi.Init()
+
+	records, err := paxos.LoadRecording(*file)
+	if err != nil {
+		log.Errorf("replay %d messages recorded before error: %v", len(records), err)
+	}
+
+	p := paxos.Replay(paxi.ID(*id), records)
+
+	for _, e := range p.LogSnapshot() {
+		fmt.Printf("%d\t%v\t%t\t%v\n", e.Slot, e.Ballot, e.Commit, e.Commands)
+	}
+	fmt.Printf("%+v\n", p.Status())
+}
@@ -0,0 +1,52 @@
+package paxi
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrMessageTooLarge is the error of message exceeding max message size
+var ErrMessageTooLarge = errors.New("message exceeds max message size")
+
+// readAhead is number of bytes a codec may buffer beyond the message it decodes
+const readAhead = 64 * 1024
+
+// limitConn fails reads once more than limit bytes are read since last reset,
+// so that decoding one message never buffers an unbounded payload from the peer
+type limitConn struct {
+	net.Conn
+	limit int
+	read  int
+}
+
+// newLimitConn returns conn limited to max message size plus read ahead of codec, conn itself if unlimited
+func newLimitConn(conn net.Conn) net.Conn {
+	if config.MaxMessageSize <= 0 {
+		return conn
+	}
+	return &limitConn{Conn: conn, limit: config.MaxMessageSize + readAhead}
+}
+
+func (c *limitConn) Read(b []byte) (int, error) {
+	if c.read >= c.limit {
+		return 0, ErrMessageTooLarge
+	}
+	if len(b) > c.limit-c.read {
+		b = b[:c.limit-c.read]
+	}
+	n, err := c.Conn.Read(b)
+	c.read += n
+	return n, err
+}
+
+// reset starts counting bytes of the next message
+func (c *limitConn) reset() {
+	c.read = 0
+}
+
+// next resets limit of conn before decoding the next message, if conn is limited
+func next(conn net.Conn) {
+	if c, ok := conn.(*limitConn); ok {
+		c.reset()
+	}
+}
@@ -0,0 +1,66 @@
+package paxi
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOversize(t *testing.T) {
+	max := config.MaxMessageSize
+	defer func() { config.MaxMessageSize = max }()
+	config.MaxMessageSize = 1024
+
+	addr := "tcp://127.0.0.1:" + freePort(t, "tcp")
+	server := NewTransport(addr)
+	server.Listen()
+	client := NewTransport(addr)
+	if err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	// first message of a type carries its type information, dropping it must not break the next one
+	client.Send(Request{Command: Command{Key: 1, Value: bytes.Repeat([]byte("v"), 4096)}})
+	client.Send(Request{Command: Command{Key: 2, Value: Value("v")}})
+	recv := make(chan interface{}, 1)
+	go func() { recv <- server.Recv() }()
+	select {
+	case m := <-recv:
+		if r, ok := m.(Request); !ok || r.Command.Key != 2 {
+			t.Errorf("expected small message after the large one is dropped, got %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("small message after the large one is lost")
+	}
+}
+
+func TestLimitConn(t *testing.T) {
+	max := config.MaxMessageSize
+	defer func() { config.MaxMessageSize = max }()
+	config.MaxMessageSize = 16
+
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write(make([]byte, 16+readAhead+1))
+		client.Close()
+	}()
+	conn := newLimitConn(server)
+	b := make([]byte, 1024)
+	read := 0
+	var err error
+	for err == nil {
+		var n int
+		n, err = conn.Read(b)
+		read += n
+	}
+	if !errors.Is(err, ErrMessageTooLarge) || read != 16+readAhead {
+		t.Fatalf("expected read to stop at limit, read %d: %v", read, err)
+	}
+	next(conn)
+	if n, err := conn.Read(b); n != 1 || err != nil {
+		t.Errorf("expected next message readable after reset, read %d: %v", n, err)
+	}
+}
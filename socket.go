@@ -72,6 +72,10 @@ func NewSocket(id ID, addrs map[ID]string) Socket {
 }
 
 func (s *socket) Send(to ID, m interface{}) {
+	s.send(to, m)
+}
+
+func (s *socket) send(to ID, m interface{}) {
 	if s.crash {
 		return
 	}
@@ -102,7 +106,7 @@ func (s *socket) MulticastZone(zone int, m interface{}) {
 			continue
 		}
 		if id.Zone() == zone {
-			s.Send(id, m)
+			s.send(id, m)
 		}
 	}
 }
@@ -114,7 +118,7 @@ func (s *socket) MulticastQuorum(quorum int, m interface{}) {
 		if id == s.id {
 			continue
 		}
-		s.Send(id, m)
+		s.send(id, m)
 		i++
 		if i == quorum {
 			break
@@ -128,7 +132,7 @@ func (s *socket) Broadcast(m interface{}) {
 		if id == s.id {
 			continue
 		}
-		s.Send(id, m)
+		s.send(id, m)
 	}
 }
 
@@ -1,10 +1,33 @@
 package paxi
 
-// StateMachine defines a deterministic state machine
+// StateMachine defines a deterministic state machine that applies committed commands in slot order
 type StateMachine interface {
-	// Execute is the state-transition function
-	// returns current state value if state unchanged or previous state value
-	Execute(interface{}) interface{}
+	// Apply is the state-transition function
+	// returns result of command c, which is the previous value of its key in the default key-value database,
+	// or error if c is rejected, e.g. failed condition, which is replied to the client while the log moves on
+	Apply(c Command) (Value, error)
+
+	// Snapshot returns the serialized current state
+	Snapshot() Value
+
+	// Restore replaces current state with snapshot
+	Restore(snapshot Value)
+}
+
+// Clocked is implemented by state machine whose commands expire in logical time,
+// protocols executing commands in slot order apply them with their slot, so that every replica
+// expires the same keys at the same point of the log instead of by its own wall clock
+type Clocked interface {
+	// ApplyAt applies command c executed in slot s, as Apply does
+	ApplyAt(c Command, s int) (Value, error)
+}
+
+// ApplyAt applies command c executed in slot s by state machine sm, with slot if sm is Clocked
+func ApplyAt(sm StateMachine, c Command, s int) (Value, error) {
+	if clocked, ok := sm.(Clocked); ok {
+		return clocked.ApplyAt(c, s)
+	}
+	return sm.Apply(c)
 }
 
 type State interface {
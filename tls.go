@@ -0,0 +1,35 @@
+package paxi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// newTLSConfig loads certificate, key and trusted CA from config files,
+// both sides of a connection present certificate signed by the CA so that peers are mutually authenticated
+func newTLSConfig() *tls.Config {
+	if config.TLSCert == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		log.Fatalf("cannot load tls certificate %s and key %s: %v", config.TLSCert, config.TLSKey, err)
+	}
+	ca, err := ioutil.ReadFile(config.TLSCA)
+	if err != nil {
+		log.Fatalf("cannot read tls ca %s: %v", config.TLSCA, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		log.Fatalf("no certificate found in tls ca %s", config.TLSCA)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
@@ -0,0 +1,68 @@
+package paxi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// Span is one timed step of handling a traced request on one node
+type Span struct {
+	TraceID    string
+	SpanID     string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+}
+
+// Export logs span as one entry with the field names of OpenTelemetry span,
+// which is one json object per line in json log format
+func (s Span) Export() {
+	log.Infow("span", log.Fields{
+		"traceId":           s.TraceID,
+		"spanId":            s.SpanID,
+		"name":              s.Name,
+		"startTimeUnixNano": s.Start.UnixNano(),
+		"endTimeUnixNano":   s.End.UnixNano(),
+		"attributes":        s.Attributes,
+	})
+}
+
+// NewTraceID returns random 16 bytes trace id in hex
+func NewTraceID() string {
+	return random(16)
+}
+
+// NewSpanID returns random 8 bytes span id in hex
+func NewSpanID() string {
+	return random(8)
+}
+
+func random(n int) string {
+	b := make([]byte, n)
+	// crypto/rand only fails if the system has no entropy source
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ParseTraceParent returns trace id of W3C traceparent header value "version-traceid-spanid-flags"
+func ParseTraceParent(header string) (string, error) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return "", fmt.Errorf("invalid traceparent %q", header)
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return "", fmt.Errorf("invalid trace id of traceparent %q: %v", header, err)
+	}
+	return parts[1], nil
+}
+
+// TraceParent returns W3C traceparent header value of trace id and span id, sampled
+func TraceParent(traceID, spanID string) string {
+	return fmt.Sprintf("00-%s-%s-01", traceID, spanID)
+}
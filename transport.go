@@ -2,13 +2,16 @@ package paxi
 
 import (
 	"bytes"
-	"encoding/gob"
+	"crypto/tls"
 	"errors"
 	"flag"
+	"io"
 	"net"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ailidani/paxi/log"
 )
@@ -45,9 +48,13 @@ func NewTransport(addr string) Transport {
 	if err != nil {
 		log.Fatalf("error parsing address %s : %s\n", addr, err)
 	}
+	if _, ok := codecs[config.Codec]; !ok {
+		log.Fatalf("unknown codec %s", config.Codec)
+	}
 
 	transport := &transport{
 		uri:   uri,
+		tls:   newTLSConfig(),
 		send:  make(chan interface{}, config.ChanBufferSize),
 		recv:  make(chan interface{}, config.ChanBufferSize),
 		close: make(chan struct{}),
@@ -74,15 +81,22 @@ func NewTransport(addr string) Transport {
 
 type transport struct {
 	uri   *url.URL
+	tls   *tls.Config // nil for plain connection
 	send  chan interface{}
 	recv  chan interface{}
 	close chan struct{}
+	link  link // statistic of messages sent to the remote server
 }
 
 func (t *transport) Send(m interface{}) {
+	atomic.AddInt64(&t.link.sent, 1)
 	t.send <- m
 }
 
+func (t *transport) stat() Link {
+	return t.link.stat()
+}
+
 func (t *transport) Recv() interface{} {
 	return <-t.recv
 }
@@ -97,27 +111,68 @@ func (t *transport) Scheme() string {
 }
 
 func (t *transport) Dial() error {
-	conn, err := net.Dial(t.Scheme(), t.uri.Host)
+	conn, err := t.connect()
 	if err != nil {
 		return err
 	}
 
-	go func(conn net.Conn) {
-		// w := bufio.NewWriter(conn)
-		// codec := NewCodec(config.Codec, conn)
-		encoder := gob.NewEncoder(conn)
-		defer conn.Close()
+	go func() {
+		// each message is encoded into buffer first, so its size is measured once on the way out
+		var b bytes.Buffer
+		codec := NewCodec(config.Codec, &b)
+		defer func() { conn.Close() }()
 		for m := range t.send {
-			err := encoder.Encode(&m)
+			b.Reset()
+			err := codec.Encode(&m)
 			if err != nil {
 				log.Error(err)
+				t.link.fail(err)
+				continue
 			}
+			if config.MaxMessageSize > 0 && b.Len() > config.MaxMessageSize {
+				log.Errorf("drops message %v of %d bytes to %s exceeding max message size %d", m, b.Len(), t.uri.Host, config.MaxMessageSize)
+				t.link.fail(ErrMessageTooLarge)
+				// type information of a stream codec may be in the dropped bytes, a new connection starts the stream again
+				conn.Close()
+				err = Retry(func() error {
+					conn, err = t.connect()
+					return err
+				}, 100, time.Duration(50)*time.Millisecond)
+				if err != nil {
+					log.Error(err)
+					return
+				}
+				codec = NewCodec(config.Codec, &b)
+				continue
+			}
+			_, err = conn.Write(b.Bytes())
+			if err != nil {
+				log.Error(err)
+				t.link.fail(err)
+				continue
+			}
+			t.link.ok()
 		}
-	}(conn)
+	}()
 
 	return nil
 }
 
+// connect opens connection to the remote server
+func (t *transport) connect() (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if t.tls != nil && t.Scheme() == "tcp" {
+		conn, err = tls.Dial(t.Scheme(), t.uri.Host, t.tls)
+		if err != nil {
+			log.Errorf("tls handshake with %s failed: %v", t.uri.Host, err)
+		}
+	} else {
+		conn, err = net.Dial(t.Scheme(), t.uri.Host)
+	}
+	return conn, err
+}
+
 /******************************
 /*     TCP communication      *
 /******************************/
@@ -131,6 +186,9 @@ func (t *tcp) Listen() {
 	if err != nil {
 		log.Fatal("TCP Listener error: ", err)
 	}
+	if t.tls != nil {
+		listener = tls.NewListener(listener, t.tls)
+	}
 
 	go func(listener net.Listener) {
 		defer listener.Close()
@@ -142,9 +200,16 @@ func (t *tcp) Listen() {
 			}
 
 			go func(conn net.Conn) {
-				// codec := NewCodec(config.Codec, conn)
-				decoder := gob.NewDecoder(conn)
 				defer conn.Close()
+				if c, ok := conn.(*tls.Conn); ok {
+					err := c.Handshake()
+					if err != nil {
+						log.Errorf("tls handshake with %s failed: %v", conn.RemoteAddr(), err)
+						return
+					}
+				}
+				limited := newLimitConn(conn)
+				codec := NewCodec(config.Codec, limited)
 				//r := bufio.NewReader(conn)
 				for {
 					select {
@@ -152,7 +217,16 @@ func (t *tcp) Listen() {
 						return
 					default:
 						var m interface{}
-						err := decoder.Decode(&m)
+						next(limited)
+						err := codec.Decode(&m)
+						if err == io.EOF {
+							return
+						}
+						if errors.Is(err, ErrMessageTooLarge) {
+							// rest of the stream cannot be decoded
+							log.Errorf("message from %s exceeds max message size %d, closing connection", conn.RemoteAddr(), config.MaxMessageSize)
+							return
+						}
 						if err != nil {
 							log.Error(err)
 							continue
@@ -166,71 +240,6 @@ func (t *tcp) Listen() {
 	}(listener)
 }
 
-/******************************
-/*     UDP communication      *
-/******************************/
-type udp struct {
-	*transport
-}
-
-func (u *udp) Dial() error {
-	addr, err := net.ResolveUDPAddr("udp", u.uri.Host)
-	if err != nil {
-		log.Fatal("UDP resolve address error: ", err)
-	}
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return err
-	}
-
-	go func(conn *net.UDPConn) {
-		// packet := make([]byte, 1500)
-		// w := bytes.NewBuffer(packet)
-		w := new(bytes.Buffer)
-		for m := range u.send {
-			gob.NewEncoder(w).Encode(&m)
-			_, err := conn.Write(w.Bytes())
-			if err != nil {
-				log.Error(err)
-			}
-			w.Reset()
-		}
-	}(conn)
-
-	return nil
-}
-
-func (u *udp) Listen() {
-	addr, err := net.ResolveUDPAddr("udp", ":"+u.uri.Port())
-	if err != nil {
-		log.Fatal("UDP resolve address error: ", err)
-	}
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		log.Fatal("UDP Listener error: ", err)
-	}
-	go func(conn *net.UDPConn) {
-		packet := make([]byte, 1500)
-		defer conn.Close()
-		for {
-			select {
-			case <-u.close:
-				return
-			default:
-				_, err := conn.Read(packet)
-				if err != nil {
-					log.Error(err)
-					continue
-				}
-				r := bytes.NewReader(packet)
-				var m interface{}
-				gob.NewDecoder(r).Decode(&m)
-				u.recv <- m
-			}
-		}
-	}(conn)
-}
-
 /*******************************
 /* Intra-process communication *
 /*******************************/
@@ -1,8 +1,21 @@
 package paxi
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/gob"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestTransport(t *testing.T) {
@@ -55,3 +68,237 @@ func TestTransport(t *testing.T) {
 		t.Error()
 	}
 }
+
+// writeCert creates certificate of 127.0.0.1 signed by parent, or self signed ca if parent is nil
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestTLSTransport(t *testing.T) {
+	gob.Register(A{})
+	dir, err := ioutil.TempDir("", "paxi-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "node", ca, caKey)
+	writeCert(t, dir, "other", nil, nil)
+
+	saved := config
+	defer func() { config = saved }()
+	config.TLSCert = filepath.Join(dir, "node.crt")
+	config.TLSKey = filepath.Join(dir, "node.key")
+	config.TLSCA = filepath.Join(dir, "ca.crt")
+
+	addr := "tcp://127.0.0.1:" + freePort(t, "tcp")
+	server := NewTransport(addr)
+	server.Listen()
+
+	client := NewTransport(addr)
+	err = client.Dial()
+	if err != nil {
+		t.Fatalf("tls dial failed: %v", err)
+	}
+	client.Send(A{I: 42, S: "hello tls"})
+	m, ok := server.Recv().(A)
+	if !ok || m.I != 42 {
+		t.Errorf("expected message A over tls, received %v", m)
+	}
+
+	// peer trusting a different ca rejects the server certificate
+	config.TLSCA = filepath.Join(dir, "other.crt")
+	untrusted := NewTransport(addr)
+	if untrusted.Dial() == nil {
+		t.Error("expected dial to fail with untrusted certificate")
+	}
+}
+
+// freePort returns a port of network tcp or udp that is free to listen on,
+// since transport exits the process if it cannot listen
+func freePort(t *testing.T, network string) string {
+	var addr net.Addr
+	if network == "udp" {
+		c, err := net.ListenPacket("udp", ":0")
+		if err != nil {
+			t.Fatalf("no free udp port: %v", err)
+		}
+		defer c.Close()
+		addr = c.LocalAddr()
+	} else {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatalf("no free tcp port: %v", err)
+		}
+		defer l.Close()
+		addr = l.Addr()
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestProtoTransport(t *testing.T) {
+	RegisterMessage(A{})
+	RegisterMessage(B{})
+	defer func(codec string) { config.Codec = codec }(config.Codec)
+	config.Codec = "proto"
+
+	addr := "tcp://127.0.0.1:" + freePort(t, "tcp")
+	server := NewTransport(addr)
+	server.Listen()
+	client := NewTransport(addr)
+	if err := Retry(client.Dial, 10, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	client.Send(A{I: 7, S: "proto", B: true})
+	client.Send(B{S: "framed"})
+	if m := server.Recv(); m != (A{I: 7, S: "proto", B: true}) {
+		t.Errorf("expected message A, received %v", m)
+	}
+	if m := server.Recv(); m != (B{S: "framed"}) {
+		t.Errorf("expected message B, received %v", m)
+	}
+}
+
+func TestUDPTransport(t *testing.T) {
+	gob.Register(A{})
+	// udp listener of TestSocket on its port outlives the test
+	addr := "udp://127.0.0.1:" + freePort(t, "udp")
+	client := NewTransport(addr)
+	client.Dial()
+
+	// message sent before server starts is retransmitted
+	client.Send(A{I: 1, S: "early"})
+	time.Sleep(3 * time.Duration(config.Retransmit) * time.Millisecond)
+
+	server := NewTransport(addr)
+	server.Listen()
+	client.Send(A{I: 2, S: "hello udp"})
+
+	received := make(map[int]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-server.(*udp).recv:
+			received[m.(A).I] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected 2 messages, received %v", received)
+		}
+	}
+	if !received[1] || !received[2] {
+		t.Errorf("expected messages 1 and 2, received %v", received)
+	}
+}
+
+func TestUDPWindow(t *testing.T) {
+	w := &window{seen: make(map[uint64]bool)}
+	for _, seq := range []uint64{0, 2, 1} {
+		if !w.deliver(seq) {
+			t.Errorf("expected seq %d delivered", seq)
+		}
+	}
+	for _, seq := range []uint64{0, 1, 2} {
+		if w.deliver(seq) {
+			t.Errorf("expected duplicate seq %d dropped", seq)
+		}
+	}
+	if w.next != 3 || len(w.seen) != 0 {
+		t.Errorf("expected window next 3 without gaps, got next %d seen %v", w.next, w.seen)
+	}
+
+	// packet 3 is dropped by sender, window moves past it once later packets fill it
+	for seq := uint64(4); seq <= 4+udpPending; seq++ {
+		w.deliver(seq)
+	}
+	if w.next != 5+udpPending || len(w.seen) != 0 {
+		t.Errorf("expected window to skip dropped packet 3, got next %d seen %d", w.next, len(w.seen))
+	}
+}
+
+func TestUDPPending(t *testing.T) {
+	u := &udp{transport: &transport{uri: &url.URL{Host: "127.0.0.1:1"}}, pending: make(map[uint64]*unacked)}
+	for i := 0; i <= udpPending; i++ {
+		u.track(make([]byte, udpHeader))
+	}
+	if _, exists := u.pending[0]; exists || len(u.pending) != udpPending || u.dropped != 1 {
+		t.Fatalf("expected oldest packet dropped beyond %d pending, pending %d", udpPending, len(u.pending))
+	}
+
+	now := time.Now()
+	timeout := time.Millisecond
+	for i := 1; i <= udpRetries; i++ {
+		now = now.Add(timeout)
+		if n := len(u.due(now, timeout)); n != udpPending {
+			t.Fatalf("expected every pending packet retransmitted on tick %d, got %d", i, n)
+		}
+	}
+	if n := len(u.due(now.Add(timeout), timeout)); n != 0 || len(u.pending) != 0 {
+		t.Errorf("expected packets dropped after %d retransmissions, retransmitted %d pending %d", udpRetries, n, len(u.pending))
+	}
+}
+
+// benchmark runs more than once with the same address
+var benchmarkTransports = make(map[string][2]Transport)
+
+func benchmarkTransport(b *testing.B, addr string) {
+	gob.Register(A{})
+	if _, exists := benchmarkTransports[addr]; !exists {
+		server := NewTransport(addr)
+		server.Listen()
+		client := NewTransport(addr)
+		err := Retry(client.Dial, 10, 10*time.Millisecond)
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchmarkTransports[addr] = [2]Transport{server, client}
+	}
+	server, client := benchmarkTransports[addr][0], benchmarkTransports[addr][1]
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.Send(A{I: i, S: "benchmark"})
+		server.Recv()
+	}
+}
+
+func BenchmarkTransportTCP(b *testing.B) {
+	benchmarkTransport(b, "tcp://127.0.0.1:1738")
+}
+
+func BenchmarkTransportUDP(b *testing.B) {
+	benchmarkTransport(b, "udp://127.0.0.1:1739")
+}
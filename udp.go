@@ -0,0 +1,247 @@
+package paxi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+/******************************
+/*     UDP communication      *
+/******************************/
+
+// udp packet starts with one byte kind and 8 bytes sequence number
+const (
+	udpData byte = iota
+	udpAck
+)
+
+const (
+	udpHeader  = 9
+	udpPacket  = 65507 // max udp payload
+	udpRetries = 50    // retransmissions of unacknowledged packet before it is dropped
+	udpPending = 4096  // max unacknowledged packets to one peer, and max out of order packets delivered from one peer
+)
+
+// udp transport acknowledges every data packet, sender retransmits unacknowledged packet
+// until it is acked so that messages are delivered, possibly out of order, unless the peer
+// stays silent for udpRetries retransmissions or udpPending later packets
+type udp struct {
+	*transport
+
+	sync.Mutex
+	seq     uint64
+	oldest  uint64              // no packet before oldest is pending
+	dropped int                 // packets dropped unacknowledged since last retransmission
+	pending map[uint64]*unacked // sent but not acknowledged packets
+	peers   map[string]*window  // delivered sequence numbers of each sender
+}
+
+type unacked struct {
+	packet  []byte
+	sent    time.Time
+	retries int
+}
+
+// track numbers packet and keeps it until acked, dropping the oldest pending packet once udpPending are pending
+func (u *udp) track(packet []byte) {
+	u.Lock()
+	defer u.Unlock()
+	for len(u.pending) >= udpPending {
+		if _, exists := u.pending[u.oldest]; exists {
+			delete(u.pending, u.oldest)
+			u.dropped++
+		}
+		u.oldest++
+	}
+	packet[0] = udpData
+	binary.BigEndian.PutUint64(packet[1:], u.seq)
+	u.pending[u.seq] = &unacked{packet: packet, sent: time.Now()}
+	u.seq++
+}
+
+// due returns pending packets unacknowledged for timeout to retransmit, and drops those retransmitted udpRetries times
+func (u *udp) due(now time.Time, timeout time.Duration) [][]byte {
+	u.Lock()
+	defer u.Unlock()
+	var packets [][]byte
+	for seq, p := range u.pending {
+		if now.Sub(p.sent) < timeout {
+			continue
+		}
+		if p.retries >= udpRetries {
+			delete(u.pending, seq)
+			u.dropped++
+			continue
+		}
+		p.retries++
+		p.sent = now
+		packets = append(packets, p.packet)
+	}
+	if u.dropped > 0 {
+		log.Warningf("drops %d packets to %s unacknowledged after %d retransmissions or %d later packets", u.dropped, u.uri.Host, udpRetries, udpPending)
+		u.dropped = 0
+	}
+	return packets
+}
+
+// window records delivered sequence numbers as every one before next plus those seen after next
+type window struct {
+	next uint64
+	seen map[uint64]bool
+}
+
+// deliver returns false if seq is a duplicate
+func (w *window) deliver(seq uint64) bool {
+	if seq < w.next || w.seen[seq] {
+		return false
+	}
+	w.seen[seq] = true
+	for w.seen[w.next] || len(w.seen) > udpPending {
+		// sender dropped next once this many later packets are delivered
+		delete(w.seen, w.next)
+		w.next++
+	}
+	return true
+}
+
+func (u *udp) Dial() error {
+	addr, err := net.ResolveUDPAddr("udp", u.uri.Host)
+	if err != nil {
+		log.Fatal("UDP resolve address error: ", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	u.pending = make(map[uint64]*unacked)
+
+	// send
+	go func(conn *net.UDPConn) {
+		defer conn.Close()
+		for m := range u.send {
+			w := bytes.NewBuffer(make([]byte, udpHeader, 1500))
+			err := NewCodec(config.Codec, w).Encode(&m)
+			if err != nil {
+				log.Error(err)
+				u.link.fail(err)
+				continue
+			}
+			packet := w.Bytes()
+			if config.MaxMessageSize > 0 && len(packet)-udpHeader > config.MaxMessageSize {
+				log.Errorf("drops message %v of %d bytes to %s exceeding max message size %d", m, len(packet)-udpHeader, u.uri.Host, config.MaxMessageSize)
+				u.link.fail(ErrMessageTooLarge)
+				continue
+			}
+			u.track(packet)
+			_, err = conn.Write(packet)
+			if err != nil {
+				// retransmitted until acked
+				log.Debug(err)
+				u.link.fail(err)
+				continue
+			}
+			u.link.ok()
+		}
+	}(conn)
+
+	// receive acks
+	go func(conn *net.UDPConn) {
+		packet := make([]byte, udpHeader)
+		for {
+			n, err := conn.Read(packet)
+			if err != nil {
+				select {
+				case <-u.close:
+					return
+				default:
+					// peer is not listening yet
+					log.Debug(err)
+					time.Sleep(time.Millisecond)
+					continue
+				}
+			}
+			if n == udpHeader && packet[0] == udpAck {
+				u.Lock()
+				delete(u.pending, binary.BigEndian.Uint64(packet[1:]))
+				u.Unlock()
+			}
+		}
+	}(conn)
+
+	// retransmit
+	go func(conn *net.UDPConn) {
+		timeout := time.Duration(config.Retransmit) * time.Millisecond
+		ticker := time.NewTicker(timeout)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-u.close:
+				return
+			case now := <-ticker.C:
+				for _, packet := range u.due(now, timeout) {
+					conn.Write(packet)
+				}
+			}
+		}
+	}(conn)
+
+	return nil
+}
+
+func (u *udp) Listen() {
+	addr, err := net.ResolveUDPAddr("udp", ":"+u.uri.Port())
+	if err != nil {
+		log.Fatal("UDP resolve address error: ", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Fatal("UDP Listener error: ", err)
+	}
+	u.peers = make(map[string]*window)
+	go func(conn *net.UDPConn) {
+		packet := make([]byte, udpPacket)
+		ack := make([]byte, udpHeader)
+		ack[0] = udpAck
+		defer conn.Close()
+		for {
+			select {
+			case <-u.close:
+				return
+			default:
+				n, from, err := conn.ReadFromUDP(packet)
+				if err != nil {
+					log.Error(err)
+					continue
+				}
+				if n < udpHeader || packet[0] != udpData {
+					continue
+				}
+				seq := binary.BigEndian.Uint64(packet[1:])
+				// ack again in case the previous ack is lost
+				binary.BigEndian.PutUint64(ack[1:], seq)
+				conn.WriteToUDP(ack, from)
+
+				w, exists := u.peers[from.String()]
+				if !exists {
+					w = &window{seen: make(map[uint64]bool)}
+					u.peers[from.String()] = w
+				}
+				if !w.deliver(seq) {
+					continue
+				}
+				var m interface{}
+				err = NewCodec(config.Codec, bytes.NewBuffer(packet[udpHeader:n])).Decode(&m)
+				if err != nil {
+					log.Error(err)
+					continue
+				}
+				u.recv <- m
+			}
+		}
+	}(conn)
+}
@@ -14,7 +14,7 @@ type kpaxos struct {
 
 func Q1(q *paxi.Quorum) bool {
 	if *fz == 0 {
-		return q.GridRow()
+		return q.AllZones()
 	}
 	return q.FGridQ1(*fz)
 }